@@ -3,10 +3,16 @@ package handlers
 
 import (
 	"database/sql"
+	"encoding/json"
 	"fmt"
+	"io"
+	"log"
+	"math"
 	"net/http"
 	"strconv"
+	"strings"
 	"psycho-test-system/database"
+	"psycho-test-system/models"
 
 	"github.com/gin-gonic/gin"
 )
@@ -64,7 +70,7 @@ func GetAdminStats(c *gin.Context) {
 	}
 
 	// Неуспешные тесты
-	err = database.DB.QueryRow("SELECT COUNT(*) FROM test_results WHERE is_passed = false").Scan(&stats.FailedTests)
+	err = database.DB.QueryRow("SELECT COUNT(*) FROM test_results WHERE is_passed = false AND COALESCE(is_valid, true)").Scan(&stats.FailedTests)
 	if err != nil {
 		stats.FailedTests = 0
 	}
@@ -106,16 +112,62 @@ func GetAdminStats(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{"stats": stats})
 }
 
-// Получение всех пользователей
+// Сортировки списка пользователей
+var userSortColumns = map[string]string{
+	"created_at":   "u.created_at",
+	"name":         "u.last_name || ' ' || u.first_name || ' ' || COALESCE(u.patronymic, '')",
+	"email":        "u.email",
+	"tests_count":  "COALESCE(stats.tests_count, 0)",
+	"success_rate": "COALESCE(stats.passed_tests::float / NULLIF(stats.tests_count, 0), 0)",
+}
+
+// Фильтры списка пользователей: $1 поиск по ФИО и email, $2 роль, $3 состояние
+// (active или blocked), $4 и $5 границы даты регистрации
+const usersListWhere = `
+	WHERE ($1 = '' OR CONCAT_WS(' ', u.last_name, u.first_name, u.patronymic, u.email) ILIKE $1)
+	  AND ($2 = '' OR u.role = $2)
+	  AND ($3 = '' OR u.is_blocked = ($3 = 'blocked'))
+	  AND ($4::timestamp IS NULL OR u.created_at >= $4) AND ($5::timestamp IS NULL OR u.created_at < $5)`
+
+// Получение пользователей постранично: page, page_size, sort, order, q, role, status, from, to
 func GetAllUsers(c *gin.Context) {
+	query, message := parseListQuery(c, userSortColumns, "created_at", true)
+	if message != "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": message})
+		return
+	}
+	status := c.Query("status")
+	if status != "" && status != "active" && status != "blocked" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Фильтр status принимает значения active или blocked"})
+		return
+	}
+	from, to, message := parseDateRange(c)
+	if message != "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": message})
+		return
+	}
+	args := []interface{}{query.Search, c.Query("role"), status, from, to}
+
+	var total int
+	if err := database.DB.QueryRow("SELECT COUNT(*) FROM users u"+usersListWhere, args...).Scan(&total); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка получения пользователей"})
+		return
+	}
+
+	// Счётчики результатов считаются одной агрегацией вместо подзапросов для каждой строки
 	rows, err := database.DB.Query(`
-		SELECT id, email, last_name, first_name, patronymic, role, is_blocked,
-		       TO_CHAR(created_at AT TIME ZONE 'Europe/Moscow', 'YYYY.MM.DD HH24.MI.SS') as created_at,
-		       (SELECT COUNT(*) FROM test_results WHERE user_id = users.id) as tests_count,
-		       (SELECT COUNT(*) FROM test_results WHERE user_id = users.id AND is_passed = true) as passed_tests
-		FROM users 
-		ORDER BY created_at DESC
-	`)
+		SELECT u.id, u.email, u.last_name, u.first_name, COALESCE(u.patronymic, ''), u.role, u.is_blocked,
+		       TO_CHAR(u.created_at AT TIME ZONE 'Europe/Moscow', 'YYYY.MM.DD HH24.MI.SS') as created_at,
+		       COALESCE(stats.tests_count, 0) as tests_count,
+		       COALESCE(stats.passed_tests, 0) as passed_tests
+		FROM users u
+		LEFT JOIN (
+			SELECT user_id, COUNT(*) as tests_count, COUNT(*) FILTER (WHERE is_passed) as passed_tests
+			FROM test_results GROUP BY user_id
+		) stats ON stats.user_id = u.id`+usersListWhere+`
+		ORDER BY `+query.OrderBy("u.id")+`
+		LIMIT $6 OFFSET $7
+	`, append(args, query.PageSize, query.Offset())...)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка получения пользователей"})
 		return
@@ -159,7 +211,10 @@ func GetAllUsers(c *gin.Context) {
 		})
 	}
 
-	c.JSON(http.StatusOK, gin.H{"users": users})
+	if users == nil {
+		users = []map[string]interface{}{}
+	}
+	c.JSON(http.StatusOK, gin.H{"users": users, "pagination": query.Pagination(total)})
 }
 
 func calculateSuccessRate(totalTests, passedTests int) string {
@@ -200,17 +255,70 @@ func BlockUser(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{"message": "Пользователь " + action})
 }
 
-// Получение всех тестов
+// Сортировки списка тестов
+var testSortColumns = map[string]string{
+	"created_at":      "pt.created_at",
+	"title":           "pt.title",
+	"methodology":     "pt.methodology_type",
+	"questions_count": "COALESCE(questions.questions_count, 0)",
+	"results_count":   "COALESCE(stats.results_count, 0)",
+	"success_rate":    "COALESCE(stats.passed_count::float / NULLIF(stats.results_count, 0), 0)",
+}
+
+// Фильтры списка тестов: $1 поиск по названию и описанию, $2 методика, $3 состояние
+// (active, inactive или archived), $4 и $5 границы даты создания
+const testsListWhere = `
+	WHERE ($1 = '' OR CONCAT_WS(' ', pt.title, pt.description) ILIKE $1)
+	  AND ($2 = '' OR pt.methodology_type = $2)
+	  AND ($3 = '' OR ($3 = 'archived' AND pt.archived_at IS NOT NULL)
+	       OR (pt.archived_at IS NULL AND pt.is_active = ($3 = 'active')))
+	  AND ($4::timestamp IS NULL OR pt.created_at >= $4) AND ($5::timestamp IS NULL OR pt.created_at < $5)`
+
+// Получение тестов постранично: page, page_size, sort, order, q, methodology, status, from, to.
+// Архивные тесты идут после действующих.
 func GetAllTests(c *gin.Context) {
+	query, message := parseListQuery(c, testSortColumns, "created_at", true)
+	if message != "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": message})
+		return
+	}
+	status := c.Query("status")
+	if status != "" && status != "active" && status != "inactive" && status != "archived" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Фильтр status принимает значения active, inactive или archived"})
+		return
+	}
+	from, to, message := parseDateRange(c)
+	if message != "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": message})
+		return
+	}
+	args := []interface{}{query.Search, c.Query("methodology"), status, from, to}
+
+	var total int
+	if err := database.DB.QueryRow("SELECT COUNT(*) FROM psychological_tests pt"+testsListWhere, args...).Scan(&total); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка получения тестов"})
+		return
+	}
+
 	rows, err := database.DB.Query(`
-		SELECT id, title, description, instructions, estimated_time, pass_threshold, methodology_type, is_active,
-		       TO_CHAR(created_at AT TIME ZONE 'Europe/Moscow', 'YYYY.MM.DD HH24.MI.SS') as created_at,
-		       (SELECT COUNT(*) FROM test_questions WHERE test_id = psychological_tests.id) as questions_count,
-		       (SELECT COUNT(*) FROM test_results WHERE test_id = psychological_tests.id) as results_count,
-		       (SELECT COUNT(*) FROM test_results WHERE test_id = psychological_tests.id AND is_passed = true) as passed_count
-		FROM psychological_tests 
-		ORDER BY created_at DESC
-	`)
+		SELECT pt.id, pt.title, pt.description, pt.instructions, pt.estimated_time, pt.pass_threshold, pt.methodology_type, pt.is_active,
+		       pt.archived_at IS NOT NULL as is_archived,
+		       COALESCE(TO_CHAR(pt.archived_at AT TIME ZONE 'Europe/Moscow', 'YYYY.MM.DD HH24.MI.SS'), '') as archived_at,
+		       TO_CHAR(pt.created_at AT TIME ZONE 'Europe/Moscow', 'YYYY.MM.DD HH24.MI.SS') as created_at,
+		       COALESCE(questions.questions_count, 0) as questions_count,
+		       COALESCE(stats.results_count, 0) as results_count,
+		       COALESCE(stats.passed_count, 0) as passed_count
+		FROM psychological_tests pt
+		LEFT JOIN (
+			SELECT version_id, COUNT(*) as questions_count FROM test_questions GROUP BY version_id
+		) questions ON questions.version_id = pt.current_version_id
+		LEFT JOIN (
+			SELECT test_id, COUNT(*) as results_count, COUNT(*) FILTER (WHERE is_passed) as passed_count
+			FROM test_results GROUP BY test_id
+		) stats ON stats.test_id = pt.id`+testsListWhere+`
+		ORDER BY pt.archived_at IS NOT NULL, `+query.OrderBy("pt.id")+`
+		LIMIT $6 OFFSET $7
+	`, append(args, query.PageSize, query.Offset())...)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка получения тестов"})
 		return
@@ -228,6 +336,8 @@ func GetAllTests(c *gin.Context) {
 			PassThreshold float64 `json:"pass_threshold"`
 			MethodologyType string `json:"methodology_type"`
 			IsActive      bool    `json:"is_active"`
+			IsArchived    bool    `json:"is_archived"`
+			ArchivedAt    string  `json:"archived_at"`
 			CreatedAt     string  `json:"created_at"`
 			QuestionsCount int    `json:"questions_count"`
 			ResultsCount  int     `json:"results_count"`
@@ -235,7 +345,7 @@ func GetAllTests(c *gin.Context) {
 		}
 		
 		err := rows.Scan(&test.ID, &test.Title, &test.Description, &test.Instructions, 
-			&test.EstimatedTime, &test.PassThreshold, &test.MethodologyType, &test.IsActive, &test.CreatedAt, 
+			&test.EstimatedTime, &test.PassThreshold, &test.MethodologyType, &test.IsActive, &test.IsArchived, &test.ArchivedAt, &test.CreatedAt, 
 			&test.QuestionsCount, &test.ResultsCount, &test.PassedCount)
 		if err != nil {
 			continue
@@ -258,6 +368,8 @@ func GetAllTests(c *gin.Context) {
 			"methodology_type": test.MethodologyType,
 			"methodology_label": methodologyLabel,
 			"is_active":       test.IsActive,
+			"is_archived":     test.IsArchived,
+			"archived_at":     test.ArchivedAt,
 			"created_at":      test.CreatedAt,
 			"questions_count": test.QuestionsCount,
 			"results_count":   test.ResultsCount,
@@ -266,7 +378,10 @@ func GetAllTests(c *gin.Context) {
 		})
 	}
 
-	c.JSON(http.StatusOK, gin.H{"tests": tests})
+	if tests == nil {
+		tests = []map[string]interface{}{}
+	}
+	c.JSON(http.StatusOK, gin.H{"tests": tests, "pagination": query.Pagination(total)})
 }
 
 func getMethodologyLabel(methodology string) string {
@@ -274,6 +389,7 @@ func getMethodologyLabel(methodology string) string {
 		"rigidity_scale":   "Методика измерения ригидности",
 		"willpower_control": "Опросник волевого самоконтроля",
 		"personality_16pf": "16PF личностный опросник",
+		"qti_import":        "Импорт QTI",
 	}
 	if label, exists := labels[methodology]; exists {
 		return label
@@ -281,22 +397,99 @@ func getMethodologyLabel(methodology string) string {
 	return methodology
 }
 
-// Удаление теста - ИСПРАВЛЕННАЯ ВЕРСИЯ (сохраняет результаты)
-func DeleteTest(c *gin.Context) {
+// setTestArchived переносит тест в архив или возвращает из него с записью в журнал аудита
+func setTestArchived(c *gin.Context, archived bool) {
 	testID, err := strconv.Atoi(c.Param("id"))
 	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Неверный ID теста"})
 		return
 	}
 
-	// Проверяем существование теста
-	var exists bool
-	err = database.DB.QueryRow("SELECT EXISTS(SELECT 1 FROM psychological_tests WHERE id = $1)", testID).Scan(&exists)
-	if err != nil || !exists {
+	userID, _ := c.Get("userID")
+
+	tx, err := database.DB.Begin()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка начала транзакции"})
+		return
+	}
+	defer tx.Rollback()
+
+	var title string
+	var isArchived bool
+	err = tx.QueryRow(`
+		SELECT title, archived_at IS NOT NULL FROM psychological_tests WHERE id = $1 FOR UPDATE
+	`, testID).Scan(&title, &isArchived)
+	if err == sql.ErrNoRows {
 		c.JSON(http.StatusNotFound, gin.H{"error": "Тест не найден"})
 		return
+	} else if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка базы данных"})
+		return
+	}
+	if isArchived == archived {
+		if archived {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Тест уже в архиве"})
+		} else {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Тест не в архиве"})
+		}
+		return
 	}
 
+	action, message := models.AuditTestRestored, "Тест восстановлен из архива"
+	if archived {
+		_, err = tx.Exec("UPDATE psychological_tests SET archived_at = NOW(), archived_by = $1 WHERE id = $2", userID, testID)
+		action, message = models.AuditTestArchived, "Тест перемещён в архив"
+	} else {
+		_, err = tx.Exec("UPDATE psychological_tests SET archived_at = NULL, archived_by = NULL WHERE id = $1", testID)
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка обновления теста"})
+		return
+	}
+
+	if err := writeAudit(tx, userID, action, "test", testID, gin.H{"title": title}); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка записи в журнал аудита"})
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка завершения операции"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": message})
+}
+
+// Перенос теста в архив: тест скрывается из списка для прохождения,
+// результаты, версии и настройки сохраняются полностью
+func ArchiveTest(c *gin.Context) {
+	setTestArchived(c, true)
+}
+
+// Восстановление теста из архива
+func RestoreTest(c *gin.Context) {
+	setTestArchived(c, false)
+}
+
+// Окончательное удаление теста. Доступно только для теста в архиве и записывается в журнал аудита;
+// результаты сохраняются в истории как результаты удалённого теста.
+func PurgeTest(c *gin.Context) {
+	testID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Неверный ID теста"})
+		return
+	}
+
+	var purgeReq struct {
+		Reason string `json:"reason"`
+	}
+	if err := c.ShouldBindJSON(&purgeReq); err != nil && err != io.EOF {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Неверный формат данных"})
+		return
+	}
+
+	userID, _ := c.Get("userID")
+
 	// Начинаем транзакцию
 	tx, err := database.DB.Begin()
 	if err != nil {
@@ -305,6 +498,27 @@ func DeleteTest(c *gin.Context) {
 	}
 	defer tx.Rollback()
 
+	// Проверяем существование теста
+	var title string
+	var isArchived bool
+	var resultsCount int
+	err = tx.QueryRow(`
+		SELECT title, archived_at IS NOT NULL,
+		       (SELECT COUNT(*) FROM test_results WHERE test_id = psychological_tests.id)
+		FROM psychological_tests WHERE id = $1 FOR UPDATE
+	`, testID).Scan(&title, &isArchived, &resultsCount)
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Тест не найден"})
+		return
+	} else if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка базы данных"})
+		return
+	}
+	if !isArchived {
+		c.JSON(http.StatusConflict, gin.H{"error": "Окончательно удалить можно только тест из архива, сначала переместите его в архив"})
+		return
+	}
+
 	// 1. Устанавливаем test_id = NULL в таблице test_results вместо удаления
 	_, err = tx.Exec(`
 		UPDATE test_results 
@@ -388,6 +602,17 @@ func DeleteTest(c *gin.Context) {
 		return
 	}
 
+	// 7. Фиксируем удаление в журнале аудита
+	err = writeAudit(tx, userID, models.AuditTestPurged, "test", testID, gin.H{
+		"title":         title,
+		"results_count": resultsCount,
+		"reason":        strings.TrimSpace(purgeReq.Reason),
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка записи в журнал аудита"})
+		return
+	}
+
 	// Коммитим транзакцию
 	err = tx.Commit()
 	if err != nil {
@@ -401,28 +626,123 @@ func DeleteTest(c *gin.Context) {
 	})
 }
 
-// Получение всех результатов тестирования - ИСПРАВЛЕННАЯ ВЕРСИЯ (работает с удаленными тестами)
-func GetAllResults(c *gin.Context) {
-	rows, err := database.DB.Query(`
-		SELECT 
-			tr.id, 
-			u.last_name, 
-			u.first_name, 
-			u.patronymic, 
-			u.email, 
+// Сортировки списка результатов
+var resultSortColumns = map[string]string{
+	"completed_at": "completed_at_raw",
+	"user":         "last_name || ' ' || first_name || ' ' || patronymic",
+	"test":         "test_title",
+	"percentage":   "percentage",
+	"attempt":      "attempt_number",
+}
+
+// resultsListSQL - результаты с пометкой официальной попытки. Официальная попытка определяется
+// по всем результатам пользователя, поэтому фильтры применяются к уже размеченным строкам:
+// $1 поиск по ФИО, email и названию теста, $2 тест, $3 пользователь, $4 методика,
+// $5 и $6 границы даты завершения, $7 итог (passed, failed или invalid), $8 только официальные.
+var resultsListSQL = `
+	FROM (
+		SELECT
+			tr.id,
+			tr.user_id,
+			tr.test_id,
+			COALESCE(u.last_name, '') as last_name,
+			COALESCE(u.first_name, '') as first_name,
+			COALESCE(u.patronymic, '') as patronymic,
+			COALESCE(u.email, '') as email,
 			COALESCE(pt.title, '[Удаленный тест]') as test_title,
 			COALESCE(pt.methodology_type, 'Неизвестно') as methodology_type,
-			tr.total_score, 
-			tr.max_possible_score, 
-			tr.percentage, 
-			tr.is_passed, 
+			tr.total_score,
+			tr.max_possible_score,
+			tr.percentage,
+			tr.is_passed,
 			tr.interpretation,
-			TO_CHAR(tr.completed_at AT TIME ZONE 'Europe/Moscow', 'YYYY.MM.DD HH24.MI.SS') as completed_at
+			TO_CHAR(tr.completed_at AT TIME ZONE 'Europe/Moscow', 'YYYY.MM.DD HH24.MI.SS') as completed_at,
+			COALESCE(tr.attempt_number, 1) as attempt_number,
+			CASE WHEN COALESCE(pt.official_attempt, 'latest') = 'first'
+			     THEN tr.completed_at = MIN(tr.completed_at) OVER (PARTITION BY tr.user_id, tr.test_id)
+			     ELSE tr.completed_at = MAX(tr.completed_at) OVER (PARTITION BY tr.user_id, tr.test_id)
+			END as is_official,
+			tr.completed_at as completed_at_raw,
+			tr.version_id,
+			COALESCE(tr.is_valid, true) as is_valid,
+			COALESCE(tr.validity_flags::text, '') as validity_flags
 		FROM test_results tr
 		LEFT JOIN users u ON tr.user_id = u.id
 		LEFT JOIN psychological_tests pt ON tr.test_id = pt.id
-		ORDER BY tr.completed_at DESC
-	`)
+	) results
+	WHERE ($1 = '' OR CONCAT_WS(' ', last_name, first_name, patronymic, email, test_title) ILIKE $1)
+	  AND ($2 = 0 OR test_id = $2) AND ($3 = 0 OR user_id = $3)
+	  AND ($4 = '' OR methodology_type = $4)
+	  AND ($5::timestamp IS NULL OR completed_at_raw >= $5) AND ($6::timestamp IS NULL OR completed_at_raw < $6)
+	  AND ` + resultStatusSQL("$7") + `
+	  AND (NOT $8 OR is_official)`
+
+// Получение результатов тестирования постранично (работает с удаленными тестами и пользователями).
+// Параметры: page, page_size, sort, order, q, test_id, user_id, methodology, from, to,
+// status (passed, failed или invalid) и official_only=true - только официальная попытка
+// каждого пользователя по каждому тесту.
+func GetAllResults(c *gin.Context) {
+	query, message := parseListQuery(c, resultSortColumns, "completed_at", true)
+	if message != "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": message})
+		return
+	}
+
+	var testID, userID int
+	for _, param := range []struct {
+		name    string
+		target  *int
+		message string
+	}{
+		{"test_id", &testID, "Неверный ID теста"},
+		{"user_id", &userID, "Неверный ID пользователя"},
+	} {
+		if value := c.Query(param.name); value != "" {
+			parsed, err := strconv.Atoi(value)
+			if err != nil || parsed < 1 {
+				c.JSON(http.StatusBadRequest, gin.H{"error": param.message})
+				return
+			}
+			*param.target = parsed
+		}
+	}
+	status, message := parseResultStatus(c)
+	if message != "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": message})
+		return
+	}
+	from, to, message := parseDateRange(c)
+	if message != "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": message})
+		return
+	}
+	args := []interface{}{query.Search, testID, userID, c.Query("methodology"), from, to, status, c.Query("official_only") == "true"}
+
+	var total int
+	if err := database.DB.QueryRow("SELECT COUNT(*)"+resultsListSQL, args...).Scan(&total); err != nil {
+		log.Printf("Ошибка подсчета результатов: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка получения результатов"})
+		return
+	}
+
+	// Назначение и версия теста присоединяются только к строкам страницы
+	rows, err := database.DB.Query(`
+		SELECT page.id, page.last_name, page.first_name, page.patronymic, page.email, page.test_title, page.methodology_type,
+		       page.total_score, page.max_possible_score, page.percentage, page.is_passed, page.interpretation,
+		       page.completed_at, page.attempt_number, page.is_official, page.completed_at_raw,
+		       CASE WHEN ta.id IS NULL THEN '' ELSE `+assignmentStatusSQL+` END as assignment_status,
+		       COALESCE(TO_CHAR(ta.deadline, 'YYYY.MM.DD HH24.MI'), '') as deadline,
+		       page.is_valid, page.validity_flags,
+		       COALESCE(tv.version_number, 0) as version_number
+		FROM (
+			SELECT *`+resultsListSQL+`
+			ORDER BY `+query.OrderBy("id")+`
+			LIMIT $9 OFFSET $10
+		) page
+		LEFT JOIN test_versions tv ON tv.id = page.version_id
+		LEFT JOIN test_assignments ta ON ta.test_id = page.test_id AND ta.user_id = page.user_id
+		ORDER BY `+query.OrderBy("page.id")+`
+	`, append(args, query.PageSize, query.Offset())...)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка получения результатов: " + err.Error()})
 		return
@@ -445,11 +765,21 @@ func GetAllResults(c *gin.Context) {
 			IsPassed      bool    `json:"is_passed"`
 			Interpretation string `json:"interpretation"`
 			CompletedAt   string  `json:"completed_at"`
+			AttemptNumber int     `json:"attempt_number"`
+			IsOfficial    bool    `json:"is_official"`
+			AssignmentStatus string `json:"assignment_status"`
+			Deadline      string  `json:"deadline"`
+			IsValid       bool    `json:"is_valid"`
+			VersionNumber int     `json:"version_number"`
 		}
+		var completedAtRaw sql.NullTime
+		var validityFlagsJSON string
 		
 		err := rows.Scan(&result.ID, &result.LastName, &result.FirstName, &result.Patronymic, 
 			&result.UserEmail, &result.TestTitle, &result.MethodologyType, &result.TotalScore, &result.MaxScore, 
-			&result.Percentage, &result.IsPassed, &result.Interpretation, &result.CompletedAt)
+			&result.Percentage, &result.IsPassed, &result.Interpretation, &result.CompletedAt,
+			&result.AttemptNumber, &result.IsOfficial, &completedAtRaw, &result.AssignmentStatus, &result.Deadline,
+			&result.IsValid, &validityFlagsJSON, &result.VersionNumber)
 		if err != nil {
 			continue
 		}
@@ -461,6 +791,16 @@ func GetAllResults(c *gin.Context) {
 			statusClass = "state-excellent"
 		}
 
+		// Недостоверный результат не получает вердикта о пригодности
+		validityFlags := []string{}
+		if !result.IsValid {
+			status = "⚠️ Недостоверно"
+			statusClass = "state-warning"
+			if validityFlagsJSON != "" {
+				json.Unmarshal([]byte(validityFlagsJSON), &validityFlags)
+			}
+		}
+
 		methodologyLabel := getMethodologyLabel(result.MethodologyType)
 		if result.TestTitle == "[Удаленный тест]" {
 			methodologyLabel = "Удаленный тест"
@@ -480,10 +820,20 @@ func GetAllResults(c *gin.Context) {
 			"status_class":    statusClass,
 			"interpretation":  result.Interpretation,
 			"completed_at":    result.CompletedAt,
+			"attempt_number":  result.AttemptNumber,
+			"is_official":     result.IsOfficial,
+			"assignment_status": result.AssignmentStatus,
+			"deadline":        result.Deadline,
+			"is_valid":        result.IsValid,
+			"validity_flags":  validityFlags,
+			"version_number":  result.VersionNumber,
 		})
 	}
 
-	c.JSON(http.StatusOK, gin.H{"results": results})
+	if results == nil {
+		results = []map[string]interface{}{}
+	}
+	c.JSON(http.StatusOK, gin.H{"results": results, "pagination": query.Pagination(total)})
 }
 
 // Создание теста
@@ -495,16 +845,12 @@ func CreateTest(c *gin.Context) {
 		EstimatedTime int    `json:"estimated_time"`
 		PassThreshold float64 `json:"pass_threshold"`
 		MethodologyType string `json:"methodology_type"`
-		Questions     []struct {
-			QuestionText string `json:"question_text"`
-			QuestionType string `json:"question_type"`
-			ScaleType    string `json:"scale_type"`
-			Weight       float64 `json:"weight"`
-			Options      []struct {
-				OptionText string `json:"option_text"`
-				ScoreValue int    `json:"score_value"`
-			} `json:"options"`
-		} `json:"questions"`
+		TimeLimit     int    `json:"time_limit"`
+		LateSubmissionPolicy string `json:"late_submission_policy"`
+		MaxAttempts   int    `json:"max_attempts"`
+		RetakeIntervalHours int `json:"retake_interval_hours"`
+		OfficialAttempt string `json:"official_attempt"`
+		Questions     []models.QuestionInput `json:"questions"`
 	}
 
 	if err := c.ShouldBindJSON(&createReq); err != nil {
@@ -512,42 +858,44 @@ func CreateTest(c *gin.Context) {
 		return
 	}
 
+	if message := normalizeQuestionInputs(createReq.Questions); message != "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": message})
+		return
+	}
+
 	userID, _ := c.Get("userID")
+
+	tx, err := database.DB.Begin()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка начала транзакции"})
+		return
+	}
+	defer tx.Rollback()
 	
 	var testID int
-	err := database.DB.QueryRow(`
-		INSERT INTO psychological_tests (title, description, instructions, estimated_time, pass_threshold, methodology_type, created_by)
-		VALUES ($1, $2, $3, $4, $5, $6, $7) RETURNING id
-	`, createReq.Title, createReq.Description, createReq.Instructions, createReq.EstimatedTime, createReq.PassThreshold, createReq.MethodologyType, userID).Scan(&testID)
+	err = tx.QueryRow(`
+		INSERT INTO psychological_tests (title, description, instructions, estimated_time, pass_threshold, methodology_type,
+		                                 time_limit, late_submission_policy, max_attempts, retake_interval_hours, official_attempt, created_by)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12) RETURNING id
+	`, createReq.Title, createReq.Description, createReq.Instructions, createReq.EstimatedTime, createReq.PassThreshold, createReq.MethodologyType,
+		createReq.TimeLimit, normalizeLatePolicy(createReq.LateSubmissionPolicy), createReq.MaxAttempts, createReq.RetakeIntervalHours,
+		normalizeOfficialAttempt(createReq.OfficialAttempt), userID).Scan(&testID)
 
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка создания теста"})
 		return
 	}
 
-	// Сохраняем вопросы теста и варианты ответов
-	for i, question := range createReq.Questions {
-		var questionID int
-		err := database.DB.QueryRow(`
-			INSERT INTO test_questions (test_id, question_text, question_type, scale_type, weight, order_index)
-			VALUES ($1, $2, $3, $4, $5, $6) RETURNING id
-		`, testID, question.QuestionText, question.QuestionType, question.ScaleType, question.Weight, i+1).Scan(&questionID)
-
-		if err != nil {
-			continue
-		}
-
-		// Сохраняем варианты ответов
-		for j, option := range question.Options {
-			_, err = database.DB.Exec(`
-				INSERT INTO question_options (question_id, option_text, score_value, order_index)
-				VALUES ($1, $2, $3, $4)
-			`, questionID, option.OptionText, option.ScoreValue, j+1)
+	// Вопросы теста и варианты ответов сохраняются как первая версия
+	if _, _, err := createTestVersion(tx, testID, userID, "Исходная версия", createReq.Questions, 0); err != nil {
+		log.Printf("Ошибка сохранения вопросов теста %d: %v", testID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка сохранения вопросов"})
+		return
+	}
 
-			if err != nil {
-				continue
-			}
-		}
+	if err := tx.Commit(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка завершения операции"})
+		return
 	}
 
 	c.JSON(http.StatusCreated, gin.H{
@@ -572,13 +920,21 @@ func GetTestForEdit(c *gin.Context) {
         EstimatedTime int     `json:"estimated_time"`
         PassThreshold float64 `json:"pass_threshold"`
         MethodologyType string `json:"methodology_type"`
+        TimeLimit     int     `json:"time_limit"`
+        LateSubmissionPolicy string `json:"late_submission_policy"`
+        MaxAttempts   int     `json:"max_attempts"`
+        RetakeIntervalHours int `json:"retake_interval_hours"`
+        OfficialAttempt string `json:"official_attempt"`
     }
 
     err = database.DB.QueryRow(`
-        SELECT id, title, description, instructions, estimated_time, pass_threshold, methodology_type
+        SELECT id, title, description, instructions, estimated_time, pass_threshold, methodology_type,
+               COALESCE(time_limit, 0), COALESCE(late_submission_policy, 'flag'),
+               COALESCE(max_attempts, 0), COALESCE(retake_interval_hours, 0), COALESCE(official_attempt, 'latest')
         FROM psychological_tests 
         WHERE id = $1
-    `, testID).Scan(&test.ID, &test.Title, &test.Description, &test.Instructions, &test.EstimatedTime, &test.PassThreshold, &test.MethodologyType)
+    `, testID).Scan(&test.ID, &test.Title, &test.Description, &test.Instructions, &test.EstimatedTime, &test.PassThreshold, &test.MethodologyType,
+        &test.TimeLimit, &test.LateSubmissionPolicy, &test.MaxAttempts, &test.RetakeIntervalHours, &test.OfficialAttempt)
 
     if err == sql.ErrNoRows {
         c.JSON(http.StatusNotFound, gin.H{"error": "Тест не найден"})
@@ -590,11 +946,12 @@ func GetTestForEdit(c *gin.Context) {
 
     // Получаем вопросы теста с вариантами ответов
     rows, err := database.DB.Query(`
-        SELECT q.id, q.question_text, q.question_type, q.scale_type, q.weight, q.order_index,
+        SELECT q.id, q.question_text, q.question_type, q.scale_type, q.weight, q.order_index, COALESCE(q.is_required, true),
+               COALESCE(q.min_value, 0), COALESCE(q.max_value, 0), COALESCE(q.scoring_key, 'direct'),
                o.id, o.option_text, o.score_value, o.order_index
         FROM test_questions q
         LEFT JOIN question_options o ON q.id = o.question_id
-        WHERE q.test_id = $1 
+        WHERE q.version_id = (SELECT current_version_id FROM psychological_tests WHERE id = $1)
         ORDER BY q.order_index, o.order_index
     `, testID)
     if err != nil {
@@ -610,6 +967,10 @@ func GetTestForEdit(c *gin.Context) {
         ScaleType    string `json:"scale_type"`
         Weight       float64 `json:"weight"`
         OrderIndex   int    `json:"order_index"`
+        IsRequired   bool   `json:"is_required"`
+        MinValue     int    `json:"min_value"`
+        MaxValue     int    `json:"max_value"`
+        ScoringKey   string `json:"scoring_key"`
         Options      []struct {
             ID         int    `json:"id"`
             OptionText string `json:"option_text"` // Теперь используем option_text для фронтенда
@@ -623,12 +984,15 @@ func GetTestForEdit(c *gin.Context) {
         var questionText, questionType, scaleType string
         var weight float64
         var orderIndex int
+        var isRequired bool
+        var minValue, maxValue int
+        var scoringKey string
         var optionID sql.NullInt64
         var optionText sql.NullString
         var scoreValue sql.NullInt64
         var optionOrder sql.NullInt64
 
-        err := rows.Scan(&questionID, &questionText, &questionType, &scaleType, &weight, &orderIndex,
+        err := rows.Scan(&questionID, &questionText, &questionType, &scaleType, &weight, &orderIndex, &isRequired, &minValue, &maxValue, &scoringKey,
             &optionID, &optionText, &scoreValue, &optionOrder)
         if err != nil {
             continue
@@ -642,6 +1006,10 @@ func GetTestForEdit(c *gin.Context) {
                 ScaleType    string `json:"scale_type"`
                 Weight       float64 `json:"weight"`
                 OrderIndex   int    `json:"order_index"`
+                IsRequired   bool   `json:"is_required"`
+                MinValue     int    `json:"min_value"`
+                MaxValue     int    `json:"max_value"`
+                ScoringKey   string `json:"scoring_key"`
                 Options      []struct {
                     ID         int    `json:"id"`
                     OptionText string `json:"option_text"`
@@ -651,10 +1019,14 @@ func GetTestForEdit(c *gin.Context) {
             }{
                 ID:           questionID,
                 QuestionText: questionText,
-                QuestionType: questionType,
+                QuestionType: normalizeQuestionType(questionType),
                 ScaleType:    scaleType,
                 Weight:       weight,
                 OrderIndex:   orderIndex,
+                IsRequired:   isRequired,
+                MinValue:     minValue,
+                MaxValue:     maxValue,
+                ScoringKey:   normalizeScoringKey(scoringKey),
                 Options:      []struct {
                     ID         int    `json:"id"`
                     OptionText string `json:"option_text"`
@@ -685,6 +1057,13 @@ func GetTestForEdit(c *gin.Context) {
         questions = append(questions, question)
     }
 
+    // История версий для редактора: изменение вопросов создаёт новую версию
+    versions, err := loadTestVersions(testID)
+    if err != nil {
+        log.Printf("Ошибка получения версий теста %d: %v", testID, err)
+        versions = []models.TestVersion{}
+    }
+
     c.JSON(http.StatusOK, gin.H{
         "test": map[string]interface{}{
             "id":              test.ID,
@@ -694,7 +1073,13 @@ func GetTestForEdit(c *gin.Context) {
             "estimated_time":  test.EstimatedTime,
             "pass_threshold":  test.PassThreshold,
             "methodology_type": test.MethodologyType,
+            "time_limit":      test.TimeLimit,
+            "late_submission_policy": test.LateSubmissionPolicy,
+            "max_attempts":    test.MaxAttempts,
+            "retake_interval_hours": test.RetakeIntervalHours,
+            "official_attempt": test.OfficialAttempt,
             "questions":       questions,
+            "versions":        versions,
         },
     })
 }
@@ -714,16 +1099,13 @@ func UpdateTest(c *gin.Context) {
 		EstimatedTime int    `json:"estimated_time"`
 		PassThreshold float64 `json:"pass_threshold"`
 		MethodologyType string `json:"methodology_type"`
-		Questions     []struct {
-			QuestionText string `json:"question_text"`
-			QuestionType string `json:"question_type"`
-			ScaleType    string `json:"scale_type"`
-			Weight       float64 `json:"weight"`
-			Options      []struct {
-				OptionText string `json:"option_text"`
-				ScoreValue int    `json:"score_value"`
-			} `json:"options"`
-		} `json:"questions"`
+		TimeLimit     int    `json:"time_limit"`
+		LateSubmissionPolicy string `json:"late_submission_policy"`
+		MaxAttempts   int    `json:"max_attempts"`
+		RetakeIntervalHours int `json:"retake_interval_hours"`
+		OfficialAttempt string `json:"official_attempt"`
+		VersionComment string `json:"version_comment"` // описание изменений для истории версий
+		Questions     []models.QuestionInput `json:"questions"`
 	}
 
 	if err := c.ShouldBindJSON(&updateReq); err != nil {
@@ -731,55 +1113,80 @@ func UpdateTest(c *gin.Context) {
 		return
 	}
 
-	// Обновляем основную информацию о тесте
-	_, err = database.DB.Exec(`
-		UPDATE psychological_tests 
-		SET title = $1, description = $2, instructions = $3, estimated_time = $4, pass_threshold = $5, methodology_type = $6
-		WHERE id = $7
-	`, updateReq.Title, updateReq.Description, updateReq.Instructions, updateReq.EstimatedTime, updateReq.PassThreshold, updateReq.MethodologyType, testID)
+	if message := normalizeQuestionInputs(updateReq.Questions); message != "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": message})
+		return
+	}
 
+	currentVersionID, _, err := findTestVersion(testID, 0)
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Тест не найден"})
+		return
+	} else if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка получения версии теста"})
+		return
+	}
+	currentQuestions, err := loadVersionQuestions(currentVersionID)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка обновления теста"})
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка получения вопросов"})
 		return
 	}
-
-	// Удаляем старые вопросы и варианты ответов
-	_, err = database.DB.Exec("DELETE FROM question_options WHERE question_id IN (SELECT id FROM test_questions WHERE test_id = $1)", testID)
+	var versionTitle string
+	var versionThreshold float64
+	err = database.DB.QueryRow("SELECT title, pass_threshold FROM test_versions WHERE id = $1", currentVersionID).
+		Scan(&versionTitle, &versionThreshold)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка обновления вариантов ответов"})
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка получения версии теста"})
 		return
 	}
 
-	_, err = database.DB.Exec("DELETE FROM test_questions WHERE test_id = $1", testID)
+	userID, _ := c.Get("userID")
+
+	tx, err := database.DB.Begin()
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка обновления вопросов"})
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка начала транзакции"})
 		return
 	}
+	defer tx.Rollback()
 
-	// Добавляем новые вопросы и варианты ответов
-	for i, question := range updateReq.Questions {
-		var questionID int
-		err := database.DB.QueryRow(`
-			INSERT INTO test_questions (test_id, question_text, question_type, scale_type, weight, order_index)
-			VALUES ($1, $2, $3, $4, $5, $6) RETURNING id
-		`, testID, question.QuestionText, question.QuestionType, question.ScaleType, question.Weight, i+1).Scan(&questionID)
-
-		if err != nil {
-			continue
-		}
+	// Обновляем основную информацию о тесте
+	_, err = tx.Exec(`
+		UPDATE psychological_tests 
+		SET title = $1, description = $2, instructions = $3, estimated_time = $4, pass_threshold = $5, methodology_type = $6,
+		    time_limit = $7, late_submission_policy = $8, max_attempts = $9, retake_interval_hours = $10, official_attempt = $11
+		WHERE id = $12
+	`, updateReq.Title, updateReq.Description, updateReq.Instructions, updateReq.EstimatedTime, updateReq.PassThreshold, updateReq.MethodologyType,
+		updateReq.TimeLimit, normalizeLatePolicy(updateReq.LateSubmissionPolicy), updateReq.MaxAttempts, updateReq.RetakeIntervalHours,
+		normalizeOfficialAttempt(updateReq.OfficialAttempt), testID)
 
-		// Сохраняем варианты ответов
-		for j, option := range question.Options {
-			_, err = database.DB.Exec(`
-				INSERT INTO question_options (question_id, option_text, score_value, order_index)
-				VALUES ($1, $2, $3, $4)
-			`, questionID, option.OptionText, option.ScoreValue, j+1)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка обновления теста"})
+		return
+	}
 
-			if err != nil {
-				continue
-			}
+	// Вопросы прошлых версий не изменяются: ответы старых результатов продолжают ссылаться на них.
+	// Новая версия создаётся, только если изменились вопросы, название или порог прохождения;
+	// настройки подсчёта переносятся в неё из текущей версии.
+	versionNumber := 0
+	// Порог сравнивается с точностью хранения DECIMAL(5,2)
+	thresholdChanged := math.Round(updateReq.PassThreshold*100) != math.Round(versionThreshold*100)
+	if len(compareVersionQuestions(currentQuestions, updateReq.Questions)) > 0 || updateReq.Title != versionTitle || thresholdChanged {
+		comment := strings.TrimSpace(updateReq.VersionComment)
+		if _, versionNumber, err = createTestVersion(tx, testID, userID, comment, updateReq.Questions, currentVersionID); err != nil {
+			log.Printf("Ошибка сохранения вопросов теста %d: %v", testID, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка сохранения вопросов"})
+			return
 		}
 	}
 
+	if err := tx.Commit(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка завершения операции"})
+		return
+	}
+
+	if versionNumber > 0 {
+		c.JSON(http.StatusOK, gin.H{"message": fmt.Sprintf("Тест обновлен, создана версия %d", versionNumber), "version_number": versionNumber})
+		return
+	}
 	c.JSON(http.StatusOK, gin.H{"message": "Тест обновлен"})
 }
@@ -0,0 +1,223 @@
+package handlers
+
+import (
+	"math"
+	"net/http"
+	"psycho-test-system/database"
+	"psycho-test-system/models"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Наибольшее число точек ряда: около трёх лет по дням
+const maxAnalyticsPoints = 1100
+
+// Период ряда по умолчанию для каждого шага, если не задана дата начала
+var analyticsDefaultSpan = map[string]func(time.Time) time.Time{
+	"day":   func(to time.Time) time.Time { return to.AddDate(0, 0, -30) },
+	"week":  func(to time.Time) time.Time { return to.AddDate(0, 0, -7*12) },
+	"month": func(to time.Time) time.Time { return to.AddDate(0, -12, 0) },
+}
+
+// analyticsPeriodStart - начало периода, в который попадает дата: день, неделя с понедельника или месяц
+func analyticsPeriodStart(t time.Time, interval string) time.Time {
+	day := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+	switch interval {
+	case "week":
+		return day.AddDate(0, 0, -(int(day.Weekday())+6)%7)
+	case "month":
+		return day.AddDate(0, 0, 1-day.Day())
+	}
+	return day
+}
+
+func analyticsNextPeriod(t time.Time, interval string) time.Time {
+	switch interval {
+	case "week":
+		return t.AddDate(0, 0, 7)
+	case "month":
+		return t.AddDate(0, 1, 0)
+	}
+	return t.AddDate(0, 0, 1)
+}
+
+func analyticsRate(part, total int) *float64 {
+	if total == 0 {
+		return nil
+	}
+	rate := math.Round(float64(part)*1000/float64(total)) / 10
+	return &rate
+}
+
+// Временные ряды для панели администратора: прохождения, процент прохождения (в целом и по
+// методикам), средний процент и новые регистрации. Параметры: interval (day, week, month),
+// from и to (ГГГГ-ММ-ДД), test_id. Периоды без данных возвращаются с нулями.
+func GetAdminAnalytics(c *gin.Context) {
+	interval := c.DefaultQuery("interval", "day")
+	defaultFrom, ok := analyticsDefaultSpan[interval]
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Шаг ряда interval принимает значения day, week или month"})
+		return
+	}
+
+	var testID int
+	if value := c.Query("test_id"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed < 1 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Неверный ID теста"})
+			return
+		}
+		testID = parsed
+	}
+
+	fromArg, toArg, message := parseDateRange(c)
+	if message != "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": message})
+		return
+	}
+	to := analyticsPeriodStart(time.Now(), "day").AddDate(0, 0, 1)
+	if toArg != nil {
+		to = toArg.(time.Time)
+	}
+	from := defaultFrom(to)
+	if fromArg != nil {
+		from = fromArg.(time.Time)
+	}
+	if !from.Before(to) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Дата начала периода позже даты окончания"})
+		return
+	}
+
+	// Границы расширяются до целых периодов, чтобы первая и последняя точки были сопоставимы с остальными
+	from = analyticsPeriodStart(from, interval)
+	if end := analyticsPeriodStart(to, interval); end.Before(to) {
+		to = analyticsNextPeriod(end, interval)
+	}
+
+	var points []models.AnalyticsPoint
+	index := make(map[string]int)
+	for period := from; period.Before(to); period = analyticsNextPeriod(period, interval) {
+		if len(points) == maxAnalyticsPoints {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Слишком длинный период для выбранного шага, уменьшите период или увеличьте шаг"})
+			return
+		}
+		index[period.Format(exportDateLayout)] = len(points)
+		points = append(points, models.AnalyticsPoint{Period: period.Format(exportDateLayout)})
+	}
+
+	rows, err := database.DB.Query(`
+		SELECT TO_CHAR(date_trunc($1, tr.completed_at), 'YYYY-MM-DD') as period,
+		       COALESCE(pt.methodology_type, 'Неизвестно') as methodology_type,
+		       COUNT(*) as submissions,
+		       COUNT(*) FILTER (WHERE COALESCE(tr.is_valid, true)) as valid,
+		       COUNT(*) FILTER (WHERE COALESCE(tr.is_valid, true) AND tr.is_passed) as passed,
+		       COALESCE(SUM(tr.percentage) FILTER (WHERE COALESCE(tr.is_valid, true)), 0) as percentage_sum
+		FROM test_results tr
+		LEFT JOIN psychological_tests pt ON pt.id = tr.test_id
+		WHERE tr.completed_at >= $2 AND tr.completed_at < $3 AND ($4 = 0 OR tr.test_id = $4)
+		GROUP BY 1, 2
+	`, interval, from, to, testID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка получения аналитики прохождений"})
+		return
+	}
+	defer rows.Close()
+
+	percentageSums := make([]float64, len(points))
+	methodologies := make(map[string]*models.MethodologySeries)
+	var methodologyOrder []string
+	for rows.Next() {
+		var period, methodology string
+		var submissions, valid, passed int
+		var percentageSum float64
+		if err := rows.Scan(&period, &methodology, &submissions, &valid, &passed, &percentageSum); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка получения аналитики прохождений"})
+			return
+		}
+		i, ok := index[period]
+		if !ok {
+			continue
+		}
+		points[i].Submissions += submissions
+		points[i].Valid += valid
+		points[i].Passed += passed
+		percentageSums[i] += percentageSum
+
+		series := methodologies[methodology]
+		if series == nil {
+			series = &models.MethodologySeries{Methodology: methodology, Label: getMethodologyLabel(methodology),
+				Points: make([]models.AnalyticsPoint, len(points))}
+			for j := range series.Points {
+				series.Points[j].Period = points[j].Period
+			}
+			methodologies[methodology] = series
+			methodologyOrder = append(methodologyOrder, methodology)
+		}
+		series.Points[i].Submissions = submissions
+		series.Points[i].Valid = valid
+		series.Points[i].Passed = passed
+		series.Points[i].PassRate = analyticsRate(passed, valid)
+		if valid > 0 {
+			average := math.Round(percentageSum/float64(valid)*10) / 10
+			series.Points[i].AveragePercentage = &average
+		}
+	}
+	if err := rows.Err(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка получения аналитики прохождений"})
+		return
+	}
+
+	// Регистрации не зависят от теста, поэтому считаются по всем пользователям
+	userRows, err := database.DB.Query(`
+		SELECT TO_CHAR(date_trunc($1, created_at), 'YYYY-MM-DD') as period, COUNT(*)
+		FROM users
+		WHERE created_at >= $2 AND created_at < $3
+		GROUP BY 1
+	`, interval, from, to)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка получения аналитики регистраций"})
+		return
+	}
+	defer userRows.Close()
+	for userRows.Next() {
+		var period string
+		var count int
+		if err := userRows.Scan(&period, &count); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка получения аналитики регистраций"})
+			return
+		}
+		if i, ok := index[period]; ok {
+			points[i].NewUsers = count
+		}
+	}
+	if err := userRows.Err(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка получения аналитики регистраций"})
+		return
+	}
+
+	for i := range points {
+		points[i].PassRate = analyticsRate(points[i].Passed, points[i].Valid)
+		if points[i].Valid > 0 {
+			average := math.Round(percentageSums[i]/float64(points[i].Valid)*10) / 10
+			points[i].AveragePercentage = &average
+		}
+	}
+
+	byMethodology := make([]models.MethodologySeries, 0, len(methodologyOrder))
+	sort.Strings(methodologyOrder)
+	for _, methodology := range methodologyOrder {
+		byMethodology = append(byMethodology, *methodologies[methodology])
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"interval":       interval,
+		"from":           from.Format(exportDateLayout),
+		"to":             to.AddDate(0, 0, -1).Format(exportDateLayout),
+		"test_id":        testID,
+		"series":         points,
+		"by_methodology": byMethodology,
+	})
+}
@@ -0,0 +1,79 @@
+package handlers
+
+import (
+	"fmt"
+	"psycho-test-system/models"
+)
+
+// validateAnswers проверяет отправленные ответы по вопросам теста и возвращает
+// сопоставление ID вопроса -> нормализованный ответ. Кроме проверок validateAnswerItems
+// требуется, чтобы были отвечены все обязательные вопросы.
+func validateAnswers(questions []*scoringQuestion, answers []models.AnswerSubmission) (map[int]models.AnswerSubmission, []models.FieldError) {
+	selected, seen, fieldErrors := validateAnswerItems(questions, answers)
+
+	for _, question := range questions {
+		if !question.IsRequired {
+			continue
+		}
+		if seen[question.ID] {
+			continue
+		}
+		fieldErrors = append(fieldErrors, models.FieldError{
+			Field:      "answers",
+			QuestionID: question.ID,
+			Message:    fmt.Sprintf("Нет ответа на обязательный вопрос №%d", question.OrderIndex),
+		})
+	}
+
+	return selected, fieldErrors
+}
+
+// validateAnswerItems проверяет каждый ответ по отдельности: вопрос принадлежит тесту,
+// на вопрос нет повторного ответа, ответ соответствует типу вопроса (validateQuestionAnswer). Подходит и для черновиков,
+// в которых отвечена только часть вопросов.
+func validateAnswerItems(questions []*scoringQuestion, answers []models.AnswerSubmission) (map[int]models.AnswerSubmission, map[int]bool, []models.FieldError) {
+	byID := make(map[int]*scoringQuestion, len(questions))
+	for _, question := range questions {
+		byID[question.ID] = question
+	}
+
+	selected := make(map[int]models.AnswerSubmission, len(answers))
+	seen := make(map[int]bool, len(answers))
+	var fieldErrors []models.FieldError
+
+	for i, answer := range answers {
+		question, exists := byID[answer.QuestionID]
+		if !exists {
+			fieldErrors = append(fieldErrors, models.FieldError{
+				Field:      fmt.Sprintf("answers[%d].question_id", i),
+				QuestionID: answer.QuestionID,
+				Message:    "Вопрос не относится к этому тесту",
+			})
+			continue
+		}
+
+		if seen[answer.QuestionID] {
+			fieldErrors = append(fieldErrors, models.FieldError{
+				Field:      fmt.Sprintf("answers[%d].question_id", i),
+				QuestionID: answer.QuestionID,
+				Message:    fmt.Sprintf("Повторный ответ на вопрос №%d", question.OrderIndex),
+			})
+			continue
+		}
+		seen[answer.QuestionID] = true
+
+		normalized, field, message := validateQuestionAnswer(question, answer)
+		if message != "" {
+			fieldErrors = append(fieldErrors, models.FieldError{
+				Field:      fmt.Sprintf("answers[%d].%s", i, field),
+				QuestionID: answer.QuestionID,
+				Message:    message,
+			})
+			continue
+		}
+
+		selected[answer.QuestionID] = normalized
+	}
+
+	return selected, seen, fieldErrors
+}
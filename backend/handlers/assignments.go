@@ -0,0 +1,420 @@
+package handlers
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"net/http"
+	"psycho-test-system/database"
+	"psycho-test-system/models"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/lib/pq"
+)
+
+// assignmentStatusSQL вычисляет статус назначения ta: пройден после назначения,
+// просрочен, начат (есть активная сессия) или только назначен
+const assignmentStatusSQL = `
+	CASE
+		WHEN EXISTS (SELECT 1 FROM test_results r
+		             WHERE r.user_id = ta.user_id AND r.test_id = ta.test_id AND r.completed_at >= ta.assigned_at) THEN 'completed'
+		WHEN ta.deadline IS NOT NULL AND ta.deadline < NOW() THEN 'overdue'
+		WHEN EXISTS (SELECT 1 FROM test_sessions s
+		             WHERE s.user_id = ta.user_id AND s.test_id = ta.test_id AND s.status = 'active'
+		               AND s.started_at >= ta.assigned_at) THEN 'started'
+		ELSE 'assigned'
+	END`
+
+var deadlineLayouts = []string{"2006-01-02T15:04:05Z07:00", "2006-01-02T15:04", "2006-01-02 15:04", "2006-01-02"}
+
+// parseDeadline разбирает срок выполнения. Время берётся как есть (по часам сервера БД),
+// дата без времени означает конец дня.
+func parseDeadline(value string) (interface{}, error) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return nil, nil
+	}
+	for _, layout := range deadlineLayouts {
+		deadline, err := time.Parse(layout, value)
+		if err != nil {
+			continue
+		}
+		if layout == "2006-01-02" {
+			deadline = deadline.Add(24*time.Hour - time.Second)
+		}
+		return deadline.Format("2006-01-02 15:04:05"), nil
+	}
+	return nil, fmt.Errorf("неверный формат срока: %s", value)
+}
+
+// isTestAssigned проверяет, назначен ли тест пользователю
+func isTestAssigned(userID, testID int) (bool, error) {
+	var assigned bool
+	err := database.DB.QueryRow(`
+		SELECT EXISTS(SELECT 1 FROM test_assignments WHERE user_id = $1 AND test_id = $2)
+	`, userID, testID).Scan(&assigned)
+	return assigned, err
+}
+
+// isAssignmentOverdue проверяет, истёк ли срок назначения теста пользователю
+func isAssignmentOverdue(userID, testID int) (bool, error) {
+	var overdue bool
+	err := database.DB.QueryRow(`
+		SELECT EXISTS(SELECT 1 FROM test_assignments
+		              WHERE user_id = $1 AND test_id = $2 AND deadline IS NOT NULL AND deadline < NOW())
+	`, userID, testID).Scan(&overdue)
+	return overdue, err
+}
+
+// syncGroupAssignments приводит назначения участников группы в соответствие с назначениями самой
+// группы: бывшие участники теряют выданные через неё тесты, новые получают их со сроком группы.
+// Если тест назначен пользователю и через другую группу, назначение переходит к ней.
+// Возвращает число отозванных назначений.
+func syncGroupAssignments(tx *sql.Tx, groupID int) (int, error) {
+	rows, err := tx.Query(`
+		DELETE FROM test_assignments ta
+		WHERE ta.group_id = $1
+		  AND (NOT EXISTS (SELECT 1 FROM user_group_members m WHERE m.group_id = $1 AND m.user_id = ta.user_id)
+		       OR NOT EXISTS (SELECT 1 FROM test_group_assignments ga WHERE ga.group_id = $1 AND ga.test_id = ta.test_id))
+		RETURNING ta.user_id
+	`, groupID)
+	if err != nil {
+		return 0, err
+	}
+	var removed []int64
+	for rows.Next() {
+		var userID int64
+		if err := rows.Scan(&userID); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		removed = append(removed, userID)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	_, err = tx.Exec(`
+		INSERT INTO test_assignments (test_id, user_id, group_id, deadline, assigned_by)
+		SELECT DISTINCT ON (ga.test_id, m.user_id) ga.test_id, m.user_id, ga.group_id, ga.deadline, ga.assigned_by
+		FROM test_group_assignments ga
+		JOIN user_group_members m ON m.group_id = ga.group_id
+		WHERE m.group_id = $1 OR m.user_id = ANY($2)
+		ORDER BY ga.test_id, m.user_id, ga.assigned_at DESC
+		ON CONFLICT (test_id, user_id) DO NOTHING
+	`, groupID, pq.Array(removed))
+	return len(removed), err
+}
+
+// requireTestAssignment пропускает администратора и кандидата с назначенным тестом,
+// иначе отвечает 403. Возвращает false, если ответ уже отправлен.
+func requireTestAssignment(c *gin.Context, testID int) bool {
+	role, _ := c.Get("userRole")
+	if role == models.RoleAdmin {
+		return true
+	}
+
+	userID, _ := c.Get("userID")
+	assigned, err := isTestAssigned(userID.(int), testID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка проверки назначения теста"})
+		return false
+	}
+	if !assigned {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Тест вам не назначен"})
+		return false
+	}
+	return true
+}
+
+// loadAssignments загружает назначения с вычисленным статусом; filter - условие WHERE по ta
+func loadAssignments(filter string, args ...interface{}) ([]models.TestAssignment, error) {
+	rows, err := database.DB.Query(`
+		SELECT ta.id, ta.test_id, COALESCE(pt.title, '[Удаленный тест]'), ta.user_id,
+		       u.last_name || ' ' || u.first_name || ' ' || COALESCE(u.patronymic, ''), u.email,
+		       ta.group_id, ta.deadline, ta.assigned_at, `+assignmentStatusSQL+`
+		FROM test_assignments ta
+		JOIN users u ON u.id = ta.user_id
+		LEFT JOIN psychological_tests pt ON pt.id = ta.test_id
+		WHERE `+filter+`
+		ORDER BY ta.assigned_at DESC, u.last_name
+	`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	assignments := []models.TestAssignment{}
+	for rows.Next() {
+		var assignment models.TestAssignment
+		var groupID sql.NullInt64
+		var deadline sql.NullTime
+		if err := rows.Scan(&assignment.ID, &assignment.TestID, &assignment.TestTitle, &assignment.UserID,
+			&assignment.UserName, &assignment.UserEmail, &groupID, &deadline, &assignment.AssignedAt, &assignment.Status); err != nil {
+			return nil, err
+		}
+		if groupID.Valid {
+			id := int(groupID.Int64)
+			assignment.GroupID = &id
+		}
+		if deadline.Valid {
+			assignment.Deadline = &deadline.Time
+		}
+		assignments = append(assignments, assignment)
+	}
+	return assignments, rows.Err()
+}
+
+// Получение назначений теста
+func GetTestAssignments(c *gin.Context) {
+	testID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Неверный ID теста"})
+		return
+	}
+
+	assignments, err := loadAssignments("ta.test_id = $1", testID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка получения назначений"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"assignments": assignments})
+}
+
+// Получение всех назначений; параметры test_id и status фильтруют по тесту и статусу
+func GetAllAssignments(c *gin.Context) {
+	filter, args := "TRUE", []interface{}{}
+	if testIDParam := c.Query("test_id"); testIDParam != "" {
+		testID, err := strconv.Atoi(testIDParam)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Неверный ID теста"})
+			return
+		}
+		filter, args = "ta.test_id = $1", append(args, testID)
+	}
+
+	assignments, err := loadAssignments(filter, args...)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка получения назначений"})
+		return
+	}
+
+	if status := c.Query("status"); status != "" {
+		filtered := []models.TestAssignment{}
+		for _, assignment := range assignments {
+			if assignment.Status == status {
+				filtered = append(filtered, assignment)
+			}
+		}
+		assignments = filtered
+	}
+
+	c.JSON(http.StatusOK, gin.H{"assignments": assignments})
+}
+
+// Назначение теста пользователям и группам
+func AssignTest(c *gin.Context) {
+	testID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Неверный ID теста"})
+		return
+	}
+
+	var archived bool
+	err = database.DB.QueryRow("SELECT archived_at IS NOT NULL FROM psychological_tests WHERE id = $1", testID).Scan(&archived)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Тест не найден"})
+		return
+	}
+	if archived {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Тест находится в архиве"})
+		return
+	}
+
+	assignedCount, ok := assignTests(c, []int{testID})
+	if !ok {
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":        "Тест назначен",
+		"assigned_count": assignedCount,
+	})
+}
+
+// assignTests разбирает models.AssignTestRequest и назначает тесты testIDs пользователям и
+// группам. Назначение группы сохраняется и создаётся каждому текущему участнику, а при изменении
+// состава группы синхронизируется (syncGroupAssignments); повторное назначение обновляет срок
+// и сбрасывает статус. При ошибке отвечает сам и возвращает false.
+func assignTests(c *gin.Context, testIDs []int) (int, bool) {
+	var assignReq models.AssignTestRequest
+	if err := c.ShouldBindJSON(&assignReq); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Неверный формат данных"})
+		return 0, false
+	}
+	if len(assignReq.UserIDs) == 0 && len(assignReq.GroupIDs) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Укажите пользователей или группы"})
+		return 0, false
+	}
+
+	deadline, err := parseDeadline(assignReq.Deadline)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Неверный формат срока. Используйте ГГГГ-ММ-ДД или ГГГГ-ММ-ДДTЧЧ:ММ"})
+		return 0, false
+	}
+
+	adminID, _ := c.Get("userID")
+
+	tx, err := database.DB.Begin()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка начала транзакции"})
+		return 0, false
+	}
+	defer tx.Rollback()
+
+	// user_id -> group_id (nil для прямого назначения)
+	targets := make(map[int]interface{})
+	var order []int
+	addTarget := func(userID int, groupID interface{}) {
+		if _, exists := targets[userID]; !exists {
+			order = append(order, userID)
+		}
+		targets[userID] = groupID
+	}
+
+	groupRows := make([][]interface{}, 0, len(assignReq.GroupIDs)*len(testIDs))
+	for _, groupID := range assignReq.GroupIDs {
+		for _, testID := range testIDs {
+			groupRows = append(groupRows, []interface{}{testID, groupID, deadline, adminID})
+		}
+	}
+	err = upsertBatch(tx, "test_group_assignments", []string{"test_id", "group_id", "deadline", "assigned_by"}, groupRows, `
+		ON CONFLICT (test_id, group_id) DO UPDATE
+		SET deadline = EXCLUDED.deadline, assigned_by = EXCLUDED.assigned_by, assigned_at = NOW()`)
+	if err != nil {
+		if strings.Contains(err.Error(), "foreign key") {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Указана несуществующая группа"})
+		} else {
+			log.Printf("Ошибка назначения теста группам: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка назначения теста группе"})
+		}
+		return 0, false
+	}
+
+	for _, groupID := range assignReq.GroupIDs {
+		rows, err := tx.Query("SELECT user_id FROM user_group_members WHERE group_id = $1", groupID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка получения участников группы"})
+			return 0, false
+		}
+		for rows.Next() {
+			var userID int
+			if err := rows.Scan(&userID); err == nil {
+				addTarget(userID, groupID)
+			}
+		}
+		rows.Close()
+	}
+	// Прямое назначение имеет приоритет над групповым
+	for _, userID := range assignReq.UserIDs {
+		addTarget(userID, nil)
+	}
+
+	rows := make([][]interface{}, 0, len(order)*len(testIDs))
+	for _, testID := range testIDs {
+		for _, userID := range order {
+			rows = append(rows, []interface{}{testID, userID, targets[userID], deadline, adminID})
+		}
+	}
+	err = upsertBatch(tx, "test_assignments", []string{"test_id", "user_id", "group_id", "deadline", "assigned_by"}, rows, `
+		ON CONFLICT (test_id, user_id) DO UPDATE
+		SET group_id = EXCLUDED.group_id, deadline = EXCLUDED.deadline,
+		    assigned_by = EXCLUDED.assigned_by, assigned_at = NOW()`)
+	if err != nil {
+		if strings.Contains(err.Error(), "foreign key") {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Указан несуществующий пользователь"})
+		} else {
+			log.Printf("Ошибка назначения теста пользователям: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка назначения теста"})
+		}
+		return 0, false
+	}
+
+	if err := tx.Commit(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка завершения операции"})
+		return 0, false
+	}
+	return len(order), true
+}
+
+// Отмена назначения теста пользователю
+func UnassignTestUser(c *gin.Context) {
+	testID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Неверный ID теста"})
+		return
+	}
+	userID, err := strconv.Atoi(c.Param("userId"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Неверный ID пользователя"})
+		return
+	}
+
+	result, err := database.DB.Exec("DELETE FROM test_assignments WHERE test_id = $1 AND user_id = $2", testID, userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка отмены назначения"})
+		return
+	}
+	if affected, _ := result.RowsAffected(); affected == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Назначение не найдено"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Назначение отменено"})
+}
+
+// Отмена назначения теста группе (назначения участникам напрямую не затрагиваются)
+func UnassignTestGroup(c *gin.Context) {
+	testID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Неверный ID теста"})
+		return
+	}
+	groupID, err := strconv.Atoi(c.Param("groupId"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Неверный ID группы"})
+		return
+	}
+
+	tx, err := database.DB.Begin()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка начала транзакции"})
+		return
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec("DELETE FROM test_group_assignments WHERE test_id = $1 AND group_id = $2", testID, groupID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка отмены назначения"})
+		return
+	}
+	removed, err := syncGroupAssignments(tx, groupID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка отмены назначения"})
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка завершения операции"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":       "Назначение группе отменено",
+		"removed_count": removed,
+	})
+}
@@ -0,0 +1,45 @@
+package handlers
+
+import (
+	"database/sql"
+	"fmt"
+	"psycho-test-system/database"
+	"psycho-test-system/models"
+)
+
+func normalizeOfficialAttempt(official string) string {
+	if official == models.OfficialAttemptFirst {
+		return models.OfficialAttemptFirst
+	}
+	return models.OfficialAttemptLatest
+}
+
+// checkAttemptPolicy проверяет, может ли пользователь пройти тест ещё раз.
+// Возвращает текст причины отказа или пустую строку, если попытка разрешена.
+func checkAttemptPolicy(userID, testID int) (string, error) {
+	var maxAttempts, attemptsUsed int
+	var waitSeconds sql.NullInt64
+
+	err := database.DB.QueryRow(`
+		SELECT COALESCE(pt.max_attempts, 0),
+		       COUNT(tr.id),
+		       EXTRACT(EPOCH FROM (MAX(tr.completed_at) + make_interval(hours => COALESCE(pt.retake_interval_hours, 0)) - NOW()))::int
+		FROM psychological_tests pt
+		LEFT JOIN test_results tr ON tr.test_id = pt.id AND tr.user_id = $1
+		WHERE pt.id = $2
+		GROUP BY pt.id
+	`, userID, testID).Scan(&maxAttempts, &attemptsUsed, &waitSeconds)
+	if err != nil {
+		return "", err
+	}
+
+	if maxAttempts > 0 && attemptsUsed >= maxAttempts {
+		return fmt.Sprintf("Использованы все попытки прохождения теста (%d из %d)", attemptsUsed, maxAttempts), nil
+	}
+	if waitSeconds.Valid && waitSeconds.Int64 > 0 {
+		hours := waitSeconds.Int64 / 3600
+		minutes := (waitSeconds.Int64%3600 + 59) / 60
+		return fmt.Sprintf("Повторное прохождение теста будет доступно через %d ч %d мин", hours, minutes), nil
+	}
+	return "", nil
+}
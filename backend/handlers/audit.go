@@ -0,0 +1,91 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"psycho-test-system/database"
+	"psycho-test-system/models"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Записей журнала аудита на страницу по умолчанию и максимум
+const (
+	defaultAuditLimit = 100
+	maxAuditLimit     = 1000
+)
+
+// writeAudit добавляет запись в журнал аудита в рамках транзакции операции,
+// поэтому действие и запись о нём фиксируются или откатываются вместе
+func writeAudit(tx *sql.Tx, userID interface{}, action, entityType string, entityID int, details interface{}) error {
+	detailsJSON, err := json.Marshal(details)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(`
+		INSERT INTO audit_log (user_id, action, entity_type, entity_id, details)
+		VALUES ($1, $2, $3, $4, $5)
+	`, userID, action, entityType, entityID, string(detailsJSON))
+	return err
+}
+
+// Журнал аудита административных операций; фильтры entity_type и entity_id необязательны
+func GetAuditLog(c *gin.Context) {
+	limit := defaultAuditLimit
+	if limitParam := c.Query("limit"); limitParam != "" {
+		parsed, err := strconv.Atoi(limitParam)
+		if err != nil || parsed < 1 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Неверное количество записей"})
+			return
+		}
+		limit = min(parsed, maxAuditLimit)
+	}
+	entityID := 0
+	if entityParam := c.Query("entity_id"); entityParam != "" {
+		parsed, err := strconv.Atoi(entityParam)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Неверный ID объекта"})
+			return
+		}
+		entityID = parsed
+	}
+
+	rows, err := database.DB.Query(`
+		SELECT a.id, a.user_id, COALESCE(u.last_name || ' ' || u.first_name, ''), a.action,
+		       a.entity_type, a.entity_id, COALESCE(a.details::text, ''), a.created_at
+		FROM audit_log a
+		LEFT JOIN users u ON u.id = a.user_id
+		WHERE ($1 = '' OR a.entity_type = $1) AND ($2 = 0 OR a.entity_id = $2)
+		ORDER BY a.created_at DESC, a.id DESC
+		LIMIT $3
+	`, c.Query("entity_type"), entityID, limit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка получения журнала аудита"})
+		return
+	}
+	defer rows.Close()
+
+	entries := []models.AuditEntry{}
+	for rows.Next() {
+		var entry models.AuditEntry
+		var userID sql.NullInt64
+		var details string
+		if err := rows.Scan(&entry.ID, &userID, &entry.UserName, &entry.Action,
+			&entry.EntityType, &entry.EntityID, &details, &entry.CreatedAt); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка чтения журнала аудита"})
+			return
+		}
+		if userID.Valid {
+			id := int(userID.Int64)
+			entry.UserID = &id
+		}
+		if details != "" {
+			entry.Details = json.RawMessage(details)
+		}
+		entries = append(entries, entry)
+	}
+
+	c.JSON(http.StatusOK, gin.H{"entries": entries})
+}
@@ -0,0 +1,543 @@
+package handlers
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"psycho-test-system/database"
+	"psycho-test-system/models"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// batteryAssignedSQL - все тесты батареи b назначены пользователю $1
+const batteryAssignedSQL = `NOT EXISTS (
+	SELECT 1 FROM battery_tests bt
+	WHERE bt.battery_id = b.id
+	  AND NOT EXISTS (SELECT 1 FROM test_assignments ta WHERE ta.test_id = bt.test_id AND ta.user_id = $1))`
+
+// normalizeBatteryRule подставляет правило по умолчанию; неизвестное правило возвращается пустым
+func normalizeBatteryRule(rule string) string {
+	switch strings.TrimSpace(rule) {
+	case "", models.BatteryRuleAllPassed:
+		return models.BatteryRuleAllPassed
+	case models.BatteryRuleWeighted:
+		return models.BatteryRuleWeighted
+	}
+	return ""
+}
+
+// validateBatteryRequest проверяет запрос и приводит правило и веса к допустимым значениям
+func validateBatteryRequest(batteryReq *models.BatteryRequest) string {
+	batteryReq.Title = strings.TrimSpace(batteryReq.Title)
+	if batteryReq.Title == "" {
+		return "Название батареи обязательно"
+	}
+	if len(batteryReq.Tests) == 0 {
+		return "Батарея должна содержать хотя бы один тест"
+	}
+
+	batteryReq.PassRule = normalizeBatteryRule(batteryReq.PassRule)
+	if batteryReq.PassRule == "" {
+		return "Правило пригодности pass_rule принимает значения all_passed или weighted"
+	}
+	if batteryReq.PassRule == models.BatteryRuleWeighted &&
+		(batteryReq.PassThreshold <= 0 || batteryReq.PassThreshold > 100) {
+		return "Порог взвешенного результата должен быть от 0 до 100"
+	}
+
+	seen := make(map[int]bool, len(batteryReq.Tests))
+	for i := range batteryReq.Tests {
+		test := &batteryReq.Tests[i]
+		if seen[test.TestID] {
+			return fmt.Sprintf("Тест %d указан в батарее дважды", test.TestID)
+		}
+		seen[test.TestID] = true
+		test.OrderIndex = i + 1
+		if test.Weight <= 0 {
+			test.Weight = 1
+		}
+		// battery_tests.weight - DECIMAL(5,2), вес хранится с точностью до сотых
+		if math.Round(test.Weight*100) >= 100000 {
+			return fmt.Sprintf("Вес теста %d должен быть меньше 1000", test.TestID)
+		}
+	}
+	return ""
+}
+
+// respondBatteryTestsError отвечает об ошибке saveBatteryTests: несуществующий тест -
+// ошибка запроса, остальное логируется как ошибка сервера
+func respondBatteryTestsError(c *gin.Context, batteryID int, err error) {
+	if strings.Contains(err.Error(), "foreign key") {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "В батарее указан несуществующий тест"})
+		return
+	}
+	log.Printf("Ошибка сохранения тестов батареи %d: %v", batteryID, err)
+	c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка сохранения тестов батареи"})
+}
+
+// loadBatteries загружает батареи с составом; filter - условие WHERE по b
+func loadBatteries(filter string, args ...interface{}) ([]models.TestBattery, error) {
+	rows, err := database.DB.Query(`
+		SELECT b.id, b.title, COALESCE(b.description, ''), b.pass_rule, b.pass_threshold, b.is_active, b.created_at,
+		       bt.test_id, COALESCE(pt.title, ''), bt.order_index, bt.weight
+		FROM test_batteries b
+		LEFT JOIN battery_tests bt ON bt.battery_id = b.id
+		LEFT JOIN psychological_tests pt ON pt.id = bt.test_id
+		WHERE `+filter+`
+		ORDER BY b.id, bt.order_index
+	`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	batteries := []models.TestBattery{}
+	for rows.Next() {
+		var battery models.TestBattery
+		var testID, orderIndex sql.NullInt64
+		var weight sql.NullFloat64
+		var testTitle string
+		if err := rows.Scan(&battery.ID, &battery.Title, &battery.Description, &battery.PassRule, &battery.PassThreshold,
+			&battery.IsActive, &battery.CreatedAt, &testID, &testTitle, &orderIndex, &weight); err != nil {
+			return nil, err
+		}
+
+		if len(batteries) == 0 || batteries[len(batteries)-1].ID != battery.ID {
+			battery.Tests = []models.BatteryTest{}
+			batteries = append(batteries, battery)
+		}
+		if testID.Valid {
+			last := &batteries[len(batteries)-1]
+			last.Tests = append(last.Tests, models.BatteryTest{
+				TestID:     int(testID.Int64),
+				TestTitle:  testTitle,
+				OrderIndex: int(orderIndex.Int64),
+				Weight:     weight.Float64,
+			})
+		}
+	}
+	return batteries, rows.Err()
+}
+
+func loadBattery(batteryID int) (*models.TestBattery, error) {
+	batteries, err := loadBatteries("b.id = $1", batteryID)
+	if err != nil {
+		return nil, err
+	}
+	if len(batteries) == 0 {
+		return nil, sql.ErrNoRows
+	}
+	return &batteries[0], nil
+}
+
+// batteryParticipant - официальные результаты одного пользователя по тестам батареи
+type batteryParticipant struct {
+	UserName  string
+	UserEmail string
+	Results   map[int]models.BatteryTestProgress
+	order     int
+}
+
+// loadBatteryResults загружает официальные попытки (по правилу official_attempt теста среди
+// достоверных результатов) по тестам батареи. userID = 0 - по всем пользователям.
+func loadBatteryResults(batteryID, userID int) (map[int]*batteryParticipant, error) {
+	rows, err := database.DB.Query(`
+		SELECT DISTINCT ON (tr.user_id, bt.test_id)
+		       tr.user_id, u.last_name || ' ' || u.first_name || ' ' || COALESCE(u.patronymic, ''), u.email,
+		       bt.test_id, tr.id, tr.percentage, tr.is_passed
+		FROM battery_tests bt
+		JOIN psychological_tests pt ON pt.id = bt.test_id
+		JOIN test_results tr ON tr.test_id = bt.test_id
+		JOIN users u ON u.id = tr.user_id
+		WHERE bt.battery_id = $1 AND ($2 = 0 OR tr.user_id = $2) AND COALESCE(tr.is_valid, true)
+		ORDER BY tr.user_id, bt.test_id,
+		         CASE WHEN COALESCE(pt.official_attempt, 'latest') = 'first' THEN tr.completed_at END ASC,
+		         tr.completed_at DESC
+	`, batteryID, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	participants := make(map[int]*batteryParticipant)
+	for rows.Next() {
+		var participantID, testID int
+		var userName, userEmail string
+		var progress models.BatteryTestProgress
+		if err := rows.Scan(&participantID, &userName, &userEmail, &testID,
+			&progress.ResultID, &progress.Percentage, &progress.IsPassed); err != nil {
+			return nil, err
+		}
+
+		participant, exists := participants[participantID]
+		if !exists {
+			participant = &batteryParticipant{
+				UserName:  strings.TrimSpace(userName),
+				UserEmail: userEmail,
+				Results:   make(map[int]models.BatteryTestProgress),
+				order:     len(participants),
+			}
+			participants[participantID] = participant
+		}
+		progress.Completed = true
+		participant.Results[testID] = progress
+	}
+	return participants, rows.Err()
+}
+
+// evaluateBattery строит итоговое заключение по батарее из отдельных результатов тестов.
+// Пока пройдены не все тесты, заключение о пригодности не выносится.
+func evaluateBattery(battery *models.TestBattery, userID int, results map[int]models.BatteryTestProgress) models.BatteryVerdict {
+	verdict := models.BatteryVerdict{
+		BatteryID:  battery.ID,
+		UserID:     userID,
+		TotalTests: len(battery.Tests),
+		Tests:      make([]models.BatteryTestProgress, 0, len(battery.Tests)),
+	}
+
+	var weightedSum, weightTotal float64
+	var failed []string
+	for _, test := range battery.Tests {
+		progress, done := results[test.TestID]
+		progress.BatteryTest = test
+		verdict.Tests = append(verdict.Tests, progress)
+
+		if !done {
+			if verdict.NextTestID == 0 {
+				verdict.NextTestID = test.TestID
+			}
+			continue
+		}
+		verdict.CompletedTests++
+		weightedSum += progress.Percentage * test.Weight
+		weightTotal += test.Weight
+		if !progress.IsPassed {
+			failed = append(failed, "«"+test.TestTitle+"»")
+		}
+	}
+
+	if weightTotal > 0 {
+		verdict.Percentage = math.Round(weightedSum/weightTotal*100) / 100
+	}
+
+	verdict.Completed = verdict.TotalTests > 0 && verdict.CompletedTests == verdict.TotalTests
+	if !verdict.Completed {
+		verdict.Verdict = fmt.Sprintf("⏳ Батарея не завершена: пройдено %d из %d тестов", verdict.CompletedTests, verdict.TotalTests)
+		return verdict
+	}
+
+	switch battery.PassRule {
+	case models.BatteryRuleWeighted:
+		verdict.IsPassed = verdict.Percentage >= battery.PassThreshold
+		if verdict.IsPassed {
+			verdict.Verdict = fmt.Sprintf("✅ ПРИГОДЕН: взвешенный результат %.1f%% (порог %.1f%%)", verdict.Percentage, battery.PassThreshold)
+		} else {
+			verdict.Verdict = fmt.Sprintf("❌ НЕ ПРИГОДЕН: взвешенный результат %.1f%% ниже порога %.1f%%", verdict.Percentage, battery.PassThreshold)
+		}
+	default:
+		verdict.IsPassed = len(failed) == 0
+		if verdict.IsPassed {
+			verdict.Verdict = "✅ ПРИГОДЕН: пройдены все тесты батареи"
+		} else {
+			verdict.Verdict = "❌ НЕ ПРИГОДЕН: не пройдены " + strings.Join(failed, ", ")
+		}
+	}
+	return verdict
+}
+
+// saveBatteryTests заменяет состав батареи внутри транзакции
+func saveBatteryTests(tx *sql.Tx, batteryID int, tests []models.BatteryTest) error {
+	if _, err := tx.Exec("DELETE FROM battery_tests WHERE battery_id = $1", batteryID); err != nil {
+		return err
+	}
+
+	rows := make([][]interface{}, 0, len(tests))
+	for _, test := range tests {
+		rows = append(rows, []interface{}{batteryID, test.TestID, test.OrderIndex, test.Weight})
+	}
+	return insertBatch(tx, "battery_tests", []string{"battery_id", "test_id", "order_index", "weight"}, rows)
+}
+
+// Получение доступных батарей с прогрессом пользователя.
+// Кандидат видит только батареи, все тесты которых ему назначены.
+func GetBatteries(c *gin.Context) {
+	userID, _ := c.Get("userID")
+	role, _ := c.Get("userRole")
+
+	filter := "b.is_active = true AND (NOT $2 OR " + batteryAssignedSQL + ")"
+	batteries, err := loadBatteries(filter, userID, role != models.RoleAdmin)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка получения батарей"})
+		return
+	}
+
+	response := make([]gin.H, 0, len(batteries))
+	for i := range batteries {
+		participants, err := loadBatteryResults(batteries[i].ID, userID.(int))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка получения результатов батареи"})
+			return
+		}
+		var results map[int]models.BatteryTestProgress
+		if participant, exists := participants[userID.(int)]; exists {
+			results = participant.Results
+		}
+		response = append(response, gin.H{
+			"battery":  batteries[i],
+			"progress": evaluateBattery(&batteries[i], userID.(int), results),
+		})
+	}
+
+	c.JSON(http.StatusOK, gin.H{"batteries": response})
+}
+
+// Получение батареи с прогрессом пользователя и следующим тестом для прохождения
+func GetBattery(c *gin.Context) {
+	batteryID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Неверный ID батареи"})
+		return
+	}
+
+	userID, _ := c.Get("userID")
+	role, _ := c.Get("userRole")
+
+	filter := "b.id = $3 AND b.is_active = true AND (NOT $2 OR " + batteryAssignedSQL + ")"
+	batteries, err := loadBatteries(filter, userID, role != models.RoleAdmin, batteryID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка получения батареи"})
+		return
+	}
+	if len(batteries) == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Батарея не найдена"})
+		return
+	}
+
+	participants, err := loadBatteryResults(batteryID, userID.(int))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка получения результатов батареи"})
+		return
+	}
+	var results map[int]models.BatteryTestProgress
+	if participant, exists := participants[userID.(int)]; exists {
+		results = participant.Results
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"battery":  batteries[0],
+		"progress": evaluateBattery(&batteries[0], userID.(int), results),
+	})
+}
+
+// Получение всех батарей (админ)
+func GetAllBatteries(c *gin.Context) {
+	batteries, err := loadBatteries("TRUE")
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка получения батарей"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"batteries": batteries})
+}
+
+// Создание батареи тестов
+func CreateBattery(c *gin.Context) {
+	var batteryReq models.BatteryRequest
+	if err := c.ShouldBindJSON(&batteryReq); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Неверный формат данных"})
+		return
+	}
+	if message := validateBatteryRequest(&batteryReq); message != "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": message})
+		return
+	}
+
+	isActive := true
+	if batteryReq.IsActive != nil {
+		isActive = *batteryReq.IsActive
+	}
+
+	tx, err := database.DB.Begin()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка начала транзакции"})
+		return
+	}
+	defer tx.Rollback()
+
+	var batteryID int
+	err = tx.QueryRow(`
+		INSERT INTO test_batteries (title, description, pass_rule, pass_threshold, is_active)
+		VALUES ($1, $2, $3, $4, $5) RETURNING id
+	`, batteryReq.Title, batteryReq.Description, batteryReq.PassRule, batteryReq.PassThreshold, isActive).Scan(&batteryID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка создания батареи"})
+		return
+	}
+
+	if err := saveBatteryTests(tx, batteryID, batteryReq.Tests); err != nil {
+		respondBatteryTestsError(c, batteryID, err)
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка завершения операции"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"message":    "Батарея создана",
+		"battery_id": batteryID,
+	})
+}
+
+// Обновление батареи тестов
+func UpdateBattery(c *gin.Context) {
+	batteryID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Неверный ID батареи"})
+		return
+	}
+
+	var batteryReq models.BatteryRequest
+	if err := c.ShouldBindJSON(&batteryReq); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Неверный формат данных"})
+		return
+	}
+	if message := validateBatteryRequest(&batteryReq); message != "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": message})
+		return
+	}
+
+	tx, err := database.DB.Begin()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка начала транзакции"})
+		return
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec(`
+		UPDATE test_batteries
+		SET title = $1, description = $2, pass_rule = $3, pass_threshold = $4, is_active = COALESCE($5, is_active)
+		WHERE id = $6
+	`, batteryReq.Title, batteryReq.Description, batteryReq.PassRule, batteryReq.PassThreshold, batteryReq.IsActive, batteryID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка обновления батареи"})
+		return
+	}
+	if affected, _ := result.RowsAffected(); affected == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Батарея не найдена"})
+		return
+	}
+
+	if err := saveBatteryTests(tx, batteryID, batteryReq.Tests); err != nil {
+		respondBatteryTestsError(c, batteryID, err)
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка завершения операции"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Батарея обновлена"})
+}
+
+// Удаление батареи. Результаты отдельных тестов не затрагиваются
+func DeleteBattery(c *gin.Context) {
+	batteryID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Неверный ID батареи"})
+		return
+	}
+
+	result, err := database.DB.Exec("DELETE FROM test_batteries WHERE id = $1", batteryID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка удаления батареи"})
+		return
+	}
+	if affected, _ := result.RowsAffected(); affected == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Батарея не найдена"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Батарея удалена"})
+}
+
+// Назначение всех тестов батареи пользователям и группам
+func AssignBattery(c *gin.Context) {
+	batteryID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Неверный ID батареи"})
+		return
+	}
+
+	battery, err := loadBattery(batteryID)
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Батарея не найдена"})
+		return
+	} else if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка получения батареи"})
+		return
+	}
+
+	testIDs := make([]int, 0, len(battery.Tests))
+	for _, test := range battery.Tests {
+		testIDs = append(testIDs, test.TestID)
+	}
+	if len(testIDs) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "В батарее нет тестов"})
+		return
+	}
+
+	assignedCount, ok := assignTests(c, testIDs)
+	if !ok {
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":        "Батарея назначена",
+		"assigned_count": assignedCount,
+	})
+}
+
+// Сводные заключения по батарее для всех пользователей, проходивших её тесты
+func GetBatteryResults(c *gin.Context) {
+	batteryID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Неверный ID батареи"})
+		return
+	}
+
+	battery, err := loadBattery(batteryID)
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Батарея не найдена"})
+		return
+	} else if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка получения батареи"})
+		return
+	}
+
+	participants, err := loadBatteryResults(batteryID, 0)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка получения результатов батареи"})
+		return
+	}
+
+	verdicts := make([]models.BatteryVerdict, len(participants))
+	for participantID, participant := range participants {
+		verdict := evaluateBattery(battery, participantID, participant.Results)
+		verdict.UserName = participant.UserName
+		verdict.UserEmail = participant.UserEmail
+		verdicts[participant.order] = verdict
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"battery":  battery,
+		"verdicts": verdicts,
+	})
+}
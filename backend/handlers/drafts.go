@@ -0,0 +1,146 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"log"
+	"net/http"
+	"psycho-test-system/database"
+	"psycho-test-system/models"
+	"sort"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+// loadDraft возвращает черновик ответов активной сессии пользователя по тесту
+func loadDraft(userID, testID int) (*models.TestDraft, error) {
+	var draft models.TestDraft
+	var answers []byte
+	var savedAt sql.NullTime
+
+	err := database.DB.QueryRow(`
+		SELECT id, test_id, draft_answers, draft_saved_at
+		FROM test_sessions
+		WHERE user_id = $1 AND test_id = $2 AND status = $3
+		ORDER BY started_at DESC
+		LIMIT 1
+	`, userID, testID, models.SessionActive).Scan(&draft.SessionID, &draft.TestID, &answers, &savedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	draft.Answers = []models.AnswerSubmission{}
+	if len(answers) > 0 {
+		if err := json.Unmarshal(answers, &draft.Answers); err != nil {
+			log.Printf("Ошибка разбора черновика сессии %d: %v", draft.SessionID, err)
+		}
+	}
+	if savedAt.Valid {
+		draft.SavedAt = &savedAt.Time
+	}
+	return &draft, nil
+}
+
+// Сохранение черновика ответов. Черновик привязан к активной сессии
+// и перезаписывается целиком при каждом сохранении.
+func SaveDraft(c *gin.Context) {
+	testID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Неверный ID теста"})
+		return
+	}
+
+	var draftReq models.SubmitTestRequest
+	if err := c.ShouldBindJSON(&draftReq); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Неверный формат данных"})
+		return
+	}
+
+	userID, exists := c.Get("userID")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Пользователь не авторизован"})
+		return
+	}
+
+	session, err := loadActiveSession(userID.(int), testID)
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusConflict, gin.H{"error": "Тест не был начат. Откройте тест заново"})
+		return
+	} else if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка получения сессии"})
+		return
+	}
+
+	questions, err := loadScoringQuestions(testID, session.VersionID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка получения вопросов"})
+		return
+	}
+
+	// В черновике допускаются неотвеченные вопросы, но не чужие вопросы и варианты
+	selected, _, fieldErrors := validateAnswerItems(questions, draftReq.Answers)
+	if len(fieldErrors) > 0 {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":  "Ответы не прошли проверку",
+			"fields": fieldErrors,
+		})
+		return
+	}
+
+	answers := make([]models.AnswerSubmission, 0, len(selected))
+	for _, answer := range selected {
+		answers = append(answers, answer)
+	}
+	sort.Slice(answers, func(i, j int) bool { return answers[i].QuestionID < answers[j].QuestionID })
+
+	answersJSON, err := json.Marshal(answers)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка сохранения черновика"})
+		return
+	}
+
+	result, err := database.DB.Exec(`
+		UPDATE test_sessions SET draft_answers = $1, draft_saved_at = NOW()
+		WHERE id = $2 AND status = $3
+	`, string(answersJSON), session.ID, models.SessionActive)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка сохранения черновика"})
+		return
+	}
+	if affected, _ := result.RowsAffected(); affected == 0 {
+		c.JSON(http.StatusConflict, gin.H{"error": "Сессия теста уже завершена"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":        "Черновик сохранен",
+		"answered_count": len(answers),
+	})
+}
+
+// Получение текущего черновика ответов пользователя по тесту
+func GetDraft(c *gin.Context) {
+	testID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Неверный ID теста"})
+		return
+	}
+
+	userID, exists := c.Get("userID")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Пользователь не авторизован"})
+		return
+	}
+
+	draft, err := loadDraft(userID.(int), testID)
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Незавершенная попытка не найдена"})
+		return
+	} else if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка получения черновика"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"draft": draft})
+}
@@ -0,0 +1,494 @@
+package handlers
+
+import (
+	"database/sql"
+	"math"
+	"net/http"
+	"psycho-test-system/database"
+	"psycho-test-system/models"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Уровень значимости критериев, поправка на множественные сравнения и наибольшее число групп
+// в одном сравнении
+const (
+	comparisonAlpha      = 0.05
+	comparisonCorrection = "holm"
+	maxComparisonGroups  = 10
+)
+
+// groupRespondent - официальный достоверный результат участника группы
+type groupRespondent struct {
+	userID     int
+	percentage float64
+	passed     bool
+	scales     map[string]float64
+}
+
+// groupSample - результаты участников группы по тесту в порядке результатов
+type groupSample struct {
+	respondents []groupRespondent
+}
+
+// groupMeasures - значения выборки, по которым считаются статистика и критерии
+type groupMeasures struct {
+	total  []float64
+	passed int
+	scales map[string][]float64
+}
+
+// measures собирает значения выборки без пользователей из exclude
+func (sample *groupSample) measures(exclude map[int]bool) groupMeasures {
+	measures := groupMeasures{scales: make(map[string][]float64)}
+	for _, respondent := range sample.respondents {
+		if exclude[respondent.userID] {
+			continue
+		}
+		measures.total = append(measures.total, respondent.percentage)
+		if respondent.passed {
+			measures.passed++
+		}
+		for scaleType, percentage := range respondent.scales {
+			measures.scales[scaleType] = append(measures.scales[scaleType], percentage)
+		}
+	}
+	return measures
+}
+
+// overlap - пользователи, входящие в обе выборки
+func (sample *groupSample) overlap(other *groupSample) map[int]bool {
+	users := make(map[int]bool, len(other.respondents))
+	for _, respondent := range other.respondents {
+		users[respondent.userID] = true
+	}
+	common := make(map[int]bool)
+	for _, respondent := range sample.respondents {
+		if users[respondent.userID] {
+			common[respondent.userID] = true
+		}
+	}
+	return common
+}
+
+// pValue - p-уровень критерия и поля результата, которые заполняет поправка
+type pValue struct {
+	p           float64
+	adjusted    *float64
+	significant *bool
+}
+
+// holmFamily - семейство проверяемых гипотез для поправки Холма
+type holmFamily []pValue
+
+func (family *holmFamily) add(p float64, adjusted *float64, significant *bool) {
+	*family = append(*family, pValue{p: p, adjusted: adjusted, significant: significant})
+}
+
+// apply заполняет скорректированные p-уровни методом Холма: k-й по возрастанию p умножается
+// на число ещё не отвергнутых гипотез, скорректированные значения не убывают
+func (family holmFamily) apply(alpha float64) {
+	sorted := append(holmFamily(nil), family...)
+	sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].p < sorted[j].p })
+	previous := 0.0
+	for i, value := range sorted {
+		adjusted := math.Min(1, math.Max(previous, float64(len(sorted)-i)*value.p))
+		previous = adjusted
+		*value.adjusted = *statRound(adjusted)
+		*value.significant = adjusted < alpha
+	}
+}
+
+// loadGroupSample загружает результаты участников группы; официальная попытка выбирается
+// так же, как в психометрическом анализе
+func loadGroupSample(testID, groupID int) (*groupSample, error) {
+	rows, err := database.DB.Query(`
+		WITH official AS (
+			SELECT DISTINCT ON (tr.user_id) tr.id, tr.user_id, tr.percentage, tr.is_passed
+			FROM test_results tr
+			JOIN psychological_tests pt ON pt.id = tr.test_id
+			WHERE tr.test_id = $1 AND COALESCE(tr.is_valid, true)
+			ORDER BY tr.user_id,
+			         CASE WHEN COALESCE(pt.official_attempt, 'latest') = 'first' THEN tr.completed_at END ASC,
+			         tr.completed_at DESC
+		)
+		SELECT official.id, official.user_id, official.percentage, official.is_passed, trs.scale_type, trs.percentage
+		FROM user_group_members m
+		JOIN official ON official.user_id = m.user_id
+		LEFT JOIN test_result_scales trs ON trs.result_id = official.id
+		WHERE m.group_id = $2
+		ORDER BY official.id
+	`, testID, groupID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	sample := &groupSample{}
+	lastResultID := 0
+	for rows.Next() {
+		var resultID, userID int
+		var percentage float64
+		var isPassed bool
+		var scaleType sql.NullString
+		var scalePercentage sql.NullFloat64
+		if err := rows.Scan(&resultID, &userID, &percentage, &isPassed, &scaleType, &scalePercentage); err != nil {
+			return nil, err
+		}
+		if resultID != lastResultID {
+			lastResultID = resultID
+			sample.respondents = append(sample.respondents, groupRespondent{userID: userID, percentage: percentage,
+				passed: isPassed, scales: make(map[string]float64)})
+		}
+		if scaleType.Valid && scalePercentage.Valid {
+			sample.respondents[len(sample.respondents)-1].scales[scaleType.String] = scalePercentage.Float64
+		}
+	}
+	return sample, rows.Err()
+}
+
+// statQuantile - квантиль отсортированной выборки с линейной интерполяцией
+func statQuantile(sorted []float64, q float64) float64 {
+	position := q * float64(len(sorted)-1)
+	lower := int(math.Floor(position))
+	if lower+1 >= len(sorted) {
+		return sorted[len(sorted)-1]
+	}
+	return sorted[lower] + (position-float64(lower))*(sorted[lower+1]-sorted[lower])
+}
+
+// describeDistribution - описательная статистика процентов и гистограмма по интервалам в 10%
+func describeDistribution(values []float64) models.DistributionStats {
+	stats := models.DistributionStats{N: len(values), Histogram: make([]int, 10)}
+	if len(values) == 0 {
+		return stats
+	}
+	sorted := append([]float64(nil), values...)
+	sort.Float64s(sorted)
+	for _, value := range sorted {
+		stats.Histogram[min(max(int(value/10), 0), 9)]++
+	}
+
+	stats.Mean = statRound(statMean(sorted))
+	stats.Median = statRound(statQuantile(sorted, 0.5))
+	stats.Min = statRound(sorted[0])
+	stats.Q1 = statRound(statQuantile(sorted, 0.25))
+	stats.Q3 = statRound(statQuantile(sorted, 0.75))
+	stats.Max = statRound(sorted[len(sorted)-1])
+	if len(sorted) > 1 {
+		stats.StdDev = statRound(math.Sqrt(statVariance(sorted)))
+	}
+	return stats
+}
+
+// betaContinuedFraction - цепная дробь неполной бета-функции (метод Лентца)
+func betaContinuedFraction(x, a, b float64) float64 {
+	const epsilon, tiny = 1e-12, 1e-300
+	c, d := 1.0, 1-(a+b)*x/(a+1)
+	if math.Abs(d) < tiny {
+		d = tiny
+	}
+	d = 1 / d
+	result := d
+	for m := 1; m <= 300; m++ {
+		m2 := float64(2 * m)
+		for _, numerator := range []float64{
+			float64(m) * (b - float64(m)) * x / ((a + m2 - 1) * (a + m2)),
+			-(a + float64(m)) * (a + b + float64(m)) * x / ((a + m2) * (a + m2 + 1)),
+		} {
+			d = 1 + numerator*d
+			if math.Abs(d) < tiny {
+				d = tiny
+			}
+			c = 1 + numerator/c
+			if math.Abs(c) < tiny {
+				c = tiny
+			}
+			d = 1 / d
+			result *= d * c
+		}
+		if math.Abs(d*c-1) < epsilon {
+			break
+		}
+	}
+	return result
+}
+
+// regularizedBeta - регуляризованная неполная бета-функция I_x(a, b)
+func regularizedBeta(x, a, b float64) float64 {
+	if x <= 0 {
+		return 0
+	}
+	if x >= 1 {
+		return 1
+	}
+	lgammaAB, _ := math.Lgamma(a + b)
+	lgammaA, _ := math.Lgamma(a)
+	lgammaB, _ := math.Lgamma(b)
+	front := math.Exp(lgammaAB - lgammaA - lgammaB + a*math.Log(x) + b*math.Log(1-x))
+	if x < (a+1)/(a+b+2) {
+		return front * betaContinuedFraction(x, a, b) / a
+	}
+	return 1 - front*betaContinuedFraction(1-x, b, a)/b
+}
+
+// studentTwoSidedP - двусторонний p-уровень t-распределения с df степенями свободы
+func studentTwoSidedP(t, df float64) float64 {
+	return regularizedBeta(df/(df+t*t), df/2, 0.5)
+}
+
+// normalTwoSidedP - двусторонний p-уровень стандартного нормального распределения
+func normalTwoSidedP(z float64) float64 {
+	return math.Erfc(math.Abs(z) / math.Sqrt2)
+}
+
+// welchTTest сравнивает средние; nil, если в группе меньше двух значений или нет разброса.
+// p-уровень добавляется в family для поправки на множественные сравнения.
+func welchTTest(a, b []float64, family *holmFamily) *models.TTestResult {
+	if len(a) < 2 || len(b) < 2 {
+		return nil
+	}
+	n1, n2 := float64(len(a)), float64(len(b))
+	v1, v2 := statVariance(a), statVariance(b)
+	se1, se2 := v1/n1, v2/n2
+	if se1+se2 == 0 {
+		return nil
+	}
+	difference := statMean(a) - statMean(b)
+	t := difference / math.Sqrt(se1+se2)
+	df := (se1 + se2) * (se1 + se2) / (se1*se1/(n1-1) + se2*se2/(n2-1))
+	p := studentTwoSidedP(t, df)
+
+	result := &models.TTestResult{
+		MeanDifference: *statRound(difference),
+		T:              *statRound(t),
+		DF:             *statRound(df),
+		P:              *statRound(p),
+	}
+	family.add(p, &result.PAdjusted, &result.Significant)
+	if pooled := ((n1-1)*v1 + (n2-1)*v2) / (n1 + n2 - 2); pooled > 0 {
+		result.CohenD = statRound(difference / math.Sqrt(pooled))
+	}
+	return result
+}
+
+// mannWhitneyTest сравнивает распределения по рангам; nil, если в группе меньше двух значений
+// или все значения равны
+func mannWhitneyTest(a, b []float64, family *holmFamily) *models.MannWhitneyResult {
+	if len(a) < 2 || len(b) < 2 {
+		return nil
+	}
+	type ranked struct {
+		value   float64
+		inFirst bool
+	}
+	values := make([]ranked, 0, len(a)+len(b))
+	for _, value := range a {
+		values = append(values, ranked{value, true})
+	}
+	for _, value := range b {
+		values = append(values, ranked{value, false})
+	}
+	sort.Slice(values, func(i, j int) bool { return values[i].value < values[j].value })
+
+	// Связанные значения получают средний ранг
+	var rankSum, tieCorrection float64
+	for i := 0; i < len(values); {
+		j := i
+		for j < len(values) && values[j].value == values[i].value {
+			j++
+		}
+		rank := float64(i+j+1) / 2
+		for k := i; k < j; k++ {
+			if values[k].inFirst {
+				rankSum += rank
+			}
+		}
+		ties := float64(j - i)
+		tieCorrection += ties*ties*ties - ties
+		i = j
+	}
+
+	n1, n2 := float64(len(a)), float64(len(b))
+	n := n1 + n2
+	u := rankSum - n1*(n1+1)/2
+	variance := n1 * n2 / 12 * ((n + 1) - tieCorrection/(n*(n-1)))
+	if variance <= 0 {
+		return nil
+	}
+	// Поправка на непрерывность приближает дискретное распределение U к нормальному
+	deviation := u - n1*n2/2
+	deviation -= math.Copysign(math.Min(0.5, math.Abs(deviation)), deviation)
+	z := deviation / math.Sqrt(variance)
+	p := normalTwoSidedP(z)
+
+	result := &models.MannWhitneyResult{
+		U: *statRound(u),
+		Z: *statRound(z),
+		P: *statRound(p),
+	}
+	family.add(p, &result.PAdjusted, &result.Significant)
+	return result
+}
+
+// proportionTest сравнивает доли прошедших тест; nil, если группа пуста или доли вырождены
+func proportionTest(passedA, totalA, passedB, totalB int, family *holmFamily) *models.ProportionTest {
+	if totalA == 0 || totalB == 0 {
+		return nil
+	}
+	p1, p2 := float64(passedA)/float64(totalA), float64(passedB)/float64(totalB)
+	pooled := float64(passedA+passedB) / float64(totalA+totalB)
+	se := math.Sqrt(pooled * (1 - pooled) * (1/float64(totalA) + 1/float64(totalB)))
+	if se == 0 {
+		return nil
+	}
+	z := (p1 - p2) / se
+	p := normalTwoSidedP(z)
+	result := &models.ProportionTest{
+		Difference: *statRound((p1 - p2) * 100),
+		Z:          *statRound(z),
+		P:          *statRound(p),
+	}
+	family.add(p, &result.PAdjusted, &result.Significant)
+	return result
+}
+
+// parseGroupIDs читает список групп вида group_ids=1,2,3
+func parseGroupIDs(value string) ([]int, string) {
+	var groupIDs []int
+	seen := make(map[int]bool)
+	for _, part := range strings.Split(value, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		groupID, err := strconv.Atoi(part)
+		if err != nil || groupID < 1 {
+			return nil, "Неверный ID группы: " + part
+		}
+		if !seen[groupID] {
+			seen[groupID] = true
+			groupIDs = append(groupIDs, groupID)
+		}
+	}
+	if len(groupIDs) == 0 {
+		return nil, "Укажите группы для сравнения в параметре group_ids"
+	}
+	if len(groupIDs) > maxComparisonGroups {
+		return nil, "Можно сравнить не больше " + strconv.Itoa(maxComparisonGroups) + " групп"
+	}
+	return groupIDs, ""
+}
+
+// Сравнение групп по результатам теста: описательная статистика итогового процента и шкал,
+// процент прохождения и попарные критерии значимости. Параметр group_ids=1,2.
+// Участники, входящие в обе группы пары, исключаются из попарных критериев; значимость
+// оценивается с поправкой Холма по всем критериям отчёта.
+func CompareGroups(c *gin.Context) {
+	testID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Неверный ID теста"})
+		return
+	}
+	groupIDs, message := parseGroupIDs(c.Query("group_ids"))
+	if message != "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": message})
+		return
+	}
+
+	report := models.GroupComparisonReport{TestID: testID, Alpha: comparisonAlpha, Correction: comparisonCorrection,
+		Groups: []models.GroupResultStats{}, Comparisons: []models.GroupPairComparison{}}
+	err = database.DB.QueryRow("SELECT title FROM psychological_tests WHERE id = $1", testID).Scan(&report.TestTitle)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Тест не найден"})
+		return
+	}
+
+	samples := make([]*groupSample, len(groupIDs))
+	for i, groupID := range groupIDs {
+		group := models.GroupResultStats{GroupID: groupID, Scales: []models.ScaleDistribution{}}
+		err := database.DB.QueryRow(`
+			SELECT g.name, COUNT(m.user_id)
+			FROM user_groups g
+			LEFT JOIN user_group_members m ON m.group_id = g.id
+			WHERE g.id = $1
+			GROUP BY g.id
+		`, groupID).Scan(&group.GroupName, &group.Members)
+		if err == sql.ErrNoRows {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Группа не найдена: " + strconv.Itoa(groupID)})
+			return
+		} else if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка получения групп"})
+			return
+		}
+
+		sample, err := loadGroupSample(testID, groupID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка получения результатов группы"})
+			return
+		}
+		samples[i] = sample
+
+		measures := sample.measures(nil)
+		group.Respondents = len(measures.total)
+		group.Passed = measures.passed
+		if group.Respondents > 0 {
+			group.PassRate = statRound(float64(measures.passed) / float64(group.Respondents) * 100)
+		}
+		group.Total = describeDistribution(measures.total)
+		for _, scaleType := range sortedScaleTypes(measures.scales) {
+			group.Scales = append(group.Scales, models.ScaleDistribution{
+				ScaleType: scaleType,
+				Stats:     describeDistribution(measures.scales[scaleType]),
+			})
+		}
+		report.Groups = append(report.Groups, group)
+	}
+
+	var family holmFamily
+	for i := range samples {
+		for j := i + 1; j < len(samples); j++ {
+			common := samples[i].overlap(samples[j])
+			a, b := samples[i].measures(common), samples[j].measures(common)
+			comparison := models.GroupPairComparison{
+				GroupA:   groupIDs[i],
+				GroupB:   groupIDs[j],
+				Overlap:  len(common),
+				PassRate: proportionTest(a.passed, len(a.total), b.passed, len(b.total), &family),
+				Total: models.SignificanceTests{
+					TTest:       welchTTest(a.total, b.total, &family),
+					MannWhitney: mannWhitneyTest(a.total, b.total, &family),
+				},
+				Scales: []models.ScaleSignificance{},
+			}
+			for _, scaleType := range sortedScaleTypes(a.scales) {
+				if _, ok := b.scales[scaleType]; !ok {
+					continue
+				}
+				comparison.Scales = append(comparison.Scales, models.ScaleSignificance{
+					ScaleType: scaleType,
+					Tests: models.SignificanceTests{
+						TTest:       welchTTest(a.scales[scaleType], b.scales[scaleType], &family),
+						MannWhitney: mannWhitneyTest(a.scales[scaleType], b.scales[scaleType], &family),
+					},
+				})
+			}
+			report.Comparisons = append(report.Comparisons, comparison)
+		}
+	}
+	family.apply(comparisonAlpha)
+
+	c.JSON(http.StatusOK, gin.H{"report": report})
+}
+
+func sortedScaleTypes(scales map[string][]float64) []string {
+	names := make([]string, 0, len(scales))
+	for name := range scales {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
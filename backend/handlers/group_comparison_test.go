@@ -0,0 +1,110 @@
+package handlers
+
+import (
+	"testing"
+)
+
+func TestWelchTTest(t *testing.T) {
+	tests := []struct {
+		name   string
+		a, b   []float64
+		p      *float64 // nil - критерий не применяется
+		t, df  float64
+		cohenD float64
+	}{
+		{"разные дисперсии", []float64{1, 2, 3, 4, 5}, []float64{2, 4, 6, 8, 10}, statRound(0.108), -1.897, 5.882, -1.2},
+		{"меньше двух значений", []float64{1}, []float64{2, 3}, nil, 0, 0, 0},
+		{"нет разброса", []float64{3, 3}, []float64{5, 5, 5}, nil, 0, 0, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var family holmFamily
+			result := welchTTest(tt.a, tt.b, &family)
+			if tt.p == nil {
+				if result != nil || len(family) != 0 {
+					t.Errorf("критерий не должен применяться: %+v", result)
+				}
+				return
+			}
+			if result == nil {
+				t.Fatal("критерий не рассчитан")
+			}
+			if result.P != *tt.p || result.T != tt.t || result.DF != tt.df {
+				t.Errorf("t = %v, df = %v, p = %v; ожидалось %v, %v, %v", result.T, result.DF, result.P, tt.t, tt.df, *tt.p)
+			}
+			if result.CohenD == nil || *result.CohenD != tt.cohenD {
+				t.Errorf("d Коэна = %v, ожидалось %v", formatStat(result.CohenD), tt.cohenD)
+			}
+			if len(family) != 1 {
+				t.Errorf("в семейство добавлено %d гипотез, ожидалась одна", len(family))
+			}
+		})
+	}
+}
+
+func TestMannWhitneyTest(t *testing.T) {
+	tests := []struct {
+		name string
+		a, b []float64
+		p    *float64
+		u, z float64
+	}{
+		{"группы не пересекаются", []float64{1, 2, 3, 4, 5}, []float64{6, 7, 8, 9, 10}, statRound(0.012), 0, -2.507},
+		// Четыре значения 2 получают средний ранг 3.5, дисперсия U уменьшается поправкой на связи
+		{"связанные значения", []float64{1, 2, 2}, []float64{2, 2, 3}, statRound(0.302), 2, -1.033},
+		{"все значения равны", []float64{4, 4}, []float64{4, 4}, nil, 0, 0},
+		{"меньше двух значений", []float64{1}, []float64{2, 3}, nil, 0, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var family holmFamily
+			result := mannWhitneyTest(tt.a, tt.b, &family)
+			if tt.p == nil {
+				if result != nil || len(family) != 0 {
+					t.Errorf("критерий не должен применяться: %+v", result)
+				}
+				return
+			}
+			if result == nil {
+				t.Fatal("критерий не рассчитан")
+			}
+			if result.U != tt.u || result.Z != tt.z || result.P != *tt.p {
+				t.Errorf("U = %v, z = %v, p = %v; ожидалось %v, %v, %v", result.U, result.Z, result.P, tt.u, tt.z, *tt.p)
+			}
+		})
+	}
+}
+
+func TestHolmFamily(t *testing.T) {
+	tests := []struct {
+		name        string
+		p           []float64
+		adjusted    []float64
+		significant []bool
+	}{
+		{"одна гипотеза", []float64{0.04}, []float64{0.04}, []bool{true}},
+		// 0.01*3 = 0.03; 0.03*2 = 0.06; 0.04*1 = 0.04 поднимается до 0.06, чтобы p не убывали
+		{"монотонность", []float64{0.01, 0.04, 0.03}, []float64{0.03, 0.06, 0.06}, []bool{true, false, false}},
+		{"не больше единицы", []float64{0.6, 0.5}, []float64{1, 1}, []bool{false, false}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var family holmFamily
+			adjusted := make([]float64, len(tt.p))
+			significant := make([]bool, len(tt.p))
+			for i, p := range tt.p {
+				family.add(p, &adjusted[i], &significant[i])
+			}
+			family.apply(0.05)
+			for i := range tt.p {
+				if adjusted[i] != tt.adjusted[i] || significant[i] != tt.significant[i] {
+					t.Errorf("p = %v: скорректированный %v (%v), ожидалось %v (%v)",
+						tt.p[i], adjusted[i], significant[i], tt.adjusted[i], tt.significant[i])
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,278 @@
+package handlers
+
+import (
+	"database/sql"
+	"log"
+	"net/http"
+	"psycho-test-system/database"
+	"psycho-test-system/models"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// replaceGroupMembers заменяет состав группы внутри транзакции и синхронизирует
+// назначения тестов, выданные через группу
+func replaceGroupMembers(tx *sql.Tx, groupID int, userIDs []int) error {
+	if _, err := tx.Exec("DELETE FROM user_group_members WHERE group_id = $1", groupID); err != nil {
+		return err
+	}
+
+	seen := make(map[int]bool, len(userIDs))
+	rows := make([][]interface{}, 0, len(userIDs))
+	for _, userID := range userIDs {
+		if seen[userID] {
+			continue
+		}
+		seen[userID] = true
+		rows = append(rows, []interface{}{groupID, userID})
+	}
+	if err := insertBatch(tx, "user_group_members", []string{"group_id", "user_id"}, rows); err != nil {
+		return err
+	}
+	_, err := syncGroupAssignments(tx, groupID)
+	return err
+}
+
+// respondGroupMembersError отвечает об ошибке replaceGroupMembers: несуществующий
+// пользователь - ошибка запроса, остальное логируется как ошибка сервера
+func respondGroupMembersError(c *gin.Context, groupID int, err error) {
+	if strings.Contains(err.Error(), "foreign key") {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Среди участников есть несуществующие пользователи"})
+		return
+	}
+	log.Printf("Ошибка сохранения участников группы %d: %v", groupID, err)
+	c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка сохранения участников группы"})
+}
+
+// Получение списка групп пользователей
+func GetGroups(c *gin.Context) {
+	rows, err := database.DB.Query(`
+		SELECT g.id, g.name, COALESCE(g.description, ''), g.created_at, COUNT(m.user_id)
+		FROM user_groups g
+		LEFT JOIN user_group_members m ON m.group_id = g.id
+		GROUP BY g.id
+		ORDER BY g.name
+	`)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка получения групп"})
+		return
+	}
+	defer rows.Close()
+
+	groups := []models.UserGroup{}
+	for rows.Next() {
+		var group models.UserGroup
+		if err := rows.Scan(&group.ID, &group.Name, &group.Description, &group.CreatedAt, &group.MembersCount); err != nil {
+			continue
+		}
+		groups = append(groups, group)
+	}
+
+	c.JSON(http.StatusOK, gin.H{"groups": groups})
+}
+
+// Создание группы пользователей
+func CreateGroup(c *gin.Context) {
+	var groupReq models.GroupRequest
+	if err := c.ShouldBindJSON(&groupReq); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Неверный формат данных"})
+		return
+	}
+
+	tx, err := database.DB.Begin()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка начала транзакции"})
+		return
+	}
+	defer tx.Rollback()
+
+	var groupID int
+	err = tx.QueryRow(`
+		INSERT INTO user_groups (name, description) VALUES ($1, $2) RETURNING id
+	`, strings.TrimSpace(groupReq.Name), groupReq.Description).Scan(&groupID)
+	if err != nil {
+		if strings.Contains(err.Error(), "duplicate key") {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Группа с таким названием уже существует"})
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка создания группы"})
+		}
+		return
+	}
+
+	if err := replaceGroupMembers(tx, groupID, groupReq.UserIDs); err != nil {
+		respondGroupMembersError(c, groupID, err)
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка завершения операции"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"message":  "Группа создана",
+		"group_id": groupID,
+	})
+}
+
+// Получение группы с составом участников
+func GetGroup(c *gin.Context) {
+	groupID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Неверный ID группы"})
+		return
+	}
+
+	group := models.UserGroup{ID: groupID, Members: []models.GroupMember{}}
+	err = database.DB.QueryRow(`
+		SELECT name, COALESCE(description, ''), created_at FROM user_groups WHERE id = $1
+	`, groupID).Scan(&group.Name, &group.Description, &group.CreatedAt)
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Группа не найдена"})
+		return
+	} else if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка получения группы"})
+		return
+	}
+
+	rows, err := database.DB.Query(`
+		SELECT u.id, u.email, CONCAT_WS(' ', u.last_name, u.first_name, u.patronymic)
+		FROM user_group_members m
+		JOIN users u ON u.id = m.user_id
+		WHERE m.group_id = $1
+		ORDER BY u.last_name, u.first_name, u.id
+	`, groupID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка получения участников группы"})
+		return
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var member models.GroupMember
+		if err := rows.Scan(&member.ID, &member.Email, &member.FullName); err != nil {
+			continue
+		}
+		group.Members = append(group.Members, member)
+	}
+	group.MembersCount = len(group.Members)
+
+	c.JSON(http.StatusOK, gin.H{"group": group})
+}
+
+// Изменение названия и описания группы и, если передан user_ids, её состава
+func UpdateGroup(c *gin.Context) {
+	groupID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Неверный ID группы"})
+		return
+	}
+
+	var groupReq models.GroupRequest
+	if err := c.ShouldBindJSON(&groupReq); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Неверный формат данных"})
+		return
+	}
+
+	tx, err := database.DB.Begin()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка начала транзакции"})
+		return
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec(`
+		UPDATE user_groups SET name = $1, description = $2 WHERE id = $3
+	`, strings.TrimSpace(groupReq.Name), groupReq.Description, groupID)
+	if err != nil {
+		if strings.Contains(err.Error(), "duplicate key") {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Группа с таким названием уже существует"})
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка изменения группы"})
+		}
+		return
+	}
+	if affected, _ := result.RowsAffected(); affected == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Группа не найдена"})
+		return
+	}
+
+	if groupReq.UserIDs != nil {
+		if err := replaceGroupMembers(tx, groupID, groupReq.UserIDs); err != nil {
+			respondGroupMembersError(c, groupID, err)
+			return
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка завершения операции"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Группа изменена"})
+}
+
+// Удаление группы. Назначения тестов, выданные через группу, остаются у её участников.
+func DeleteGroup(c *gin.Context) {
+	groupID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Неверный ID группы"})
+		return
+	}
+
+	result, err := database.DB.Exec("DELETE FROM user_groups WHERE id = $1", groupID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка удаления группы"})
+		return
+	}
+	if affected, _ := result.RowsAffected(); affected == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Группа не найдена"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Группа удалена"})
+}
+
+// Замена состава группы
+func SetGroupMembers(c *gin.Context) {
+	groupID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Неверный ID группы"})
+		return
+	}
+
+	var membersReq struct {
+		UserIDs []int `json:"user_ids"`
+	}
+	if err := c.ShouldBindJSON(&membersReq); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Неверный формат данных"})
+		return
+	}
+
+	tx, err := database.DB.Begin()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка начала транзакции"})
+		return
+	}
+	defer tx.Rollback()
+
+	var exists bool
+	if err := tx.QueryRow("SELECT EXISTS(SELECT 1 FROM user_groups WHERE id = $1)", groupID).Scan(&exists); err != nil || !exists {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Группа не найдена"})
+		return
+	}
+
+	if err := replaceGroupMembers(tx, groupID, membersReq.UserIDs); err != nil {
+		respondGroupMembersError(c, groupID, err)
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка завершения операции"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Состав группы обновлен"})
+}
@@ -0,0 +1,323 @@
+package handlers
+
+import (
+	"database/sql"
+	"fmt"
+	"net/http"
+	"psycho-test-system/database"
+	"psycho-test-system/models"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// loadInterpretationRules загружает все правила интерпретации версии теста
+func loadInterpretationRules(versionID int) ([]models.InterpretationRule, error) {
+	rows, err := database.DB.Query(`
+		SELECT id, test_id, scale_type, min_percentage, max_percentage, interpretation, COALESCE(recommendation, '')
+		FROM interpretation_rules
+		WHERE version_id = $1
+		ORDER BY scale_type, min_percentage
+	`, versionID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	rules := []models.InterpretationRule{}
+	for rows.Next() {
+		var rule models.InterpretationRule
+		if err := rows.Scan(&rule.ID, &rule.TestID, &rule.ScaleType, &rule.MinPercentage, &rule.MaxPercentage,
+			&rule.Interpretation, &rule.Recommendation); err != nil {
+			return nil, err
+		}
+		rules = append(rules, rule)
+	}
+	return rules, rows.Err()
+}
+
+// matchInterpretationRule выбирает диапазон, в который попадает процент по шкале.
+// На границе двух диапазонов выигрывает диапазон с большим нижним порогом.
+func matchInterpretationRule(rules []models.InterpretationRule, scaleType string, percentage float64) *models.InterpretationRule {
+	var matched *models.InterpretationRule
+	for i := range rules {
+		rule := &rules[i]
+		if rule.ScaleType != scaleType {
+			continue
+		}
+		if percentage < rule.MinPercentage || percentage > rule.MaxPercentage {
+			continue
+		}
+		if matched == nil || rule.MinPercentage > matched.MinPercentage {
+			matched = rule
+		}
+	}
+	return matched
+}
+
+// renderInterpretationText подставляет баллы в шаблон текста правила.
+// Поддерживаются {score}, {max_score} и {percentage}.
+func renderInterpretationText(text string, score, maxScore, percentage float64) string {
+	return strings.NewReplacer(
+		"{score}", fmt.Sprintf("%.1f", score),
+		"{max_score}", fmt.Sprintf("%.1f", maxScore),
+		"{percentage}", fmt.Sprintf("%.1f", percentage),
+	).Replace(text)
+}
+
+// applyInterpretationRules заполняет интерпретацию шкал и возвращает заключение по тесту.
+// Вердикт о пригодности определяется только прохождением порога теста, а правило для итогового
+// процента добавляет к нему описательный текст. Без подходящего правила используется стандартный текст.
+func applyInterpretationRules(rules []models.InterpretationRule, sheet *scoreSheet, isPassed bool) (string, string) {
+	for i := range sheet.Scales {
+		scale := &sheet.Scales[i]
+		if rule := matchInterpretationRule(rules, scale.ScaleName, scale.Percentage); rule != nil {
+			scale.Interpretation = renderInterpretationText(rule.Interpretation, scale.Score, scale.MaxScore, scale.Percentage)
+			scale.Recommendation = renderInterpretationText(rule.Recommendation, scale.Score, scale.MaxScore, scale.Percentage)
+		}
+	}
+
+	verdict, recommendation := getGenericResult(isPassed, sheet.Percentage)
+	rule := matchInterpretationRule(rules, "", sheet.Percentage)
+	if rule == nil {
+		return verdict, recommendation
+	}
+	interpretation := verdict + ": " + renderInterpretationText(rule.Interpretation, sheet.TotalScore, sheet.MaxScore, sheet.Percentage)
+	if rule.Recommendation != "" {
+		recommendation = renderInterpretationText(rule.Recommendation, sheet.TotalScore, sheet.MaxScore, sheet.Percentage)
+	}
+	return interpretation, recommendation
+}
+
+func validateInterpretationRule(rule models.InterpretationRule) string {
+	if strings.TrimSpace(rule.Interpretation) == "" {
+		return "Текст интерпретации обязателен"
+	}
+	if rule.MinPercentage < 0 || rule.MaxPercentage > 100 {
+		return "Границы диапазона должны быть в пределах от 0 до 100%"
+	}
+	if rule.MinPercentage > rule.MaxPercentage {
+		return "Нижняя граница диапазона больше верхней"
+	}
+	return ""
+}
+
+// editableRule находит правило текущей версии теста и возвращает его ID в версии, которую
+// можно менять (см. editableVersion): при создании новой версии правила копируются в порядке ID,
+// поэтому правило находится по своему порядковому номеру. Если правила нет, возвращает sql.ErrNoRows.
+func editableRule(tx *sql.Tx, testID, ruleID int, userID interface{}, comment string) (int, int, error) {
+	var position int
+	err := tx.QueryRow(`
+		SELECT (SELECT COUNT(*) FROM interpretation_rules o WHERE o.version_id = r.version_id AND o.id < r.id)
+		FROM interpretation_rules r
+		JOIN psychological_tests pt ON pt.id = r.test_id AND pt.current_version_id = r.version_id
+		WHERE r.id = $1 AND r.test_id = $2
+	`, ruleID, testID).Scan(&position)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	versionID, versionNumber, err := editableVersion(tx, testID, userID, comment)
+	if err != nil || versionNumber == 0 {
+		return ruleID, versionNumber, err
+	}
+	err = tx.QueryRow(`
+		SELECT id FROM interpretation_rules WHERE version_id = $1 ORDER BY id OFFSET $2 LIMIT 1
+	`, versionID, position).Scan(&ruleID)
+	return ruleID, versionNumber, err
+}
+
+func testExists(testID int) (bool, error) {
+	var exists bool
+	err := database.DB.QueryRow("SELECT EXISTS(SELECT 1 FROM psychological_tests WHERE id = $1)", testID).Scan(&exists)
+	return exists, err
+}
+
+// Получение правил интерпретации текущей версии теста
+func GetInterpretationRules(c *gin.Context) {
+	testID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Неверный ID теста"})
+		return
+	}
+
+	versionID, err := resolveVersionID(testID, 0)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Тест не найден"})
+		return
+	}
+
+	rules, err := loadInterpretationRules(versionID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка получения правил интерпретации"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"rules": rules})
+}
+
+// Создание правила интерпретации. Правила меняются так же, как остальные настройки подсчёта:
+// если по текущей версии уже начинались попытки, изменение сохраняется в новой версии.
+func CreateInterpretationRule(c *gin.Context) {
+	testID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Неверный ID теста"})
+		return
+	}
+
+	var rule models.InterpretationRule
+	if err := c.ShouldBindJSON(&rule); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Неверный формат данных"})
+		return
+	}
+	if msg := validateInterpretationRule(rule); msg != "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": msg})
+		return
+	}
+
+	tx, err := database.DB.Begin()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка начала транзакции"})
+		return
+	}
+	defer tx.Rollback()
+
+	userID, _ := c.Get("userID")
+	versionID, versionNumber, err := editableVersion(tx, testID, userID, "Изменение правил интерпретации")
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Тест не найден"})
+		return
+	} else if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка создания версии теста"})
+		return
+	}
+
+	rule.TestID = testID
+	err = tx.QueryRow(`
+		INSERT INTO interpretation_rules (test_id, version_id, scale_type, min_percentage, max_percentage, interpretation, recommendation)
+		VALUES ($1, $2, $3, $4, $5, $6, $7) RETURNING id
+	`, testID, versionID, rule.ScaleType, rule.MinPercentage, rule.MaxPercentage, rule.Interpretation, rule.Recommendation).Scan(&rule.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка создания правила интерпретации"})
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка завершения операции"})
+		return
+	}
+
+	response := gin.H{"message": "Правило интерпретации создано", "rule": rule}
+	if versionNumber > 0 {
+		response["version_number"] = versionNumber
+	}
+	c.JSON(http.StatusCreated, response)
+}
+
+// Обновление правила интерпретации
+func UpdateInterpretationRule(c *gin.Context) {
+	testID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Неверный ID теста"})
+		return
+	}
+	ruleID, err := strconv.Atoi(c.Param("ruleId"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Неверный ID правила"})
+		return
+	}
+
+	var rule models.InterpretationRule
+	if err := c.ShouldBindJSON(&rule); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Неверный формат данных"})
+		return
+	}
+	if msg := validateInterpretationRule(rule); msg != "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": msg})
+		return
+	}
+
+	tx, err := database.DB.Begin()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка начала транзакции"})
+		return
+	}
+	defer tx.Rollback()
+
+	userID, _ := c.Get("userID")
+	ruleID, versionNumber, err := editableRule(tx, testID, ruleID, userID, "Изменение правил интерпретации")
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Правило интерпретации не найдено"})
+		return
+	} else if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка создания версии теста"})
+		return
+	}
+
+	_, err = tx.Exec(`
+		UPDATE interpretation_rules
+		SET scale_type = $1, min_percentage = $2, max_percentage = $3, interpretation = $4, recommendation = $5
+		WHERE id = $6
+	`, rule.ScaleType, rule.MinPercentage, rule.MaxPercentage, rule.Interpretation, rule.Recommendation, ruleID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка обновления правила интерпретации"})
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка завершения операции"})
+		return
+	}
+
+	rule.ID = ruleID
+	rule.TestID = testID
+	response := gin.H{"message": "Правило интерпретации обновлено", "rule": rule}
+	if versionNumber > 0 {
+		response["version_number"] = versionNumber
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// Удаление правила интерпретации
+func DeleteInterpretationRule(c *gin.Context) {
+	testID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Неверный ID теста"})
+		return
+	}
+	ruleID, err := strconv.Atoi(c.Param("ruleId"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Неверный ID правила"})
+		return
+	}
+
+	tx, err := database.DB.Begin()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка начала транзакции"})
+		return
+	}
+	defer tx.Rollback()
+
+	userID, _ := c.Get("userID")
+	ruleID, versionNumber, err := editableRule(tx, testID, ruleID, userID, "Изменение правил интерпретации")
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Правило интерпретации не найдено"})
+		return
+	} else if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка создания версии теста"})
+		return
+	}
+
+	if _, err := tx.Exec("DELETE FROM interpretation_rules WHERE id = $1", ruleID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка удаления правила интерпретации"})
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка завершения операции"})
+		return
+	}
+
+	respondVersionedChange(c, "Правило интерпретации удалено", versionNumber)
+}
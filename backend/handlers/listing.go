@@ -0,0 +1,139 @@
+package handlers
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Размер страницы списков администратора по умолчанию и наибольший допустимый
+const (
+	defaultPageSize = 50
+	maxPageSize     = 500
+)
+
+// listQuery - параметры постраничного списка: page, page_size, sort, order и строка поиска q
+type listQuery struct {
+	Page     int
+	PageSize int
+	Sort     string
+	Desc     bool
+	Search   string // шаблон для ILIKE, пустой - без поиска
+	orderBy  string
+}
+
+func (query *listQuery) Offset() int {
+	return (query.Page - 1) * query.PageSize
+}
+
+// OrderBy - выражение сортировки; id в конце делает порядок страниц устойчивым
+func (query *listQuery) OrderBy(idColumn string) string {
+	direction := "ASC"
+	if query.Desc {
+		direction = "DESC"
+	}
+	return fmt.Sprintf("%s %s NULLS LAST, %s %s", query.orderBy, direction, idColumn, direction)
+}
+
+// Pagination - сведения о странице для ответа
+func (query *listQuery) Pagination(total int) gin.H {
+	return gin.H{
+		"page":      query.Page,
+		"page_size": query.PageSize,
+		"total":     total,
+		"pages":     (total + query.PageSize - 1) / query.PageSize,
+		"sort":      query.Sort,
+		"order":     map[bool]string{false: "asc", true: "desc"}[query.Desc],
+	}
+}
+
+// likePattern - шаблон ILIKE для поиска подстроки; % и _ в тексте поиска экранируются
+func likePattern(search string) string {
+	search = strings.TrimSpace(search)
+	if search == "" {
+		return ""
+	}
+	replacer := strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+	return "%" + replacer.Replace(search) + "%"
+}
+
+// parseListQuery читает параметры списка. sortColumns сопоставляет допустимые значения sort
+// с выражениями SQL, в запрос попадают только они. При ошибке возвращается её описание.
+func parseListQuery(c *gin.Context, sortColumns map[string]string, defaultSort string, defaultDesc bool) (*listQuery, string) {
+	query := &listQuery{Page: 1, PageSize: defaultPageSize, Sort: defaultSort, Desc: defaultDesc, Search: likePattern(c.Query("q"))}
+
+	if value := c.Query("page"); value != "" {
+		page, err := strconv.Atoi(value)
+		if err != nil || page < 1 {
+			return nil, "Неверный номер страницы"
+		}
+		query.Page = page
+	}
+	if value := c.Query("page_size"); value != "" {
+		size, err := strconv.Atoi(value)
+		if err != nil || size < 1 || size > maxPageSize {
+			return nil, fmt.Sprintf("Размер страницы должен быть от 1 до %d", maxPageSize)
+		}
+		query.PageSize = size
+	}
+
+	if value := c.Query("sort"); value != "" {
+		if _, ok := sortColumns[value]; !ok {
+			names := make([]string, 0, len(sortColumns))
+			for name := range sortColumns {
+				names = append(names, name)
+			}
+			sort.Strings(names)
+			return nil, "Неизвестное поле сортировки, допустимы: " + strings.Join(names, ", ")
+		}
+		query.Sort = value
+	}
+	query.orderBy = sortColumns[query.Sort]
+
+	switch c.Query("order") {
+	case "":
+	case "asc":
+		query.Desc = false
+	case "desc":
+		query.Desc = true
+	default:
+		return nil, "Порядок сортировки принимает значения asc или desc"
+	}
+	return query, ""
+}
+
+// parseDateRange читает период from и to (ГГГГ-ММ-ДД). Границы возвращаются как nil или
+// time.Time; последний день периода входит в него целиком.
+func parseDateRange(c *gin.Context) (interface{}, interface{}, string) {
+	var from, to time.Time
+	if value := c.Query("from"); value != "" {
+		parsed, err := time.Parse(exportDateLayout, value)
+		if err != nil {
+			return nil, nil, "Неверная дата начала периода, ожидается ГГГГ-ММ-ДД"
+		}
+		from = parsed
+	}
+	if value := c.Query("to"); value != "" {
+		parsed, err := time.Parse(exportDateLayout, value)
+		if err != nil {
+			return nil, nil, "Неверная дата окончания периода, ожидается ГГГГ-ММ-ДД"
+		}
+		to = parsed.AddDate(0, 0, 1)
+	}
+	if !from.IsZero() && !to.IsZero() && !from.Before(to) {
+		return nil, nil, "Дата начала периода позже даты окончания"
+	}
+
+	var fromArg, toArg interface{}
+	if !from.IsZero() {
+		fromArg = from
+	}
+	if !to.IsZero() {
+		toArg = to
+	}
+	return fromArg, toArg, ""
+}
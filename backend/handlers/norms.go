@@ -0,0 +1,490 @@
+package handlers
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"psycho-test-system/database"
+	"psycho-test-system/models"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Минимальный объём выборки для пересчёта норм по умолчанию
+const defaultNormSampleSize = 30
+
+// loadNormTables загружает нормативные таблицы теста
+func loadNormTables(testID int) ([]models.NormTable, error) {
+	rows, err := database.DB.Query(`
+		SELECT n.id, n.test_id, n.version_id, tv.version_number, n.scale_type, n.gender, n.age_min, n.age_max,
+		       n.mean, n.std_dev, n.sample_size, n.source, n.updated_at
+		FROM norm_tables n
+		LEFT JOIN test_versions tv ON tv.id = n.version_id
+		WHERE n.test_id = $1
+		ORDER BY n.scale_type, n.source DESC, n.gender, n.age_min NULLS FIRST
+	`, testID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	norms := []models.NormTable{}
+	for rows.Next() {
+		var norm models.NormTable
+		var versionID, versionNumber, ageMin, ageMax sql.NullInt64
+		if err := rows.Scan(&norm.ID, &norm.TestID, &versionID, &versionNumber, &norm.ScaleType, &norm.Gender, &ageMin, &ageMax,
+			&norm.Mean, &norm.StdDev, &norm.SampleSize, &norm.Source, &norm.UpdatedAt); err != nil {
+			return nil, err
+		}
+		if versionID.Valid {
+			value := int(versionID.Int64)
+			norm.VersionID = &value
+		}
+		if versionNumber.Valid {
+			value := int(versionNumber.Int64)
+			norm.VersionNumber = &value
+		}
+		if ageMin.Valid {
+			value := int(ageMin.Int64)
+			norm.AgeMin = &value
+		}
+		if ageMax.Valid {
+			value := int(ageMax.Int64)
+			norm.AgeMax = &value
+		}
+		norms = append(norms, norm)
+	}
+	return norms, rows.Err()
+}
+
+// selectNormTable выбирает самую точную таблицу для шкалы: совпадение по полу важнее
+// совпадения по возрасту, при равенстве загруженные нормы важнее рассчитанных.
+// Таблицы с границами возраста не подходят, если возраст неизвестен.
+func selectNormTable(norms []models.NormTable, scaleType, gender string, age *int) *models.NormTable {
+	var selected *models.NormTable
+	bestRank := -1
+	for i := range norms {
+		norm := &norms[i]
+		if norm.ScaleType != scaleType {
+			continue
+		}
+		if norm.Gender != "" && norm.Gender != gender {
+			continue
+		}
+		hasAgeBounds := norm.AgeMin != nil || norm.AgeMax != nil
+		if hasAgeBounds {
+			if age == nil || (norm.AgeMin != nil && *age < *norm.AgeMin) || (norm.AgeMax != nil && *age > *norm.AgeMax) {
+				continue
+			}
+		}
+
+		rank := 0
+		if norm.Gender != "" {
+			rank += 4
+		}
+		if hasAgeBounds {
+			rank += 2
+		}
+		if norm.Source == models.NormSourceUpload {
+			rank++
+		}
+		if rank > bestRank {
+			selected, bestRank = norm, rank
+		}
+	}
+	return selected
+}
+
+// normGroupLabel - описание нормативной группы для отчёта
+func normGroupLabel(norm *models.NormTable) string {
+	var parts []string
+	switch norm.Gender {
+	case models.GenderMale:
+		parts = append(parts, "мужчины")
+	case models.GenderFemale:
+		parts = append(parts, "женщины")
+	}
+	switch {
+	case norm.AgeMin != nil && norm.AgeMax != nil:
+		parts = append(parts, fmt.Sprintf("%d–%d лет", *norm.AgeMin, *norm.AgeMax))
+	case norm.AgeMin != nil:
+		parts = append(parts, fmt.Sprintf("от %d лет", *norm.AgeMin))
+	case norm.AgeMax != nil:
+		parts = append(parts, fmt.Sprintf("до %d лет", *norm.AgeMax))
+	}
+	if len(parts) == 0 {
+		return "общая выборка"
+	}
+	return strings.Join(parts, ", ")
+}
+
+// standardScores переводит сырой балл в стандартные шкалы через z-оценку:
+// стен = 5.5 + 2z (от 1 до 10), T = 50 + 10z, процентиль - доля нормальной выборки ниже балла
+func standardScores(raw, mean, stdDev float64) (int, float64, float64) {
+	z := (raw - mean) / stdDev
+
+	sten := int(math.Round(5.5 + 2*z))
+	if sten < 1 {
+		sten = 1
+	} else if sten > 10 {
+		sten = 10
+	}
+
+	tScore := math.Round((50+10*z)*10) / 10
+	percentile := math.Round(50*(1+math.Erf(z/math.Sqrt2))*10) / 10
+	return sten, tScore, percentile
+}
+
+// loadRespondent возвращает пол и возраст пользователя в полных годах (nil, если дата рождения не указана)
+func loadRespondent(userID int) (string, *int, error) {
+	var gender string
+	var age sql.NullInt64
+	err := database.DB.QueryRow(`
+		SELECT COALESCE(gender, ''), EXTRACT(YEAR FROM AGE(birth_date))::int
+		FROM users WHERE id = $1
+	`, userID).Scan(&gender, &age)
+	if err != nil {
+		return "", nil, err
+	}
+	if !age.Valid {
+		return gender, nil, nil
+	}
+	value := int(age.Int64)
+	return gender, &value, nil
+}
+
+// applyNorms заполняет стены, T-баллы и процентили шкал по нормам, подходящим пользователю.
+// Шкалы без подходящей таблицы остаются только с сырыми баллами.
+func applyNorms(testID, userID int, scales []models.ScaleResult) error {
+	norms, err := loadNormTables(testID)
+	if err != nil {
+		return fmt.Errorf("ошибка загрузки норм: %v", err)
+	}
+	if len(norms) == 0 {
+		return nil
+	}
+
+	gender, age, err := loadRespondent(userID)
+	if err != nil {
+		return fmt.Errorf("ошибка загрузки данных пользователя: %v", err)
+	}
+
+	for i := range scales {
+		scale := &scales[i]
+		norm := selectNormTable(norms, scale.ScaleName, gender, age)
+		if norm == nil {
+			continue
+		}
+		sten, tScore, percentile := standardScores(scale.Score, norm.Mean, norm.StdDev)
+		scale.Sten, scale.TScore, scale.Percentile = &sten, &tScore, &percentile
+		scale.NormGroup = normGroupLabel(norm)
+	}
+	return nil
+}
+
+func validateNormTable(norm *models.NormTable) string {
+	norm.ScaleType = strings.TrimSpace(norm.ScaleType)
+	if norm.ScaleType == "" {
+		return "Название шкалы обязательно"
+	}
+	if norm.Gender != "" && norm.Gender != models.GenderMale && norm.Gender != models.GenderFemale {
+		return fmt.Sprintf("Шкала «%s»: неизвестный пол «%s»", norm.ScaleType, norm.Gender)
+	}
+	if (norm.AgeMin != nil && *norm.AgeMin < 0) || (norm.AgeMax != nil && *norm.AgeMax < 0) {
+		return fmt.Sprintf("Шкала «%s»: возраст не может быть отрицательным", norm.ScaleType)
+	}
+	if norm.AgeMin != nil && norm.AgeMax != nil && *norm.AgeMin > *norm.AgeMax {
+		return fmt.Sprintf("Шкала «%s»: нижняя граница возраста больше верхней", norm.ScaleType)
+	}
+	if math.IsNaN(norm.Mean) || math.IsNaN(norm.StdDev) || norm.StdDev <= 0 {
+		return fmt.Sprintf("Шкала «%s»: стандартное отклонение должно быть больше нуля", norm.ScaleType)
+	}
+	if norm.SampleSize < 0 {
+		return fmt.Sprintf("Шкала «%s»: объём выборки не может быть отрицательным", norm.ScaleType)
+	}
+	return ""
+}
+
+// normGroupKey - ключ нормативной группы для поиска дублей
+func normGroupKey(norm models.NormTable) string {
+	bound := func(value *int) string {
+		if value == nil {
+			return "-"
+		}
+		return strconv.Itoa(*value)
+	}
+	return norm.ScaleType + "|" + norm.Gender + "|" + bound(norm.AgeMin) + "|" + bound(norm.AgeMax)
+}
+
+// validateNormTables проверяет набор таблиц: каждая нормативная группа шкалы указывается один раз
+func validateNormTables(norms []models.NormTable) string {
+	groups := make(map[string]bool, len(norms))
+	for i := range norms {
+		if message := validateNormTable(&norms[i]); message != "" {
+			return message
+		}
+		key := normGroupKey(norms[i])
+		if groups[key] {
+			return fmt.Sprintf("Шкала «%s»: норма для группы «%s» указана дважды",
+				norms[i].ScaleType, normGroupLabel(&norms[i]))
+		}
+		groups[key] = true
+	}
+	return ""
+}
+
+// writeNormTables заменяет таблицы теста из указанного источника в рамках транзакции.
+// Версия теста сохраняется из VersionID таблицы.
+func writeNormTables(tx *sql.Tx, testID int, source string, norms []models.NormTable) error {
+	if _, err := tx.Exec("DELETE FROM norm_tables WHERE test_id = $1 AND source = $2", testID, source); err != nil {
+		return fmt.Errorf("ошибка удаления норм: %v", err)
+	}
+
+	rows := make([][]interface{}, 0, len(norms))
+	for _, norm := range norms {
+		rows = append(rows, []interface{}{testID, norm.VersionID, norm.ScaleType, norm.Gender, norm.AgeMin, norm.AgeMax,
+			norm.Mean, norm.StdDev, norm.SampleSize, source})
+	}
+	return insertBatch(tx, "norm_tables",
+		[]string{"test_id", "version_id", "scale_type", "gender", "age_min", "age_max", "mean", "std_dev", "sample_size", "source"}, rows)
+}
+
+// replaceNormTables заменяет таблицы теста из указанного источника
+func replaceNormTables(testID int, source string, norms []models.NormTable) error {
+	tx, err := database.DB.Begin()
+	if err != nil {
+		return fmt.Errorf("ошибка начала транзакции: %v", err)
+	}
+	defer tx.Rollback()
+
+	if err := writeNormTables(tx, testID, source, norms); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// Получение нормативных таблиц теста
+func GetNormTables(c *gin.Context) {
+	testID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Неверный ID теста"})
+		return
+	}
+
+	exists, err := testExists(testID)
+	if err != nil || !exists {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Тест не найден"})
+		return
+	}
+
+	norms, err := loadNormTables(testID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка получения норм"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"norms": norms})
+}
+
+// Загрузка опубликованных норм теста. Заменяет ранее загруженные таблицы,
+// рассчитанные по результатам системы остаются.
+func UploadNormTables(c *gin.Context) {
+	testID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Неверный ID теста"})
+		return
+	}
+
+	var normsReq struct {
+		Norms []models.NormTable `json:"norms"`
+	}
+	if err := c.ShouldBindJSON(&normsReq); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Неверный формат данных"})
+		return
+	}
+
+	if message := validateNormTables(normsReq.Norms); message != "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": message})
+		return
+	}
+
+	exists, err := testExists(testID)
+	if err != nil || !exists {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Тест не найден"})
+		return
+	}
+
+	if err := replaceNormTables(testID, models.NormSourceUpload, normsReq.Norms); err != nil {
+		log.Printf("Ошибка сохранения норм теста %d: %v", testID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка сохранения норм"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Нормы сохранены", "count": len(normsReq.Norms)})
+}
+
+// normSample - накопленные сырые баллы одной нормативной группы
+type normSample struct {
+	norm  models.NormTable
+	sum   float64
+	sumSq float64
+	count int
+}
+
+// Пересчёт норм по официальным попыткам пользователей на текущей версии теста: баллы
+// разных версий не смешиваются, а рассчитанные таблицы запоминают версию, по которой построены.
+// Недостоверные результаты не учитываются. Всегда считается общая выборка, по запросу - отдельно по полу и возрастным группам.
+// Группы меньше min_sample_size пропускаются.
+func RecomputeNormTables(c *gin.Context) {
+	testID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Неверный ID теста"})
+		return
+	}
+
+	var recomputeReq models.NormRecomputeRequest
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&recomputeReq); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Неверный формат данных"})
+			return
+		}
+	}
+	if recomputeReq.MinSampleSize == 0 {
+		recomputeReq.MinSampleSize = defaultNormSampleSize
+	}
+	if recomputeReq.MinSampleSize < 2 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Минимальный объём выборки - 2 результата"})
+		return
+	}
+	for _, group := range recomputeReq.AgeGroups {
+		if group[0] < 0 || group[0] > group[1] {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Неверная возрастная группа %d–%d", group[0], group[1])})
+			return
+		}
+	}
+
+	versionID, err := resolveVersionID(testID, 0)
+	if err == sql.ErrNoRows || (err == nil && versionID == 0) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Тест не найден"})
+		return
+	}
+	if err != nil {
+		log.Printf("Ошибка получения версии теста %d: %v", testID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка получения версии теста"})
+		return
+	}
+
+	// Возраст берётся на момент прохождения теста
+	rows, err := database.DB.Query(`
+		SELECT trs.scale_type, trs.score, official.gender, official.age
+		FROM (
+			SELECT DISTINCT ON (tr.user_id)
+			       tr.id, COALESCE(u.gender, '') as gender,
+			       EXTRACT(YEAR FROM AGE(tr.completed_at, u.birth_date))::int as age
+			FROM test_results tr
+			JOIN users u ON u.id = tr.user_id
+			JOIN psychological_tests pt ON pt.id = tr.test_id
+			WHERE tr.test_id = $1 AND tr.version_id = $3 AND u.role = $2 AND COALESCE(tr.is_valid, true)
+			ORDER BY tr.user_id,
+			         CASE WHEN COALESCE(pt.official_attempt, 'latest') = 'first' THEN tr.completed_at END ASC,
+			         tr.completed_at DESC
+		) official
+		JOIN test_result_scales trs ON trs.result_id = official.id
+	`, testID, models.RoleUser, versionID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка получения результатов"})
+		return
+	}
+	defer rows.Close()
+
+	samples := make(map[string]*normSample)
+	var order []string
+	addScore := func(norm models.NormTable, score float64) {
+		key := normGroupKey(norm)
+		sample, exists := samples[key]
+		if !exists {
+			sample = &normSample{norm: norm}
+			samples[key] = sample
+			order = append(order, key)
+		}
+		sample.sum += score
+		sample.sumSq += score * score
+		sample.count++
+	}
+
+	for rows.Next() {
+		var scaleType, gender string
+		var score float64
+		var age sql.NullInt64
+		if err := rows.Scan(&scaleType, &score, &gender, &age); err != nil {
+			log.Printf("Ошибка чтения результатов теста %d для норм: %v", testID, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка получения результатов"})
+			return
+		}
+
+		addScore(models.NormTable{ScaleType: scaleType}, score)
+		if recomputeReq.ByGender && gender != "" {
+			addScore(models.NormTable{ScaleType: scaleType, Gender: gender}, score)
+		}
+		if !age.Valid {
+			continue
+		}
+		for _, group := range recomputeReq.AgeGroups {
+			if int(age.Int64) < group[0] || int(age.Int64) > group[1] {
+				continue
+			}
+			ageMin, ageMax := group[0], group[1]
+			addScore(models.NormTable{ScaleType: scaleType, AgeMin: &ageMin, AgeMax: &ageMax}, score)
+			if recomputeReq.ByGender && gender != "" {
+				addScore(models.NormTable{ScaleType: scaleType, Gender: gender, AgeMin: &ageMin, AgeMax: &ageMax}, score)
+			}
+		}
+	}
+	if err := rows.Err(); err != nil {
+		log.Printf("Ошибка чтения результатов теста %d для норм: %v", testID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка получения результатов"})
+		return
+	}
+
+	norms := []models.NormTable{}
+	skipped := 0
+	for _, key := range order {
+		sample := samples[key]
+		if sample.count < recomputeReq.MinSampleSize {
+			skipped++
+			continue
+		}
+		// Несмещённая оценка стандартного отклонения
+		mean := sample.sum / float64(sample.count)
+		variance := (sample.sumSq - float64(sample.count)*mean*mean) / float64(sample.count-1)
+		stdDev := math.Round(math.Sqrt(math.Max(variance, 0))*1000) / 1000
+		if stdDev <= 0 {
+			skipped++
+			continue
+		}
+		norm := sample.norm
+		norm.TestID = testID
+		norm.VersionID = &versionID
+		norm.Mean = math.Round(mean*1000) / 1000
+		norm.StdDev = stdDev
+		norm.SampleSize = sample.count
+		norm.Source = models.NormSourceComputed
+		norms = append(norms, norm)
+	}
+
+	if err := replaceNormTables(testID, models.NormSourceComputed, norms); err != nil {
+		log.Printf("Ошибка сохранения рассчитанных норм теста %d: %v", testID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка сохранения норм"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Нормы пересчитаны",
+		"norms":   norms,
+		"skipped": skipped,
+	})
+}
@@ -0,0 +1,553 @@
+package handlers
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"psycho-test-system/database"
+	"psycho-test-system/models"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/goccy/go-yaml"
+)
+
+// Максимальный размер импортируемого пакета
+const maxPackageSize = 5 << 20
+
+// Комментарий версии теста, созданной импортом
+const importVersionComment = "Импорт из пакета"
+
+// packageNormTable и normPackageEntry переводят нормы между форматом базы и пакета
+func packageNormTable(norm models.PackageNorm) models.NormTable {
+	return models.NormTable{
+		ScaleType: norm.ScaleType, Gender: norm.Gender, AgeMin: norm.AgeMin, AgeMax: norm.AgeMax,
+		Mean: norm.Mean, StdDev: norm.StdDev, SampleSize: norm.SampleSize,
+	}
+}
+
+func normPackageEntry(norm models.NormTable) models.PackageNorm {
+	return models.PackageNorm{
+		ScaleType: norm.ScaleType, Gender: norm.Gender, AgeMin: norm.AgeMin, AgeMax: norm.AgeMax,
+		Mean: norm.Mean, StdDev: norm.StdDev, SampleSize: norm.SampleSize,
+	}
+}
+
+// sortPackageSections упорядочивает разделы пакета, порядок которых не важен,
+// чтобы пакеты из разных источников можно было сравнивать
+func sortPackageSections(pkg *models.TestPackage) {
+	for i := range pkg.ScaleKeys {
+		sort.Ints(pkg.ScaleKeys[i].Direct)
+		sort.Ints(pkg.ScaleKeys[i].Reverse)
+	}
+	sort.SliceStable(pkg.ScaleKeys, func(i, j int) bool {
+		return pkg.ScaleKeys[i].ScaleType < pkg.ScaleKeys[j].ScaleType
+	})
+	sort.SliceStable(pkg.InterpretationRules, func(i, j int) bool {
+		a, b := pkg.InterpretationRules[i], pkg.InterpretationRules[j]
+		if a.ScaleType != b.ScaleType {
+			return a.ScaleType < b.ScaleType
+		}
+		return a.MinPercentage < b.MinPercentage
+	})
+	sort.SliceStable(pkg.Norms, func(i, j int) bool {
+		return normGroupKey(packageNormTable(pkg.Norms[i])) < normGroupKey(packageNormTable(pkg.Norms[j]))
+	})
+}
+
+// loadTestPackage собирает пакет с текущей версией теста
+func loadTestPackage(testID int) (*models.TestPackage, error) {
+	pkg := &models.TestPackage{
+		Format:     models.TestPackageFormat,
+		Version:    models.TestPackageVersion,
+		ExportedAt: time.Now(),
+	}
+
+	var versionID int
+	test := &pkg.Test
+	err := database.DB.QueryRow(`
+		SELECT title, COALESCE(description, ''), COALESCE(instructions, ''), COALESCE(estimated_time, 0),
+		       pass_threshold, methodology_type, COALESCE(time_limit, 0), COALESCE(late_submission_policy, 'flag'),
+		       COALESCE(max_attempts, 0), COALESCE(retake_interval_hours, 0), COALESCE(official_attempt, 'latest'),
+		       COALESCE(current_version_id, 0)
+		FROM psychological_tests WHERE id = $1
+	`, testID).Scan(&test.Title, &test.Description, &test.Instructions, &test.EstimatedTime,
+		&test.PassThreshold, &test.MethodologyType, &test.TimeLimit, &test.LateSubmissionPolicy,
+		&test.MaxAttempts, &test.RetakeIntervalHours, &test.OfficialAttempt, &versionID)
+	if err != nil {
+		return nil, err
+	}
+
+	if pkg.Questions, err = loadVersionQuestions(versionID); err != nil {
+		return nil, fmt.Errorf("ошибка загрузки вопросов: %v", err)
+	}
+	if pkg.ScaleKeys, err = loadScaleKeyTable(versionID); err != nil {
+		return nil, fmt.Errorf("ошибка загрузки ключей шкал: %v", err)
+	}
+
+	rules, err := loadInterpretationRules(versionID)
+	if err != nil {
+		return nil, fmt.Errorf("ошибка загрузки правил интерпретации: %v", err)
+	}
+	pkg.InterpretationRules = make([]models.PackageRule, 0, len(rules))
+	for _, rule := range rules {
+		pkg.InterpretationRules = append(pkg.InterpretationRules, models.PackageRule{
+			ScaleType: rule.ScaleType, MinPercentage: rule.MinPercentage, MaxPercentage: rule.MaxPercentage,
+			Interpretation: rule.Interpretation, Recommendation: rule.Recommendation,
+		})
+	}
+
+	validity, err := loadValiditySettings(versionID)
+	if err != nil {
+		return nil, fmt.Errorf("ошибка загрузки настроек достоверности: %v", err)
+	}
+	pkg.Validity = *validity
+
+	// Рассчитанные нормы переносятся, только если для группы нет опубликованной:
+	// в пакете на группу приходится одна таблица
+	norms, err := loadNormTables(testID)
+	if err != nil {
+		return nil, fmt.Errorf("ошибка загрузки норм: %v", err)
+	}
+	pkg.Norms = []models.PackageNorm{}
+	groups := make(map[string]bool, len(norms))
+	for _, norm := range norms {
+		if key := normGroupKey(norm); !groups[key] {
+			groups[key] = true
+			pkg.Norms = append(pkg.Norms, normPackageEntry(norm))
+		}
+	}
+
+	sortPackageSections(pkg)
+	return pkg, nil
+}
+
+// parseTestPackage разбирает пакет в формате JSON или YAML
+func parseTestPackage(data []byte, format string) (*models.TestPackage, error) {
+	var pkg models.TestPackage
+	var err error
+	if format == "yaml" {
+		err = yaml.Unmarshal(data, &pkg)
+	} else {
+		err = json.Unmarshal(data, &pkg)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &pkg, nil
+}
+
+// packageFormat определяет формат пакета: параметр format, затем Content-Type, затем содержимое
+func packageFormat(c *gin.Context, data []byte) string {
+	if format := c.Query("format"); format != "" {
+		return format
+	}
+	if strings.Contains(c.ContentType(), "yaml") {
+		return "yaml"
+	}
+	if strings.HasPrefix(string(bytes.TrimSpace(data)), "{") {
+		return "json"
+	}
+	return "yaml"
+}
+
+// validateTestPackage проверяет пакет и приводит его к виду, в котором тест хранится в базе
+func validateTestPackage(pkg *models.TestPackage) string {
+	if pkg.Format != models.TestPackageFormat {
+		return fmt.Sprintf("Неизвестный формат пакета «%s»", pkg.Format)
+	}
+	if pkg.Version < 1 || pkg.Version > models.TestPackageVersion {
+		return fmt.Sprintf("Версия пакета %d не поддерживается, поддерживаются версии до %d", pkg.Version, models.TestPackageVersion)
+	}
+
+	test := &pkg.Test
+	test.Title = strings.TrimSpace(test.Title)
+	if test.Title == "" {
+		return "Название теста обязательно"
+	}
+	if test.PassThreshold < 0 || test.PassThreshold > 100 {
+		return "Порог прохождения должен быть от 0 до 100%"
+	}
+	if test.EstimatedTime < 0 || test.TimeLimit < 0 || test.MaxAttempts < 0 || test.RetakeIntervalHours < 0 {
+		return "Время, лимит и число попыток не могут быть отрицательными"
+	}
+	test.LateSubmissionPolicy = normalizeLatePolicy(test.LateSubmissionPolicy)
+	test.OfficialAttempt = normalizeOfficialAttempt(test.OfficialAttempt)
+
+	if len(pkg.Questions) == 0 {
+		return "В пакете нет вопросов"
+	}
+	if message := normalizeQuestionInputs(pkg.Questions); message != "" {
+		return message
+	}
+
+	if pkg.ScaleKeys == nil {
+		pkg.ScaleKeys = []models.ScaleKey{}
+	}
+	if message := validateScaleKeys(pkg.ScaleKeys, len(pkg.Questions)); message != "" {
+		return message
+	}
+	for i := range pkg.ScaleKeys {
+		key := &pkg.ScaleKeys[i]
+		key.ScaleType = strings.TrimSpace(key.ScaleType)
+		if key.Direct == nil {
+			key.Direct = []int{}
+		}
+		if key.Reverse == nil {
+			key.Reverse = []int{}
+		}
+	}
+
+	if pkg.InterpretationRules == nil {
+		pkg.InterpretationRules = []models.PackageRule{}
+	}
+	for i, rule := range pkg.InterpretationRules {
+		if message := validateInterpretationRule(models.InterpretationRule{
+			MinPercentage: rule.MinPercentage, MaxPercentage: rule.MaxPercentage, Interpretation: rule.Interpretation,
+		}); message != "" {
+			return fmt.Sprintf("Правило интерпретации №%d: %s", i+1, message)
+		}
+	}
+
+	if pkg.Validity.InconsistencyPairs == nil {
+		pkg.Validity.InconsistencyPairs = []models.InconsistencyPair{}
+	}
+	if message := validateValiditySettings(&pkg.Validity, len(pkg.Questions)); message != "" {
+		return message
+	}
+
+	if pkg.Norms == nil {
+		pkg.Norms = []models.PackageNorm{}
+	}
+	norms := make([]models.NormTable, 0, len(pkg.Norms))
+	for _, norm := range pkg.Norms {
+		norms = append(norms, packageNormTable(norm))
+	}
+	if message := validateNormTables(norms); message != "" {
+		return message
+	}
+	for i := range norms {
+		pkg.Norms[i] = normPackageEntry(norms[i])
+	}
+
+	sortPackageSections(pkg)
+	return ""
+}
+
+// packageTestDiffFields - поля описания теста, которые различаются, по именам из пакета
+func packageTestDiffFields(before, after models.PackageTest) []string {
+	fields := []string{}
+	beforeValue, afterValue := reflect.ValueOf(before), reflect.ValueOf(after)
+	for i := 0; i < beforeValue.NumField(); i++ {
+		if beforeValue.Field(i).Interface() != afterValue.Field(i).Interface() {
+			fields = append(fields, beforeValue.Type().Field(i).Tag.Get("json"))
+		}
+	}
+	return fields
+}
+
+// sameJSON сравнивает разделы пакета по их сериализованному виду
+func sameJSON(a, b interface{}) bool {
+	dataA, errA := json.Marshal(a)
+	dataB, errB := json.Marshal(b)
+	return errA == nil && errB == nil && bytes.Equal(dataA, dataB)
+}
+
+// previewPackageImport описывает изменения, которые внесёт импорт пакета.
+// testID = 0 - пакет импортируется как новый тест.
+func previewPackageImport(testID int, pkg *models.TestPackage) (*models.PackageImportPreview, error) {
+	preview := &models.PackageImportPreview{
+		TestID:                   testID,
+		CreatesTest:              testID == 0,
+		QuestionsCount:           len(pkg.Questions),
+		ScaleKeysCount:           len(pkg.ScaleKeys),
+		InterpretationRulesCount: len(pkg.InterpretationRules),
+		NormsCount:               len(pkg.Norms),
+	}
+
+	if testID == 0 {
+		preview.ChangedFields = []string{}
+		preview.QuestionChanges = compareVersionQuestions(nil, pkg.Questions)
+		preview.ScaleKeysChanged = len(pkg.ScaleKeys) > 0
+		preview.InterpretationRulesChanged = len(pkg.InterpretationRules) > 0
+		preview.ValidityChanged = !sameJSON(pkg.Validity, models.ValiditySettings{InconsistencyPairs: []models.InconsistencyPair{}})
+		preview.NormsChanged = len(pkg.Norms) > 0
+		return preview, nil
+	}
+
+	current, err := loadTestPackage(testID)
+	if err != nil {
+		return nil, err
+	}
+	preview.ChangedFields = packageTestDiffFields(current.Test, pkg.Test)
+	preview.QuestionChanges = compareVersionQuestions(current.Questions, pkg.Questions)
+	preview.ScaleKeysChanged = !sameJSON(current.ScaleKeys, pkg.ScaleKeys)
+	preview.InterpretationRulesChanged = !sameJSON(current.InterpretationRules, pkg.InterpretationRules)
+	preview.ValidityChanged = !sameJSON(current.Validity, pkg.Validity)
+	preview.NormsChanged = !sameJSON(current.Norms, pkg.Norms)
+	return preview, nil
+}
+
+// applyTestPackage записывает пакет в базу: создаёт тест или обновляет существующий.
+// Новая версия создаётся, если изменились вопросы, название или порог прохождения, а при
+// изменении только настроек подсчёта - по правилам editableVersion.
+// Возвращает ID теста и номер созданной версии (0 - версия не создавалась).
+func applyTestPackage(tx *sql.Tx, testID int, userID interface{}, pkg *models.TestPackage,
+	preview *models.PackageImportPreview) (int, int, error) {
+	test := pkg.Test
+	if testID == 0 {
+		err := tx.QueryRow(`
+			INSERT INTO psychological_tests (title, description, instructions, estimated_time, pass_threshold, methodology_type,
+			                                 time_limit, late_submission_policy, max_attempts, retake_interval_hours, official_attempt, created_by)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12) RETURNING id
+		`, test.Title, test.Description, test.Instructions, test.EstimatedTime, test.PassThreshold, test.MethodologyType,
+			test.TimeLimit, test.LateSubmissionPolicy, test.MaxAttempts, test.RetakeIntervalHours, test.OfficialAttempt,
+			userID).Scan(&testID)
+		if err != nil {
+			return 0, 0, fmt.Errorf("ошибка создания теста: %v", err)
+		}
+	} else {
+		_, err := tx.Exec(`
+			UPDATE psychological_tests
+			SET title = $1, description = $2, instructions = $3, estimated_time = $4, pass_threshold = $5, methodology_type = $6,
+			    time_limit = $7, late_submission_policy = $8, max_attempts = $9, retake_interval_hours = $10, official_attempt = $11
+			WHERE id = $12
+		`, test.Title, test.Description, test.Instructions, test.EstimatedTime, test.PassThreshold, test.MethodologyType,
+			test.TimeLimit, test.LateSubmissionPolicy, test.MaxAttempts, test.RetakeIntervalHours, test.OfficialAttempt, testID)
+		if err != nil {
+			return 0, 0, fmt.Errorf("ошибка обновления теста: %v", err)
+		}
+	}
+
+	versionFieldsChanged := false
+	for _, field := range preview.ChangedFields {
+		if field == "title" || field == "pass_threshold" {
+			versionFieldsChanged = true
+		}
+	}
+	configChanged := preview.ScaleKeysChanged || preview.InterpretationRulesChanged || preview.ValidityChanged
+
+	// Настройки подсчёта новой версии целиком берутся из пакета, поэтому из текущей не копируются
+	versionID, versionNumber := 0, 0
+	var err error
+	switch {
+	case len(preview.QuestionChanges) > 0 || versionFieldsChanged:
+		versionID, versionNumber, err = createTestVersion(tx, testID, userID, importVersionComment, pkg.Questions, 0)
+		configChanged = true
+	case configChanged:
+		versionID, versionNumber, err = editableVersion(tx, testID, userID, importVersionComment)
+	}
+	if err != nil {
+		return 0, 0, err
+	}
+
+	if configChanged {
+		if err := writeScaleKeys(tx, testID, versionID, pkg.ScaleKeys); err != nil {
+			return 0, 0, err
+		}
+
+		if _, err := tx.Exec("DELETE FROM interpretation_rules WHERE version_id = $1", versionID); err != nil {
+			return 0, 0, fmt.Errorf("ошибка удаления правил интерпретации: %v", err)
+		}
+		rules := make([][]interface{}, 0, len(pkg.InterpretationRules))
+		for _, rule := range pkg.InterpretationRules {
+			rules = append(rules, []interface{}{testID, versionID, rule.ScaleType, rule.MinPercentage, rule.MaxPercentage,
+				rule.Interpretation, rule.Recommendation})
+		}
+		if err := insertBatch(tx, "interpretation_rules",
+			[]string{"test_id", "version_id", "scale_type", "min_percentage", "max_percentage", "interpretation", "recommendation"}, rules); err != nil {
+			return 0, 0, err
+		}
+
+		if err := writeValiditySettings(tx, testID, versionID, &pkg.Validity); err != nil {
+			return 0, 0, fmt.Errorf("ошибка сохранения настроек достоверности: %v", err)
+		}
+	}
+
+	// Нормы из пакета считаются опубликованными; рассчитанные по результатам этой базы не трогаются
+	norms := make([]models.NormTable, 0, len(pkg.Norms))
+	for _, norm := range pkg.Norms {
+		norms = append(norms, packageNormTable(norm))
+	}
+	if err := writeNormTables(tx, testID, models.NormSourceUpload, norms); err != nil {
+		return 0, 0, err
+	}
+
+	return testID, versionNumber, nil
+}
+
+// Экспорт текущей версии теста в переносимый пакет. Формат задаётся параметром format: json (по умолчанию) или yaml.
+func ExportTestPackage(c *gin.Context) {
+	testID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Неверный ID теста"})
+		return
+	}
+
+	format := c.DefaultQuery("format", "json")
+	if format != "json" && format != "yaml" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Неизвестный формат пакета: используйте json или yaml"})
+		return
+	}
+
+	pkg, err := loadTestPackage(testID)
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Тест не найден"})
+		return
+	} else if err != nil {
+		log.Printf("Ошибка экспорта теста %d: %v", testID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка экспорта теста"})
+		return
+	}
+
+	var data []byte
+	contentType := "application/json; charset=utf-8"
+	if format == "yaml" {
+		data, err = yaml.Marshal(pkg)
+		contentType = "application/yaml; charset=utf-8"
+	} else {
+		data, err = json.MarshalIndent(pkg, "", "  ")
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка формирования пакета"})
+		return
+	}
+
+	c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="test-%d.%s"`, testID, format))
+	c.Data(http.StatusOK, contentType, data)
+}
+
+// Импорт теста из пакета JSON или YAML. Без test_id создаётся новый тест, с test_id обновляется
+// существующий. При dry_run=true пакет только проверяется и возвращается список изменений.
+func ImportTestPackage(c *gin.Context) {
+	testID, ok := importTargetTest(c)
+	if !ok {
+		return
+	}
+	data, ok := readImportBody(c)
+	if !ok {
+		return
+	}
+
+	pkg, err := parseTestPackage(data, packageFormat(c, data))
+	if err != nil {
+		log.Printf("Ошибка разбора пакета теста: %v", err)
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Неверный формат пакета: ожидается JSON или YAML пакета теста"})
+		return
+	}
+
+	runPackageImport(c, testID, pkg, c.Query("dry_run") == "true", "package", nil)
+}
+
+// importTargetTest читает ID обновляемого теста из параметра test_id; 0 - импорт в новый тест
+func importTargetTest(c *gin.Context) (int, bool) {
+	testParam := c.Query("test_id")
+	if testParam == "" {
+		return 0, true
+	}
+	testID, err := strconv.Atoi(testParam)
+	if err != nil || testID < 1 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Неверный ID теста"})
+		return 0, false
+	}
+	return testID, true
+}
+
+// readImportBody читает тело запроса импорта с ограничением размера
+func readImportBody(c *gin.Context) ([]byte, bool) {
+	data, err := io.ReadAll(io.LimitReader(c.Request.Body, maxPackageSize+1))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Ошибка чтения пакета"})
+		return nil, false
+	}
+	if len(data) > maxPackageSize {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "Пакет слишком большой"})
+		return nil, false
+	}
+	return data, true
+}
+
+// runPackageImport проверяет пакет, сравнивает его с текущим тестом и, если это не пробный запуск,
+// записывает в базу с отметкой в журнале аудита. source - формат исходного файла,
+// warnings - замечания, накопленные при переводе из внешнего формата.
+func runPackageImport(c *gin.Context, testID int, pkg *models.TestPackage, dryRun bool, source string, warnings []string) {
+	if warnings == nil {
+		warnings = []string{}
+	}
+
+	if message := validateTestPackage(pkg); message != "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": message})
+		return
+	}
+
+	preview, err := previewPackageImport(testID, pkg)
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Тест не найден"})
+		return
+	} else if err != nil {
+		log.Printf("Ошибка сравнения пакета с тестом %d: %v", testID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка сравнения с текущим тестом"})
+		return
+	}
+
+	if dryRun {
+		c.JSON(http.StatusOK, gin.H{"dry_run": true, "preview": preview, "warnings": warnings})
+		return
+	}
+
+	userID, _ := c.Get("userID")
+
+	tx, err := database.DB.Begin()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка начала транзакции"})
+		return
+	}
+	defer tx.Rollback()
+
+	testID, versionNumber, err := applyTestPackage(tx, testID, userID, pkg, preview)
+	if err != nil {
+		log.Printf("Ошибка импорта пакета теста: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка импорта теста"})
+		return
+	}
+
+	details := gin.H{
+		"title":          pkg.Test.Title,
+		"created":        preview.CreatesTest,
+		"version_number": versionNumber,
+		"source":         source,
+	}
+	if !pkg.ExportedAt.IsZero() {
+		details["exported_at"] = pkg.ExportedAt
+	}
+	err = writeAudit(tx, userID, models.AuditTestImported, "test", testID, details)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка записи в журнал аудита"})
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка завершения операции"})
+		return
+	}
+
+	status, message := http.StatusOK, "Тест обновлен из пакета"
+	if preview.CreatesTest {
+		status, message = http.StatusCreated, "Тест импортирован"
+	}
+	c.JSON(status, gin.H{
+		"message":        message,
+		"test_id":        testID,
+		"version_number": versionNumber,
+		"preview":        preview,
+		"warnings":       warnings,
+	})
+}
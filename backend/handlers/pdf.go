@@ -0,0 +1,294 @@
+package handlers
+
+import (
+	"bytes"
+	"compress/zlib"
+	"fmt"
+	"sort"
+	"strings"
+	"unicode/utf16"
+)
+
+// Размер страницы A4 в пунктах
+const (
+	pdfPageWidth  = 595.28
+	pdfPageHeight = 841.89
+)
+
+// pdfColor - цвет RGB, компоненты от 0 до 1
+type pdfColor [3]float64
+
+// pdfFont - встроенный шрифт документа и использованные в нём глифы
+type pdfFont struct {
+	ttf    *trueTypeFont
+	glyphs map[uint16]rune // глиф -> символ для таблицы ToUnicode
+}
+
+// pdfDocument - простой PDF-документ: текст шрифтами TrueType (кодировка Identity-H,
+// поэтому доступна кириллица), линии и прямоугольники. Координаты задаются от левого
+// верхнего угла страницы.
+type pdfDocument struct {
+	fonts []*pdfFont
+	pages []*bytes.Buffer
+}
+
+func newPDFDocument(fonts ...*trueTypeFont) *pdfDocument {
+	document := &pdfDocument{}
+	for _, font := range fonts {
+		document.fonts = append(document.fonts, &pdfFont{ttf: font, glyphs: make(map[uint16]rune)})
+	}
+	return document
+}
+
+// AddPage начинает новую страницу; дальнейший вывод идёт на неё
+func (document *pdfDocument) AddPage() {
+	document.pages = append(document.pages, &bytes.Buffer{})
+}
+
+// PageCount - число страниц документа
+func (document *pdfDocument) PageCount() int {
+	return len(document.pages)
+}
+
+func (document *pdfDocument) content(page int) *bytes.Buffer {
+	if len(document.pages) == 0 {
+		document.AddPage()
+	}
+	if page < 0 || page >= len(document.pages) {
+		page = len(document.pages) - 1
+	}
+	return document.pages[page]
+}
+
+// TextWidth - ширина строки в пунктах. Символы, которых нет в шрифте, не выводятся и не учитываются.
+func (document *pdfDocument) TextWidth(font int, size float64, text string) float64 {
+	ttf := document.fonts[font].ttf
+	units := 0
+	for _, r := range text {
+		if gid := ttf.Glyph(r); gid != 0 {
+			units += ttf.Advance(gid)
+		}
+	}
+	return float64(units) * size / float64(ttf.UnitsPerEm)
+}
+
+// WrapText разбивает текст на строки не шире width; переводы строк сохраняются
+func (document *pdfDocument) WrapText(font int, size float64, text string, width float64) []string {
+	var lines []string
+	for _, paragraph := range strings.Split(text, "\n") {
+		line := ""
+		for _, word := range strings.Fields(paragraph) {
+			candidate := word
+			if line != "" {
+				candidate = line + " " + word
+			}
+			if document.TextWidth(font, size, candidate) <= width {
+				line = candidate
+				continue
+			}
+			if line != "" {
+				lines = append(lines, line)
+			}
+			// Слово длиннее строки переносится по символам
+			line = ""
+			for _, r := range word {
+				if line != "" && document.TextWidth(font, size, line+string(r)) > width {
+					lines = append(lines, line)
+					line = ""
+				}
+				line += string(r)
+			}
+		}
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+// Text выводит строку на страницу page (-1 - текущая); y - базовая линия от верха страницы
+func (document *pdfDocument) Text(page int, x, y float64, font int, size float64, color pdfColor, text string) {
+	embedded := document.fonts[font]
+	var glyphs strings.Builder
+	for _, r := range text {
+		if gid := embedded.ttf.Glyph(r); gid != 0 {
+			embedded.glyphs[gid] = r
+			fmt.Fprintf(&glyphs, "%04X", gid)
+		}
+	}
+	if glyphs.Len() == 0 {
+		return
+	}
+	fmt.Fprintf(document.content(page), "BT %.3f %.3f %.3f rg /F%d %.2f Tf %.2f %.2f Td <%s> Tj ET\n",
+		color[0], color[1], color[2], font+1, size, x, pdfPageHeight-y, glyphs.String())
+}
+
+// Rect закрашивает прямоугольник; y - верхний край от верха страницы
+func (document *pdfDocument) Rect(x, y, width, height float64, color pdfColor) {
+	fmt.Fprintf(document.content(-1), "%.3f %.3f %.3f rg %.2f %.2f %.2f %.2f re f\n",
+		color[0], color[1], color[2], x, pdfPageHeight-y-height, width, height)
+}
+
+// Line проводит отрезок заданной толщины
+func (document *pdfDocument) Line(x1, y1, x2, y2, width float64, color pdfColor) {
+	fmt.Fprintf(document.content(-1), "%.3f %.3f %.3f RG %.2f w %.2f %.2f m %.2f %.2f l S\n",
+		color[0], color[1], color[2], width, x1, pdfPageHeight-y1, x2, pdfPageHeight-y2)
+}
+
+// pdfWriter собирает объекты файла и таблицу перекрёстных ссылок
+type pdfWriter struct {
+	buffer  bytes.Buffer
+	offsets []int
+}
+
+// reserve выделяет номер объекта, который будет записан позже
+func (writer *pdfWriter) reserve() int {
+	writer.offsets = append(writer.offsets, 0)
+	return len(writer.offsets)
+}
+
+func (writer *pdfWriter) object(id int, body string) {
+	writer.offsets[id-1] = writer.buffer.Len()
+	fmt.Fprintf(&writer.buffer, "%d 0 obj\n%s\nendobj\n", id, body)
+}
+
+// stream записывает поток со сжатием Flate; extra - дополнительные ключи словаря
+func (writer *pdfWriter) stream(id int, extra string, data []byte) error {
+	var compressed bytes.Buffer
+	zw := zlib.NewWriter(&compressed)
+	if _, err := zw.Write(data); err != nil {
+		return err
+	}
+	if err := zw.Close(); err != nil {
+		return err
+	}
+	writer.offsets[id-1] = writer.buffer.Len()
+	fmt.Fprintf(&writer.buffer, "%d 0 obj\n<< /Length %d /Filter /FlateDecode%s >>\nstream\n", id, compressed.Len(), extra)
+	writer.buffer.Write(compressed.Bytes())
+	writer.buffer.WriteString("\nendstream\nendobj\n")
+	return nil
+}
+
+// toUnicodeCMap - таблица соответствия глифов символам, чтобы текст PDF можно было копировать и искать
+func toUnicodeCMap(glyphs map[uint16]rune) []byte {
+	gids := make([]int, 0, len(glyphs))
+	for gid := range glyphs {
+		gids = append(gids, int(gid))
+	}
+	sort.Ints(gids)
+
+	var cmap strings.Builder
+	cmap.WriteString("/CIDInit /ProcSet findresource begin\n12 dict begin\nbegincmap\n" +
+		"/CIDSystemInfo << /Registry (Adobe) /Ordering (UCS) /Supplement 0 >> def\n" +
+		"/CMapName /Adobe-Identity-UCS def\n/CMapType 2 def\n" +
+		"1 begincodespacerange\n<0000> <FFFF>\nendcodespacerange\n")
+	for start := 0; start < len(gids); start += 100 {
+		block := gids[start:min(start+100, len(gids))]
+		fmt.Fprintf(&cmap, "%d beginbfchar\n", len(block))
+		for _, gid := range block {
+			fmt.Fprintf(&cmap, "<%04X> <", gid)
+			for _, unit := range utf16.Encode([]rune{glyphs[uint16(gid)]}) {
+				fmt.Fprintf(&cmap, "%04X", unit)
+			}
+			cmap.WriteString(">\n")
+		}
+		cmap.WriteString("endbfchar\n")
+	}
+	cmap.WriteString("endcmap\nCMapName currentdict /CMap defineresource pop\nend\nend\n")
+	return []byte(cmap.String())
+}
+
+// writeFont записывает шрифт как Type0 с потомком CIDFontType2 и возвращает номер объекта шрифта
+func (writer *pdfWriter) writeFont(font *pdfFont, tag string) (int, error) {
+	ttf := font.ttf
+	scale := func(value int) int { return value * 1000 / ttf.UnitsPerEm }
+	fontID, cidFontID, descriptorID := writer.reserve(), writer.reserve(), writer.reserve()
+	fileID, toUnicodeID := writer.reserve(), writer.reserve()
+	name := tag + "+" + ttf.Name
+
+	gids := make([]int, 0, len(font.glyphs))
+	used := make(map[uint16]bool, len(font.glyphs))
+	for gid := range font.glyphs {
+		gids = append(gids, int(gid))
+		used[gid] = true
+	}
+	sort.Ints(gids)
+	var widths strings.Builder
+	for _, gid := range gids {
+		fmt.Fprintf(&widths, "%d [%d] ", gid, scale(ttf.Advance(uint16(gid))))
+	}
+
+	writer.object(fontID, fmt.Sprintf("<< /Type /Font /Subtype /Type0 /BaseFont /%s /Encoding /Identity-H "+
+		"/DescendantFonts [%d 0 R] /ToUnicode %d 0 R >>", name, cidFontID, toUnicodeID))
+	writer.object(cidFontID, fmt.Sprintf("<< /Type /Font /Subtype /CIDFontType2 /BaseFont /%s "+
+		"/CIDSystemInfo << /Registry (Adobe) /Ordering (Identity) /Supplement 0 >> "+
+		"/FontDescriptor %d 0 R /CIDToGIDMap /Identity /W [%s] >>", name, descriptorID, widths.String()))
+	writer.object(descriptorID, fmt.Sprintf("<< /Type /FontDescriptor /FontName /%s /Flags 32 "+
+		"/FontBBox [%d %d %d %d] /ItalicAngle 0 /Ascent %d /Descent %d /CapHeight %d /StemV 80 /FontFile2 %d 0 R >>",
+		name, scale(ttf.BBox[0]), scale(ttf.BBox[1]), scale(ttf.BBox[2]), scale(ttf.BBox[3]),
+		scale(ttf.Ascent), scale(ttf.Descent), scale(ttf.Ascent), fileID))
+
+	subset := ttf.Subset(used)
+	if err := writer.stream(fileID, fmt.Sprintf(" /Length1 %d", len(subset)), subset); err != nil {
+		return 0, err
+	}
+	if err := writer.stream(toUnicodeID, "", toUnicodeCMap(font.glyphs)); err != nil {
+		return 0, err
+	}
+	return fontID, nil
+}
+
+// pdfTextString - строка метаданных в кодировке UTF-16BE
+func pdfTextString(text string) string {
+	var hex strings.Builder
+	hex.WriteString("<FEFF")
+	for _, unit := range utf16.Encode([]rune(text)) {
+		fmt.Fprintf(&hex, "%04X", unit)
+	}
+	hex.WriteString(">")
+	return hex.String()
+}
+
+// Bytes собирает файл PDF
+func (document *pdfDocument) Bytes(title string) ([]byte, error) {
+	if len(document.pages) == 0 {
+		document.AddPage()
+	}
+
+	writer := &pdfWriter{}
+	writer.buffer.WriteString("%PDF-1.4\n%\xE2\xE3\xCF\xD3\n")
+	catalogID, pagesID, infoID := writer.reserve(), writer.reserve(), writer.reserve()
+
+	var fontRefs strings.Builder
+	for i, font := range document.fonts {
+		// Метка подмножества - шесть заглавных букв, разная для каждого шрифта
+		tag := strings.Repeat(string(rune('A'+i)), 6)
+		fontID, err := writer.writeFont(font, tag)
+		if err != nil {
+			return nil, err
+		}
+		fmt.Fprintf(&fontRefs, "/F%d %d 0 R ", i+1, fontID)
+	}
+
+	var kids strings.Builder
+	for _, page := range document.pages {
+		pageID, contentID := writer.reserve(), writer.reserve()
+		writer.object(pageID, fmt.Sprintf("<< /Type /Page /Parent %d 0 R /MediaBox [0 0 %.2f %.2f] "+
+			"/Resources << /Font << %s>> >> /Contents %d 0 R >>", pagesID, pdfPageWidth, pdfPageHeight, fontRefs.String(), contentID))
+		if err := writer.stream(contentID, "", page.Bytes()); err != nil {
+			return nil, err
+		}
+		fmt.Fprintf(&kids, "%d 0 R ", pageID)
+	}
+
+	writer.object(pagesID, fmt.Sprintf("<< /Type /Pages /Kids [%s] /Count %d >>", kids.String(), len(document.pages)))
+	writer.object(catalogID, fmt.Sprintf("<< /Type /Catalog /Pages %d 0 R >>", pagesID))
+	writer.object(infoID, fmt.Sprintf("<< /Title %s /Producer (psycho-test-system) >>", pdfTextString(title)))
+
+	xref := writer.buffer.Len()
+	fmt.Fprintf(&writer.buffer, "xref\n0 %d\n0000000000 65535 f \n", len(writer.offsets)+1)
+	for _, offset := range writer.offsets {
+		fmt.Fprintf(&writer.buffer, "%010d 00000 n \n", offset)
+	}
+	fmt.Fprintf(&writer.buffer, "trailer\n<< /Size %d /Root %d 0 R /Info %d 0 R >>\nstartxref\n%d\n%%%%EOF\n",
+		len(writer.offsets)+1, catalogID, infoID, xref)
+	return writer.buffer.Bytes(), nil
+}
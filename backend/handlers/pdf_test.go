@@ -0,0 +1,292 @@
+package handlers
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strconv"
+	"testing"
+)
+
+// testGlyphs - символы тестового шрифта и их номера глифов; глиф 0 - .notdef
+var testGlyphs = map[rune]uint16{'А': 1, 'B': 2}
+
+// buildTestTrueType собирает минимальный шрифт TrueType с тремя глифами.
+// numMetrics - значение numberOfHMetrics, headLength - длина таблицы head.
+func buildTestTrueType(numMetrics, headLength int) []byte {
+	const numGlyphs = 3
+
+	head := make([]byte, headLength)
+	binary.BigEndian.PutUint32(head[0:], 0x00010000)
+	binary.BigEndian.PutUint16(head[18:], 1000) // unitsPerEm
+	if headLength >= 44 {
+		binary.BigEndian.PutUint16(head[40:], 700) // yMax
+	}
+
+	hhea := make([]byte, 36)
+	binary.BigEndian.PutUint32(hhea[0:], 0x00010000)
+	binary.BigEndian.PutUint16(hhea[4:], 800)                 // ascent
+	binary.BigEndian.PutUint16(hhea[6:], uint16(0x10000-200)) // descent
+	binary.BigEndian.PutUint16(hhea[34:], uint16(numMetrics))
+
+	maxp := make([]byte, 6)
+	binary.BigEndian.PutUint32(maxp[0:], 0x00005000)
+	binary.BigEndian.PutUint16(maxp[4:], numGlyphs)
+
+	var hmtx []byte
+	for gid := 0; gid < numMetrics; gid++ {
+		hmtx = binary.BigEndian.AppendUint16(hmtx, uint16(500+gid*100))
+		hmtx = binary.BigEndian.AppendUint16(hmtx, 0)
+	}
+
+	// Простые глифы без контуров: только заголовок с рамкой
+	var glyf, loca []byte
+	for gid := 0; gid < numGlyphs; gid++ {
+		loca = binary.BigEndian.AppendUint16(loca, uint16(len(glyf)/2))
+		glyf = append(glyf, make([]byte, 12)...)
+		binary.BigEndian.PutUint16(glyf[len(glyf)-4:], uint16(gid))
+	}
+	loca = binary.BigEndian.AppendUint16(loca, uint16(len(glyf)/2))
+
+	// cmap формата 4: по сегменту на символ и завершающий сегмент 0xFFFF
+	codes := make([]int, 0, len(testGlyphs))
+	for r := range testGlyphs {
+		codes = append(codes, int(r))
+	}
+	sort.Ints(codes)
+	segments := len(codes) + 1
+	var ends, starts, deltas, rangeOffsets []byte
+	for _, code := range codes {
+		ends = binary.BigEndian.AppendUint16(ends, uint16(code))
+		starts = binary.BigEndian.AppendUint16(starts, uint16(code))
+		deltas = binary.BigEndian.AppendUint16(deltas, uint16(int(testGlyphs[rune(code)])-code))
+		rangeOffsets = binary.BigEndian.AppendUint16(rangeOffsets, 0)
+	}
+	ends = binary.BigEndian.AppendUint16(ends, 0xFFFF)
+	starts = binary.BigEndian.AppendUint16(starts, 0xFFFF)
+	deltas = binary.BigEndian.AppendUint16(deltas, 1)
+	rangeOffsets = binary.BigEndian.AppendUint16(rangeOffsets, 0)
+
+	subtable := make([]byte, 14)
+	binary.BigEndian.PutUint16(subtable[0:], 4)
+	binary.BigEndian.PutUint16(subtable[6:], uint16(segments*2))
+	subtable = append(subtable, ends...)
+	subtable = append(subtable, 0, 0)
+	subtable = append(subtable, starts...)
+	subtable = append(subtable, deltas...)
+	subtable = append(subtable, rangeOffsets...)
+	binary.BigEndian.PutUint16(subtable[2:], uint16(len(subtable)))
+
+	cmap := []byte{0, 0, 0, 1, 0, 3, 0, 1, 0, 0, 0, 12}
+	cmap = append(cmap, subtable...)
+
+	tables := map[string][]byte{"cmap": cmap, "glyf": glyf, "head": head, "hhea": hhea,
+		"hmtx": hmtx, "loca": loca, "maxp": maxp}
+	tags := make([]string, 0, len(tables))
+	for tag := range tables {
+		tags = append(tags, tag)
+	}
+	sort.Strings(tags)
+
+	output := make([]byte, 12)
+	binary.BigEndian.PutUint32(output[0:], 0x00010000)
+	binary.BigEndian.PutUint16(output[4:], uint16(len(tags)))
+	offset := 12 + len(tags)*16
+	for _, tag := range tags {
+		output = append(output, tag...)
+		output = binary.BigEndian.AppendUint32(output, trueTypeChecksum(tables[tag]))
+		output = binary.BigEndian.AppendUint32(output, uint32(offset))
+		output = binary.BigEndian.AppendUint32(output, uint32(len(tables[tag])))
+		offset += (len(tables[tag]) + 3) &^ 3
+	}
+	for _, tag := range tags {
+		output = append(output, tables[tag]...)
+		for len(output)%4 != 0 {
+			output = append(output, 0)
+		}
+	}
+	return output
+}
+
+func TestParseTrueTypeRejectsBrokenTables(t *testing.T) {
+	if _, err := parseTrueType("Test", buildTestTrueType(3, 54)); err != nil {
+		t.Fatalf("корректный шрифт не разобран: %v", err)
+	}
+	if _, err := parseTrueType("Test", buildTestTrueType(0, 54)); err == nil {
+		t.Error("шрифт с numberOfHMetrics = 0 должен отклоняться")
+	}
+	if _, err := parseTrueType("Test", buildTestTrueType(3, 36)); err == nil {
+		t.Error("шрифт с укороченной таблицей head должен отклоняться")
+	}
+}
+
+func TestParseTrueTypeInheritsLastAdvance(t *testing.T) {
+	font, err := parseTrueType("Test", buildTestTrueType(1, 54))
+	if err != nil {
+		t.Fatalf("шрифт не разобран: %v", err)
+	}
+	for gid := uint16(0); gid < 3; gid++ {
+		if advance := font.Advance(gid); advance != 500 {
+			t.Errorf("ширина глифа %d = %d, ожидалось 500", gid, advance)
+		}
+	}
+}
+
+var (
+	pdfStartXref  = regexp.MustCompile(`startxref\n(\d+)\n%%EOF\n$`)
+	pdfXrefHeader = regexp.MustCompile(`^xref\n0 (\d+)\n0000000000 65535 f \n`)
+	pdfLength     = regexp.MustCompile(`/Length (\d+)`)
+)
+
+// readPDFObjects проверяет таблицу перекрёстных ссылок и возвращает тела объектов;
+// потоки возвращаются распакованными
+func readPDFObjects(t *testing.T, data []byte) map[int][]byte {
+	t.Helper()
+	if !bytes.HasPrefix(data, []byte("%PDF-1.4\n")) {
+		t.Fatal("нет заголовка PDF")
+	}
+	match := pdfStartXref.FindSubmatch(data)
+	if match == nil {
+		t.Fatal("нет startxref в конце файла")
+	}
+	xref, _ := strconv.Atoi(string(match[1]))
+	if xref >= len(data) {
+		t.Fatalf("startxref %d за пределами файла", xref)
+	}
+	header := pdfXrefHeader.FindSubmatch(data[xref:])
+	if header == nil {
+		t.Fatalf("startxref %d не указывает на таблицу xref", xref)
+	}
+	size, _ := strconv.Atoi(string(header[1]))
+
+	objects := make(map[int][]byte, size-1)
+	entries := data[xref+len(header[0]):]
+	for id := 1; id < size; id++ {
+		if len(entries) < id*20 {
+			t.Fatalf("таблица xref обрывается на объекте %d", id)
+		}
+		offset, err := strconv.Atoi(string(entries[(id-1)*20 : (id-1)*20+10]))
+		if err != nil || offset >= xref {
+			t.Fatalf("неверное смещение объекта %d: %q", id, entries[(id-1)*20:id*20])
+		}
+		prefix := []byte(fmt.Sprintf("%d 0 obj\n", id))
+		if !bytes.HasPrefix(data[offset:], prefix) {
+			t.Fatalf("смещение объекта %d указывает не на его начало", id)
+		}
+		body := data[offset+len(prefix):]
+		end := bytes.Index(body, []byte("\nendobj\n"))
+		if end < 0 {
+			t.Fatalf("объект %d не закрыт", id)
+		}
+		body = body[:end]
+
+		if start := bytes.Index(body, []byte(">>\nstream\n")); start >= 0 {
+			length := pdfLength.FindSubmatch(body[:start])
+			if length == nil {
+				t.Fatalf("у потока %d нет /Length", id)
+			}
+			n, _ := strconv.Atoi(string(length[1]))
+			stream := body[start+len(">>\nstream\n"):]
+			if len(stream) != n+len("\nendstream") || !bytes.HasSuffix(stream, []byte("\nendstream")) {
+				t.Fatalf("длина потока %d не совпадает с /Length %d", id, n)
+			}
+			reader, err := zlib.NewReader(bytes.NewReader(stream[:n]))
+			if err != nil {
+				t.Fatalf("поток %d не распаковывается: %v", id, err)
+			}
+			decoded, err := io.ReadAll(reader)
+			if err != nil {
+				t.Fatalf("поток %d не распаковывается: %v", id, err)
+			}
+			body = append(append([]byte{}, body[:start+2]...), decoded...)
+		}
+		objects[id] = body
+	}
+	return objects
+}
+
+// checkFontSubset проверяет встроенное подмножество шрифта: каталог таблиц, контрольную сумму
+// файла и наличие описаний использованных глифов. Таблица cmap в подмножество не входит,
+// глифы адресуются напрямую через CIDToGIDMap /Identity.
+func checkFontSubset(t *testing.T, data []byte) {
+	t.Helper()
+	if len(data) < 12 || trueTypeUint32(data, 0) != 0x00010000 {
+		t.Fatal("встроенный шрифт не является шрифтом TrueType")
+	}
+	if sum := trueTypeChecksum(data); sum != 0xB1B0AFBA {
+		t.Errorf("контрольная сумма шрифта %#x, ожидалось 0xB1B0AFBA", sum)
+	}
+
+	tables := make(map[string][]byte)
+	for i := 0; i < int(trueTypeUint16(data, 4)); i++ {
+		record := 12 + i*16
+		tag := string(data[record : record+4])
+		offset, length := int(trueTypeUint32(data, record+8)), int(trueTypeUint32(data, record+12))
+		if offset+length > len(data) {
+			t.Fatalf("таблица %q выходит за пределы шрифта", tag)
+		}
+		tables[tag] = data[offset : offset+length]
+		if sum := trueTypeChecksum(tables[tag]); tag != "head" && sum != trueTypeUint32(data, record+4) {
+			t.Errorf("неверная контрольная сумма таблицы %q", tag)
+		}
+	}
+	for _, tag := range []string{"glyf", "head", "hhea", "hmtx", "loca", "maxp"} {
+		if tables[tag] == nil {
+			t.Fatalf("во встроенном шрифте нет таблицы %q", tag)
+		}
+	}
+
+	if trueTypeUint16(tables["head"], 50) != 1 {
+		t.Fatal("таблица loca подмножества должна быть в длинном формате")
+	}
+	for r, gid := range testGlyphs {
+		start, end := trueTypeUint32(tables["loca"], int(gid)*4), trueTypeUint32(tables["loca"], int(gid+1)*4)
+		if start >= end || int(end) > len(tables["glyf"]) || trueTypeUint16(tables["glyf"], int(end)-4) != gid {
+			t.Errorf("во встроенном шрифте нет глифа %q", r)
+		}
+	}
+}
+
+func TestPDFDocumentParses(t *testing.T) {
+	font, err := parseTrueType("Test", buildTestTrueType(3, 54))
+	if err != nil {
+		t.Fatalf("шрифт не разобран: %v", err)
+	}
+
+	document := newPDFDocument(font)
+	document.Text(-1, 50, 100, 0, 12, reportTextColor, "АB")
+	document.Rect(50, 120, 100, 10, reportBarColor)
+	document.AddPage()
+	document.Line(50, 50, 200, 50, 1, reportMutedColor)
+	data, err := document.Bytes("Отчёт")
+	if err != nil {
+		t.Fatalf("PDF не собран: %v", err)
+	}
+
+	objects := readPDFObjects(t, data)
+	var pages, fontFiles int
+	for id, body := range objects {
+		switch {
+		case bytes.Contains(body, []byte("/Type /Pages ")):
+			if !bytes.Contains(body, []byte("/Count 2")) {
+				t.Errorf("в дереве страниц %d не две страницы: %s", id, body)
+			}
+		case bytes.Contains(body, []byte("/Type /Page ")):
+			pages++
+		case bytes.Contains(body, []byte("/Length1 ")):
+			fontFiles++
+			checkFontSubset(t, body[bytes.Index(body, []byte(">>"))+2:])
+		case bytes.Contains(body, []byte(" Tj ET")):
+			if !bytes.Contains(body, []byte("<00010002> Tj")) {
+				t.Errorf("текст страницы записан не номерами глифов: %s", body)
+			}
+		}
+	}
+	if pages != 2 || fontFiles != 1 {
+		t.Errorf("страниц %d, встроенных шрифтов %d; ожидалось 2 и 1", pages, fontFiles)
+	}
+}
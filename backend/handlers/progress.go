@@ -0,0 +1,384 @@
+package handlers
+
+import (
+	"database/sql"
+	"math"
+	"net/http"
+	"psycho-test-system/database"
+	"psycho-test-system/models"
+	"strconv"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Параметры индекса надёжного изменения (Jacobson, Truax, 1991): RCI = разность / (SD * sqrt(2 * (1 - r))).
+// Разброс SD и надёжность r (альфа Кронбаха) шкалы оцениваются по достоверным результатам той же
+// версии теста, если их не меньше minProgressSample.
+const (
+	reliableChangeThreshold = 1.96
+	minProgressSample       = 10
+	significantStenDelta    = 2
+)
+
+// Ключ итогового процента в таблице разброса теста
+const progressTotalKey = ""
+
+// progressNorms - разброс и надёжность итогового процента и шкал одной версии теста
+type progressNorms struct {
+	spread      map[string]float64
+	reliability map[string]float64
+}
+
+// loadScaleSpread - стандартное отклонение процента итогового балла и шкал версии теста по достоверным результатам
+func loadScaleSpread(testID, versionID int) (map[string]float64, error) {
+	spread := make(map[string]float64)
+	rows, err := database.DB.Query(`
+		SELECT '' as scale_type, STDDEV_SAMP(percentage), COUNT(*)
+		FROM test_results
+		WHERE test_id = $1 AND version_id = $2 AND COALESCE(is_valid, true)
+		UNION ALL
+		SELECT trs.scale_type, STDDEV_SAMP(trs.percentage), COUNT(*)
+		FROM test_result_scales trs
+		JOIN test_results tr ON tr.id = trs.result_id
+		WHERE tr.test_id = $1 AND tr.version_id = $2 AND COALESCE(tr.is_valid, true)
+		GROUP BY trs.scale_type
+	`, testID, versionID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var scaleType string
+		var stdDev sql.NullFloat64
+		var count int
+		if err := rows.Scan(&scaleType, &stdDev, &count); err != nil {
+			return nil, err
+		}
+		if stdDev.Valid && stdDev.Float64 > 0 && count >= minProgressSample {
+			spread[scaleType] = stdDev.Float64
+		}
+	}
+	return spread, rows.Err()
+}
+
+// loadScaleReliability - альфа Кронбаха итогового балла и шкал версии теста по официальным
+// достоверным попыткам этой версии. Пункты берутся так же, как при подсчёте результата:
+// вопросы только шкалы лжи не входят в итоговый балл. Шкалы без оценки или с альфой
+// вне (0; 1) в ответ не попадают.
+func loadScaleReliability(testID, versionID int) (map[string]float64, error) {
+	reliability := make(map[string]float64)
+	questions, err := loadScoringQuestions(testID, versionID)
+	if err != nil {
+		return nil, err
+	}
+	settings, err := loadValiditySettings(versionID)
+	if err != nil {
+		return nil, err
+	}
+	resultIDs, answers, err := loadOfficialAnswers(testID, versionID, questions)
+	if err != nil {
+		return nil, err
+	}
+	if len(resultIDs) < minProgressSample {
+		return reliability, nil
+	}
+
+	columns := make(map[string][][]float64)
+	for _, question := range questions {
+		if question.QuestionType == models.QuestionFreeText {
+			continue
+		}
+		if !question.onlyInScale(settings.LieScale) {
+			columns[progressTotalKey] = append(columns[progressTotalKey],
+				keyedScores(question, question.Reversed, resultIDs, answers))
+		}
+		for _, key := range question.Keys {
+			columns[key.Scale] = append(columns[key.Scale], keyedScores(question, key.Reverse, resultIDs, answers))
+		}
+	}
+	for scale, items := range columns {
+		if alpha := cronbachAlpha(items); alpha != nil && *alpha > 0 && *alpha < 1 {
+			reliability[scale] = *alpha
+		}
+	}
+	return reliability, nil
+}
+
+// Альфа Кронбаха требует ответов всех респондентов версии, поэтому надёжность шкал
+// хранится по версиям и пересчитывается, только когда меняется выборка
+var scaleReliabilityCache struct {
+	sync.Mutex
+	versions map[int]cachedReliability
+}
+
+// cachedReliability - надёжность шкал версии и отметка выборки, по которой она посчитана
+type cachedReliability struct {
+	sample      string
+	reliability map[string]float64
+}
+
+// cachedScaleReliability возвращает loadScaleReliability из кэша, если с прошлого расчёта
+// у версии не появилось и не пропало достоверных результатов и не сменилось правило
+// выбора официальной попытки. Возвращаемую таблицу нельзя изменять.
+func cachedScaleReliability(testID, versionID int) (map[string]float64, error) {
+	var sample string
+	err := database.DB.QueryRow(`
+		SELECT COUNT(tr.id) || '|' || COALESCE(MAX(tr.id), 0) || '|' || COALESCE(pt.official_attempt, 'latest')
+		FROM psychological_tests pt
+		LEFT JOIN test_results tr ON tr.test_id = pt.id AND tr.version_id = $2 AND COALESCE(tr.is_valid, true)
+		WHERE pt.id = $1
+		GROUP BY pt.official_attempt
+	`, testID, versionID).Scan(&sample)
+	if err != nil {
+		return nil, err
+	}
+
+	scaleReliabilityCache.Lock()
+	cached, exists := scaleReliabilityCache.versions[versionID]
+	scaleReliabilityCache.Unlock()
+	if exists && cached.sample == sample {
+		return cached.reliability, nil
+	}
+
+	reliability, err := loadScaleReliability(testID, versionID)
+	if err != nil {
+		return nil, err
+	}
+	scaleReliabilityCache.Lock()
+	if scaleReliabilityCache.versions == nil {
+		scaleReliabilityCache.versions = make(map[int]cachedReliability)
+	}
+	scaleReliabilityCache.versions[versionID] = cachedReliability{sample: sample, reliability: reliability}
+	scaleReliabilityCache.Unlock()
+	return reliability, nil
+}
+
+// loadProgressNorms загружает разброс и надёжность шкал версии теста
+func loadProgressNorms(testID, versionID int) (*progressNorms, error) {
+	spread, err := loadScaleSpread(testID, versionID)
+	if err != nil {
+		return nil, err
+	}
+	reliability, err := cachedScaleReliability(testID, versionID)
+	if err != nil {
+		return nil, err
+	}
+	return &progressNorms{spread: spread, reliability: reliability}, nil
+}
+
+// change сравнивает процент шкалы scale с предыдущей попыткой по нормам версии;
+// norms = nil - версия попытки неизвестна, и RCI не считается
+func (norms *progressNorms) change(scale string, previousID int, previous, current float64, previousSten, currentSten *int) *models.ScoreChange {
+	var stdDev, reliability float64
+	if norms != nil {
+		stdDev, reliability = norms.spread[scale], norms.reliability[scale]
+	}
+	return scoreChange(previousID, previous, current, previousSten, currentSten, stdDev, reliability)
+}
+
+// scoreChange сравнивает процент с предыдущей попыткой. stdDev = 0 или reliability = 0 означает,
+// что разброс или надёжность шкалы неизвестны.
+func scoreChange(previousID int, previous, current float64, previousSten, currentSten *int, stdDev, reliability float64) *models.ScoreChange {
+	change := &models.ScoreChange{
+		PreviousResultID:   previousID,
+		PreviousPercentage: previous,
+		Delta:              math.Round((current-previous)*10) / 10,
+		Direction:          "none",
+	}
+	switch {
+	case change.Delta > 0:
+		change.Direction = "up"
+	case change.Delta < 0:
+		change.Direction = "down"
+	}
+	if previousSten != nil && currentSten != nil {
+		stenDelta := *currentSten - *previousSten
+		change.StenDelta = &stenDelta
+	}
+
+	if stdDev > 0 && reliability > 0 && reliability < 1 {
+		rci := (current - previous) / (stdDev * math.Sqrt(2*(1-reliability)))
+		change.RCI = statRound(rci)
+		change.Reliability = statRound(reliability)
+		change.Method = "rci"
+		change.Significant = math.Abs(rci) >= reliableChangeThreshold
+	} else if change.StenDelta != nil {
+		change.Method = "sten"
+		change.Significant = *change.StenDelta >= significantStenDelta || *change.StenDelta <= -significantStenDelta
+	}
+	return change
+}
+
+// loadUserProgress собирает историю прохождений пользователя; testID = 0 - по всем тестам
+func loadUserProgress(userID, testID int) ([]models.TestProgress, error) {
+	rows, err := database.DB.Query(`
+		SELECT tr.id, COALESCE(tr.test_id, 0), COALESCE(pt.title, '[Удаленный тест]'), COALESCE(pt.methodology_type, 'Неизвестно'),
+		       tr.completed_at, COALESCE(tr.attempt_number, 1), COALESCE(tv.version_number, 0),
+		       COALESCE(tr.is_valid, true), tr.is_passed, tr.percentage,
+		       COALESCE(tr.interpretation, ''), COALESCE(tr.recommendation, ''), COALESCE(tr.version_id, 0)
+		FROM test_results tr
+		LEFT JOIN psychological_tests pt ON pt.id = tr.test_id
+		LEFT JOIN test_versions tv ON tv.id = tr.version_id
+		WHERE tr.user_id = $1 AND ($2 = 0 OR tr.test_id = $2)
+		ORDER BY tr.test_id, tr.completed_at, tr.id
+	`, userID, testID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	tests := []models.TestProgress{}
+	attempts := make(map[int]*models.ProgressAttempt)
+	versions := make(map[int]int) // result_id -> version_id
+	for rows.Next() {
+		var attempt models.ProgressAttempt
+		var attemptTestID, versionID int
+		var title, methodology string
+		if err := rows.Scan(&attempt.ResultID, &attemptTestID, &title, &methodology, &attempt.CompletedAt,
+			&attempt.AttemptNumber, &attempt.VersionNumber, &attempt.IsValid, &attempt.IsPassed, &attempt.Percentage,
+			&attempt.Interpretation, &attempt.Recommendation, &versionID); err != nil {
+			return nil, err
+		}
+		versions[attempt.ResultID] = versionID
+		if len(tests) == 0 || tests[len(tests)-1].TestID != attemptTestID {
+			tests = append(tests, models.TestProgress{TestID: attemptTestID, TestTitle: title,
+				MethodologyLabel: getMethodologyLabel(methodology), Attempts: []models.ProgressAttempt{}})
+		}
+		attempt.Scales = []models.ScaleProgress{}
+		test := &tests[len(tests)-1]
+		test.Attempts = append(test.Attempts, attempt)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	for i := range tests {
+		for j := range tests[i].Attempts {
+			attempts[tests[i].Attempts[j].ResultID] = &tests[i].Attempts[j]
+		}
+	}
+
+	scaleRows, err := database.DB.Query(`
+		SELECT trs.result_id, trs.scale_type, trs.score, trs.max_score, trs.percentage, trs.sten
+		FROM test_result_scales trs
+		JOIN test_results tr ON tr.id = trs.result_id
+		WHERE tr.user_id = $1 AND ($2 = 0 OR tr.test_id = $2)
+		ORDER BY trs.result_id, trs.id
+	`, userID, testID)
+	if err != nil {
+		return nil, err
+	}
+	defer scaleRows.Close()
+	for scaleRows.Next() {
+		var resultID int
+		var scale models.ScaleProgress
+		var sten sql.NullInt64
+		if err := scaleRows.Scan(&resultID, &scale.ScaleName, &scale.Score, &scale.MaxScore, &scale.Percentage, &sten); err != nil {
+			return nil, err
+		}
+		if sten.Valid {
+			value := int(sten.Int64)
+			scale.Sten = &value
+		}
+		if attempt := attempts[resultID]; attempt != nil {
+			attempt.Scales = append(attempt.Scales, scale)
+		}
+	}
+	if err := scaleRows.Err(); err != nil {
+		return nil, err
+	}
+
+	// Каждая достоверная попытка сравнивается с предыдущей достоверной попыткой того же теста
+	// по разбросу и надёжности шкал версии, на которой пройдена текущая попытка
+	normsByVersion := make(map[int]*progressNorms)
+	for i := range tests {
+		test := &tests[i]
+		if test.TestID == 0 || len(test.Attempts) < 2 {
+			continue
+		}
+
+		var previous *models.ProgressAttempt
+		for j := range test.Attempts {
+			attempt := &test.Attempts[j]
+			if !attempt.IsValid {
+				continue
+			}
+			if previous != nil {
+				versionID := versions[attempt.ResultID]
+				norms, loaded := normsByVersion[versionID]
+				if !loaded && versionID != 0 {
+					if norms, err = loadProgressNorms(test.TestID, versionID); err != nil {
+						return nil, err
+					}
+					normsByVersion[versionID] = norms
+				}
+				attempt.Change = norms.change(progressTotalKey, previous.ResultID, previous.Percentage, attempt.Percentage, nil, nil)
+				previousScales := make(map[string]models.ScaleProgress, len(previous.Scales))
+				for _, scale := range previous.Scales {
+					previousScales[scale.ScaleName] = scale
+				}
+				for k := range attempt.Scales {
+					scale := &attempt.Scales[k]
+					if before, ok := previousScales[scale.ScaleName]; ok {
+						scale.Change = norms.change(scale.ScaleName, previous.ResultID, before.Percentage, scale.Percentage,
+							before.Sten, scale.Sten)
+					}
+				}
+			}
+			previous = attempt
+		}
+	}
+	return tests, nil
+}
+
+// respondProgress отправляет историю прохождений с рекомендацией за месяц
+func respondProgress(c *gin.Context, userID int) {
+	testID := 0
+	if value := c.Query("test_id"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed < 1 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Неверный ID теста"})
+			return
+		}
+		testID = parsed
+	}
+
+	tests, err := loadUserProgress(userID, testID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка получения истории прохождений"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"progress": models.ProgressReport{
+		UserID:         userID,
+		Tests:          tests,
+		Recommendation: getMonthlyRecommendation(tests),
+	}})
+}
+
+// История повторных прохождений текущего пользователя; test_id ограничивает историю одним тестом
+func GetUserProgress(c *gin.Context) {
+	userID, exists := c.Get("userID")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Пользователь не авторизован"})
+		return
+	}
+	respondProgress(c, userID.(int))
+}
+
+// История повторных прохождений пользователя для администратора
+func GetUserProgressAdmin(c *gin.Context) {
+	userID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Неверный ID пользователя"})
+		return
+	}
+
+	var exists bool
+	if err := database.DB.QueryRow("SELECT EXISTS(SELECT 1 FROM users WHERE id = $1)", userID).Scan(&exists); err != nil || !exists {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Пользователь не найден"})
+		return
+	}
+	respondProgress(c, userID)
+}
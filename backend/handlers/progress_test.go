@@ -0,0 +1,48 @@
+package handlers
+
+import (
+	"testing"
+)
+
+func TestScoreChange(t *testing.T) {
+	sten := func(value int) *int { return &value }
+
+	// При SD = 10 и r = 0.8 стандартная ошибка разности 10 * sqrt(2 * 0.2) ≈ 6.32,
+	// надёжным считается изменение не меньше 1.96 * 6.32 ≈ 12.4 пункта
+	tests := []struct {
+		name                      string
+		previous, current         float64
+		previousSten, currentSten *int
+		stdDev, reliability       float64
+		method                    string
+		significant               bool
+		direction                 string
+		rci                       *float64
+	}{
+		{"надёжный рост", 50, 63, nil, nil, 10, 0.8, "rci", true, "up", statRound(2.055)},
+		{"рост в пределах ошибки", 50, 62, nil, nil, 10, 0.8, "rci", false, "up", statRound(1.897)},
+		{"надёжное снижение", 60, 45, nil, nil, 10, 0.8, "rci", true, "down", statRound(-2.372)},
+		{"RCI важнее стенов", 50, 55, sten(3), sten(7), 10, 0.8, "rci", false, "up", statRound(0.791)},
+		{"стены без надёжности", 50, 70, sten(4), sten(6), 10, 0, "sten", true, "up", nil},
+		{"стены без разброса", 50, 55, sten(5), sten(6), 0, 0.8, "sten", false, "up", nil},
+		{"стены, снижение на два", 70, 50, sten(7), sten(5), 0, 0, "sten", true, "down", nil},
+		{"нет данных для оценки", 50, 80, nil, nil, 0, 0, "", false, "up", nil},
+		{"без изменений", 50, 50, nil, nil, 10, 0.8, "rci", false, "none", statRound(0)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			change := scoreChange(1, tt.previous, tt.current, tt.previousSten, tt.currentSten, tt.stdDev, tt.reliability)
+			if change.Method != tt.method || change.Significant != tt.significant || change.Direction != tt.direction {
+				t.Errorf("метод %q, значимо %v, направление %s; ожидалось %q, %v, %s",
+					change.Method, change.Significant, change.Direction, tt.method, tt.significant, tt.direction)
+			}
+			if !sameStat(change.RCI, tt.rci) {
+				t.Errorf("RCI = %v, ожидалось %v", formatStat(change.RCI), formatStat(tt.rci))
+			}
+			if change.Delta != tt.current-tt.previous {
+				t.Errorf("разность = %v, ожидалось %v", change.Delta, tt.current-tt.previous)
+			}
+		})
+	}
+}
@@ -0,0 +1,414 @@
+package handlers
+
+import (
+	"database/sql"
+	"fmt"
+	"math"
+	"net/http"
+	"psycho-test-system/database"
+	"psycho-test-system/models"
+	"sort"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Пороги предупреждений анализа пунктов
+const (
+	minItemDifficulty  = 0.1
+	maxItemDifficulty  = 0.9
+	minItemCorrelation = 0.2
+)
+
+// loadOfficialAnswers восстанавливает ответы официальных достоверных попыток теста;
+// versionID ограничивает попытки одной версией (0 - все версии).
+// Возвращает ID результатов по порядку и ответы: result_id -> question_id -> ответ.
+// Ответы на удалённые при редактировании вопросы не учитываются.
+func loadOfficialAnswers(testID, versionID int, questions []*scoringQuestion) ([]int, map[int]map[int]models.AnswerSubmission, error) {
+	byID := make(map[int]*scoringQuestion, len(questions))
+	for _, question := range questions {
+		byID[question.ID] = question
+	}
+
+	rows, err := database.DB.Query(`
+		SELECT ua.result_id, ua.question_id, ua.option_id, ua.answer_value
+		FROM user_answers ua
+		JOIN (
+			SELECT DISTINCT ON (tr.user_id) tr.id
+			FROM test_results tr
+			JOIN psychological_tests pt ON pt.id = tr.test_id
+			WHERE tr.test_id = $1 AND COALESCE(tr.is_valid, true) AND ($2 = 0 OR tr.version_id = $2)
+			ORDER BY tr.user_id,
+			         CASE WHEN COALESCE(pt.official_attempt, 'latest') = 'first' THEN tr.completed_at END ASC,
+			         tr.completed_at DESC
+		) official ON official.id = ua.result_id
+		WHERE ua.question_id IS NOT NULL
+		ORDER BY ua.result_id, ua.question_id, ua.rank_position NULLS LAST, ua.id
+	`, testID, versionID)
+	if err != nil {
+		return nil, nil, fmt.Errorf("ошибка загрузки ответов: %v", err)
+	}
+	defer rows.Close()
+
+	var resultIDs []int
+	answers := make(map[int]map[int]models.AnswerSubmission)
+	for rows.Next() {
+		var resultID, questionID int
+		var optionID sql.NullInt64
+		var value sql.NullFloat64
+		if err := rows.Scan(&resultID, &questionID, &optionID, &value); err != nil {
+			return nil, nil, fmt.Errorf("ошибка чтения ответов: %v", err)
+		}
+
+		question := byID[questionID]
+		if question == nil || question.QuestionType == models.QuestionFreeText {
+			continue
+		}
+		if answers[resultID] == nil {
+			answers[resultID] = make(map[int]models.AnswerSubmission)
+			resultIDs = append(resultIDs, resultID)
+		}
+
+		answer := answers[resultID][questionID]
+		answer.QuestionID = questionID
+		switch question.QuestionType {
+		case models.QuestionMultiSelect, models.QuestionRanking:
+			if optionID.Valid {
+				answer.OptionIDs = append(answer.OptionIDs, int(optionID.Int64))
+			}
+		case models.QuestionLikert:
+			if value.Valid {
+				answer.Value = &value.Float64
+			}
+		default:
+			if optionID.Valid {
+				answer.OptionID = int(optionID.Int64)
+			}
+		}
+		answers[resultID][questionID] = answer
+	}
+	return resultIDs, answers, rows.Err()
+}
+
+func statMean(values []float64) float64 {
+	if len(values) == 0 {
+		return 0
+	}
+	var sum float64
+	for _, value := range values {
+		sum += value
+	}
+	return sum / float64(len(values))
+}
+
+// statVariance - несмещённая оценка дисперсии
+func statVariance(values []float64) float64 {
+	if len(values) < 2 {
+		return 0
+	}
+	mean := statMean(values)
+	var sum float64
+	for _, value := range values {
+		sum += (value - mean) * (value - mean)
+	}
+	return sum / float64(len(values)-1)
+}
+
+// statRound округляет показатель до тысячных
+func statRound(value float64) *float64 {
+	rounded := math.Round(value*1000) / 1000
+	return &rounded
+}
+
+// pearson - коэффициент корреляции Пирсона; nil, если у одного из рядов нет разброса
+func pearson(x, y []float64) *float64 {
+	if len(x) < 3 {
+		return nil
+	}
+	meanX, meanY := statMean(x), statMean(y)
+	var covariance, sumX, sumY float64
+	for i := range x {
+		dx, dy := x[i]-meanX, y[i]-meanY
+		covariance += dx * dy
+		sumX += dx * dx
+		sumY += dy * dy
+	}
+	if sumX == 0 || sumY == 0 {
+		return nil
+	}
+	return statRound(covariance / math.Sqrt(sumX*sumY))
+}
+
+// cronbachAlpha - альфа Кронбаха по столбцам баллов пунктов: k/(k-1) * (1 - Σдисперсий пунктов / дисперсия суммы)
+func cronbachAlpha(items [][]float64) *float64 {
+	if len(items) < 2 || len(items[0]) < 2 {
+		return nil
+	}
+	totals := make([]float64, len(items[0]))
+	var itemVariances float64
+	for _, item := range items {
+		itemVariances += statVariance(item)
+		for r, score := range item {
+			totals[r] += score
+		}
+	}
+	totalVariance := statVariance(totals)
+	if totalVariance == 0 {
+		return nil
+	}
+	k := float64(len(items))
+	return statRound(k / (k - 1) * (1 - itemVariances/totalVariance))
+}
+
+// keyedScores - взвешенные баллы респондентов resultIDs по вопросу с прямым или обратным ключом,
+// как при подсчёте результата; неотвеченный вопрос даёт 0
+func keyedScores(question *scoringQuestion, reverse bool, resultIDs []int, answers map[int]map[int]models.AnswerSubmission) []float64 {
+	keyed := question.withKey(reverse)
+	column := make([]float64, len(resultIDs))
+	for r, resultID := range resultIDs {
+		if answer, answered := answers[resultID][question.ID]; answered {
+			column[r] = questionScore(keyed, answer) * question.Weight
+		}
+	}
+	return column
+}
+
+// restScores - сумма всех столбцов, кроме skip, по каждому респонденту
+func restScores(items [][]float64, skip int) []float64 {
+	rest := make([]float64, len(items[0]))
+	for i, item := range items {
+		if i == skip {
+			continue
+		}
+		for r, score := range item {
+			rest[r] += score
+		}
+	}
+	return rest
+}
+
+// itemWarnings - замечания к пункту для проверки отредактированных методик
+func itemWarnings(item *models.ItemAnalysis) []string {
+	var warnings []string
+	if item.Difficulty != nil && *item.Difficulty > maxItemDifficulty {
+		warnings = append(warnings, "Слишком лёгкий пункт: почти все получают максимальный балл")
+	}
+	if item.Difficulty != nil && *item.Difficulty < minItemDifficulty {
+		warnings = append(warnings, "Слишком трудный пункт: почти никто не получает баллов")
+	}
+	if item.ItemTotalCorrelation != nil {
+		if *item.ItemTotalCorrelation < 0 {
+			warnings = append(warnings, "Отрицательная корреляция с итоговым баллом - проверьте ключ пункта")
+		} else if *item.ItemTotalCorrelation < minItemCorrelation {
+			warnings = append(warnings, "Низкая различающая способность пункта")
+		}
+	}
+	if item.QuestionType == models.QuestionSingleChoice || item.QuestionType == models.QuestionMultiSelect {
+		for _, option := range item.Distribution {
+			if option.Count == 0 && item.Answered > 0 {
+				warnings = append(warnings, fmt.Sprintf("Вариант «%s» не выбрал ни один респондент", option.Label))
+			}
+		}
+	}
+	return warnings
+}
+
+// answerDistribution считает распределение ответов на пункт
+func answerDistribution(question *scoringQuestion, optionLabels map[int]string, optionOrder []int,
+	answers []models.AnswerSubmission) []models.AnswerDistribution {
+	distribution := []models.AnswerDistribution{}
+	share := func(count int) float64 {
+		return math.Round(percentOf(float64(count), float64(len(answers)))*10) / 10
+	}
+
+	if question.QuestionType == models.QuestionLikert {
+		for value := question.ScaleMin; value <= question.ScaleMax; value++ {
+			count := 0
+			for _, answer := range answers {
+				if answer.Value != nil && int(math.Round(*answer.Value)) == value {
+					count++
+				}
+			}
+			distribution = append(distribution, models.AnswerDistribution{
+				Label: strconv.Itoa(value), Count: count, Percentage: share(count),
+			})
+		}
+		return distribution
+	}
+
+	for _, optionID := range optionOrder {
+		entry := models.AnswerDistribution{OptionID: optionID, Label: optionLabels[optionID]}
+		var rankSum float64
+		for _, answer := range answers {
+			if answer.OptionID == optionID {
+				entry.Count++
+			}
+			for position, picked := range answer.OptionIDs {
+				if picked == optionID {
+					entry.Count++
+					rankSum += float64(position + 1)
+				}
+			}
+		}
+		if question.QuestionType == models.QuestionRanking && entry.Count > 0 {
+			entry.MeanRank = statRound(rankSum / float64(entry.Count))
+		}
+		entry.Percentage = share(entry.Count)
+		distribution = append(distribution, entry)
+	}
+	return distribution
+}
+
+// Психометрический анализ пунктов текущей версии теста: альфа Кронбаха по шкалам, корреляции пункт-итог,
+// трудность пунктов и распределение ответов по вариантам
+func GetTestPsychometrics(c *gin.Context) {
+	testID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Неверный ID теста"})
+		return
+	}
+
+	report := models.PsychometricsReport{TestID: testID, Scales: []models.ScaleReliability{}, Items: []models.ItemAnalysis{}}
+	err = database.DB.QueryRow("SELECT title FROM psychological_tests WHERE id = $1", testID).Scan(&report.TestTitle)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Тест не найден"})
+		return
+	}
+
+	// Анализ строится по текущей версии: у попыток других версий свои вопросы
+	versionID, err := resolveVersionID(testID, 0)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка получения вопросов"})
+		return
+	}
+	questions, err := loadScoringQuestions(testID, versionID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка получения вопросов"})
+		return
+	}
+
+	// Тексты вопросов и вариантов для отчёта
+	questionTexts := make(map[int]string)
+	optionLabels := make(map[int]string)
+	optionOrder := make(map[int][]int)
+	rows, err := database.DB.Query(`
+		SELECT q.id, q.question_text, o.id, o.option_text
+		FROM test_questions q
+		LEFT JOIN question_options o ON o.question_id = q.id
+		WHERE q.version_id = $1
+		ORDER BY q.order_index, o.order_index
+	`, versionID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка получения вопросов"})
+		return
+	}
+	for rows.Next() {
+		var questionID int
+		var questionText string
+		var optionID sql.NullInt64
+		var optionText sql.NullString
+		if err := rows.Scan(&questionID, &questionText, &optionID, &optionText); err != nil {
+			continue
+		}
+		questionTexts[questionID] = questionText
+		if optionID.Valid {
+			optionLabels[int(optionID.Int64)] = optionText.String
+			optionOrder[questionID] = append(optionOrder[questionID], int(optionID.Int64))
+		}
+	}
+	rows.Close()
+
+	resultIDs, answers, err := loadOfficialAnswers(testID, versionID, questions)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка получения ответов"})
+		return
+	}
+	report.Respondents = len(resultIDs)
+
+	// Матрица взвешенных баллов: пункт -> респондент, как при подсчёте итогового балла
+	var scored []*scoringQuestion
+	var columns [][]float64
+	for _, question := range questions {
+		if question.QuestionType == models.QuestionFreeText {
+			continue
+		}
+		scored = append(scored, question)
+		columns = append(columns, keyedScores(question, question.Reversed, resultIDs, answers))
+	}
+
+	if len(columns) > 0 {
+		report.Alpha = cronbachAlpha(columns)
+	}
+
+	for i, question := range scored {
+		item := models.ItemAnalysis{
+			QuestionID:     question.ID,
+			QuestionNumber: question.OrderIndex,
+			QuestionText:   questionTexts[question.ID],
+			QuestionType:   question.QuestionType,
+			ScaleType:      question.ScaleType,
+		}
+
+		keyed := question.withKey(question.Reversed)
+		var given []models.AnswerSubmission
+		var rawScores []float64
+		for _, resultID := range resultIDs {
+			if answer, answered := answers[resultID][question.ID]; answered {
+				given = append(given, answer)
+				rawScores = append(rawScores, questionScore(keyed, answer))
+			}
+		}
+		item.Answered = len(given)
+		item.MeanScore = *statRound(statMean(rawScores))
+		if maxScore := questionMaxScore(keyed); maxScore > 0 && item.Answered > 0 {
+			item.Difficulty = statRound(item.MeanScore / maxScore)
+		}
+		if len(columns) > 1 && len(resultIDs) > 0 {
+			item.ItemTotalCorrelation = pearson(columns[i], restScores(columns, i))
+		}
+		item.Distribution = answerDistribution(question, optionLabels, optionOrder[question.ID], given)
+		item.Warnings = itemWarnings(&item)
+		report.Items = append(report.Items, item)
+	}
+
+	// Надёжность шкал по ключам вопросов (с учётом таблицы ключей)
+	scaleColumns := make(map[string][][]float64)
+	scaleItems := make(map[string][]models.ScaleItemStats)
+	var scaleOrder []string
+	for _, question := range scored {
+		for _, key := range question.Keys {
+			if _, exists := scaleColumns[key.Scale]; !exists {
+				scaleOrder = append(scaleOrder, key.Scale)
+			}
+			scaleColumns[key.Scale] = append(scaleColumns[key.Scale], keyedScores(question, key.Reverse, resultIDs, answers))
+			scaleItems[key.Scale] = append(scaleItems[key.Scale], models.ScaleItemStats{
+				QuestionNumber: question.OrderIndex,
+				Reverse:        key.Reverse,
+			})
+		}
+	}
+	sort.Strings(scaleOrder)
+
+	for _, scale := range scaleOrder {
+		items := scaleColumns[scale]
+		reliability := models.ScaleReliability{
+			ScaleType:  scale,
+			ItemsCount: len(items),
+			Alpha:      cronbachAlpha(items),
+			Items:      scaleItems[scale],
+		}
+		for i := range reliability.Items {
+			if len(items) < 2 || len(resultIDs) == 0 {
+				break
+			}
+			reliability.Items[i].ItemRestCorrelation = pearson(items[i], restScores(items, i))
+			if len(items) > 2 {
+				rest := append(append([][]float64{}, items[:i]...), items[i+1:]...)
+				reliability.Items[i].AlphaIfDeleted = cronbachAlpha(rest)
+			}
+		}
+		report.Scales = append(report.Scales, reliability)
+	}
+
+	c.JSON(http.StatusOK, gin.H{"report": report})
+}
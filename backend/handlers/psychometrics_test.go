@@ -0,0 +1,83 @@
+package handlers
+
+import (
+	"reflect"
+	"testing"
+)
+
+// sameStat сравнивает показатель с ожидаемым; nil - показатель не определён
+func sameStat(got, want *float64) bool {
+	if got == nil || want == nil {
+		return got == nil && want == nil
+	}
+	return *got == *want
+}
+
+func formatStat(value *float64) interface{} {
+	if value == nil {
+		return "nil"
+	}
+	return *value
+}
+
+func TestCronbachAlpha(t *testing.T) {
+	tests := []struct {
+		name  string
+		items [][]float64
+		want  *float64
+	}{
+		{"параллельные пункты", [][]float64{{1, 2, 3, 4}, {1, 2, 3, 4}}, statRound(1)},
+		{"согласованные пункты", [][]float64{{2, 3, 3, 4, 5}, {3, 3, 4, 4, 5}, {2, 4, 3, 5, 5}}, statRound(0.916)},
+		// Сумма пунктов одинакова у всех респондентов - дисперсия суммы нулевая
+		{"противоположные пункты", [][]float64{{1, 2, 3}, {3, 2, 1}}, nil},
+		{"один пункт", [][]float64{{1, 2, 3}}, nil},
+		{"один респондент", [][]float64{{1}, {2}}, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := cronbachAlpha(tt.items); !sameStat(got, tt.want) {
+				t.Errorf("альфа = %v, ожидалось %v", formatStat(got), formatStat(tt.want))
+			}
+		})
+	}
+}
+
+func TestPearson(t *testing.T) {
+	tests := []struct {
+		name string
+		x, y []float64
+		want *float64
+	}{
+		{"прямая связь", []float64{1, 2, 3, 4}, []float64{2, 4, 6, 8}, statRound(1)},
+		{"обратная связь", []float64{1, 2, 3, 4}, []float64{8, 6, 4, 2}, statRound(-1)},
+		{"частичная связь", []float64{1, 2, 3, 4, 5}, []float64{2, 1, 4, 3, 5}, statRound(0.8)},
+		{"нет разброса", []float64{1, 2, 3}, []float64{5, 5, 5}, nil},
+		{"меньше трёх наблюдений", []float64{1, 2}, []float64{1, 2}, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := pearson(tt.x, tt.y); !sameStat(got, tt.want) {
+				t.Errorf("r = %v, ожидалось %v", formatStat(got), formatStat(tt.want))
+			}
+		})
+	}
+}
+
+func TestRestScores(t *testing.T) {
+	items := [][]float64{{1, 2}, {3, 4}, {5, 6}}
+	tests := []struct {
+		skip int
+		want []float64
+	}{
+		{0, []float64{8, 10}},
+		{1, []float64{6, 8}},
+		{2, []float64{4, 6}},
+	}
+	for _, tt := range tests {
+		if got := restScores(items, tt.skip); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("без пункта %d: %v, ожидалось %v", tt.skip, got, tt.want)
+		}
+	}
+}
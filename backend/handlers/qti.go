@@ -0,0 +1,1025 @@
+package handlers
+
+import (
+	"archive/zip"
+	"bytes"
+	"database/sql"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"net/http"
+	"path"
+	"psycho-test-system/models"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Пространства имён IMS QTI 2.1 и манифеста пакета содержимого IMS
+const (
+	qtiNamespace       = "http://www.imsglobal.org/xsd/imsqti_v2p1"
+	qtiManifestNS      = "http://www.imsglobal.org/xsd/imscp_v1p1"
+	qtiItemResource    = "imsqti_item_xmlv2p1"
+	qtiTestResource    = "imsqti_test_xmlv2p1"
+	qtiTestFile        = "assessmentTest.xml"
+	qtiResponseID      = "RESPONSE"
+	qtiScoreOutcome    = "SCORE"
+	qtiWeightID        = "WEIGHT"
+	qtiDefaultScale    = "general"
+	qtiMethodology     = "qti_import"
+	qtiPassThreshold   = 70
+	maxQTIUnpackedSize = 4 * maxPackageSize
+)
+
+// errQTITooLarge - распакованный архив QTI больше maxQTIUnpackedSize
+var errQTITooLarge = fmt.Errorf("распакованное содержимое больше %d МБ", maxQTIUnpackedSize>>20)
+
+// Стандартные переменные результата QTI; шкалой считается первая переменная не из этого списка
+var qtiReservedOutcomes = map[string]bool{
+	"SCORE": true, "MAXSCORE": true, "MINSCORE": true, "PASSED": true,
+	"FEEDBACK": true, "FEEDBACKBASIC": true, "COMPLETION_STATUS": true,
+}
+
+// Поддерживаемые взаимодействия QTI
+var qtiInteractions = map[string]bool{
+	"choiceInteraction": true, "orderInteraction": true, "sliderInteraction": true,
+	"extendedTextInteraction": true, "textEntryInteraction": true,
+}
+
+// Блочные элементы XHTML: при извлечении текста они разделяют строки
+var qtiBlockElements = map[string]bool{
+	"p": true, "div": true, "br": true, "li": true, "h1": true, "h2": true, "h3": true,
+	"h4": true, "h5": true, "h6": true, "blockquote": true, "pre": true, "tr": true,
+}
+
+type qtiManifest struct {
+	XMLName       xml.Name      `xml:"manifest"`
+	Xmlns         string        `xml:"xmlns,attr"`
+	Identifier    string        `xml:"identifier,attr"`
+	Organizations struct{}      `xml:"organizations"`
+	Resources     []qtiResource `xml:"resources>resource"`
+}
+
+type qtiResource struct {
+	Identifier   string          `xml:"identifier,attr"`
+	Type         string          `xml:"type,attr"`
+	Href         string          `xml:"href,attr"`
+	Files        []qtiFile       `xml:"file"`
+	Dependencies []qtiDependency `xml:"dependency"`
+}
+
+type qtiFile struct {
+	Href string `xml:"href,attr"`
+}
+
+type qtiDependency struct {
+	IdentifierRef string `xml:"identifierref,attr"`
+}
+
+type qtiAssessmentTest struct {
+	XMLName    xml.Name  `xml:"assessmentTest"`
+	Xmlns      string    `xml:"xmlns,attr,omitempty"`
+	Identifier string    `xml:"identifier,attr"`
+	Title      string    `xml:"title,attr"`
+	TestParts  []qtiNode `xml:"testPart"`
+}
+
+// qtiNode - часть теста (testPart), раздел (assessmentSection) или ссылка на задание
+// (assessmentItemRef). Вложенные элементы хранятся в Parts в порядке документа.
+type qtiNode struct {
+	XMLName            xml.Name               `xml:""`
+	Identifier         string                 `xml:"identifier,attr"`
+	Title              string                 `xml:"title,attr,omitempty"`
+	Visible            string                 `xml:"visible,attr,omitempty"`
+	Href               string                 `xml:"href,attr,omitempty"`
+	NavigationMode     string                 `xml:"navigationMode,attr,omitempty"`
+	SubmissionMode     string                 `xml:"submissionMode,attr,omitempty"`
+	ItemSessionControl *qtiItemSessionControl `xml:"itemSessionControl"`
+	Weights            []qtiWeight            `xml:"weight"`
+	RubricBlocks       []qtiRubricBlock       `xml:"rubricBlock"`
+	Parts              []qtiNode              `xml:",any"`
+}
+
+type qtiItemSessionControl struct {
+	AllowSkipping string `xml:"allowSkipping,attr,omitempty"`
+}
+
+type qtiWeight struct {
+	Identifier string  `xml:"identifier,attr"`
+	Value      float64 `xml:"value,attr"`
+}
+
+type qtiRubricBlock struct {
+	View  string `xml:"view,attr"`
+	Inner string `xml:",innerxml"`
+}
+
+type qtiItem struct {
+	XMLName              xml.Name                 `xml:"assessmentItem"`
+	Xmlns                string                   `xml:"xmlns,attr,omitempty"`
+	Identifier           string                   `xml:"identifier,attr"`
+	Title                string                   `xml:"title,attr"`
+	Adaptive             bool                     `xml:"adaptive,attr"`
+	TimeDependent        bool                     `xml:"timeDependent,attr"`
+	ResponseDeclarations []qtiResponseDeclaration `xml:"responseDeclaration"`
+	OutcomeDeclarations  []qtiOutcomeDeclaration  `xml:"outcomeDeclaration"`
+	ItemBody             qtiMarkup                `xml:"itemBody"`
+	ResponseProcessing   *qtiMarkup               `xml:"responseProcessing"`
+}
+
+// qtiMarkup - элемент, содержимое которого разбирается или формируется вручную
+type qtiMarkup struct {
+	Inner string `xml:",innerxml"`
+}
+
+type qtiResponseDeclaration struct {
+	Identifier      string      `xml:"identifier,attr"`
+	Cardinality     string      `xml:"cardinality,attr"`
+	BaseType        string      `xml:"baseType,attr"`
+	CorrectResponse *qtiValues  `xml:"correctResponse"`
+	Mapping         *qtiMapping `xml:"mapping"`
+}
+
+type qtiOutcomeDeclaration struct {
+	Identifier     string     `xml:"identifier,attr"`
+	Cardinality    string     `xml:"cardinality,attr"`
+	BaseType       string     `xml:"baseType,attr"`
+	Interpretation string     `xml:"interpretation,attr,omitempty"`
+	DefaultValue   *qtiValues `xml:"defaultValue"`
+}
+
+type qtiValues struct {
+	Values []string `xml:"value"`
+}
+
+type qtiMapping struct {
+	DefaultValue float64       `xml:"defaultValue,attr"`
+	Entries      []qtiMapEntry `xml:"mapEntry"`
+}
+
+type qtiMapEntry struct {
+	MapKey      string  `xml:"mapKey,attr"`
+	MappedValue float64 `xml:"mappedValue,attr"`
+}
+
+// qtiInteractionXML - взаимодействие в itemBody при экспорте
+type qtiInteractionXML struct {
+	XMLName            xml.Name
+	ResponseIdentifier string            `xml:"responseIdentifier,attr"`
+	Shuffle            string            `xml:"shuffle,attr,omitempty"`
+	MaxChoices         string            `xml:"maxChoices,attr,omitempty"`
+	LowerBound         string            `xml:"lowerBound,attr,omitempty"`
+	UpperBound         string            `xml:"upperBound,attr,omitempty"`
+	Step               string            `xml:"step,attr,omitempty"`
+	Prompt             string            `xml:"prompt"`
+	Choices            []qtiSimpleChoice `xml:"simpleChoice"`
+}
+
+type qtiSimpleChoice struct {
+	Identifier string `xml:"identifier,attr"`
+	Text       string `xml:",chardata"`
+}
+
+// qtiInteraction - взаимодействие, найденное в itemBody при импорте
+type qtiInteraction struct {
+	Kind               string
+	ResponseIdentifier string
+	MaxChoices         string
+	LowerBound         string
+	UpperBound         string
+	Prompt             string
+	Choices            []qtiSimpleChoice
+}
+
+// qtiItemBody - разобранное содержимое itemBody
+type qtiItemBody struct {
+	Stem        string
+	Interaction *qtiInteraction
+	Extra       int      // взаимодействия после первого поддерживаемого
+	Unsupported []string // неподдерживаемые взаимодействия
+}
+
+// qtiContent - задания QTI, переведённые в вопросы теста
+type qtiContent struct {
+	Title        string
+	Instructions string
+	Questions    []models.QuestionInput
+	Warnings     []string
+}
+
+// qtiItemUse - задание в порядке теста с настройками из assessmentTest
+type qtiItemUse struct {
+	Path       string
+	IsRequired *bool
+	Weight     float64
+}
+
+// qtiIdentifier приводит название шкалы к идентификатору QTI (NCName)
+func qtiIdentifier(name string) string {
+	var builder strings.Builder
+	for _, r := range strings.TrimSpace(name) {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) || r == '_' || r == '-' || r == '.' {
+			builder.WriteRune(r)
+		} else {
+			builder.WriteRune('_')
+		}
+	}
+	identifier := builder.String()
+	if identifier == "" {
+		return qtiDefaultScale
+	}
+	if first := []rune(identifier)[0]; (!unicode.IsLetter(first) && first != '_') || qtiReservedOutcomes[identifier] {
+		identifier = "SCALE_" + identifier
+	}
+	return identifier
+}
+
+// qtiEscape экранирует текст для вставки в разметку
+func qtiEscape(text string) string {
+	var buffer bytes.Buffer
+	xml.EscapeText(&buffer, []byte(text))
+	return buffer.String()
+}
+
+// qtiScale - переменная результата задания для шкалы вопроса
+func qtiScale(scaleType string) qtiOutcomeDeclaration {
+	if strings.TrimSpace(scaleType) == "" {
+		scaleType = qtiDefaultScale
+	}
+	outcome := qtiOutcomeDeclaration{
+		Identifier: qtiIdentifier(scaleType), Cardinality: "single", BaseType: "float",
+		DefaultValue: &qtiValues{Values: []string{"0"}},
+	}
+	if outcome.Identifier != scaleType {
+		outcome.Interpretation = scaleType
+	}
+	return outcome
+}
+
+// effectiveOptionScores - баллы вариантов с учётом обратного ключа: в QTI ключ не хранится,
+// поэтому выгружаются уже отражённые баллы
+func effectiveOptionScores(question models.QuestionInput) []int {
+	scores := make([]int, len(question.Options))
+	minScore, maxScore := 0, 0
+	for i, option := range question.Options {
+		scores[i] = option.ScoreValue
+		if i == 0 || option.ScoreValue < minScore {
+			minScore = option.ScoreValue
+		}
+		if i == 0 || option.ScoreValue > maxScore {
+			maxScore = option.ScoreValue
+		}
+	}
+	if question.ScoringKey == models.ScoringReverse {
+		for i := range scores {
+			scores[i] = minScore + maxScore - scores[i]
+		}
+	}
+	return scores
+}
+
+// buildQTIItem переводит вопрос теста в задание QTI
+func buildQTIItem(identifier string, question models.QuestionInput) (*qtiItem, error) {
+	scale := qtiScale(question.ScaleType)
+	item := &qtiItem{
+		Xmlns: qtiNamespace, Identifier: identifier, Title: question.QuestionText,
+		OutcomeDeclarations: []qtiOutcomeDeclaration{
+			{Identifier: qtiScoreOutcome, Cardinality: "single", BaseType: "float", DefaultValue: &qtiValues{Values: []string{"0"}}},
+			scale,
+		},
+	}
+	if title := []rune(item.Title); len(title) > 100 {
+		item.Title = string(title[:100]) + "…"
+	}
+
+	interaction := qtiInteractionXML{ResponseIdentifier: qtiResponseID, Prompt: question.QuestionText}
+	declaration := qtiResponseDeclaration{Identifier: qtiResponseID, Cardinality: "single", BaseType: "identifier"}
+	setScale := fmt.Sprintf(`<setOutcomeValue identifier="%s"><variable identifier="%s"/></setOutcomeValue>`,
+		scale.Identifier, qtiScoreOutcome)
+	var scoreExpression string
+
+	switch question.QuestionType {
+	case models.QuestionLikert:
+		interaction.XMLName.Local = "sliderInteraction"
+		interaction.LowerBound = strconv.Itoa(question.MinValue)
+		interaction.UpperBound = strconv.Itoa(question.MaxValue)
+		interaction.Step = "1"
+		declaration.BaseType = "integer"
+		scoreExpression = fmt.Sprintf(`<variable identifier="%s"/>`, qtiResponseID)
+		if question.ScoringKey == models.ScoringReverse {
+			scoreExpression = fmt.Sprintf(`<subtract><baseValue baseType="integer">%d</baseValue><variable identifier="%s"/></subtract>`,
+				question.MinValue+question.MaxValue, qtiResponseID)
+		}
+	case models.QuestionFreeText:
+		interaction.XMLName.Local = "extendedTextInteraction"
+		declaration.BaseType = "string"
+	default:
+		interaction.XMLName.Local = "choiceInteraction"
+		interaction.Shuffle = "false"
+		interaction.MaxChoices = "1"
+		switch question.QuestionType {
+		case models.QuestionMultiSelect:
+			interaction.MaxChoices = "0"
+			declaration.Cardinality = "multiple"
+		case models.QuestionRanking:
+			interaction.XMLName.Local = "orderInteraction"
+			interaction.MaxChoices = ""
+			declaration.Cardinality = "ordered"
+		}
+		declaration.Mapping = &qtiMapping{}
+		for i, score := range effectiveOptionScores(question) {
+			choiceID := fmt.Sprintf("CHOICE_%d", i+1)
+			interaction.Choices = append(interaction.Choices, qtiSimpleChoice{Identifier: choiceID, Text: question.Options[i].OptionText})
+			declaration.Mapping.Entries = append(declaration.Mapping.Entries, qtiMapEntry{MapKey: choiceID, MappedValue: float64(score)})
+		}
+		// Для ранжирования балл зависит от позиции варианта, что в QTI не выражается
+		// стандартными правилами: баллы вариантов сохраняются только в mapping
+		if question.QuestionType != models.QuestionRanking {
+			scoreExpression = fmt.Sprintf(`<mapResponse identifier="%s"/>`, qtiResponseID)
+		}
+	}
+
+	body, err := xml.Marshal(interaction)
+	if err != nil {
+		return nil, err
+	}
+	item.ItemBody.Inner = string(body)
+	item.ResponseDeclarations = []qtiResponseDeclaration{declaration}
+	if scoreExpression != "" {
+		item.ResponseProcessing = &qtiMarkup{Inner: fmt.Sprintf(`<setOutcomeValue identifier="%s">%s</setOutcomeValue>%s`,
+			qtiScoreOutcome, scoreExpression, setScale)}
+	}
+	return item, nil
+}
+
+// writeQTIFile добавляет в архив XML-документ
+func writeQTIFile(archive *zip.Writer, name string, document interface{}) error {
+	data, err := xml.MarshalIndent(document, "", "  ")
+	if err != nil {
+		return err
+	}
+	file, err := archive.Create(name)
+	if err != nil {
+		return err
+	}
+	if _, err := io.WriteString(file, xml.Header); err != nil {
+		return err
+	}
+	_, err = file.Write(data)
+	return err
+}
+
+// buildQTIPackage собирает пакет содержимого IMS с тестом QTI 2.1: манифест,
+// assessmentTest и по файлу на задание
+func buildQTIPackage(testID int, pkg *models.TestPackage) ([]byte, error) {
+	testIdentifier := fmt.Sprintf("test-%d", testID)
+	section := qtiNode{
+		XMLName: xml.Name{Local: "assessmentSection"}, Identifier: "section-1",
+		Title: pkg.Test.Title, Visible: "true",
+	}
+	if instructions := strings.TrimSpace(pkg.Test.Instructions); instructions != "" {
+		var paragraphs strings.Builder
+		for _, line := range strings.Split(instructions, "\n") {
+			if line = strings.TrimSpace(line); line != "" {
+				paragraphs.WriteString("<p>" + qtiEscape(line) + "</p>")
+			}
+		}
+		section.RubricBlocks = []qtiRubricBlock{{View: "candidate", Inner: paragraphs.String()}}
+	}
+
+	manifest := qtiManifest{Xmlns: qtiManifestNS, Identifier: "manifest-" + testIdentifier}
+	testResource := qtiResource{
+		Identifier: testIdentifier, Type: qtiTestResource, Href: qtiTestFile,
+		Files: []qtiFile{{Href: qtiTestFile}},
+	}
+
+	var buffer bytes.Buffer
+	archive := zip.NewWriter(&buffer)
+	for i, question := range pkg.Questions {
+		identifier := fmt.Sprintf("item-%03d", i+1)
+		href := "items/" + identifier + ".xml"
+		item, err := buildQTIItem(identifier, question)
+		if err != nil {
+			return nil, fmt.Errorf("вопрос №%d: %v", i+1, err)
+		}
+		if err := writeQTIFile(archive, href, item); err != nil {
+			return nil, err
+		}
+
+		allowSkipping := question.IsRequired != nil && !*question.IsRequired
+		ref := qtiNode{
+			XMLName: xml.Name{Local: "assessmentItemRef"}, Identifier: identifier, Href: href,
+			ItemSessionControl: &qtiItemSessionControl{AllowSkipping: strconv.FormatBool(allowSkipping)},
+		}
+		if question.Weight != 1 {
+			ref.Weights = []qtiWeight{{Identifier: qtiWeightID, Value: question.Weight}}
+		}
+		section.Parts = append(section.Parts, ref)
+
+		manifest.Resources = append(manifest.Resources, qtiResource{
+			Identifier: identifier, Type: qtiItemResource, Href: href, Files: []qtiFile{{Href: href}},
+		})
+		testResource.Dependencies = append(testResource.Dependencies, qtiDependency{IdentifierRef: identifier})
+	}
+	manifest.Resources = append([]qtiResource{testResource}, manifest.Resources...)
+
+	test := qtiAssessmentTest{
+		Xmlns: qtiNamespace, Identifier: testIdentifier, Title: pkg.Test.Title,
+		TestParts: []qtiNode{{
+			XMLName: xml.Name{Local: "testPart"}, Identifier: "part-1",
+			NavigationMode: "nonlinear", SubmissionMode: "simultaneous",
+			Parts: []qtiNode{section},
+		}},
+	}
+	if err := writeQTIFile(archive, qtiTestFile, test); err != nil {
+		return nil, err
+	}
+	if err := writeQTIFile(archive, "imsmanifest.xml", manifest); err != nil {
+		return nil, err
+	}
+	if err := archive.Close(); err != nil {
+		return nil, err
+	}
+	return buffer.Bytes(), nil
+}
+
+// qtiDecoder - нестрогий разбор XML: в содержимое QTI часто попадают сущности HTML
+func qtiDecoder(data string) *xml.Decoder {
+	decoder := xml.NewDecoder(strings.NewReader(data))
+	decoder.Strict = false
+	decoder.Entity = xml.HTMLEntity
+	return decoder
+}
+
+// qtiRootElement возвращает имя корневого элемента документа
+func qtiRootElement(data []byte) string {
+	decoder := qtiDecoder(string(data))
+	for {
+		token, err := decoder.Token()
+		if err != nil {
+			return ""
+		}
+		if start, ok := token.(xml.StartElement); ok {
+			return start.Name.Local
+		}
+	}
+}
+
+func qtiAttr(element xml.StartElement, name string) string {
+	for _, attr := range element.Attr {
+		if attr.Name.Local == name {
+			return attr.Value
+		}
+	}
+	return ""
+}
+
+// qtiTextBuilder собирает текст разметки: пробелы внутри строки схлопываются,
+// блочные элементы начинают новую строку
+type qtiTextBuilder struct {
+	lines []string
+	line  strings.Builder
+}
+
+func (builder *qtiTextBuilder) write(text string) {
+	builder.line.WriteString(text)
+}
+
+func (builder *qtiTextBuilder) breakLine() {
+	if line := strings.Join(strings.Fields(builder.line.String()), " "); line != "" {
+		builder.lines = append(builder.lines, line)
+	}
+	builder.line.Reset()
+}
+
+func (builder *qtiTextBuilder) String() string {
+	builder.breakLine()
+	return strings.Join(builder.lines, "\n")
+}
+
+// qtiText извлекает текст из разметки XHTML
+func qtiText(markup string) string {
+	var text qtiTextBuilder
+	decoder := qtiDecoder("<root>" + markup + "</root>")
+	for {
+		token, err := decoder.Token()
+		if err != nil {
+			break
+		}
+		switch token := token.(type) {
+		case xml.StartElement:
+			if qtiBlockElements[token.Name.Local] {
+				text.breakLine()
+			}
+		case xml.EndElement:
+			if qtiBlockElements[token.Name.Local] {
+				text.breakLine()
+			}
+		case xml.CharData:
+			text.write(string(token))
+		}
+	}
+	return text.String()
+}
+
+// parseQTIItemBody находит в itemBody первое поддерживаемое взаимодействие, его подсказку
+// и варианты. Текст вне взаимодействий считается формулировкой вопроса.
+func parseQTIItemBody(markup string) (*qtiItemBody, error) {
+	body := &qtiItemBody{}
+	var stem, prompt, choice qtiTextBuilder
+	current := &stem
+	inside, choiceID := "", ""
+
+	decoder := qtiDecoder("<itemBody>" + markup + "</itemBody>")
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+
+		switch token := token.(type) {
+		case xml.StartElement:
+			name := token.Name.Local
+			switch {
+			case inside == "" && qtiInteractions[name]:
+				if body.Interaction != nil {
+					body.Extra++
+					if err := decoder.Skip(); err != nil {
+						return nil, err
+					}
+					continue
+				}
+				body.Interaction = &qtiInteraction{
+					Kind: name, ResponseIdentifier: qtiAttr(token, "responseIdentifier"),
+					MaxChoices: qtiAttr(token, "maxChoices"),
+					LowerBound: qtiAttr(token, "lowerBound"), UpperBound: qtiAttr(token, "upperBound"),
+				}
+				inside, current = name, nil
+			case inside == "" && strings.HasSuffix(name, "Interaction"):
+				body.Unsupported = append(body.Unsupported, name)
+				if err := decoder.Skip(); err != nil {
+					return nil, err
+				}
+			case inside != "" && name == "prompt":
+				current = &prompt
+			case inside != "" && name == "simpleChoice":
+				choice = qtiTextBuilder{}
+				choiceID, current = qtiAttr(token, "identifier"), &choice
+			case qtiBlockElements[name] && current != nil:
+				current.breakLine()
+			}
+		case xml.EndElement:
+			name := token.Name.Local
+			switch {
+			case inside != "" && name == inside:
+				inside, current = "", &stem
+			case inside != "" && name == "prompt":
+				current = nil
+			case inside != "" && name == "simpleChoice":
+				body.Interaction.Choices = append(body.Interaction.Choices, qtiSimpleChoice{Identifier: choiceID, Text: choice.String()})
+				current = nil
+			case qtiBlockElements[name] && current != nil:
+				current.breakLine()
+			}
+		case xml.CharData:
+			if current != nil {
+				current.write(string(token))
+			}
+		}
+	}
+
+	body.Stem = stem.String()
+	if body.Interaction != nil {
+		body.Interaction.Prompt = prompt.String()
+	}
+	return body, nil
+}
+
+// qtiScaleName - шкала задания: первая нестандартная переменная результата.
+// Исходное название шкалы восстанавливается из interpretation, если его записал экспорт.
+func qtiScaleName(outcomes []qtiOutcomeDeclaration) string {
+	for _, outcome := range outcomes {
+		if qtiReservedOutcomes[outcome.Identifier] || outcome.Identifier == "" {
+			continue
+		}
+		if outcome.Interpretation != "" && qtiIdentifier(outcome.Interpretation) == outcome.Identifier {
+			return outcome.Interpretation
+		}
+		return outcome.Identifier
+	}
+	return qtiDefaultScale
+}
+
+// qtiReversedScore проверяет, вычисляется ли балл вычитанием ответа из константы -
+// так экспорт записывает обратный ключ шкалы Лайкерта
+func qtiReversedScore(processing *qtiMarkup) bool {
+	if processing == nil {
+		return false
+	}
+	decoder := qtiDecoder("<responseProcessing>" + processing.Inner + "</responseProcessing>")
+	depth := 0
+	for {
+		token, err := decoder.Token()
+		if err != nil {
+			return false
+		}
+		switch token := token.(type) {
+		case xml.StartElement:
+			if token.Name.Local == "setOutcomeValue" {
+				depth++
+			} else if token.Name.Local == "subtract" && depth > 0 {
+				return true
+			}
+		case xml.EndElement:
+			if token.Name.Local == "setOutcomeValue" {
+				depth--
+			}
+		}
+	}
+}
+
+// qtiChoiceScores - баллы вариантов из mapping ответа; без mapping верный ответ даёт 1 балл
+func qtiChoiceScores(declaration *qtiResponseDeclaration, choices []qtiSimpleChoice) ([]int, []string) {
+	var warnings []string
+	scores := make([]int, len(choices))
+	rounded := false
+	round := func(value float64) int {
+		if value != math.Trunc(value) {
+			rounded = true
+		}
+		return int(math.Round(value))
+	}
+
+	switch {
+	case declaration != nil && declaration.Mapping != nil:
+		mapped := make(map[string]float64, len(declaration.Mapping.Entries))
+		for _, entry := range declaration.Mapping.Entries {
+			mapped[entry.MapKey] = entry.MappedValue
+		}
+		for i, choice := range choices {
+			value, exists := mapped[choice.Identifier]
+			if !exists {
+				value = declaration.Mapping.DefaultValue
+			}
+			scores[i] = round(value)
+		}
+	case declaration != nil && declaration.CorrectResponse != nil:
+		correct := make(map[string]bool, len(declaration.CorrectResponse.Values))
+		for _, value := range declaration.CorrectResponse.Values {
+			correct[strings.TrimSpace(value)] = true
+		}
+		for i, choice := range choices {
+			if correct[choice.Identifier] {
+				scores[i] = 1
+			}
+		}
+	default:
+		warnings = append(warnings, "баллы вариантов не заданы, все варианты получили 0 баллов")
+	}
+
+	if rounded {
+		warnings = append(warnings, "дробные баллы вариантов округлены до целых")
+	}
+	return scores, warnings
+}
+
+// convertQTIItem переводит задание QTI в вопрос теста. Если задание перенести нельзя,
+// возвращает nil и причину в замечаниях.
+func convertQTIItem(item *qtiItem) (*models.QuestionInput, []string) {
+	body, err := parseQTIItemBody(item.ItemBody.Inner)
+	if err != nil {
+		return nil, []string{"ошибка разбора itemBody: " + err.Error()}
+	}
+	if body.Interaction == nil {
+		if len(body.Unsupported) > 0 {
+			return nil, []string{"взаимодействие " + strings.Join(body.Unsupported, ", ") + " не поддерживается"}
+		}
+		return nil, []string{"нет взаимодействия с ответом"}
+	}
+
+	var warnings []string
+	if body.Extra > 0 || len(body.Unsupported) > 0 {
+		warnings = append(warnings, "перенесено только первое взаимодействие задания")
+	}
+	interaction := body.Interaction
+
+	var textParts []string
+	for _, part := range []string{body.Stem, interaction.Prompt} {
+		if part != "" {
+			textParts = append(textParts, part)
+		}
+	}
+	question := &models.QuestionInput{
+		QuestionText: strings.Join(textParts, "\n"),
+		ScaleType:    qtiScaleName(item.OutcomeDeclarations),
+		Weight:       1,
+		ScoringKey:   models.ScoringDirect,
+	}
+	if question.QuestionText == "" {
+		question.QuestionText = item.Title
+	}
+
+	var declaration *qtiResponseDeclaration
+	for i := range item.ResponseDeclarations {
+		if item.ResponseDeclarations[i].Identifier == interaction.ResponseIdentifier {
+			declaration = &item.ResponseDeclarations[i]
+			break
+		}
+	}
+
+	switch interaction.Kind {
+	case "choiceInteraction", "orderInteraction":
+		question.QuestionType = models.QuestionRanking
+		if interaction.Kind == "choiceInteraction" {
+			// По спецификации maxChoices по умолчанию равен 1
+			question.QuestionType = models.QuestionSingleChoice
+			if maxChoices := strings.TrimSpace(interaction.MaxChoices); maxChoices != "" && maxChoices != "1" {
+				question.QuestionType = models.QuestionMultiSelect
+			}
+		}
+		scores, scoreWarnings := qtiChoiceScores(declaration, interaction.Choices)
+		warnings = append(warnings, scoreWarnings...)
+		for i, choice := range interaction.Choices {
+			question.Options = append(question.Options, models.OptionInput{OptionText: choice.Text, ScoreValue: scores[i]})
+		}
+	case "sliderInteraction":
+		question.QuestionType = models.QuestionLikert
+		lower, errLower := strconv.ParseFloat(interaction.LowerBound, 64)
+		upper, errUpper := strconv.ParseFloat(interaction.UpperBound, 64)
+		if errLower != nil || errUpper != nil {
+			return nil, []string{"у шкалы не заданы границы"}
+		}
+		question.MinValue, question.MaxValue = int(math.Round(lower)), int(math.Round(upper))
+		if float64(question.MinValue) != lower || float64(question.MaxValue) != upper {
+			warnings = append(warnings, "границы шкалы округлены до целых")
+		}
+		if qtiReversedScore(item.ResponseProcessing) {
+			question.ScoringKey = models.ScoringReverse
+		}
+	default:
+		question.QuestionType = models.QuestionFreeText
+	}
+	return question, warnings
+}
+
+// readQTIFiles возвращает XML-документы из zip-архива или единственный присланный документ
+func readQTIFiles(data []byte) (map[string][]byte, error) {
+	if !bytes.HasPrefix(data, []byte("PK")) {
+		return map[string][]byte{"item.xml": data}, nil
+	}
+
+	archive, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return nil, err
+	}
+	files := make(map[string][]byte)
+	total := 0
+	for _, file := range archive.File {
+		if file.FileInfo().IsDir() || !strings.EqualFold(path.Ext(file.Name), ".xml") {
+			continue
+		}
+		reader, err := file.Open()
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", file.Name, err)
+		}
+		content, err := io.ReadAll(io.LimitReader(reader, int64(maxQTIUnpackedSize-total+1)))
+		reader.Close()
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", file.Name, err)
+		}
+		if total += len(content); total > maxQTIUnpackedSize {
+			return nil, errQTITooLarge
+		}
+		files[path.Clean(file.Name)] = content
+	}
+	return files, nil
+}
+
+// qtiItemUses обходит assessmentTest и возвращает задания в порядке теста.
+// Разрешение пропуска наследуется от части теста и раздела; если оно не задано,
+// по спецификации задание можно пропустить.
+func qtiItemUses(test *qtiAssessmentTest, testPath string) ([]qtiItemUse, string) {
+	var uses []qtiItemUse
+	var instructions []string
+	dir := path.Dir(testPath)
+
+	var walk func(node qtiNode, allowSkipping bool)
+	walk = func(node qtiNode, allowSkipping bool) {
+		if node.ItemSessionControl != nil && node.ItemSessionControl.AllowSkipping != "" {
+			if value, err := strconv.ParseBool(node.ItemSessionControl.AllowSkipping); err == nil {
+				allowSkipping = value
+			}
+		}
+		switch node.XMLName.Local {
+		case "assessmentItemRef":
+			isRequired := !allowSkipping
+			use := qtiItemUse{Path: path.Join(dir, node.Href), IsRequired: &isRequired, Weight: 1}
+			for _, weight := range node.Weights {
+				if weight.Identifier == qtiWeightID || len(node.Weights) == 1 {
+					use.Weight = weight.Value
+				}
+			}
+			uses = append(uses, use)
+		case "testPart", "assessmentSection":
+			for _, rubric := range node.RubricBlocks {
+				if text := qtiText(rubric.Inner); text != "" && strings.Contains(rubric.View, "candidate") {
+					instructions = append(instructions, text)
+				}
+			}
+			for _, part := range node.Parts {
+				walk(part, allowSkipping)
+			}
+		}
+	}
+	for _, part := range test.TestParts {
+		walk(part, true)
+	}
+	return uses, strings.Join(instructions, "\n")
+}
+
+// parseQTIFiles переводит документы QTI в вопросы теста. Порядок и настройки заданий берутся
+// из assessmentTest, без него задания идут в порядке имён файлов.
+func parseQTIFiles(files map[string][]byte) (*qtiContent, error) {
+	content := &qtiContent{Warnings: []string{}}
+	names := make([]string, 0, len(files))
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	items := make(map[string]*qtiItem)
+	var itemNames []string
+	var test *qtiAssessmentTest
+	testPath := ""
+	for _, name := range names {
+		switch qtiRootElement(files[name]) {
+		case "assessmentItem":
+			var item qtiItem
+			if err := qtiDecoder(string(files[name])).Decode(&item); err != nil {
+				return nil, fmt.Errorf("%s: %v", name, err)
+			}
+			items[name] = &item
+			itemNames = append(itemNames, name)
+		case "assessmentTest":
+			if test != nil {
+				content.Warnings = append(content.Warnings, fmt.Sprintf("%s: используется только первый assessmentTest", name))
+				continue
+			}
+			test = &qtiAssessmentTest{}
+			if err := qtiDecoder(string(files[name])).Decode(test); err != nil {
+				return nil, fmt.Errorf("%s: %v", name, err)
+			}
+			testPath = name
+		}
+	}
+
+	var uses []qtiItemUse
+	if test != nil {
+		content.Title = strings.TrimSpace(test.Title)
+		uses, content.Instructions = qtiItemUses(test, testPath)
+	} else {
+		for _, name := range itemNames {
+			uses = append(uses, qtiItemUse{Path: name, Weight: 1})
+		}
+		if len(itemNames) > 0 {
+			content.Title = strings.TrimSpace(items[itemNames[0]].Title)
+		}
+	}
+
+	for _, use := range uses {
+		item, exists := items[use.Path]
+		if !exists {
+			content.Warnings = append(content.Warnings, fmt.Sprintf("%s: задание не найдено", use.Path))
+			continue
+		}
+		question, warnings := convertQTIItem(item)
+		for _, warning := range warnings {
+			content.Warnings = append(content.Warnings, fmt.Sprintf("Задание %s: %s", item.Identifier, warning))
+		}
+		if question == nil {
+			continue
+		}
+		question.IsRequired = use.IsRequired
+		question.Weight = use.Weight
+		// Вес вне диапазона, который хранит база, заменяется обычным, чтобы задание не потерялось
+		if rounded := math.Round(use.Weight*100) / 100; !(rounded > 0 && rounded <= maxQuestionWeight) {
+			content.Warnings = append(content.Warnings, fmt.Sprintf("Задание %s: вес %g вне диапазона (0; %.2f], используется вес 1",
+				item.Identifier, use.Weight, maxQuestionWeight))
+			question.Weight = 1
+		}
+		content.Questions = append(content.Questions, *question)
+	}
+	return content, nil
+}
+
+// Экспорт текущей версии теста в пакет IMS QTI 2.1 (zip). Ключи шкал, нормы и настройки
+// достоверности в QTI не переносятся - для них есть собственный формат пакета.
+func ExportQTIPackage(c *gin.Context) {
+	testID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Неверный ID теста"})
+		return
+	}
+
+	pkg, err := loadTestPackage(testID)
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Тест не найден"})
+		return
+	} else if err != nil {
+		log.Printf("Ошибка экспорта теста %d в QTI: %v", testID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка экспорта теста"})
+		return
+	}
+
+	data, err := buildQTIPackage(testID, pkg)
+	if err != nil {
+		log.Printf("Ошибка формирования пакета QTI теста %d: %v", testID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка формирования пакета QTI"})
+		return
+	}
+
+	c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="test-%d-qti.zip"`, testID))
+	c.Data(http.StatusOK, "application/zip", data)
+}
+
+// Импорт вопросов из QTI 2.1: zip-пакет с assessmentTest или отдельное задание assessmentItem.
+// С test_id заменяются вопросы существующего теста, остальные разделы сохраняются.
+// Для нового теста порог и методику можно задать параметрами pass_threshold и methodology_type.
+func ImportQTIPackage(c *gin.Context) {
+	testID, ok := importTargetTest(c)
+	if !ok {
+		return
+	}
+	data, ok := readImportBody(c)
+	if !ok {
+		return
+	}
+
+	files, err := readQTIFiles(data)
+	if err == errQTITooLarge {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "Распакованный архив QTI слишком большой"})
+		return
+	} else if err != nil {
+		log.Printf("Ошибка чтения архива QTI: %v", err)
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Неверный архив QTI"})
+		return
+	}
+	content, err := parseQTIFiles(files)
+	if err != nil {
+		log.Printf("Ошибка разбора QTI: %v", err)
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Неверный формат QTI: ожидается assessmentItem или assessmentTest QTI 2.1"})
+		return
+	}
+	if len(content.Questions) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "В содержимом QTI нет заданий, которые можно перенести", "warnings": content.Warnings})
+		return
+	}
+
+	var pkg *models.TestPackage
+	if testID > 0 {
+		pkg, err = loadTestPackage(testID)
+		if err == sql.ErrNoRows {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Тест не найден"})
+			return
+		} else if err != nil {
+			log.Printf("Ошибка загрузки теста %d для импорта QTI: %v", testID, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка загрузки теста"})
+			return
+		}
+		if content.Title != "" {
+			pkg.Test.Title = content.Title
+		}
+		if content.Instructions != "" {
+			pkg.Test.Instructions = content.Instructions
+		}
+	} else {
+		pkg = &models.TestPackage{
+			Format:  models.TestPackageFormat,
+			Version: models.TestPackageVersion,
+			Test: models.PackageTest{
+				Title: content.Title, Instructions: content.Instructions,
+				PassThreshold: qtiPassThreshold, MethodologyType: c.DefaultQuery("methodology_type", qtiMethodology),
+			},
+		}
+		if thresholdParam := c.Query("pass_threshold"); thresholdParam != "" {
+			threshold, err := strconv.ParseFloat(thresholdParam, 64)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Неверный порог прохождения"})
+				return
+			}
+			pkg.Test.PassThreshold = threshold
+		}
+	}
+	pkg.ExportedAt = time.Time{}
+	pkg.Questions = content.Questions
+
+	runPackageImport(c, testID, pkg, c.Query("dry_run") == "true", "qti", content.Warnings)
+}
@@ -0,0 +1,201 @@
+package handlers
+
+import (
+	"fmt"
+	"math"
+	"psycho-test-system/models"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+// Максимальная длина свободного ответа в символах
+const maxFreeTextLength = 5000
+
+// Границы шкалы Лайкерта по умолчанию
+const (
+	defaultLikertMin = 1
+	defaultLikertMax = 5
+)
+
+func normalizeQuestionType(questionType string) string {
+	switch questionType {
+	case models.QuestionMultiSelect, models.QuestionLikert, models.QuestionRanking, models.QuestionFreeText:
+		return questionType
+	}
+	return models.QuestionSingleChoice
+}
+
+func normalizeScoringKey(key string) string {
+	if key == models.ScoringReverse {
+		return models.ScoringReverse
+	}
+	return models.ScoringDirect
+}
+
+// validateQuestionDefinition проверяет, что вопрос редактора можно пройти и оценить.
+// Для likert подставляет границы шкалы по умолчанию.
+func validateQuestionDefinition(questionType string, optionsCount int, minValue, maxValue *int) string {
+	switch questionType {
+	case models.QuestionLikert:
+		if *minValue == 0 && *maxValue == 0 {
+			*minValue, *maxValue = defaultLikertMin, defaultLikertMax
+		}
+		if *minValue >= *maxValue {
+			return "минимальное значение шкалы должно быть меньше максимального"
+		}
+	case models.QuestionRanking:
+		if optionsCount < 2 {
+			return "для ранжирования нужно не меньше двух вариантов"
+		}
+	case models.QuestionFreeText:
+	default:
+		if optionsCount == 0 {
+			return "нужен хотя бы один вариант ответа"
+		}
+	}
+	return ""
+}
+
+// questionMaxScore - максимальный балл вопроса без учёта веса
+func questionMaxScore(question *scoringQuestion) float64 {
+	switch question.QuestionType {
+	case models.QuestionMultiSelect:
+		var total float64
+		for _, value := range question.Options {
+			if value > 0 {
+				total += float64(value)
+			}
+		}
+		return total
+	case models.QuestionLikert:
+		return float64(question.ScaleMax)
+	case models.QuestionRanking:
+		values := make([]int, 0, len(question.Options))
+		for _, value := range question.Options {
+			values = append(values, value)
+		}
+		sort.Sort(sort.Reverse(sort.IntSlice(values)))
+		return rankingScore(values)
+	case models.QuestionFreeText:
+		return 0
+	}
+	return float64(question.MaxValue)
+}
+
+// questionScore - балл за ответ без учёта веса. Ответ должен быть проверен validateQuestionAnswer
+func questionScore(question *scoringQuestion, answer models.AnswerSubmission) float64 {
+	switch question.QuestionType {
+	case models.QuestionMultiSelect:
+		var total float64
+		for _, optionID := range answer.OptionIDs {
+			total += float64(question.Options[optionID])
+		}
+		return total
+	case models.QuestionLikert:
+		if answer.Value == nil {
+			return 0
+		}
+		if question.Reversed {
+			return float64(question.ScaleMin+question.ScaleMax) - *answer.Value
+		}
+		return *answer.Value
+	case models.QuestionRanking:
+		values := make([]int, 0, len(answer.OptionIDs))
+		for _, optionID := range answer.OptionIDs {
+			values = append(values, question.Options[optionID])
+		}
+		return rankingScore(values)
+	case models.QuestionFreeText:
+		return 0
+	}
+	return float64(question.Options[answer.OptionID])
+}
+
+// rankingScore: вариант на позиции i из n получает балл варианта, умноженный на (n - i).
+// Максимум достигается, когда варианты упорядочены по убыванию баллов.
+func rankingScore(values []int) float64 {
+	var total float64
+	for i, value := range values {
+		total += float64(value * (len(values) - i))
+	}
+	return total
+}
+
+// validateQuestionAnswer проверяет ответ по правилам типа вопроса и возвращает
+// нормализованный ответ (заполнены только поля этого типа), поле с ошибкой и текст ошибки
+func validateQuestionAnswer(question *scoringQuestion, answer models.AnswerSubmission) (models.AnswerSubmission, string, string) {
+	normalized := models.AnswerSubmission{QuestionID: answer.QuestionID}
+
+	switch question.QuestionType {
+	case models.QuestionMultiSelect, models.QuestionRanking:
+		if len(answer.OptionIDs) == 0 {
+			return normalized, "option_ids", fmt.Sprintf("Не выбран ни один вариант в вопросе №%d", question.OrderIndex)
+		}
+		picked := make(map[int]bool, len(answer.OptionIDs))
+		for _, optionID := range answer.OptionIDs {
+			if _, valid := question.Options[optionID]; !valid {
+				return normalized, "option_ids", fmt.Sprintf("Вариант ответа не относится к вопросу №%d", question.OrderIndex)
+			}
+			if picked[optionID] {
+				return normalized, "option_ids", fmt.Sprintf("Вариант ответа указан дважды в вопросе №%d", question.OrderIndex)
+			}
+			picked[optionID] = true
+		}
+		if question.QuestionType == models.QuestionRanking && len(answer.OptionIDs) != len(question.Options) {
+			return normalized, "option_ids", fmt.Sprintf("В вопросе №%d нужно упорядочить все варианты", question.OrderIndex)
+		}
+		normalized.OptionIDs = append([]int(nil), answer.OptionIDs...)
+
+	case models.QuestionLikert:
+		if answer.Value == nil || math.IsNaN(*answer.Value) {
+			return normalized, "value", fmt.Sprintf("Не указано значение в вопросе №%d", question.OrderIndex)
+		}
+		if *answer.Value < float64(question.ScaleMin) || *answer.Value > float64(question.ScaleMax) {
+			return normalized, "value", fmt.Sprintf("Значение в вопросе №%d должно быть от %d до %d",
+				question.OrderIndex, question.ScaleMin, question.ScaleMax)
+		}
+		value := *answer.Value
+		normalized.Value = &value
+
+	case models.QuestionFreeText:
+		text := strings.TrimSpace(answer.Text)
+		if text == "" {
+			return normalized, "text", fmt.Sprintf("Пустой ответ на вопрос №%d", question.OrderIndex)
+		}
+		if utf8.RuneCountInString(text) > maxFreeTextLength {
+			return normalized, "text", fmt.Sprintf("Ответ на вопрос №%d длиннее %d символов", question.OrderIndex, maxFreeTextLength)
+		}
+		normalized.Text = text
+
+	default:
+		if _, valid := question.Options[answer.OptionID]; !valid {
+			return normalized, "option_id", fmt.Sprintf("Вариант ответа не относится к вопросу №%d", question.OrderIndex)
+		}
+		normalized.OptionID = answer.OptionID
+	}
+
+	return normalized, "", ""
+}
+
+// answerRows - строки user_answers для ответа: по строке на выбранный вариант
+// (для ранжирования с позицией), значение шкалы или текст
+func answerRows(resultID int, questionType string, answer models.AnswerSubmission) [][]interface{} {
+	switch {
+	case len(answer.OptionIDs) > 0:
+		rows := make([][]interface{}, 0, len(answer.OptionIDs))
+		for i, optionID := range answer.OptionIDs {
+			var rankPosition interface{}
+			if questionType == models.QuestionRanking {
+				rankPosition = i + 1
+			}
+			rows = append(rows, []interface{}{resultID, answer.QuestionID, optionID, rankPosition, nil, nil})
+		}
+		return rows
+	case answer.Value != nil:
+		return [][]interface{}{{resultID, answer.QuestionID, nil, nil, *answer.Value, nil}}
+	case answer.Text != "":
+		return [][]interface{}{{resultID, answer.QuestionID, nil, nil, nil, answer.Text}}
+	}
+	return [][]interface{}{{resultID, answer.QuestionID, answer.OptionID, nil, nil, nil}}
+}
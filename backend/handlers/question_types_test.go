@@ -0,0 +1,44 @@
+package handlers
+
+import (
+	"psycho-test-system/models"
+	"testing"
+)
+
+func likertValue(value float64) models.AnswerSubmission {
+	return models.AnswerSubmission{QuestionID: 1, Value: &value}
+}
+
+func TestQuestionScore(t *testing.T) {
+	likert := &scoringQuestion{ID: 1, QuestionType: models.QuestionLikert, ScaleMin: 1, ScaleMax: 5}
+
+	tests := []struct {
+		name     string
+		question *scoringQuestion
+		answer   models.AnswerSubmission
+		score    float64
+		maxScore float64
+	}{
+		{"один вариант", choiceQuestion(models.QuestionSingleChoice), models.AnswerSubmission{OptionID: 3}, 3, 3},
+		{"один вариант с нулевым баллом", choiceQuestion(models.QuestionSingleChoice), models.AnswerSubmission{OptionID: 1}, 0, 3},
+		{"несколько вариантов", choiceQuestion(models.QuestionMultiSelect), models.AnswerSubmission{OptionIDs: []int{2, 3}}, 4, 4},
+		{"несколько вариантов без ответа", choiceQuestion(models.QuestionMultiSelect), models.AnswerSubmission{}, 0, 4},
+		{"шкала Лайкерта", likert, likertValue(4), 4, 5},
+		{"шкала Лайкерта без значения", likert, models.AnswerSubmission{}, 0, 5},
+		// Позиция i из n умножает балл варианта на n - i: 3*3 + 1*2 + 0*1
+		{"ранжирование по убыванию", choiceQuestion(models.QuestionRanking), models.AnswerSubmission{OptionIDs: []int{3, 2, 1}}, 11, 11},
+		{"ранжирование по возрастанию", choiceQuestion(models.QuestionRanking), models.AnswerSubmission{OptionIDs: []int{1, 2, 3}}, 5, 11},
+		{"свободный ответ", &scoringQuestion{QuestionType: models.QuestionFreeText}, models.AnswerSubmission{Text: "ответ"}, 0, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if score := questionScore(tt.question, tt.answer); score != tt.score {
+				t.Errorf("балл = %v, ожидалось %v", score, tt.score)
+			}
+			if maxScore := questionMaxScore(tt.question); maxScore != tt.maxScore {
+				t.Errorf("максимум = %v, ожидалось %v", maxScore, tt.maxScore)
+			}
+		})
+	}
+}
@@ -0,0 +1,347 @@
+package handlers
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"psycho-test-system/database"
+	"psycho-test-system/models"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Каталоги со шрифтами DejaVu: Alpine (пакет font-dejavu) и Debian/Ubuntu (fonts-dejavu-core).
+// Другой каталог задаётся переменной окружения REPORT_FONT_DIR.
+var reportFontDirs = []string{"/usr/share/fonts/dejavu", "/usr/share/fonts/truetype/dejavu"}
+
+const (
+	reportFontRegular = "DejaVuSans.ttf"
+	reportFontBold    = "DejaVuSans-Bold.ttf"
+)
+
+// Шрифты отчёта загружаются при первом запросе и переиспользуются
+var reportFonts struct {
+	sync.Mutex
+	regular, bold *trueTypeFont
+}
+
+// Поля страницы отчёта и оформление в пунктах
+const (
+	reportMargin       = 50.0
+	reportMarginTop    = 60.0
+	reportMarginBottom = 60.0
+	reportContentWidth = pdfPageWidth - 2*reportMargin
+	reportScaleColumn  = 170.0
+	reportBarWidth     = 200.0
+	reportBarHeight    = 10.0
+)
+
+// Начертания шрифтов документа отчёта
+const (
+	reportRegular = 0
+	reportBold    = 1
+)
+
+var (
+	reportTextColor  = pdfColor{0.13, 0.13, 0.13}
+	reportMutedColor = pdfColor{0.45, 0.45, 0.45}
+	reportBarColor   = pdfColor{0.20, 0.45, 0.70}
+	reportTrackColor = pdfColor{0.90, 0.90, 0.90}
+	reportAlertColor = pdfColor{0.75, 0.22, 0.17}
+)
+
+func readTrueType(path string) (*trueTypeFont, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return parseTrueType(strings.TrimSuffix(filepath.Base(path), filepath.Ext(path)), data)
+}
+
+// loadReportFonts находит и разбирает обычное и полужирное начертания шрифта отчёта
+func loadReportFonts() (*trueTypeFont, *trueTypeFont, error) {
+	reportFonts.Lock()
+	defer reportFonts.Unlock()
+	if reportFonts.regular != nil {
+		return reportFonts.regular, reportFonts.bold, nil
+	}
+
+	dirs := reportFontDirs
+	if dir := os.Getenv("REPORT_FONT_DIR"); dir != "" {
+		dirs = []string{dir}
+	}
+	for _, dir := range dirs {
+		regular, err := readTrueType(filepath.Join(dir, reportFontRegular))
+		if os.IsNotExist(err) {
+			continue
+		} else if err != nil {
+			return nil, nil, fmt.Errorf("%s: %v", reportFontRegular, err)
+		}
+		bold, err := readTrueType(filepath.Join(dir, reportFontBold))
+		if err != nil {
+			return nil, nil, fmt.Errorf("%s: %v", reportFontBold, err)
+		}
+		reportFonts.regular, reportFonts.bold = regular, bold
+		return regular, bold, nil
+	}
+	return nil, nil, fmt.Errorf("шрифты DejaVu не найдены в %s, укажите каталог в REPORT_FONT_DIR", strings.Join(dirs, ", "))
+}
+
+// resultReport - вёрстка отчёта сверху вниз с переносом на новую страницу
+type resultReport struct {
+	document *pdfDocument
+	y        float64
+}
+
+// ensure начинает новую страницу, если блок высотой height не помещается на текущей
+func (report *resultReport) ensure(height float64) {
+	if report.document.PageCount() == 0 || report.y+height > pdfPageHeight-reportMarginBottom {
+		report.document.AddPage()
+		report.y = reportMarginTop
+	}
+}
+
+// paragraph выводит текст с переносом строк, начиная с отступа indent
+func (report *resultReport) paragraph(font int, size float64, color pdfColor, indent float64, text string) {
+	lineHeight := size * 1.4
+	for _, line := range report.document.WrapText(font, size, text, reportContentWidth-indent) {
+		report.ensure(lineHeight)
+		report.y += lineHeight
+		report.document.Text(-1, reportMargin+indent, report.y, font, size, color, line)
+	}
+}
+
+func (report *resultReport) heading(text string) {
+	report.ensure(40)
+	report.y += 14
+	report.paragraph(reportBold, 13, reportTextColor, 0, text)
+	report.y += 4
+	report.document.Line(reportMargin, report.y, reportMargin+reportContentWidth, report.y, 0.5, reportMutedColor)
+	report.y += 4
+}
+
+// field выводит строку «Название: значение»
+func (report *resultReport) field(label, value string) {
+	if value == "" {
+		return
+	}
+	const labelWidth = 150.0
+	lines := report.document.WrapText(reportRegular, 10.5, value, reportContentWidth-labelWidth)
+	for i, line := range lines {
+		report.ensure(15)
+		report.y += 15
+		if i == 0 {
+			report.document.Text(-1, reportMargin, report.y, reportBold, 10.5, reportTextColor, label)
+		}
+		report.document.Text(-1, reportMargin+labelWidth, report.y, reportRegular, 10.5, reportTextColor, line)
+	}
+}
+
+// scale выводит шкалу: название, полосу процента, баллы, стандартные баллы и интерпретацию
+func (report *resultReport) scale(scale models.ScaleResult) {
+	nameLines := report.document.WrapText(reportBold, 10, scale.ScaleName, reportScaleColumn-10)
+	report.ensure(float64(len(nameLines))*14 + 24)
+	top := report.y
+
+	for i, line := range nameLines {
+		report.document.Text(-1, reportMargin, top+14*float64(i+1), reportBold, 10, reportTextColor, line)
+	}
+
+	percentage := min(max(scale.Percentage, 0), 100)
+	barX, barY := reportMargin+reportScaleColumn, top+5
+	report.document.Rect(barX, barY, reportBarWidth, reportBarHeight, reportTrackColor)
+	if percentage > 0 {
+		report.document.Rect(barX, barY, reportBarWidth*percentage/100, reportBarHeight, reportBarColor)
+	}
+	report.document.Text(-1, barX+reportBarWidth+8, top+14, reportRegular, 9.5, reportTextColor,
+		fmt.Sprintf("%.1f из %.1f (%.1f%%)", scale.Score, scale.MaxScore, scale.Percentage))
+
+	report.y = top + max(14*float64(len(nameLines)), 20)
+	if scale.Sten != nil {
+		norms := fmt.Sprintf("Стен %d", *scale.Sten)
+		if scale.TScore != nil {
+			norms += fmt.Sprintf(", T = %.1f", *scale.TScore)
+		}
+		if scale.Percentile != nil {
+			norms += fmt.Sprintf(", процентиль %.1f", *scale.Percentile)
+		}
+		if scale.NormGroup != "" {
+			norms += " (нормативная группа: " + scale.NormGroup + ")"
+		}
+		report.paragraph(reportRegular, 9, reportMutedColor, reportScaleColumn, norms)
+	}
+	if scale.Interpretation != "" {
+		report.paragraph(reportRegular, 9.5, reportTextColor, reportScaleColumn, scale.Interpretation)
+	}
+	if scale.Recommendation != "" {
+		report.paragraph(reportRegular, 9.5, reportMutedColor, reportScaleColumn, "Рекомендация: "+scale.Recommendation)
+	}
+	report.y += 8
+}
+
+// signatures выводит поля для подписи психолога
+func (report *resultReport) signatures() {
+	report.ensure(110)
+	report.y += 30
+	report.document.Text(-1, reportMargin, report.y, reportBold, 10.5, reportTextColor, "Психолог")
+
+	lineY := report.y + 2
+	nameX, signX := reportMargin+80, reportMargin+320
+	report.document.Line(nameX, lineY, nameX+200, lineY, 0.5, reportTextColor)
+	report.document.Line(signX, lineY, signX+reportContentWidth-320, lineY, 0.5, reportTextColor)
+	report.document.Text(-1, nameX, lineY+11, reportRegular, 8, reportMutedColor, "(фамилия, инициалы)")
+	report.document.Text(-1, signX, lineY+11, reportRegular, 8, reportMutedColor, "(подпись)")
+
+	report.y = lineY + 40
+	report.document.Text(-1, reportMargin, report.y, reportRegular, 10.5, reportTextColor,
+		"Дата «____» ________________ 20____ г.")
+	report.y += 10
+}
+
+// reportDuration - длительность прохождения в минутах и секундах
+func reportDuration(seconds int) string {
+	if seconds <= 0 {
+		return ""
+	}
+	if seconds < 60 {
+		return fmt.Sprintf("%d с", seconds)
+	}
+	return fmt.Sprintf("%d мин %d с", seconds/60, seconds%60)
+}
+
+// buildResultReport верстает заключение по результату теста
+func buildResultReport(detailed *models.DetailedTestResult, fullName, birthDate string, versionNumber int,
+	regular, bold *trueTypeFont) ([]byte, error) {
+	result := &detailed.OverallResult
+	report := &resultReport{document: newPDFDocument(regular, bold)}
+	report.ensure(0)
+
+	report.paragraph(reportBold, 16, reportTextColor, 0, "Заключение по результатам психологического тестирования")
+	report.y += 6
+
+	report.heading("Сведения о тестировании")
+	report.field("ФИО:", fullName)
+	report.field("Дата рождения:", birthDate)
+	test := detailed.TestTitle
+	if versionNumber > 0 {
+		test += fmt.Sprintf(" (версия %d)", versionNumber)
+	}
+	report.field("Тест:", test)
+	if methodology := getMethodologyLabel(detailed.Methodology); methodology != "" && methodology != detailed.TestTitle {
+		report.field("Методика:", methodology)
+	}
+	if !result.CompletedAt.IsZero() {
+		report.field("Дата прохождения:", result.CompletedAt.Format("02.01.2006 15:04"))
+	}
+	report.field("Попытка:", strconv.Itoa(result.AttemptNumber))
+	report.field("Длительность:", reportDuration(result.DurationSeconds))
+
+	report.heading("Общий результат")
+	report.field("Итоговый балл:", fmt.Sprintf("%.1f из %.1f (%.1f%%)", result.TotalScore, result.MaxPossibleScore, result.Percentage))
+	switch {
+	case !result.IsValid:
+		report.field("Вывод:", "Результат недостоверен")
+		for _, flag := range result.ValidityFlags {
+			report.paragraph(reportRegular, 9.5, reportAlertColor, 150, "• "+flag)
+		}
+	case result.IsPassed:
+		report.field("Вывод:", "Пригоден")
+	default:
+		report.field("Вывод:", "Не пригоден")
+	}
+	if result.IsLate {
+		report.paragraph(reportRegular, 9.5, reportAlertColor, 150, "Ответы отправлены после истечения лимита времени")
+	}
+
+	if len(detailed.ScaleResults) > 0 {
+		report.heading("Результаты по шкалам")
+		report.y += 4
+		for _, scale := range detailed.ScaleResults {
+			report.scale(scale)
+		}
+	}
+
+	report.heading("Интерпретация")
+	report.paragraph(reportRegular, 10.5, reportTextColor, 0, result.Interpretation)
+	if result.Recommendation != "" {
+		report.heading("Рекомендации")
+		report.paragraph(reportRegular, 10.5, reportTextColor, 0, result.Recommendation)
+	}
+
+	report.signatures()
+
+	generated := time.Now().Format("02.01.2006 15:04")
+	pages := report.document.PageCount()
+	for page := 0; page < pages; page++ {
+		footerY := pdfPageHeight - 30
+		report.document.Text(page, reportMargin, footerY, reportRegular, 8, reportMutedColor,
+			fmt.Sprintf("Результат №%d, сформировано %s", result.ID, generated))
+		pageLabel := fmt.Sprintf("Стр. %d из %d", page+1, pages)
+		report.document.Text(page, reportMargin+reportContentWidth-report.document.TextWidth(reportRegular, 8, pageLabel),
+			footerY, reportRegular, 8, reportMutedColor, pageLabel)
+	}
+
+	return report.document.Bytes(fmt.Sprintf("%s - %s", detailed.TestTitle, fullName))
+}
+
+// Заключение по результату теста в PDF для личного дела
+func GetResultReport(c *gin.Context) {
+	resultID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Неверный ID результата"})
+		return
+	}
+
+	detailed, err := loadDetailedResult(resultID)
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Результат не найден"})
+		return
+	} else if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка получения результата"})
+		return
+	}
+
+	var lastName, firstName, patronymic, birthDate string
+	err = database.DB.QueryRow(`
+		SELECT last_name, first_name, COALESCE(patronymic, ''), COALESCE(TO_CHAR(birth_date, 'DD.MM.YYYY'), '')
+		FROM users WHERE id = $1
+	`, detailed.OverallResult.UserID).Scan(&lastName, &firstName, &patronymic, &birthDate)
+	if err != nil && err != sql.ErrNoRows {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка получения пользователя"})
+		return
+	}
+	fullName := strings.Join(strings.Fields(lastName+" "+firstName+" "+patronymic), " ")
+	if fullName == "" {
+		fullName = "[Удаленный пользователь]"
+	}
+
+	var versionNumber int
+	if detailed.OverallResult.VersionID != 0 {
+		database.DB.QueryRow("SELECT version_number FROM test_versions WHERE id = $1",
+			detailed.OverallResult.VersionID).Scan(&versionNumber)
+	}
+
+	regular, bold, err := loadReportFonts()
+	if err != nil {
+		log.Printf("Ошибка загрузки шрифтов отчета: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка загрузки шрифтов отчета"})
+		return
+	}
+
+	data, err := buildResultReport(detailed, fullName, birthDate, versionNumber, regular, bold)
+	if err != nil {
+		log.Printf("Ошибка формирования отчета по результату %d: %v", resultID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка формирования отчета"})
+		return
+	}
+
+	c.Header("Content-Disposition", fmt.Sprintf(`inline; filename="result-%d.pdf"`, resultID))
+	c.Data(http.StatusOK, "application/pdf", data)
+}
@@ -0,0 +1,233 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"psycho-test-system/database"
+	"psycho-test-system/models"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Максимум строк в одном INSERT, чтобы не упереться в лимит параметров PostgreSQL
+const insertBatchSize = 500
+
+// insertBatch вставляет строки пачками по insertBatchSize одним INSERT ... VALUES (...), (...)
+func insertBatch(tx *sql.Tx, table string, columns []string, rows [][]interface{}) error {
+	return upsertBatch(tx, table, columns, rows, "")
+}
+
+// upsertBatch - то же, что insertBatch, с дополнительным ON CONFLICT (onConflict) в конце запроса
+func upsertBatch(tx *sql.Tx, table string, columns []string, rows [][]interface{}, onConflict string) error {
+	for start := 0; start < len(rows); start += insertBatchSize {
+		end := start + insertBatchSize
+		if end > len(rows) {
+			end = len(rows)
+		}
+
+		placeholders := make([]string, 0, end-start)
+		args := make([]interface{}, 0, (end-start)*len(columns))
+		for _, row := range rows[start:end] {
+			marks := make([]string, len(row))
+			for i, value := range row {
+				args = append(args, value)
+				marks[i] = fmt.Sprintf("$%d", len(args))
+			}
+			placeholders = append(placeholders, "("+strings.Join(marks, ", ")+")")
+		}
+
+		query := fmt.Sprintf("INSERT INTO %s (%s) VALUES %s %s",
+			table, strings.Join(columns, ", "), strings.Join(placeholders, ", "), onConflict)
+		if _, err := tx.Exec(query, args...); err != nil {
+			return fmt.Errorf("ошибка вставки в %s: %v", table, err)
+		}
+	}
+	return nil
+}
+
+// errSessionClosed - сессия уже завершена другим запросом (повторная отправка теста)
+var errSessionClosed = errors.New("сессия теста уже закрыта")
+
+// saveTestResult сохраняет результат теста, ответы пользователя и разбивку по шкалам
+// в одной транзакции. При любой ошибке ничего не сохраняется, поэтому неполный
+// результат не может появиться в списке результатов.
+func saveTestResult(result models.TestResult, scales []models.ScaleResult, questions []*scoringQuestion,
+	selected map[int]models.AnswerSubmission) (int, int, error) {
+	scaleResultsJSON, err := json.Marshal(scales)
+	if err != nil {
+		return 0, 0, fmt.Errorf("ошибка сериализации результатов по шкалам: %v", err)
+	}
+
+	var validityFlagsJSON interface{}
+	if len(result.ValidityFlags) > 0 {
+		flags, err := json.Marshal(result.ValidityFlags)
+		if err != nil {
+			return 0, 0, fmt.Errorf("ошибка сериализации признаков недостоверности: %v", err)
+		}
+		validityFlagsJSON = string(flags)
+	}
+
+	tx, err := database.DB.Begin()
+	if err != nil {
+		return 0, 0, fmt.Errorf("ошибка начала транзакции: %v", err)
+	}
+	defer tx.Rollback()
+
+	var sessionID interface{}
+	if result.SessionID != 0 {
+		// Закрываем сессию первой: если её уже закрыл параллельный запрос, результат не дублируется
+		closed, err := tx.Exec(`
+			UPDATE test_sessions SET status = $1, completed_at = NOW()
+			WHERE id = $2 AND status = $3
+		`, models.SessionCompleted, result.SessionID, models.SessionActive)
+		if err != nil {
+			return 0, 0, fmt.Errorf("ошибка закрытия сессии: %v", err)
+		}
+		if affected, _ := closed.RowsAffected(); affected == 0 {
+			return 0, 0, errSessionClosed
+		}
+		sessionID = result.SessionID
+	}
+
+	// Номер попытки считается по уже сохранённым результатам, поэтому параллельные отправки
+	// одного теста пользователем выполняются по очереди до конца транзакции
+	if _, err := tx.Exec("SELECT pg_advisory_xact_lock($1, $2)", result.UserID, result.TestID); err != nil {
+		return 0, 0, fmt.Errorf("ошибка блокировки попыток пользователя: %v", err)
+	}
+
+	var resultID, attemptNumber int
+	err = tx.QueryRow(`
+		INSERT INTO test_results (user_id, test_id, total_score, max_possible_score, percentage, is_passed,
+		                          interpretation, recommendation, scale_results, session_id, duration_seconds, is_late,
+		                          attempt_number, started_at, completed_at, is_valid, validity_flags, version_id)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12,
+		        (SELECT COUNT(*) + 1 FROM test_results WHERE user_id = $1 AND test_id = $2),
+		        COALESCE((SELECT started_at FROM test_sessions WHERE id = $10), NOW()), NOW(), $13, $14, NULLIF($15, 0)) RETURNING id, attempt_number
+	`, result.UserID, result.TestID, result.TotalScore, result.MaxPossibleScore, result.Percentage, result.IsPassed,
+		result.Interpretation, result.Recommendation, string(scaleResultsJSON), sessionID, result.DurationSeconds, result.IsLate,
+		result.IsValid, validityFlagsJSON, result.VersionID).Scan(&resultID, &attemptNumber)
+	if err != nil {
+		return 0, 0, fmt.Errorf("ошибка сохранения результата: %v", err)
+	}
+
+	questionTypes := make(map[int]string, len(questions))
+	for _, question := range questions {
+		questionTypes[question.ID] = question.QuestionType
+	}
+
+	questionIDs := make([]int, 0, len(selected))
+	for questionID := range selected {
+		questionIDs = append(questionIDs, questionID)
+	}
+	sort.Ints(questionIDs)
+
+	rows := make([][]interface{}, 0, len(questionIDs))
+	for _, questionID := range questionIDs {
+		rows = append(rows, answerRows(resultID, questionTypes[questionID], selected[questionID])...)
+	}
+	if err := insertBatch(tx, "user_answers",
+		[]string{"result_id", "question_id", "option_id", "rank_position", "answer_value", "answer_text"}, rows); err != nil {
+		return 0, 0, err
+	}
+
+	scaleRows := make([][]interface{}, 0, len(scales))
+	for _, scale := range scales {
+		scaleRows = append(scaleRows, []interface{}{resultID, scale.ScaleName, scale.Score, scale.MaxScore, scale.Percentage,
+			scale.Sten, scale.TScore, scale.Percentile})
+	}
+	if err := insertBatch(tx, "test_result_scales",
+		[]string{"result_id", "scale_type", "score", "max_score", "percentage", "sten", "t_score", "percentile"}, scaleRows); err != nil {
+		return 0, 0, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, 0, fmt.Errorf("ошибка завершения транзакции: %v", err)
+	}
+	return resultID, attemptNumber, nil
+}
+
+// loadDetailedResult загружает сохранённый результат вместе с разбивкой по шкалам
+func loadDetailedResult(resultID int) (*models.DetailedTestResult, error) {
+	var detailed models.DetailedTestResult
+	var userID, testID sql.NullInt64
+	var recommendation, scaleResults, validityFlags sql.NullString
+	var startedAt, completedAt sql.NullTime
+
+	result := &detailed.OverallResult
+	err := database.DB.QueryRow(`
+		SELECT tr.id, tr.user_id, tr.test_id, tr.total_score, tr.max_possible_score, tr.percentage, tr.is_passed,
+		       tr.interpretation, tr.recommendation, tr.scale_results, tr.started_at, tr.completed_at,
+		       COALESCE(tr.session_id, 0), COALESCE(tr.duration_seconds, 0), COALESCE(tr.is_late, false),
+		       COALESCE(tr.attempt_number, 1), COALESCE(tr.is_valid, true), tr.validity_flags, COALESCE(tr.version_id, 0),
+		       COALESCE(pt.title, '[Удаленный тест]'), COALESCE(pt.methodology_type, 'Неизвестно')
+		FROM test_results tr
+		LEFT JOIN psychological_tests pt ON tr.test_id = pt.id
+		WHERE tr.id = $1
+	`, resultID).Scan(&result.ID, &userID, &testID, &result.TotalScore, &result.MaxPossibleScore, &result.Percentage,
+		&result.IsPassed, &result.Interpretation, &recommendation, &scaleResults, &startedAt, &completedAt,
+		&result.SessionID, &result.DurationSeconds, &result.IsLate, &result.AttemptNumber, &result.IsValid, &validityFlags, &result.VersionID,
+		&detailed.TestTitle, &detailed.Methodology)
+	if err != nil {
+		return nil, err
+	}
+
+	result.UserID = int(userID.Int64)
+	result.TestID = int(testID.Int64)
+	result.Recommendation = recommendation.String
+	result.StartedAt = startedAt.Time
+	result.CompletedAt = completedAt.Time
+
+	if validityFlags.Valid && validityFlags.String != "" {
+		if err := json.Unmarshal([]byte(validityFlags.String), &result.ValidityFlags); err != nil {
+			log.Printf("Ошибка разбора validity_flags результата %d: %v", resultID, err)
+		}
+	}
+
+	detailed.ScaleResults = []models.ScaleResult{}
+	if scaleResults.Valid && scaleResults.String != "" {
+		if err := json.Unmarshal([]byte(scaleResults.String), &detailed.ScaleResults); err != nil {
+			log.Printf("Ошибка разбора scale_results результата %d: %v", resultID, err)
+		}
+	}
+
+	return &detailed, nil
+}
+
+// Получение сохранённого результата. Пользователь видит только свои результаты, администратор - любые
+func GetUserResult(c *gin.Context) {
+	resultID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Неверный ID результата"})
+		return
+	}
+
+	userID, exists := c.Get("userID")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Пользователь не авторизован"})
+		return
+	}
+	role, _ := c.Get("userRole")
+
+	detailed, err := loadDetailedResult(resultID)
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Результат не найден"})
+		return
+	} else if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка получения результата"})
+		return
+	}
+
+	if role != models.RoleAdmin && detailed.OverallResult.UserID != userID.(int) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Результат не найден"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"result": detailed})
+}
@@ -0,0 +1,452 @@
+package handlers
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"psycho-test-system/database"
+	"psycho-test-system/models"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Формат дат в параметрах фильтра выгрузки
+const exportDateLayout = "2006-01-02"
+
+// exportResultsSQL отбирает результаты для выгрузки. Официальная попытка определяется
+// по всем результатам пользователя, поэтому фильтры применяются к уже размеченным строкам.
+// Параметры: $1 тест, $2 пользователь, $3 и $4 границы даты завершения, $5 итог (см. resultStatusSQL),
+// $6 только официальные попытки.
+var exportResultsSQL = `
+	SELECT * FROM (
+		SELECT tr.id, tr.user_id, tr.test_id, tr.completed_at, tr.is_passed, COALESCE(tr.is_valid, true) AS is_valid,
+		       CASE WHEN COALESCE(pt.official_attempt, 'latest') = 'first'
+		            THEN tr.completed_at = MIN(tr.completed_at) OVER (PARTITION BY tr.user_id, tr.test_id)
+		            ELSE tr.completed_at = MAX(tr.completed_at) OVER (PARTITION BY tr.user_id, tr.test_id)
+		       END AS is_official
+		FROM test_results tr
+		LEFT JOIN psychological_tests pt ON pt.id = tr.test_id
+	) results
+	WHERE ($1 = 0 OR test_id = $1) AND ($2 = 0 OR user_id = $2)
+	  AND ($3::timestamp IS NULL OR completed_at >= $3) AND ($4::timestamp IS NULL OR completed_at < $4)
+	  AND ` + resultStatusSQL("$5") + ` AND (NOT $6 OR is_official)`
+
+// resultStatusSQL - условие фильтра итога для запросов со столбцами is_valid и is_passed.
+// Параметр param - "", passed, failed или invalid: недостоверный результат не считается
+// ни пройденным, ни непройденным.
+func resultStatusSQL(param string) string {
+	return fmt.Sprintf(`(%[1]s = '' OR (%[1]s = 'invalid' AND NOT is_valid) OR (is_valid AND is_passed = (%[1]s = 'passed')))`, param)
+}
+
+// parseResultStatus читает фильтр итога status; при ошибке возвращает её описание
+func parseResultStatus(c *gin.Context) (string, string) {
+	switch status := c.Query("status"); status {
+	case "", "passed", "failed", "invalid":
+		return status, ""
+	default:
+		return "", "Фильтр status принимает значения passed, failed или invalid"
+	}
+}
+
+// resultsExportFilter - фильтры выгрузки результатов
+type resultsExportFilter struct {
+	TestID       int
+	UserID       int
+	From         interface{} // nil или начало первого дня периода
+	To           interface{} // nil или начало дня, следующего за последним днём периода
+	Status       string      // "", passed, failed или invalid
+	OfficialOnly bool
+}
+
+func (filter *resultsExportFilter) args() []interface{} {
+	return []interface{}{filter.TestID, filter.UserID, filter.From, filter.To, filter.Status, filter.OfficialOnly}
+}
+
+// parseResultsExportFilter читает фильтры из параметров запроса; при ошибке возвращает её описание
+func parseResultsExportFilter(c *gin.Context) (*resultsExportFilter, string) {
+	filter := &resultsExportFilter{OfficialOnly: c.Query("official_only") == "true"}
+
+	for _, param := range []struct {
+		name    string
+		target  *int
+		message string
+	}{
+		{"test_id", &filter.TestID, "Неверный ID теста"},
+		{"user_id", &filter.UserID, "Неверный ID пользователя"},
+	} {
+		if value := c.Query(param.name); value != "" {
+			parsed, err := strconv.Atoi(value)
+			if err != nil || parsed < 1 {
+				return nil, param.message
+			}
+			*param.target = parsed
+		}
+	}
+
+	var message string
+	if filter.From, filter.To, message = parseDateRange(c); message != "" {
+		return nil, message
+	}
+
+	if filter.Status, message = parseResultStatus(c); message != "" {
+		return nil, message
+	}
+	return filter, ""
+}
+
+// exportedResult - строка выгрузки до разворачивания шкал и ответов в столбцы
+type exportedResult struct {
+	values    []interface{}
+	versionID int
+	scales    map[string]models.ScaleResult
+	answers   map[int][]string // номер вопроса -> коды ответа
+	numeric   map[int]bool     // ответ - одно число (код варианта или значение шкалы)
+}
+
+// Основные столбцы выгрузки. Имена латиницей, чтобы их без переименования принимали SPSS и R.
+var exportResultColumns = []string{
+	"result_id", "user_id", "last_name", "first_name", "patronymic", "email",
+	"test_id", "test_title", "version", "attempt", "is_official", "started_at", "completed_at",
+	"duration_seconds", "total_score", "max_score", "percentage", "is_passed", "is_valid",
+	"validity_flags", "interpretation",
+}
+
+// loadExportedResults загружает результаты по фильтру в порядке завершения
+func loadExportedResults(filter *resultsExportFilter) ([]*exportedResult, map[int]*exportedResult, error) {
+	rows, err := database.DB.Query(`
+		WITH exported AS (`+exportResultsSQL+`)
+		SELECT tr.id, COALESCE(tr.user_id, 0), COALESCE(u.last_name, ''), COALESCE(u.first_name, ''),
+		       COALESCE(u.patronymic, ''), COALESCE(u.email, ''), COALESCE(tr.test_id, 0),
+		       COALESCE(pt.title, '[Удаленный тест]'), COALESCE(tv.version_number, 0), COALESCE(tr.attempt_number, 1),
+		       exported.is_official,
+		       COALESCE(TO_CHAR(tr.started_at AT TIME ZONE 'Europe/Moscow', 'YYYY-MM-DD HH24:MI:SS'), ''),
+		       TO_CHAR(tr.completed_at AT TIME ZONE 'Europe/Moscow', 'YYYY-MM-DD HH24:MI:SS'),
+		       COALESCE(tr.duration_seconds, 0), tr.total_score, tr.max_possible_score, tr.percentage, tr.is_passed,
+		       COALESCE(tr.is_valid, true), COALESCE(tr.validity_flags::text, ''), tr.interpretation,
+		       COALESCE(tr.version_id, 0)
+		FROM exported
+		JOIN test_results tr ON tr.id = exported.id
+		LEFT JOIN users u ON u.id = tr.user_id
+		LEFT JOIN psychological_tests pt ON pt.id = tr.test_id
+		LEFT JOIN test_versions tv ON tv.id = tr.version_id
+		ORDER BY tr.completed_at, tr.id
+	`, filter.args()...)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+
+	var results []*exportedResult
+	byID := make(map[int]*exportedResult)
+	for rows.Next() {
+		var id, userID, testID, versionNumber, attempt, duration, versionID int
+		var lastName, firstName, patronymic, email, title, startedAt, completedAt, flagsJSON, interpretation string
+		var isOfficial, isPassed, isValid bool
+		var totalScore, maxScore, percentage float64
+		if err := rows.Scan(&id, &userID, &lastName, &firstName, &patronymic, &email, &testID, &title,
+			&versionNumber, &attempt, &isOfficial, &startedAt, &completedAt, &duration, &totalScore, &maxScore,
+			&percentage, &isPassed, &isValid, &flagsJSON, &interpretation, &versionID); err != nil {
+			return nil, nil, err
+		}
+
+		var flags []string
+		if flagsJSON != "" {
+			// Повреждённые причины не мешают выгрузке: строка выгружается без них
+			if err := json.Unmarshal([]byte(flagsJSON), &flags); err != nil {
+				log.Printf("Ошибка разбора validity_flags результата %d: %v", id, err)
+			}
+		}
+
+		result := &exportedResult{
+			values: []interface{}{
+				id, userID, lastName, firstName, patronymic, email,
+				testID, title, versionNumber, attempt, isOfficial, exportTime(startedAt), exportTime(completedAt),
+				duration, totalScore, maxScore, percentage, isPassed, isValid,
+				strings.Join(flags, "; "), interpretation,
+			},
+			versionID: versionID,
+			scales:    make(map[string]models.ScaleResult),
+			answers:   make(map[int][]string),
+			numeric:   make(map[int]bool),
+		}
+		results = append(results, result)
+		byID[id] = result
+	}
+	return results, byID, rows.Err()
+}
+
+// exportTime разбирает дату из запроса выгрузки; пустая строка - значение отсутствует
+func exportTime(value string) interface{} {
+	parsed, err := time.Parse(exportTimeLayout, value)
+	if err != nil {
+		return nil
+	}
+	return parsed
+}
+
+// loadExportedScales добавляет к результатам разбивку по шкалам и возвращает шкалы
+// в порядке первого появления; hasNorms - для шкалы есть стандартные баллы
+func loadExportedScales(filter *resultsExportFilter, byID map[int]*exportedResult) ([]string, map[string]bool, error) {
+	rows, err := database.DB.Query(`
+		WITH exported AS (`+exportResultsSQL+`)
+		SELECT trs.result_id, trs.scale_type, trs.score, trs.max_score, trs.percentage, trs.sten, trs.t_score, trs.percentile
+		FROM test_result_scales trs
+		JOIN exported ON exported.id = trs.result_id
+		ORDER BY trs.result_id, trs.id
+	`, filter.args()...)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+
+	var scales []string
+	hasNorms := make(map[string]bool)
+	seen := make(map[string]bool)
+	for rows.Next() {
+		var resultID int
+		var scale models.ScaleResult
+		var sten sql.NullInt64
+		var tScore, percentile sql.NullFloat64
+		if err := rows.Scan(&resultID, &scale.ScaleName, &scale.Score, &scale.MaxScore, &scale.Percentage,
+			&sten, &tScore, &percentile); err != nil {
+			return nil, nil, err
+		}
+		if sten.Valid {
+			value := int(sten.Int64)
+			scale.Sten = &value
+		}
+		if tScore.Valid {
+			scale.TScore = &tScore.Float64
+		}
+		if percentile.Valid {
+			scale.Percentile = &percentile.Float64
+		}
+
+		if !seen[scale.ScaleName] {
+			seen[scale.ScaleName] = true
+			scales = append(scales, scale.ScaleName)
+		}
+		if scale.Sten != nil || scale.TScore != nil || scale.Percentile != nil {
+			hasNorms[scale.ScaleName] = true
+		}
+		if result := byID[resultID]; result != nil {
+			result.scales[scale.ScaleName] = scale
+		}
+	}
+	return scales, hasNorms, rows.Err()
+}
+
+// loadExportedAnswers добавляет к результатам ответы и возвращает наибольший номер вопроса.
+// Вариант кодируется своим порядковым номером в вопросе, шкала Лайкерта - выбранным значением,
+// свободный ответ выгружается текстом. Несколько вариантов разделяются «;», для ранжирования -
+// в порядке мест.
+func loadExportedAnswers(filter *resultsExportFilter, byID map[int]*exportedResult) (int, error) {
+	rows, err := database.DB.Query(`
+		WITH exported AS (`+exportResultsSQL+`)
+		SELECT ua.result_id, q.order_index, COALESCE(o.order_index, 0), ua.answer_value, COALESCE(ua.answer_text, '')
+		FROM user_answers ua
+		JOIN exported ON exported.id = ua.result_id
+		JOIN test_questions q ON q.id = ua.question_id
+		LEFT JOIN question_options o ON o.id = ua.option_id
+		ORDER BY ua.result_id, q.order_index, ua.rank_position NULLS LAST, o.order_index
+	`, filter.args()...)
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+
+	maxQuestion := 0
+	for rows.Next() {
+		var resultID, questionNumber, optionNumber int
+		var value sql.NullFloat64
+		var text string
+		if err := rows.Scan(&resultID, &questionNumber, &optionNumber, &value, &text); err != nil {
+			return 0, err
+		}
+		result := byID[resultID]
+		if result == nil {
+			continue
+		}
+
+		code, numeric := text, false
+		switch {
+		case optionNumber > 0:
+			code, numeric = strconv.Itoa(optionNumber), true
+		case value.Valid:
+			code, numeric = strconv.FormatFloat(value.Float64, 'f', -1, 64), true
+		}
+		if _, exists := result.answers[questionNumber]; !exists {
+			result.numeric[questionNumber] = numeric
+		} else {
+			result.numeric[questionNumber] = false
+		}
+		result.answers[questionNumber] = append(result.answers[questionNumber], code)
+		maxQuestion = max(maxQuestion, questionNumber)
+	}
+	return maxQuestion, rows.Err()
+}
+
+// buildResultsTable разворачивает результаты в таблицу: основные столбцы, затем по столбцу
+// на каждую характеристику шкалы и по столбцу на вопрос (q1, q2, ...)
+func buildResultsTable(results []*exportedResult, scales []string, hasNorms map[string]bool, maxQuestion int) *exportTable {
+	table := &exportTable{Name: "Результаты", Header: append([]string{}, exportResultColumns...)}
+	for _, scale := range scales {
+		table.Header = append(table.Header, scale+"_score", scale+"_pct")
+		if hasNorms[scale] {
+			table.Header = append(table.Header, scale+"_sten", scale+"_t", scale+"_percentile")
+		}
+	}
+	for number := 1; number <= maxQuestion; number++ {
+		table.Header = append(table.Header, fmt.Sprintf("q%d", number))
+	}
+
+	for _, result := range results {
+		row := append([]interface{}{}, result.values...)
+		for _, scale := range scales {
+			value, exists := result.scales[scale]
+			if !exists {
+				row = append(row, nil, nil)
+				if hasNorms[scale] {
+					row = append(row, nil, nil, nil)
+				}
+				continue
+			}
+			row = append(row, value.Score, value.Percentage)
+			if hasNorms[scale] {
+				var sten, tScore, percentile interface{}
+				if value.Sten != nil {
+					sten = *value.Sten
+				}
+				if value.TScore != nil {
+					tScore = *value.TScore
+				}
+				if value.Percentile != nil {
+					percentile = *value.Percentile
+				}
+				row = append(row, sten, tScore, percentile)
+			}
+		}
+		for number := 1; number <= maxQuestion; number++ {
+			codes, answered := result.answers[number]
+			switch {
+			case !answered:
+				row = append(row, nil)
+			case result.numeric[number]:
+				value, _ := strconv.ParseFloat(codes[0], 64)
+				row = append(row, value)
+			default:
+				row = append(row, strings.Join(codes, ";"))
+			}
+		}
+		table.Rows = append(table.Rows, row)
+	}
+	return table
+}
+
+// buildCodebookTable описывает столбцы вопросов: формулировку и значения кодов
+// для каждой версии теста, попавшей в выгрузку
+func buildCodebookTable(results []*exportedResult) (*exportTable, error) {
+	table := &exportTable{
+		Name:   "Кодировка",
+		Header: []string{"test_id", "test_title", "version", "question", "question_text", "question_type", "scale", "code", "label", "score"},
+	}
+
+	seen := make(map[int]bool)
+	for _, result := range results {
+		if result.versionID == 0 || seen[result.versionID] {
+			continue
+		}
+		seen[result.versionID] = true
+
+		questions, err := loadVersionQuestions(result.versionID)
+		if err != nil {
+			return nil, err
+		}
+		// test_id, test_title и version совпадают с основными столбцами результата
+		prefix := []interface{}{result.values[6], result.values[7], result.values[8]}
+		for i, question := range questions {
+			base := append(append([]interface{}{}, prefix...), fmt.Sprintf("q%d", i+1), question.QuestionText,
+				question.QuestionType, question.ScaleType)
+			switch {
+			case len(question.Options) > 0:
+				for j, option := range question.Options {
+					table.Rows = append(table.Rows, append(append([]interface{}{}, base...), j+1, option.OptionText, option.ScoreValue))
+				}
+			case question.QuestionType == models.QuestionLikert:
+				table.Rows = append(table.Rows, append(base, nil,
+					fmt.Sprintf("значение от %d до %d", question.MinValue, question.MaxValue), nil))
+			default:
+				table.Rows = append(table.Rows, append(base, nil, "текст ответа", nil))
+			}
+		}
+	}
+	return table, nil
+}
+
+// Выгрузка результатов в CSV или XLSX с фильтрами по тесту, пользователю, периоду и итогу.
+// Параметры: format=csv|xlsx, test_id, user_id, from и to (ГГГГ-ММ-ДД, включительно),
+// status=passed|failed|invalid, official_only=true. В XLSX кодировка ответов выводится отдельным листом,
+// в CSV её можно получить параметром codebook=true.
+func ExportResults(c *gin.Context) {
+	format := c.DefaultQuery("format", "csv")
+	if format != "csv" && format != "xlsx" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Неизвестный формат выгрузки: используйте csv или xlsx"})
+		return
+	}
+	filter, message := parseResultsExportFilter(c)
+	if message != "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": message})
+		return
+	}
+
+	results, byID, err := loadExportedResults(filter)
+	if err != nil {
+		log.Printf("Ошибка выгрузки результатов: получение результатов: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка получения результатов"})
+		return
+	}
+	scales, hasNorms, err := loadExportedScales(filter, byID)
+	if err != nil {
+		log.Printf("Ошибка выгрузки результатов: получение результатов по шкалам: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка получения результатов по шкалам"})
+		return
+	}
+	maxQuestion, err := loadExportedAnswers(filter, byID)
+	if err != nil {
+		log.Printf("Ошибка выгрузки результатов: получение ответов: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка получения ответов"})
+		return
+	}
+	codebook, err := buildCodebookTable(results)
+	if err != nil {
+		log.Printf("Ошибка выгрузки результатов: получение вопросов: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка получения вопросов"})
+		return
+	}
+
+	name := "results-" + time.Now().Format("20060102")
+	var buffer bytes.Buffer
+	contentType := "text/csv; charset=utf-8"
+	if format == "xlsx" {
+		contentType = "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet"
+		err = writeXLSX(&buffer, []*exportTable{buildResultsTable(results, scales, hasNorms, maxQuestion), codebook})
+	} else if c.Query("codebook") == "true" {
+		name += "-codebook"
+		err = writeCSVTable(&buffer, codebook)
+	} else {
+		err = writeCSVTable(&buffer, buildResultsTable(results, scales, hasNorms, maxQuestion))
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка формирования файла выгрузки"})
+		return
+	}
+
+	c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="%s.%s"`, name, format))
+	c.Data(http.StatusOK, contentType, buffer.Bytes())
+}
@@ -0,0 +1,105 @@
+package handlers
+
+import (
+	"database/sql"
+	"net/http"
+	"psycho-test-system/database"
+	"psycho-test-system/models"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Получение свободных ответов для проверки. Параметры: test_id - фильтр по тесту,
+// status=pending|reviewed - только непроверенные или только проверенные
+func GetFreeTextAnswers(c *gin.Context) {
+	testID := 0
+	if testIDParam := c.Query("test_id"); testIDParam != "" {
+		var err error
+		if testID, err = strconv.Atoi(testIDParam); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Неверный ID теста"})
+			return
+		}
+	}
+
+	status := c.Query("status")
+	if status != "" && status != "pending" && status != "reviewed" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Неверный статус проверки"})
+		return
+	}
+
+	rows, err := database.DB.Query(`
+		SELECT ua.id, ua.result_id, COALESCE(tr.test_id, 0), COALESCE(pt.title, '[Удаленный тест]'),
+		       COALESCE(ua.question_id, 0), COALESCE(q.question_text, '[Удаленный вопрос]'),
+		       u.last_name || ' ' || u.first_name || ' ' || COALESCE(u.patronymic, ''), u.email,
+		       ua.answer_text, ua.answered_at, COALESCE(ua.review_comment, ''), ua.reviewed_at
+		FROM user_answers ua
+		JOIN test_results tr ON tr.id = ua.result_id
+		JOIN users u ON u.id = tr.user_id
+		LEFT JOIN psychological_tests pt ON pt.id = tr.test_id
+		LEFT JOIN test_questions q ON q.id = ua.question_id
+		WHERE ua.answer_text IS NOT NULL
+		  AND ($1 = 0 OR tr.test_id = $1)
+		  AND ($2 = '' OR ($2 = 'pending') = (ua.reviewed_at IS NULL))
+		ORDER BY ua.answered_at DESC
+	`, testID, status)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка получения ответов"})
+		return
+	}
+	defer rows.Close()
+
+	answers := []models.FreeTextAnswer{}
+	for rows.Next() {
+		var answer models.FreeTextAnswer
+		var reviewedAt sql.NullTime
+		if err := rows.Scan(&answer.ID, &answer.ResultID, &answer.TestID, &answer.TestTitle, &answer.QuestionID,
+			&answer.QuestionText, &answer.UserName, &answer.UserEmail, &answer.AnswerText, &answer.AnsweredAt,
+			&answer.ReviewComment, &reviewedAt); err != nil {
+			continue
+		}
+		answer.UserName = strings.TrimSpace(answer.UserName)
+		if reviewedAt.Valid {
+			answer.ReviewedAt = &reviewedAt.Time
+		}
+		answers = append(answers, answer)
+	}
+
+	c.JSON(http.StatusOK, gin.H{"answers": answers})
+}
+
+// Отметка свободного ответа как проверенного с комментарием администратора
+func ReviewFreeTextAnswer(c *gin.Context) {
+	answerID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Неверный ID ответа"})
+		return
+	}
+
+	var reviewReq struct {
+		ReviewComment string `json:"review_comment"`
+	}
+	if err := c.ShouldBindJSON(&reviewReq); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Неверный формат данных"})
+		return
+	}
+
+	adminID, _ := c.Get("userID")
+
+	result, err := database.DB.Exec(`
+		UPDATE user_answers
+		SET review_comment = $1, reviewed_by = $2, reviewed_at = NOW()
+		WHERE id = $3 AND answer_text IS NOT NULL
+	`, strings.TrimSpace(reviewReq.ReviewComment), adminID, answerID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка сохранения проверки"})
+		return
+	}
+	if affected, _ := result.RowsAffected(); affected == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Свободный ответ не найден"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Ответ проверен"})
+}
@@ -0,0 +1,215 @@
+package handlers
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"net/http"
+	"psycho-test-system/database"
+	"psycho-test-system/models"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// loadScaleKeys загружает таблицу ключей версии теста: номер пункта (order_index) -> шкалы с направлением.
+// Пункты задаются номерами, а не ID вопросов, чтобы ключ переносился в новые версии теста.
+func loadScaleKeys(versionID int) (map[int][]scaleKey, error) {
+	rows, err := database.DB.Query(`
+		SELECT question_number, scale_type, is_reverse
+		FROM scale_keys
+		WHERE version_id = $1
+		ORDER BY id
+	`, versionID)
+	if err != nil {
+		return nil, fmt.Errorf("ошибка загрузки ключей шкал: %v", err)
+	}
+	defer rows.Close()
+
+	keys := make(map[int][]scaleKey)
+	for rows.Next() {
+		var questionNumber int
+		var key scaleKey
+		if err := rows.Scan(&questionNumber, &key.Scale, &key.Reverse); err != nil {
+			return nil, fmt.Errorf("ошибка чтения ключей шкал: %v", err)
+		}
+		keys[questionNumber] = append(keys[questionNumber], key)
+	}
+	return keys, rows.Err()
+}
+
+// validateScaleKeys проверяет номера пунктов: они есть в тесте и не указаны в шкале дважды
+func validateScaleKeys(keys []models.ScaleKey, questionsCount int) string {
+	scales := make(map[string]bool, len(keys))
+	for _, key := range keys {
+		scale := strings.TrimSpace(key.ScaleType)
+		if scale == "" {
+			return "Название шкалы обязательно"
+		}
+		if scales[scale] {
+			return fmt.Sprintf("Шкала «%s» указана дважды", scale)
+		}
+		scales[scale] = true
+
+		seen := make(map[int]bool)
+		for _, number := range append(append([]int{}, key.Direct...), key.Reverse...) {
+			if number < 1 || number > questionsCount {
+				return fmt.Sprintf("Шкала «%s»: пункта №%d нет в тесте", scale, number)
+			}
+			if seen[number] {
+				return fmt.Sprintf("Шкала «%s»: пункт №%d указан дважды", scale, number)
+			}
+			seen[number] = true
+		}
+	}
+	return ""
+}
+
+// loadScaleKeyTable загружает ключи шкал версии теста в формате «шкала: прямые и обратные пункты»
+func loadScaleKeyTable(versionID int) ([]models.ScaleKey, error) {
+	rows, err := database.DB.Query(`
+		SELECT scale_type, question_number, is_reverse
+		FROM scale_keys
+		WHERE version_id = $1
+		ORDER BY id
+	`, versionID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	keys := []models.ScaleKey{}
+	byScale := make(map[string]int)
+	for rows.Next() {
+		var scale string
+		var questionNumber int
+		var isReverse bool
+		if err := rows.Scan(&scale, &questionNumber, &isReverse); err != nil {
+			return nil, err
+		}
+
+		idx, exists := byScale[scale]
+		if !exists {
+			idx = len(keys)
+			byScale[scale] = idx
+			keys = append(keys, models.ScaleKey{ScaleType: scale, Direct: []int{}, Reverse: []int{}})
+		}
+		if isReverse {
+			keys[idx].Reverse = append(keys[idx].Reverse, questionNumber)
+		} else {
+			keys[idx].Direct = append(keys[idx].Direct, questionNumber)
+		}
+	}
+
+	for i := range keys {
+		sort.Ints(keys[i].Direct)
+		sort.Ints(keys[i].Reverse)
+	}
+	return keys, rows.Err()
+}
+
+// writeScaleKeys заменяет таблицу ключей шкал версии теста в рамках транзакции
+func writeScaleKeys(tx *sql.Tx, testID, versionID int, keys []models.ScaleKey) error {
+	if _, err := tx.Exec("DELETE FROM scale_keys WHERE version_id = $1", versionID); err != nil {
+		return fmt.Errorf("ошибка удаления ключей шкал: %v", err)
+	}
+
+	var rows [][]interface{}
+	for _, key := range keys {
+		scale := strings.TrimSpace(key.ScaleType)
+		for _, number := range key.Direct {
+			rows = append(rows, []interface{}{testID, versionID, scale, number, false})
+		}
+		for _, number := range key.Reverse {
+			rows = append(rows, []interface{}{testID, versionID, scale, number, true})
+		}
+	}
+	return insertBatch(tx, "scale_keys", []string{"test_id", "version_id", "scale_type", "question_number", "is_reverse"}, rows)
+}
+
+// Получение ключей шкал текущей версии теста в формате «шкала: прямые и обратные пункты»
+func GetScaleKeys(c *gin.Context) {
+	testID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Неверный ID теста"})
+		return
+	}
+
+	versionID, err := resolveVersionID(testID, 0)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Тест не найден"})
+		return
+	}
+	keys, err := loadScaleKeyTable(versionID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка получения ключей шкал"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"keys": keys})
+}
+
+// Замена таблицы ключей шкал теста. Пустой список удаляет ключи,
+// и шкалы снова определяются полями scale_type и scoring_key вопросов.
+// Если по текущей версии уже начинались попытки, ключи сохраняются в новой версии.
+func SetScaleKeys(c *gin.Context) {
+	testID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Неверный ID теста"})
+		return
+	}
+
+	var keysReq struct {
+		Keys []models.ScaleKey `json:"keys"`
+	}
+	if err := c.ShouldBindJSON(&keysReq); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Неверный формат данных"})
+		return
+	}
+
+	var questionsCount int
+	err = database.DB.QueryRow(`
+		SELECT COUNT(*) FROM test_questions
+		WHERE version_id = (SELECT current_version_id FROM psychological_tests WHERE id = $1)
+	`, testID).Scan(&questionsCount)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка получения вопросов"})
+		return
+	}
+	if message := validateScaleKeys(keysReq.Keys, questionsCount); message != "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": message})
+		return
+	}
+
+	tx, err := database.DB.Begin()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка начала транзакции"})
+		return
+	}
+	defer tx.Rollback()
+
+	userID, _ := c.Get("userID")
+	versionID, versionNumber, err := editableVersion(tx, testID, userID, "Изменение ключей шкал")
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Тест не найден"})
+		return
+	} else if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка создания версии теста"})
+		return
+	}
+
+	if err := writeScaleKeys(tx, testID, versionID, keysReq.Keys); err != nil {
+		log.Printf("Ошибка сохранения ключей шкал теста %d: %v", testID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка сохранения ключей шкал"})
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка завершения операции"})
+		return
+	}
+
+	respondVersionedChange(c, "Ключи шкал сохранены", versionNumber)
+}
@@ -0,0 +1,224 @@
+package handlers
+
+import (
+	"fmt"
+	"psycho-test-system/database"
+	"psycho-test-system/models"
+)
+
+// scoringQuestion - вопрос теста со всеми данными, необходимыми для подсчёта баллов
+type scoringQuestion struct {
+	ID           int
+	QuestionType string
+	ScaleType    string
+	Weight       float64
+	OrderIndex   int
+	IsRequired   bool
+	Options      map[int]int // option_id -> score_value
+	Positions    map[int]int // option_id -> порядковый номер варианта
+	MaxValue     int         // наибольший балл варианта
+	ScaleMin     int         // границы шкалы likert
+	ScaleMax     int
+	Reversed     bool        // обратный ключ для итогового балла (для likert учитывается в questionScore)
+	Keys         []scaleKey  // шкалы, в которые входит вопрос
+}
+
+// scaleKey - вхождение вопроса в шкалу с прямым или обратным ключом
+type scaleKey struct {
+	Scale   string
+	Reverse bool
+}
+
+// withKey возвращает вопрос с заданным направлением ключа, независимо от scoring_key вопроса.
+// Для обратного ключа баллы вариантов отражаются: min + max - score_value, так что
+// минимальный балл становится максимальным; для likert направление учитывает questionScore.
+func (question *scoringQuestion) withKey(reverse bool) *scoringQuestion {
+	reversed := *question
+	reversed.Reversed = reverse
+	if !reverse || len(question.Options) == 0 {
+		return &reversed
+	}
+
+	minValue := question.MaxValue
+	for _, value := range question.Options {
+		if value < minValue {
+			minValue = value
+		}
+	}
+	reversed.Options = make(map[int]int, len(question.Options))
+	for optionID, value := range question.Options {
+		reversed.Options[optionID] = minValue + question.MaxValue - value
+	}
+	return &reversed
+}
+
+// scoreSheet - итог подсчёта: общий балл, максимум и профиль по шкалам
+type scoreSheet struct {
+	TotalScore float64
+	MaxScore   float64
+	Percentage float64
+	Scales     []models.ScaleResult
+}
+
+// loadScoringQuestions загружает вопросы версии теста вместе с баллами вариантов ответов.
+// versionID = 0 - текущая версия теста.
+func loadScoringQuestions(testID, versionID int) ([]*scoringQuestion, error) {
+	versionID, err := resolveVersionID(testID, versionID)
+	if err != nil {
+		return nil, fmt.Errorf("ошибка загрузки версии теста: %v", err)
+	}
+
+	rows, err := database.DB.Query(`
+		SELECT q.id, COALESCE(q.question_type, ''), q.scale_type, COALESCE(q.weight, 1.0), COALESCE(q.order_index, 0),
+		       COALESCE(q.is_required, true), COALESCE(q.min_value, $2), COALESCE(q.max_value, $3),
+		       COALESCE(q.scoring_key, '') = $4, o.id, o.score_value
+		FROM test_questions q
+		LEFT JOIN question_options o ON q.id = o.question_id
+		WHERE q.test_id = $1 AND q.version_id = $5
+		ORDER BY q.order_index, o.order_index
+	`, testID, defaultLikertMin, defaultLikertMax, models.ScoringReverse, versionID)
+	if err != nil {
+		return nil, fmt.Errorf("ошибка загрузки вопросов теста: %v", err)
+	}
+	defer rows.Close()
+
+	var questions []*scoringQuestion
+	byID := make(map[int]*scoringQuestion)
+	for rows.Next() {
+		var questionID, orderIndex, scaleMin, scaleMax int
+		var isRequired, reversed bool
+		var questionType, scaleType string
+		var weight float64
+		var optionID, scoreValue *int
+
+		if err := rows.Scan(&questionID, &questionType, &scaleType, &weight, &orderIndex, &isRequired,
+			&scaleMin, &scaleMax, &reversed, &optionID, &scoreValue); err != nil {
+			return nil, fmt.Errorf("ошибка чтения вопросов теста: %v", err)
+		}
+
+		question, exists := byID[questionID]
+		if !exists {
+			// Вес 0 означает, что вес не задан в редакторе
+			if weight <= 0 {
+				weight = 1.0
+			}
+			question = &scoringQuestion{
+				ID:           questionID,
+				QuestionType: normalizeQuestionType(questionType),
+				ScaleType:    scaleType,
+				Weight:       weight,
+				OrderIndex:   orderIndex,
+				IsRequired:   isRequired,
+				Options:      make(map[int]int),
+				Positions:    make(map[int]int),
+				ScaleMin:     scaleMin,
+				ScaleMax:     scaleMax,
+				Reversed:     reversed,
+				Keys:         []scaleKey{{Scale: scaleType, Reverse: reversed}},
+			}
+			byID[questionID] = question
+			questions = append(questions, question)
+		}
+
+		if optionID != nil && scoreValue != nil {
+			if len(question.Options) == 0 || *scoreValue > question.MaxValue {
+				question.MaxValue = *scoreValue
+			}
+			question.Options[*optionID] = *scoreValue
+			question.Positions[*optionID] = len(question.Positions) + 1
+		}
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	// Если для версии задана таблица ключей, состав шкал берётся из неё
+	keys, err := loadScaleKeys(versionID)
+	if err != nil {
+		return nil, err
+	}
+	if len(keys) > 0 {
+		for _, question := range questions {
+			question.Keys = keys[question.OrderIndex]
+		}
+	}
+
+	return questions, nil
+}
+
+// onlyInScale проверяет, что все ключи вопроса относятся к шкале scale
+func (question *scoringQuestion) onlyInScale(scale string) bool {
+	if scale == "" || len(question.Keys) == 0 {
+		return false
+	}
+	for _, key := range question.Keys {
+		if key.Scale != scale {
+			return false
+		}
+	}
+	return true
+}
+
+// scoreAnswers считает сырые баллы и максимумы по каждой шкале с учётом веса вопросов.
+// selected сопоставляет ID вопроса с проверенным ответом на него.
+// Неотвеченные вопросы дают 0 баллов, но учитываются в максимуме шкалы.
+// Свободные ответы не оцениваются и в шкалы не входят. Вопрос попадает в шкалы
+// по своим ключам (Keys) и может входить в несколько шкал с разным направлением.
+// Вопросы только шкалы лжи lieScale считаются в своей шкале, но не в итоговом балле:
+// социально желательные ответы не должны повышать вердикт о пригодности.
+func scoreAnswers(questions []*scoringQuestion, selected map[int]models.AnswerSubmission, lieScale string) scoreSheet {
+	var sheet scoreSheet
+	scaleIndex := make(map[string]int)
+
+	addToScale := func(scale string, score, maxScore float64) {
+		idx, exists := scaleIndex[scale]
+		if !exists {
+			idx = len(sheet.Scales)
+			scaleIndex[scale] = idx
+			sheet.Scales = append(sheet.Scales, models.ScaleResult{ScaleName: scale})
+		}
+		sheet.Scales[idx].Score += score
+		sheet.Scales[idx].MaxScore += maxScore
+	}
+
+	for _, question := range questions {
+		if question.QuestionType == models.QuestionFreeText {
+			continue
+		}
+
+		answer, answered := selected[question.ID]
+
+		// Итоговый балл учитывает каждый вопрос один раз с его собственным ключом
+		if !question.onlyInScale(lieScale) {
+			keyed := question.withKey(question.Reversed)
+			sheet.MaxScore += questionMaxScore(keyed) * question.Weight
+			if answered {
+				sheet.TotalScore += questionScore(keyed, answer) * question.Weight
+			}
+		}
+
+		for _, key := range question.Keys {
+			keyed := question.withKey(key.Reverse)
+			var score float64
+			if answered {
+				score = questionScore(keyed, answer) * question.Weight
+			}
+			addToScale(key.Scale, score, questionMaxScore(keyed)*question.Weight)
+		}
+	}
+
+	for i := range sheet.Scales {
+		sheet.Scales[i].Percentage = percentOf(sheet.Scales[i].Score, sheet.Scales[i].MaxScore)
+	}
+	sheet.Percentage = percentOf(sheet.TotalScore, sheet.MaxScore)
+
+	return sheet
+}
+
+func percentOf(score, maxScore float64) float64 {
+	if maxScore <= 0 {
+		return 0
+	}
+	return (score / maxScore) * 100
+}
@@ -0,0 +1,136 @@
+package handlers
+
+import (
+	"math"
+	"psycho-test-system/models"
+	"testing"
+)
+
+// choiceQuestion - вопрос с вариантами 1, 2, 3 и баллами 0, 1, 3
+func choiceQuestion(questionType string) *scoringQuestion {
+	return &scoringQuestion{
+		ID:           1,
+		QuestionType: questionType,
+		Options:      map[int]int{1: 0, 2: 1, 3: 3},
+		MaxValue:     3,
+	}
+}
+
+// scaleQuestion - вопрос с одним вариантом и баллами 0, 1, 3, входящий в шкалу scale с прямым ключом
+func scaleQuestion(id int, scale string, weight float64) *scoringQuestion {
+	question := choiceQuestion(models.QuestionSingleChoice)
+	question.ID, question.OrderIndex, question.ScaleType, question.Weight = id, id, scale, weight
+	question.Keys = []scaleKey{{Scale: scale}}
+	return question
+}
+
+func TestScoreAnswers(t *testing.T) {
+	questions := []*scoringQuestion{
+		scaleQuestion(1, "E", 1),
+		scaleQuestion(2, "E", 2),
+		scaleQuestion(3, "N", 1),
+	}
+
+	tests := []struct {
+		name       string
+		selected   map[int]int // ID вопроса -> ID варианта
+		total      float64
+		percentage float64
+		scales     map[string]float64
+	}{
+		{"все ответы с наибольшим баллом", map[int]int{1: 3, 2: 3, 3: 3}, 12, 100, map[string]float64{"E": 9, "N": 3}},
+		// Вес второго вопроса удваивает его балл и максимум
+		{"вес вопроса", map[int]int{1: 1, 2: 2, 3: 1}, 2, 2.0 / 12 * 100, map[string]float64{"E": 2, "N": 0}},
+		{"неотвеченный вопрос учитывается в максимуме", map[int]int{1: 3}, 3, 25, map[string]float64{"E": 3, "N": 0}},
+		{"нет ответов", map[int]int{}, 0, 0, map[string]float64{"E": 0, "N": 0}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			selected := make(map[int]models.AnswerSubmission, len(tt.selected))
+			for questionID, optionID := range tt.selected {
+				selected[questionID] = models.AnswerSubmission{QuestionID: questionID, OptionID: optionID}
+			}
+
+			sheet := scoreAnswers(questions, selected, "")
+			if sheet.TotalScore != tt.total || sheet.MaxScore != 12 {
+				t.Errorf("итог = %v из %v, ожидалось %v из 12", sheet.TotalScore, sheet.MaxScore, tt.total)
+			}
+			if math.Abs(sheet.Percentage-tt.percentage) > 1e-9 {
+				t.Errorf("процент = %v, ожидалось %v", sheet.Percentage, tt.percentage)
+			}
+			if len(sheet.Scales) != len(tt.scales) {
+				t.Fatalf("шкал %d, ожидалось %d", len(sheet.Scales), len(tt.scales))
+			}
+			for _, scale := range sheet.Scales {
+				if scale.Score != tt.scales[scale.ScaleName] {
+					t.Errorf("шкала %s: балл %v, ожидалось %v", scale.ScaleName, scale.Score, tt.scales[scale.ScaleName])
+				}
+			}
+		})
+	}
+}
+
+func TestWithKey(t *testing.T) {
+	choice := choiceQuestion(models.QuestionSingleChoice)
+	likert := &scoringQuestion{ID: 1, QuestionType: models.QuestionLikert, ScaleMin: 1, ScaleMax: 5}
+
+	tests := []struct {
+		name     string
+		question *scoringQuestion
+		reverse  bool
+		answer   models.AnswerSubmission
+		score    float64
+	}{
+		{"прямой ключ", choice, false, models.AnswerSubmission{OptionID: 3}, 3},
+		// Баллы 0, 1, 3 отражаются в 3, 2, 0: min + max - балл
+		{"обратный ключ, наибольший балл", choice, true, models.AnswerSubmission{OptionID: 3}, 0},
+		{"обратный ключ, наименьший балл", choice, true, models.AnswerSubmission{OptionID: 1}, 3},
+		{"обратный ключ, средний балл", choice, true, models.AnswerSubmission{OptionID: 2}, 2},
+		{"шкала Лайкерта, прямой ключ", likert, false, likertValue(4), 4},
+		{"шкала Лайкерта, обратный ключ", likert, true, likertValue(4), 2},
+		{"шкала Лайкерта, обратный ключ на границе", likert, true, likertValue(1), 5},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			keyed := tt.question.withKey(tt.reverse)
+			if score := questionScore(keyed, tt.answer); score != tt.score {
+				t.Errorf("балл = %v, ожидалось %v", score, tt.score)
+			}
+			if maxScore, original := questionMaxScore(keyed), questionMaxScore(tt.question); maxScore != original {
+				t.Errorf("максимум с ключом = %v, без ключа %v", maxScore, original)
+			}
+		})
+	}
+
+	if choice.Options[3] != 3 {
+		t.Error("withKey изменил баллы исходного вопроса")
+	}
+}
+
+func TestScoreAnswersWithScaleKeys(t *testing.T) {
+	// Вопрос входит в шкалу E с прямым ключом и в шкалу I с обратным;
+	// в итоговый балл он входит один раз по собственному ключу
+	question := scaleQuestion(1, "E", 1)
+	question.Keys = []scaleKey{{Scale: "E"}, {Scale: "I", Reverse: true}}
+
+	sheet := scoreAnswers([]*scoringQuestion{question}, map[int]models.AnswerSubmission{1: {QuestionID: 1, OptionID: 2}}, "")
+	if sheet.TotalScore != 1 || sheet.MaxScore != 3 {
+		t.Errorf("итог = %v из %v, ожидалось 1 из 3", sheet.TotalScore, sheet.MaxScore)
+	}
+	want := map[string]float64{"E": 1, "I": 2}
+	if len(sheet.Scales) != len(want) {
+		t.Fatalf("шкал %d, ожидалось %d", len(sheet.Scales), len(want))
+	}
+	for _, scale := range sheet.Scales {
+		if scale.Score != want[scale.ScaleName] || scale.MaxScore != 3 {
+			t.Errorf("шкала %s: %v из %v, ожидалось %v из 3", scale.ScaleName, scale.Score, scale.MaxScore, want[scale.ScaleName])
+		}
+	}
+
+	question.Reversed = true
+	if sheet := scoreAnswers([]*scoringQuestion{question}, map[int]models.AnswerSubmission{1: {QuestionID: 1, OptionID: 2}}, ""); sheet.TotalScore != 2 {
+		t.Errorf("итог вопроса с обратным ключом = %v, ожидалось 2", sheet.TotalScore)
+	}
+}
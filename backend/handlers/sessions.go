@@ -0,0 +1,165 @@
+package handlers
+
+import (
+	"database/sql"
+	"log"
+	"net/http"
+	"psycho-test-system/database"
+	"psycho-test-system/models"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Запас времени на сетевую задержку при проверке лимита времени
+const lateGraceSeconds = 30
+
+// activeSession - незавершённая сессия пользователя с временем, посчитанным на стороне БД
+type activeSession struct {
+	models.TestSession
+	ElapsedSeconds int
+	Expired        bool
+}
+
+func normalizeLatePolicy(policy string) string {
+	if policy == models.LatePolicyReject {
+		return models.LatePolicyReject
+	}
+	return models.LatePolicyFlag
+}
+
+// loadActiveSession возвращает последнюю активную сессию пользователя по тесту.
+// Прошедшее время и истечение лимита считаются в БД, чтобы не зависеть от часового пояса сервера.
+func loadActiveSession(userID, testID int) (*activeSession, error) {
+	var session activeSession
+	var expiresAt sql.NullTime
+
+	err := database.DB.QueryRow(`
+		SELECT s.id, s.user_id, s.test_id, COALESCE(s.version_id, pt.current_version_id, 0), s.status, s.started_at, s.expires_at,
+		       COALESCE(pt.time_limit, 0),
+		       EXTRACT(EPOCH FROM (NOW() - s.started_at))::int,
+		       COALESCE(NOW() > s.expires_at + make_interval(secs => $3), false)
+		FROM test_sessions s
+		JOIN psychological_tests pt ON pt.id = s.test_id
+		WHERE s.user_id = $1 AND s.test_id = $2 AND s.status = $4
+		ORDER BY s.started_at DESC
+		LIMIT 1
+	`, userID, testID, lateGraceSeconds, models.SessionActive).Scan(&session.ID, &session.UserID, &session.TestID, &session.VersionID, &session.Status,
+		&session.StartedAt, &expiresAt, &session.TimeLimit, &session.ElapsedSeconds, &session.Expired)
+	if err != nil {
+		return nil, err
+	}
+
+	if expiresAt.Valid {
+		session.ExpiresAt = &expiresAt.Time
+		remaining := session.TimeLimit*60 - session.ElapsedSeconds
+		if remaining < 0 {
+			remaining = 0
+		}
+		session.RemainingSeconds = &remaining
+	}
+	return &session, nil
+}
+
+func expireSession(sessionID int) {
+	_, err := database.DB.Exec("UPDATE test_sessions SET status = $1 WHERE id = $2", models.SessionExpired, sessionID)
+	if err != nil {
+		log.Printf("Ошибка закрытия просроченной сессии %d: %v", sessionID, err)
+	}
+}
+
+// Начало прохождения теста. Повторный вызов возвращает уже открытую сессию,
+// поэтому перезагрузка страницы не сбрасывает время начала.
+func StartTest(c *gin.Context) {
+	testID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Неверный ID теста"})
+		return
+	}
+
+	userID, exists := c.Get("userID")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Пользователь не авторизован"})
+		return
+	}
+
+	var isActive bool
+	var latePolicy string
+	err = database.DB.QueryRow(`
+		SELECT is_active AND archived_at IS NULL, COALESCE(late_submission_policy, 'flag')
+		FROM psychological_tests WHERE id = $1
+	`, testID).Scan(&isActive, &latePolicy)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Тест не найден"})
+		return
+	}
+	if !isActive {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Тест не доступен для прохождения"})
+		return
+	}
+	if !requireTestAssignment(c, testID) {
+		return
+	}
+
+	session, err := loadActiveSession(userID.(int), testID)
+	if err != nil && err != sql.ErrNoRows {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка получения сессии"})
+		return
+	}
+
+	if session != nil {
+		// Просроченную сессию при строгом лимите закрываем и начинаем заново
+		if !(session.Expired && latePolicy == models.LatePolicyReject) {
+			c.JSON(http.StatusOK, gin.H{"session": session.TestSession})
+			return
+		}
+		expireSession(session.ID)
+	}
+
+	// Начатую до срока сессию можно завершить, а новую после срока назначения начать нельзя
+	if role, _ := c.Get("userRole"); role != models.RoleAdmin {
+		overdue, err := isAssignmentOverdue(userID.(int), testID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка проверки назначения теста"})
+			return
+		}
+		if overdue {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Срок выполнения теста истек"})
+			return
+		}
+	}
+
+	// Новую попытку проверяем по лимиту попыток и интервалу между ними
+	violation, err := checkAttemptPolicy(userID.(int), testID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка проверки попыток"})
+		return
+	}
+	if violation != "" {
+		c.JSON(http.StatusForbidden, gin.H{"error": violation})
+		return
+	}
+
+	_, err = database.DB.Exec(`
+		INSERT INTO test_sessions (user_id, test_id, version_id, status, started_at, expires_at)
+		SELECT $1, id, current_version_id, $2, NOW(),
+		       CASE WHEN COALESCE(time_limit, 0) > 0 THEN NOW() + make_interval(mins => time_limit) END
+		FROM psychological_tests WHERE id = $3
+	`, userID, models.SessionActive, testID)
+	if err != nil {
+		log.Printf("Ошибка создания сессии теста %d: %v", testID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка начала теста"})
+		return
+	}
+
+	session, err = loadActiveSession(userID.(int), testID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка получения сессии"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"message": "Тест начат",
+		"session": session.TestSession,
+	})
+}
@@ -0,0 +1,255 @@
+package handlers
+
+import (
+	"archive/zip"
+	"encoding/csv"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Формат даты и времени в выгрузках
+const exportTimeLayout = "2006-01-02 15:04:05"
+
+// Ячейка Excel вмещает не больше 32767 символов, более длинный текст файл делает повреждённым
+const xlsxMaxCellLength = 32767
+
+// exportTable - таблица выгрузки (лист книги Excel). Значения строк: nil, string, int,
+// float64, bool или time.Time.
+type exportTable struct {
+	Name   string
+	Header []string
+	Rows   [][]interface{}
+}
+
+// csvFormulaPrefixes - первые символы, с которых Excel и LibreOffice начинают формулу
+const csvFormulaPrefixes = "=+-@\t\r"
+
+// escapeCSVFormula экранирует апострофом текст, который табличный редактор принял бы за формулу:
+// ответы и названия вводятся пользователями и не должны исполняться при открытии выгрузки.
+func escapeCSVFormula(value string) string {
+	if value != "" && strings.ContainsRune(csvFormulaPrefixes, rune(value[0])) {
+		return "'" + value
+	}
+	return value
+}
+
+// formatExportValue - текстовое представление значения для CSV. Логические значения
+// выводятся как 1 и 0, чтобы их без перекодировки читали SPSS и R. Текст экранируется
+// от интерпретации как формулы, числа выводятся как есть.
+func formatExportValue(value interface{}) string {
+	switch value := value.(type) {
+	case nil:
+		return ""
+	case string:
+		return escapeCSVFormula(value)
+	case int:
+		return strconv.Itoa(value)
+	case float64:
+		return strconv.FormatFloat(value, 'f', -1, 64)
+	case bool:
+		if value {
+			return "1"
+		}
+		return "0"
+	case time.Time:
+		return value.Format(exportTimeLayout)
+	}
+	return fmt.Sprint(value)
+}
+
+// writeCSVTable записывает таблицу в CSV (UTF-8 с BOM, чтобы Excel распознал кодировку)
+func writeCSVTable(w io.Writer, table *exportTable) error {
+	if _, err := io.WriteString(w, "\ufeff"); err != nil {
+		return err
+	}
+	writer := csv.NewWriter(w)
+	header := make([]string, len(table.Header))
+	for i, name := range table.Header {
+		header[i] = escapeCSVFormula(name)
+	}
+	if err := writer.Write(header); err != nil {
+		return err
+	}
+	record := make([]string, len(table.Header))
+	for _, row := range table.Rows {
+		for i := range record {
+			record[i] = ""
+			if i < len(row) {
+				record[i] = formatExportValue(row[i])
+			}
+		}
+		if err := writer.Write(record); err != nil {
+			return err
+		}
+	}
+	writer.Flush()
+	return writer.Error()
+}
+
+// xlsxColumn - буквенное обозначение столбца: 0 - A, 26 - AA
+func xlsxColumn(index int) string {
+	name := ""
+	for index++; index > 0; index = (index - 1) / 26 {
+		name = string(rune('A'+(index-1)%26)) + name
+	}
+	return name
+}
+
+// xlsxSerial - дата в виде числа дней с 30.12.1899, как её хранит Excel
+func xlsxSerial(t time.Time) float64 {
+	epoch := time.Date(1899, 12, 30, 0, 0, 0, 0, time.UTC)
+	local := time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), t.Second(), 0, time.UTC)
+	return float64(local.Sub(epoch)) / float64(24*time.Hour)
+}
+
+func xlsxEscape(text string) string {
+	if runes := []rune(text); len(runes) > xlsxMaxCellLength {
+		text = string(runes[:xlsxMaxCellLength])
+	}
+	var builder strings.Builder
+	xml.EscapeText(&builder, []byte(text))
+	return builder.String()
+}
+
+// Стили книги: 0 - обычная ячейка, 1 - заголовок, 2 - дата и время
+const xlsxStyles = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<styleSheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main">
+<numFmts count="1"><numFmt numFmtId="164" formatCode="yyyy-mm-dd hh:mm:ss"/></numFmts>
+<fonts count="2"><font><sz val="11"/><name val="Calibri"/></font><font><b/><sz val="11"/><name val="Calibri"/></font></fonts>
+<fills count="2"><fill><patternFill patternType="none"/></fill><fill><patternFill patternType="gray125"/></fill></fills>
+<borders count="1"><border><left/><right/><top/><bottom/><diagonal/></border></borders>
+<cellStyleXfs count="1"><xf numFmtId="0" fontId="0" fillId="0" borderId="0"/></cellStyleXfs>
+<cellXfs count="3"><xf numFmtId="0" fontId="0" fillId="0" borderId="0" xfId="0"/><xf numFmtId="0" fontId="1" fillId="0" borderId="0" xfId="0" applyFont="1"/><xf numFmtId="164" fontId="0" fillId="0" borderId="0" xfId="0" applyNumberFormat="1"/></cellXfs>
+<cellStyles count="1"><cellStyle name="Normal" xfId="0" builtinId="0"/></cellStyles>
+</styleSheet>`
+
+// writeXLSXCell записывает ячейку листа; пустые значения пропускаются
+func writeXLSXCell(w io.Writer, ref string, value interface{}, style int) error {
+	var err error
+	switch value := value.(type) {
+	case nil:
+	case string:
+		if value == "" {
+			break
+		}
+		_, err = fmt.Fprintf(w, `<c r="%s" t="inlineStr" s="%d"><is><t xml:space="preserve">%s</t></is></c>`, ref, style, xlsxEscape(value))
+	case int, float64:
+		_, err = fmt.Fprintf(w, `<c r="%s" s="%d"><v>%s</v></c>`, ref, style, formatExportValue(value))
+	case bool:
+		_, err = fmt.Fprintf(w, `<c r="%s" t="b" s="%d"><v>%s</v></c>`, ref, style, formatExportValue(value))
+	case time.Time:
+		_, err = fmt.Fprintf(w, `<c r="%s" s="2"><v>%s</v></c>`, ref, strconv.FormatFloat(xlsxSerial(value), 'f', -1, 64))
+	default:
+		_, err = fmt.Fprintf(w, `<c r="%s" t="inlineStr" s="%d"><is><t>%s</t></is></c>`, ref, style, xlsxEscape(fmt.Sprint(value)))
+	}
+	return err
+}
+
+// writeXLSXSheet записывает лист с закреплённой строкой заголовка
+func writeXLSXSheet(w io.Writer, table *exportTable) error {
+	if _, err := io.WriteString(w, `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<worksheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main">
+<sheetViews><sheetView workbookViewId="0"><pane ySplit="1" topLeftCell="A2" activePane="bottomLeft" state="frozen"/></sheetView></sheetViews>
+<sheetData>`); err != nil {
+		return err
+	}
+
+	columns := make([]string, len(table.Header))
+	for i := range columns {
+		columns[i] = xlsxColumn(i)
+	}
+	writeRow := func(number int, values []interface{}, style int) error {
+		if _, err := fmt.Fprintf(w, `<row r="%d">`, number); err != nil {
+			return err
+		}
+		for i, value := range values {
+			if i >= len(columns) {
+				break
+			}
+			if err := writeXLSXCell(w, columns[i]+strconv.Itoa(number), value, style); err != nil {
+				return err
+			}
+		}
+		_, err := io.WriteString(w, "</row>")
+		return err
+	}
+
+	header := make([]interface{}, len(table.Header))
+	for i, name := range table.Header {
+		header[i] = name
+	}
+	if err := writeRow(1, header, 1); err != nil {
+		return err
+	}
+	for i, row := range table.Rows {
+		if err := writeRow(i+2, row, 0); err != nil {
+			return err
+		}
+	}
+	_, err := io.WriteString(w, "</sheetData></worksheet>")
+	return err
+}
+
+// writeXLSX записывает книгу Excel (Office Open XML) с листом на каждую таблицу
+func writeXLSX(w io.Writer, tables []*exportTable) error {
+	archive := zip.NewWriter(w)
+	addFile := func(name, content string) error {
+		file, err := archive.Create(name)
+		if err != nil {
+			return err
+		}
+		_, err = io.WriteString(file, content)
+		return err
+	}
+
+	var contentTypes, sheets, relationships strings.Builder
+	for i, table := range tables {
+		fmt.Fprintf(&contentTypes, `<Override PartName="/xl/worksheets/sheet%d.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.worksheet+xml"/>`, i+1)
+		fmt.Fprintf(&sheets, `<sheet name="%s" sheetId="%d" r:id="rId%d"/>`, xlsxEscape(table.Name), i+1, i+1)
+		fmt.Fprintf(&relationships, `<Relationship Id="rId%d" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/worksheet" Target="worksheets/sheet%d.xml"/>`, i+1, i+1)
+	}
+	fmt.Fprintf(&relationships, `<Relationship Id="rId%d" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/styles" Target="styles.xml"/>`, len(tables)+1)
+
+	files := []struct{ name, content string }{
+		{"[Content_Types].xml", `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Types xmlns="http://schemas.openxmlformats.org/package/2006/content-types">
+<Default Extension="rels" ContentType="application/vnd.openxmlformats-package.relationships+xml"/>
+<Default Extension="xml" ContentType="application/xml"/>
+<Override PartName="/xl/workbook.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.sheet.main+xml"/>
+<Override PartName="/xl/styles.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.styles+xml"/>
+` + contentTypes.String() + `</Types>`},
+		{"_rels/.rels", `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">
+<Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/officeDocument" Target="xl/workbook.xml"/>
+</Relationships>`},
+		{"xl/workbook.xml", `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<workbook xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main" xmlns:r="http://schemas.openxmlformats.org/officeDocument/2006/relationships">
+<sheets>` + sheets.String() + `</sheets>
+</workbook>`},
+		{"xl/_rels/workbook.xml.rels", `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">
+` + relationships.String() + `
+</Relationships>`},
+		{"xl/styles.xml", xlsxStyles},
+	}
+	for _, file := range files {
+		if err := addFile(file.name, file.content); err != nil {
+			return err
+		}
+	}
+
+	for i, table := range tables {
+		file, err := archive.Create(fmt.Sprintf("xl/worksheets/sheet%d.xml", i+1))
+		if err != nil {
+			return err
+		}
+		if err := writeXLSXSheet(file, table); err != nil {
+			return err
+		}
+	}
+	return archive.Close()
+}
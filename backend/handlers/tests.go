@@ -6,17 +6,27 @@ import (
 	"log"
 	"net/http"
 	"strconv"
+	"strings"
 	"psycho-test-system/database"
+	"psycho-test-system/models"
 
 	"github.com/gin-gonic/gin"
 )
 
 func GetTests(c *gin.Context) {
+	userID, _ := c.Get("userID")
+	role, _ := c.Get("userRole")
+
+	// Кандидат видит только назначенные ему тесты, администратор - все активные. Тесты из архива скрыты.
+	assignedOnly := role != models.RoleAdmin
 	rows, err := database.DB.Query(`
-		SELECT id, title, description, instructions, estimated_time, pass_threshold, methodology_type
-		FROM psychological_tests 
-		WHERE is_active = true
-	`)
+		SELECT pt.id, pt.title, pt.description, pt.instructions, pt.estimated_time, pt.pass_threshold, pt.methodology_type,
+		       ta.deadline, CASE WHEN ta.id IS NULL THEN '' ELSE `+assignmentStatusSQL+` END
+		FROM psychological_tests pt
+		LEFT JOIN test_assignments ta ON ta.test_id = pt.id AND ta.user_id = $1
+		WHERE pt.is_active = true AND pt.archived_at IS NULL AND (NOT $2 OR ta.id IS NOT NULL)
+		ORDER BY ta.deadline NULLS LAST, pt.id
+	`, userID, assignedOnly)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка получения тестов"})
 		return
@@ -34,13 +44,15 @@ func GetTests(c *gin.Context) {
 			PassThreshold float64 `json:"pass_threshold"`
 			MethodologyType string `json:"methodology_type"`
 		}
+		var deadline sql.NullTime
+		var assignmentStatus string
 		err := rows.Scan(&test.ID, &test.Title, &test.Description, &test.Instructions, 
-			&test.EstimatedTime, &test.PassThreshold, &test.MethodologyType)
+			&test.EstimatedTime, &test.PassThreshold, &test.MethodologyType, &deadline, &assignmentStatus)
 		if err != nil {
 			continue
 		}
 		
-		tests = append(tests, map[string]interface{}{
+		testData := map[string]interface{}{
 			"id":              test.ID,
 			"title":           test.Title,
 			"description":     test.Description,
@@ -48,7 +60,14 @@ func GetTests(c *gin.Context) {
 			"estimated_time":  test.EstimatedTime,
 			"pass_threshold":  test.PassThreshold,
 			"methodology_type": test.MethodologyType,
-		})
+		}
+		if assignmentStatus != "" {
+			testData["assignment_status"] = assignmentStatus
+			if deadline.Valid {
+				testData["deadline"] = deadline.Time
+			}
+		}
+		tests = append(tests, testData)
 	}
 
 	c.JSON(http.StatusOK, gin.H{"tests": tests})
@@ -69,14 +88,15 @@ func GetTest(c *gin.Context) {
 		EstimatedTime int     `json:"estimated_time"`
 		PassThreshold float64 `json:"pass_threshold"`
 		MethodologyType string `json:"methodology_type"`
+		TimeLimit     int     `json:"time_limit"`
 	}
 
 	err = database.DB.QueryRow(`
-		SELECT id, title, description, instructions, estimated_time, pass_threshold, methodology_type
+		SELECT id, title, description, instructions, estimated_time, pass_threshold, methodology_type, COALESCE(time_limit, 0)
 		FROM psychological_tests 
-		WHERE id = $1 AND is_active = true
+		WHERE id = $1 AND is_active = true AND archived_at IS NULL
 	`, testID).Scan(&test.ID, &test.Title, &test.Description, &test.Instructions, 
-		&test.EstimatedTime, &test.PassThreshold, &test.MethodologyType)
+		&test.EstimatedTime, &test.PassThreshold, &test.MethodologyType, &test.TimeLimit)
 
 	if err == sql.ErrNoRows {
 		c.JSON(http.StatusNotFound, gin.H{"error": "Тест не найден"})
@@ -86,15 +106,27 @@ func GetTest(c *gin.Context) {
 		return
 	}
 
-	// Получаем вопросы теста с вариантами ответов
+	if !requireTestAssignment(c, testID) {
+		return
+	}
+
+	// Получаем вопросы теста с вариантами ответов. Незавершённая попытка продолжается
+	// на своей версии теста, даже если тест успели отредактировать.
+	userID, _ := c.Get("userID")
 	rows, err := database.DB.Query(`
-		SELECT q.id, q.question_text, q.question_type, q.scale_type, q.weight, q.order_index,
+		SELECT q.id, q.question_text, q.question_type, q.scale_type, q.weight, q.order_index, COALESCE(q.is_required, true),
+		       COALESCE(q.min_value, 0), COALESCE(q.max_value, 0),
 		       o.id, o.option_text, o.score_value, o.order_index
 		FROM test_questions q
 		LEFT JOIN question_options o ON q.id = o.question_id
-		WHERE q.test_id = $1 
+		WHERE q.test_id = $1
+		  AND q.version_id = COALESCE(
+		      (SELECT s.version_id FROM test_sessions s
+		       WHERE s.user_id = $2 AND s.test_id = $1 AND s.status = $3
+		       ORDER BY s.started_at DESC LIMIT 1),
+		      (SELECT current_version_id FROM psychological_tests WHERE id = $1))
 		ORDER BY q.order_index, o.order_index
-	`, testID)
+	`, testID, userID, models.SessionActive)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка получения вопросов"})
 		return
@@ -108,6 +140,9 @@ func GetTest(c *gin.Context) {
 		ScaleType    string `json:"scale_type"`
 		Weight       float64 `json:"weight"`
 		OrderIndex   int    `json:"order_index"`
+		IsRequired   bool   `json:"is_required"`
+		MinValue     int    `json:"min_value"`
+		MaxValue     int    `json:"max_value"`
 		Options      []struct {
 			ID         int    `json:"id"`
 			OptionText string `json:"text"`
@@ -121,12 +156,14 @@ func GetTest(c *gin.Context) {
 		var questionText, questionType, scaleType string
 		var weight float64
 		var orderIndex int
+		var isRequired bool
+		var minValue, maxValue int
 		var optionID sql.NullInt64
 		var optionText sql.NullString
 		var scoreValue sql.NullInt64
 		var optionOrder sql.NullInt64
 
-		err := rows.Scan(&questionID, &questionText, &questionType, &scaleType, &weight, &orderIndex,
+		err := rows.Scan(&questionID, &questionText, &questionType, &scaleType, &weight, &orderIndex, &isRequired, &minValue, &maxValue,
 			&optionID, &optionText, &scoreValue, &optionOrder)
 		if err != nil {
 			continue
@@ -140,6 +177,9 @@ func GetTest(c *gin.Context) {
 				ScaleType    string `json:"scale_type"`
 				Weight       float64 `json:"weight"`
 				OrderIndex   int    `json:"order_index"`
+				IsRequired   bool   `json:"is_required"`
+				MinValue     int    `json:"min_value"`
+				MaxValue     int    `json:"max_value"`
 				Options      []struct {
 					ID         int    `json:"id"`
 					OptionText string `json:"text"`
@@ -149,10 +189,13 @@ func GetTest(c *gin.Context) {
 			}{
 				ID:           questionID,
 				QuestionText: questionText,
-				QuestionType: questionType,
+				QuestionType: normalizeQuestionType(questionType),
 				ScaleType:    scaleType,
 				Weight:       weight,
 				OrderIndex:   orderIndex,
+				IsRequired:   isRequired,
+				MinValue:     minValue,
+				MaxValue:     maxValue,
 				Options:      []struct {
 					ID         int    `json:"id"`
 					OptionText string `json:"text"`
@@ -188,171 +231,88 @@ func GetTest(c *gin.Context) {
 		}
 	}
 
-	c.JSON(http.StatusOK, gin.H{
-		"test": map[string]interface{}{
-			"id":              test.ID,
-			"title":           test.Title,
-			"description":     test.Description,
-			"instructions":    test.Instructions,
-			"estimated_time":  test.EstimatedTime,
-			"pass_threshold":  test.PassThreshold,
-			"methodology_type": test.MethodologyType,
-			"questions":       questions,
-		},
-	})
+	testData := map[string]interface{}{
+		"id":              test.ID,
+		"title":           test.Title,
+		"description":     test.Description,
+		"instructions":    test.Instructions,
+		"estimated_time":  test.EstimatedTime,
+		"pass_threshold":  test.PassThreshold,
+		"methodology_type": test.MethodologyType,
+		"time_limit":      test.TimeLimit,
+		"questions":       questions,
+	}
+
+	// Если есть незавершённая попытка, отдаём сохранённые ответы для продолжения
+	if userID, exists := c.Get("userID"); exists {
+		if draft, err := loadDraft(userID.(int), testID); err == nil {
+			testData["draft"] = draft
+		} else if err != sql.ErrNoRows {
+			log.Printf("Ошибка получения черновика теста %d: %v", testID, err)
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{"test": testData})
+}
+
+// testScore - баллы попытки с вердиктом по порогу версии теста и заключением
+type testScore struct {
+    scoreSheet
+    PassThreshold  float64
+    IsPassed       bool
+    Interpretation string
+    Recommendation string
 }
 
 // УНИВЕРСАЛЬНЫЙ РАСЧЕТ ДЛЯ ВСЕХ ТЕСТОВ
-func calculateProfessionalTestScore(answers map[string]interface{}, testID int) (float64, float64, string, string, map[string]float64) {
+// Баллы и максимумы считаются по шкалам из test_questions и question_options,
+// поэтому тесты, созданные через админ-панель, оцениваются так же, как встроенные методики
+// versionID - версия теста, по порогу и правилам которой оценивается попытка;
+// lieScale - шкала лжи версии, её вопросы не входят в итоговый балл
+func calculateProfessionalTestScore(testID, versionID int, questions []*scoringQuestion, selected map[int]models.AnswerSubmission, lieScale string) (*testScore, error) {
     log.Printf("=== РАСЧЕТ ТЕСТА %d ===", testID)
-    log.Printf("Полученные ответы: %v", answers)
+    log.Printf("Получено ответов: %d", len(selected))
     
     // Получаем тип методики и порог
     var methodologyType string
     var passThreshold float64
     err := database.DB.QueryRow(`
-        SELECT methodology_type, pass_threshold 
-        FROM psychological_tests WHERE id = $1`, testID).Scan(&methodologyType, &passThreshold)
+        SELECT pt.methodology_type, v.pass_threshold 
+        FROM psychological_tests pt
+        JOIN test_versions v ON v.test_id = pt.id AND v.id = $2
+        WHERE pt.id = $1`, testID, versionID).Scan(&methodologyType, &passThreshold)
     if err != nil {
-        log.Printf("Ошибка получения информации о тесте: %v", err)
-        return 0, 0, "Ошибка загрузки теста", "", nil
+        return nil, fmt.Errorf("ошибка получения порога версии теста: %v", err)
     }
     
     log.Printf("Методика: %s, Порог: %.1f%%", methodologyType, passThreshold)
-    
-    // Выбираем метод расчета
-    switch methodologyType {
-    case "rigidity_scale":
-        return calculateTestScore(answers, testID, passThreshold, 20.0, "ригидности")
-    case "willpower_control":
-        return calculateTestScore(answers, testID, passThreshold, 50.0, "ВСК")
-    case "personality_16pf":
-        return calculateTestScore(answers, testID, passThreshold, 60.0, "16PF")
-    default:
-        return calculateGenericScore(answers, passThreshold)
-    }
-}
-
-// ОБЩАЯ ФУНКЦИЯ РАСЧЕТА ДЛЯ ВСЕХ ТЕСТОВ - ИСПРАВЛЕННАЯ ВЕРСИЯ
-func calculateTestScore(answers map[string]interface{}, testID int, passThreshold float64, 
-                       maxPossibleScore float64, testName string) (float64, float64, string, string, map[string]float64) {
-    
-    totalScore := 0.0
-    answeredQuestions := 0
-    
-    log.Printf("=== РАСЧЕТ ТЕСТА %s ===", testName)
-    
-    // Пройдемся по всем ответам
-    for questionKey, userAnswer := range answers {
-        questionOrder, err := strconv.Atoi(questionKey)
-        if err != nil {
-            continue
-        }
-        
-        selectedOptionID := int(userAnswer.(float64))
-        answeredQuestions++
-        
-        // Получаем вопрос по order_index
-        var questionID int
-        err = database.DB.QueryRow(`
-            SELECT id FROM test_questions 
-            WHERE test_id = $1 AND order_index = $2
-        `, testID, questionOrder).Scan(&questionID)
-        
-        if err != nil {
-            log.Printf("Вопрос %d: не найден в БД", questionOrder)
-            continue
-        }
-        
-        // Получаем выбранный вариант ответа
-        var scoreValue int
-        var optionText string
-        err = database.DB.QueryRow(`
-            SELECT score_value, option_text 
-            FROM question_options 
-            WHERE id = $1
-        `, selectedOptionID).Scan(&scoreValue, &optionText)
-        
-        if err != nil {
-            log.Printf("Вопрос %d: вариант с ID=%d не найден в БД!", questionOrder, selectedOptionID)
-            continue
-        }
-        
-        log.Printf("Вопрос %d (ID=%d): выбрано '%s' (ID=%d) → %d баллов", 
-            questionOrder, questionID, optionText, selectedOptionID, scoreValue)
-        
-        totalScore += float64(scoreValue)
-    }
-    
-    // Вычисляем процент
-    var percentage float64
-    if maxPossibleScore > 0 {
-        percentage = (totalScore / maxPossibleScore) * 100
-    }
-    
-    isPassed := percentage >= passThreshold
-    
-    log.Printf("=== ИТОГ теста %s: %.1f/%.1f = %.1f%%, Порог: %.1f%%, Пройден: %v ===",
-        testName, totalScore, maxPossibleScore, percentage, passThreshold, isPassed)
-    
-    // Получаем интерпретацию в зависимости от типа теста
-    var interpretation, recommendation string
-    switch testName {
-    case "ригидности":
-        interpretation, recommendation = getRigidityResult(isPassed, percentage)
-    case "ВСК":
-        interpretation, recommendation = getWillpowerResult(isPassed, percentage)
-    case "16PF":
-        interpretation, recommendation = getPersonality16PFResult(isPassed, percentage)
-    default:
-        interpretation, recommendation = getGenericResult(isPassed, percentage)
-    }
-    
-    return totalScore, maxPossibleScore, interpretation, recommendation, nil
-}
 
-// ОБЩИЙ РАСЧЕТ
-func calculateGenericScore(answers map[string]interface{}, passThreshold float64) (float64, float64, string, string, map[string]float64) {
-    totalScore := 50.0
-    maxPossibleScore := 100.0
-    percentage := 50.0
-    isPassed := percentage >= passThreshold
-    
-    interpretation, recommendation := getGenericResult(isPassed, percentage)
-    return totalScore, maxPossibleScore, interpretation, recommendation, nil
-}
+    sheet := scoreAnswers(questions, selected, lieScale)
+    isPassed := sheet.Percentage >= passThreshold
 
-// ФУНКЦИИ ФОРМИРОВАНИЯ РЕЗУЛЬТАТОВ
-func getRigidityResult(isPassed bool, percentage float64) (string, string) {
-    if isPassed {
-        return "✅ НИЗКИЙ УРОВЕНЬ РИГИДНОСТИ - ПРИГОДЕН", 
-               fmt.Sprintf("Результат: %.1f%%. Кандидат демонстрирует хорошую психологическую гибкость, способность адаптироваться к изменениям и переключаться между задачами. Подходит для работы в динамичной среде информационной безопасности.", percentage)
-    } else {
-        return "❌ ВЫСОКИЙ УРОВЕНЬ РИГИДНОСТИ - НЕ ПРИГОДЕН", 
-               fmt.Sprintf("Результат: %.1f%%. Выявлен повышенный уровень ригидности. Кандидат может испытывать трудности с адаптацией к изменениям и переключением между задачами, что критично для работы в ИБ.", percentage)
+    for _, scale := range sheet.Scales {
+        log.Printf("Шкала %s: %.1f/%.1f = %.1f%%", scale.ScaleName, scale.Score, scale.MaxScore, scale.Percentage)
     }
-}
+    log.Printf("=== ИТОГ теста %d: %.1f/%.1f = %.1f%%, Порог: %.1f%%, Пройден: %v ===",
+        testID, sheet.TotalScore, sheet.MaxScore, sheet.Percentage, passThreshold, isPassed)
 
-func getWillpowerResult(isPassed bool, percentage float64) (string, string) {
-    if isPassed {
-        return "✅ ВЫСОКИЙ УРОВЕНЬ ВОЛЕВОГО САМОКОНТРОЛЯ - ПРИГОДЕН", 
-               fmt.Sprintf("Результат: %.1f%%. Кандидат демонстрирует развитые волевые качества: настойчивость, самодисциплину, эмоциональную устойчивость. Способен эффективно работать в условиях стресса и неопределенности.", percentage)
-    } else {
-        return "❌ НИЗКИЙ УРОВЕНЬ ВОЛЕВОГО САМОКОНТРОЛЯ - НЕ ПРИГОДЕН", 
-               fmt.Sprintf("Результат: %.1f%%. Выявлены недостатки волевой регуляции: возможны проблемы с самодисциплиной, концентрацией внимания и работой в стрессовых ситуациях.", percentage)
-    }
-}
-
-func getPersonality16PFResult(isPassed bool, percentage float64) (string, string) {
-    if isPassed {
-        return "✅ БЛАГОПРИЯТНЫЙ ЛИЧНОСТНЫЙ ПРОФИЛЬ - ПРИГОДЕН", 
-               fmt.Sprintf("Результат: %.1f%%. Личностный профиль соответствует требованиям работы в информационной безопасности: эмоциональная стабильность, ответственность, самоконтроль.", percentage)
-    } else {
-        return "❌ НЕБЛАГОПРИЯТНЫЙ ЛИЧНОСТНЫЙ ПРОФИЛЬ - НЕ ПРИГОДЕН", 
-               fmt.Sprintf("Результат: %.1f%%. Личностный профиль не полностью соответствует требованиям работы в ИБ.", percentage)
+    // Интерпретация по настроенным диапазонам теста и его шкал
+    rules, err := loadInterpretationRules(versionID)
+    if err != nil {
+        log.Printf("Ошибка загрузки правил интерпретации теста %d: %v", testID, err)
     }
+    interpretation, recommendation := applyInterpretationRules(rules, &sheet, isPassed)
+
+    return &testScore{
+        scoreSheet:     sheet,
+        PassThreshold:  passThreshold,
+        IsPassed:       isPassed,
+        Interpretation: interpretation,
+        Recommendation: recommendation,
+    }, nil
 }
 
+// Стандартное заключение для тестов без настроенных правил интерпретации
 func getGenericResult(isPassed bool, percentage float64) (string, string) {
     if isPassed {
         return "✅ КАНДИДАТ ПРИГОДЕН", 
@@ -370,11 +330,8 @@ func SubmitTest(c *gin.Context) {
         return
     }
 
-    var submission struct {
-        Answers map[string]interface{} `json:"answers"`
-    }
-
-    if err := c.BindJSON(&submission); err != nil {
+    var submission models.SubmitTestRequest
+    if err := c.ShouldBindJSON(&submission); err != nil {
         c.JSON(http.StatusBadRequest, gin.H{"error": "Неверный формат данных"})
         return
     }
@@ -386,9 +343,12 @@ func SubmitTest(c *gin.Context) {
     }
 
     // Проверяем, существует ли тест
-    var testTitle string
+    var testTitle, latePolicy string
     var isActive bool
-    err = database.DB.QueryRow("SELECT title, is_active FROM psychological_tests WHERE id = $1", testID).Scan(&testTitle, &isActive)
+    err = database.DB.QueryRow(`
+        SELECT title, is_active AND archived_at IS NULL, COALESCE(late_submission_policy, 'flag')
+        FROM psychological_tests WHERE id = $1
+    `, testID).Scan(&testTitle, &isActive, &latePolicy)
     if err != nil {
         c.JSON(http.StatusNotFound, gin.H{"error": "Тест не найден"})
         return
@@ -399,67 +359,146 @@ func SubmitTest(c *gin.Context) {
         return
     }
 
+    // Результат принимается только в рамках сессии, начатой через /start
+    session, err := loadActiveSession(userID.(int), testID)
+    if err == sql.ErrNoRows {
+        c.JSON(http.StatusConflict, gin.H{"error": "Тест не был начат. Откройте тест заново"})
+        return
+    } else if err != nil {
+        c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка получения сессии"})
+        return
+    }
+
+    if session.Expired && latePolicy == models.LatePolicyReject {
+        expireSession(session.ID)
+        c.JSON(http.StatusBadRequest, gin.H{"error": "Время на прохождение теста истекло"})
+        return
+    }
+
+    // Лимит попыток и интервал проверяются и при сдаче: политика могла измениться после начала сессии
+    violation, err := checkAttemptPolicy(userID.(int), testID)
+    if err != nil {
+        c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка проверки попыток"})
+        return
+    }
+    if violation != "" {
+        c.JSON(http.StatusForbidden, gin.H{"error": violation})
+        return
+    }
+
     log.Printf("=== ОБРАБОТКА ТЕСТА %d: пользователь=%v, ответов=%d ===", 
         testID, userID, len(submission.Answers))
 
+    // Ответы проверяются и оцениваются по версии, на которой была начата попытка
+    versionID, err := resolveVersionID(testID, session.VersionID)
+    if err != nil {
+        log.Printf("ОШИБКА получения версии теста %d: %v", testID, err)
+        c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка получения вопросов"})
+        return
+    }
+    questions, err := loadScoringQuestions(testID, versionID)
+    if err != nil {
+        log.Printf("ОШИБКА загрузки вопросов теста %d: %v", testID, err)
+        c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка получения вопросов"})
+        return
+    }
+
+    // Проверяем ответы до того, как что-либо попадёт в БД
+    selected, fieldErrors := validateAnswers(questions, submission.Answers)
+    if len(fieldErrors) > 0 {
+        c.JSON(http.StatusBadRequest, gin.H{
+            "error":  "Ответы не прошли проверку",
+            "fields": fieldErrors,
+        })
+        return
+    }
+
+    // Настройки достоверности нужны до расчета: вопросы шкалы лжи не входят в итоговый балл
+    settings, err := loadValiditySettings(versionID)
+    if err != nil {
+        log.Printf("Ошибка загрузки настроек достоверности теста %d: %v", testID, err)
+        settings = nil
+    }
+    lieScale := ""
+    if settings != nil {
+        lieScale = settings.LieScale
+    }
+
     // Расчет баллов
-    score, maxScore, interpretation, _, scalePercentages := calculateProfessionalTestScore(submission.Answers, testID)
+    scored, err := calculateProfessionalTestScore(testID, versionID, questions, selected, lieScale)
+    if err != nil {
+        log.Printf("ОШИБКА расчета результата теста %d: %v", testID, err)
+        c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка расчета результата"})
+        return
+    }
+    score, maxScore, scaleResults := scored.TotalScore, scored.MaxScore, scored.Scales
+    interpretation, recommendation := scored.Interpretation, scored.Recommendation
+
+    // Стены, T-баллы и процентили по нормам, подходящим по полу и возрасту
+    if err := applyNorms(testID, userID.(int), scaleResults); err != nil {
+        log.Printf("Ошибка пересчета баллов по нормам теста %d: %v", testID, err)
+    }
 
     // Сохраняем результаты
-    percentage := (score / maxScore) * 100
-    var passThreshold float64
-    database.DB.QueryRow("SELECT pass_threshold FROM psychological_tests WHERE id = $1", testID).Scan(&passThreshold)
-    isPassed := percentage >= passThreshold
+    percentage, passThreshold, isPassed := scored.Percentage, scored.PassThreshold, scored.IsPassed
+
+    // Проверка достоверности: недостоверный результат не получает вердикта о пригодности
+    isValid := true
+    var validityFlags []string
+    if settings == nil {
+        log.Printf("Достоверность результата теста %d не проверялась", testID)
+    } else if validityFlags = checkValidity(settings, questions, selected, scaleResults, session.ElapsedSeconds); len(validityFlags) > 0 {
+        isValid, isPassed = false, false
+        interpretation = invalidResultInterpretation
+        recommendation = invalidResultRecommendation(validityFlags)
+        log.Printf("Результат теста %d недостоверен: %s", testID, strings.Join(validityFlags, "; "))
+    }
 
     log.Printf("=== ФИНАЛЬНЫЙ РЕЗУЛЬТАТ: баллы=%.1f/%.1f, процент=%.1f%%, порог=%.1f%%, пройден=%v ===", 
         score, maxScore, percentage, passThreshold, isPassed)
 
-    // Сохраняем в БД
-    var resultID int
-    err = database.DB.QueryRow(`
-        INSERT INTO test_results (user_id, test_id, total_score, max_possible_score, percentage, is_passed, interpretation, completed_at)
-        VALUES ($1, $2, $3, $4, $5, $6, $7, NOW()) RETURNING id
-    `, userID, testID, score, maxScore, percentage, isPassed, interpretation).Scan(&resultID)
-
-    if err != nil {
+    // Сохраняем результат, ответы и разбивку по шкалам одной транзакцией
+    resultID, attemptNumber, err := saveTestResult(models.TestResult{
+        UserID:           userID.(int),
+        TestID:           testID,
+        TotalScore:       score,
+        MaxPossibleScore: maxScore,
+        Percentage:       percentage,
+        IsPassed:         isPassed,
+        Interpretation:   interpretation,
+        Recommendation:   recommendation,
+        SessionID:        session.ID,
+        DurationSeconds:  session.ElapsedSeconds,
+        IsLate:           session.Expired,
+        VersionID:        versionID,
+        IsValid:          isValid,
+        ValidityFlags:    validityFlags,
+    }, scaleResults, questions, selected)
+    if err == errSessionClosed {
+        c.JSON(http.StatusConflict, gin.H{"error": "Результат этой попытки уже сохранен"})
+        return
+    } else if err != nil {
         log.Printf("ОШИБКА сохранения результата: %v", err)
         c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка сохранения результатов"})
         return
     }
 
-    // Сохраняем ответы пользователя
-    for questionKey, userAnswer := range submission.Answers {
-        questionOrder, _ := strconv.Atoi(questionKey)
-        
-        // Получаем реальный ID вопроса по order_index
-        var realQuestionID int
-        err := database.DB.QueryRow(`
-            SELECT id FROM test_questions 
-            WHERE test_id = $1 AND order_index = $2
-        `, testID, questionOrder).Scan(&realQuestionID)
-        
-        if err != nil {
-            log.Printf("Ошибка поиска вопроса с order_index=%d: %v", questionOrder, err)
-            continue
-        }
-        
-        if selectedOptionID, ok := userAnswer.(float64); ok {
-            database.DB.Exec(`
-                INSERT INTO user_answers (result_id, question_id, option_id)
-                VALUES ($1, $2, $3)
-            `, resultID, realQuestionID, int(selectedOptionID))
-        }
-    }
-
     log.Printf("=== ТЕСТ %d УСПЕШНО СОХРАНЕН: пользователь=%v, пройден=%t ===", testID, userID, isPassed)
 
     c.JSON(http.StatusOK, gin.H{
         "message": "Тест завершен",
         "result": gin.H{
+            "id":             resultID,
             "is_passed":      isPassed,
             "interpretation": interpretation,
+            "recommendation": recommendation,
             "test_title":     testTitle,
-            "scale_results":  scalePercentages,
+            "duration_seconds": session.ElapsedSeconds,
+            "is_late":        session.Expired,
+            "attempt_number": attemptNumber,
+            "is_valid":       isValid,
+            "validity_flags": validityFlags,
+            "scale_results":  scaleResults,
         },
     })
 }
\ No newline at end of file
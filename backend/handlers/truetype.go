@@ -0,0 +1,330 @@
+package handlers
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sort"
+)
+
+// Таблицы TrueType, которые нужны шрифту, встроенному в PDF (ISO 32000-1, 9.9)
+var trueTypeEmbeddedTables = []string{"cvt ", "fpgm", "glyf", "head", "hhea", "hmtx", "loca", "maxp", "prep"}
+
+// trueTypeFont - шрифт TrueType с данными, нужными для вёрстки и встраивания в PDF
+type trueTypeFont struct {
+	Name       string
+	UnitsPerEm int
+	BBox       [4]int // xMin, yMin, xMax, yMax
+	Ascent     int
+	Descent    int
+	tables     map[string][]byte
+	advances   []uint16
+	cmap       map[rune]uint16
+	loca       []uint32
+}
+
+var errTrueTypeFormat = errors.New("файл не является шрифтом TrueType")
+
+func trueTypeUint16(data []byte, offset int) uint16 {
+	if offset+2 > len(data) {
+		return 0
+	}
+	return binary.BigEndian.Uint16(data[offset:])
+}
+
+func trueTypeUint32(data []byte, offset int) uint32 {
+	if offset+4 > len(data) {
+		return 0
+	}
+	return binary.BigEndian.Uint32(data[offset:])
+}
+
+// parseTrueType разбирает таблицы шрифта, таблицу символов и метрики глифов
+func parseTrueType(name string, data []byte) (*trueTypeFont, error) {
+	if len(data) < 12 {
+		return nil, errTrueTypeFormat
+	}
+	if version := trueTypeUint32(data, 0); version != 0x00010000 && version != 0x74727565 {
+		return nil, errTrueTypeFormat
+	}
+
+	font := &trueTypeFont{Name: name, tables: make(map[string][]byte)}
+	numTables := int(trueTypeUint16(data, 4))
+	for i := 0; i < numTables; i++ {
+		record := 12 + i*16
+		if record+16 > len(data) {
+			return nil, errTrueTypeFormat
+		}
+		tag := string(data[record : record+4])
+		offset, length := int(trueTypeUint32(data, record+8)), int(trueTypeUint32(data, record+12))
+		if offset < 0 || length < 0 || offset+length > len(data) {
+			return nil, fmt.Errorf("таблица %q выходит за пределы файла", tag)
+		}
+		font.tables[tag] = data[offset : offset+length]
+	}
+	for _, tag := range []string{"head", "hhea", "maxp", "hmtx", "loca", "glyf", "cmap"} {
+		if font.tables[tag] == nil {
+			return nil, fmt.Errorf("в шрифте нет таблицы %q", tag)
+		}
+	}
+
+	// Поля читаются по фиксированным смещениям: indexToLocFormat в head на 50-м байте,
+	// numberOfHMetrics в hhea на 34-м
+	head, hhea := font.tables["head"], font.tables["hhea"]
+	if len(head) < 54 || len(hhea) < 36 {
+		return nil, errTrueTypeFormat
+	}
+	font.UnitsPerEm = int(trueTypeUint16(head, 18))
+	if font.UnitsPerEm == 0 {
+		return nil, errTrueTypeFormat
+	}
+	for i := range font.BBox {
+		font.BBox[i] = int(int16(trueTypeUint16(head, 36+i*2)))
+	}
+	font.Ascent = int(int16(trueTypeUint16(hhea, 4)))
+	font.Descent = int(int16(trueTypeUint16(hhea, 6)))
+
+	numGlyphs := int(trueTypeUint16(font.tables["maxp"], 4))
+	numMetrics := int(trueTypeUint16(hhea, 34))
+	hmtx := font.tables["hmtx"]
+	if numMetrics == 0 || len(hmtx) < numMetrics*4 {
+		return nil, errors.New("в шрифте повреждена таблица метрик глифов")
+	}
+	font.advances = make([]uint16, numGlyphs)
+	for gid := range font.advances {
+		// Глифы после numberOfHMetrics наследуют ширину последнего
+		font.advances[gid] = trueTypeUint16(hmtx, min(gid, numMetrics-1)*4)
+	}
+
+	loca := font.tables["loca"]
+	font.loca = make([]uint32, numGlyphs+1)
+	longLoca := trueTypeUint16(head, 50) == 1
+	for gid := range font.loca {
+		if longLoca {
+			font.loca[gid] = trueTypeUint32(loca, gid*4)
+		} else {
+			font.loca[gid] = uint32(trueTypeUint16(loca, gid*2)) * 2
+		}
+	}
+
+	cmap, err := parseTrueTypeCmap(font.tables["cmap"])
+	if err != nil {
+		return nil, err
+	}
+	font.cmap = cmap
+	return font, nil
+}
+
+// parseTrueTypeCmap читает юникодную таблицу символов: формат 12 (все плоскости) или формат 4
+func parseTrueTypeCmap(data []byte) (map[rune]uint16, error) {
+	var format4, format12 int
+	numTables := int(trueTypeUint16(data, 2))
+	for i := 0; i < numTables; i++ {
+		record := 4 + i*8
+		platform, encoding := trueTypeUint16(data, record), trueTypeUint16(data, record+2)
+		offset := int(trueTypeUint32(data, record+4))
+		unicode := platform == 0 || (platform == 3 && (encoding == 1 || encoding == 10))
+		if !unicode || offset >= len(data) {
+			continue
+		}
+		switch trueTypeUint16(data, offset) {
+		case 4:
+			format4 = offset
+		case 12:
+			format12 = offset
+		}
+	}
+
+	cmap := make(map[rune]uint16)
+	switch {
+	case format12 > 0:
+		groups := int(trueTypeUint32(data, format12+12))
+		for i := 0; i < groups; i++ {
+			group := format12 + 16 + i*12
+			start, end, glyph := trueTypeUint32(data, group), trueTypeUint32(data, group+4), trueTypeUint32(data, group+8)
+			for code := start; code <= end && code <= 0x10FFFF; code++ {
+				cmap[rune(code)] = uint16(glyph + code - start)
+			}
+		}
+	case format4 > 0:
+		segments := int(trueTypeUint16(data, format4+6)) / 2
+		endCodes := format4 + 14
+		startCodes := endCodes + segments*2 + 2
+		deltas := startCodes + segments*2
+		rangeOffsets := deltas + segments*2
+		for i := 0; i < segments; i++ {
+			start, end := int(trueTypeUint16(data, startCodes+i*2)), int(trueTypeUint16(data, endCodes+i*2))
+			delta := trueTypeUint16(data, deltas+i*2)
+			rangeOffset := int(trueTypeUint16(data, rangeOffsets+i*2))
+			for code := start; code <= end && code < 0xFFFF; code++ {
+				glyph := uint16(code) + delta
+				if rangeOffset != 0 {
+					glyph = trueTypeUint16(data, rangeOffsets+i*2+rangeOffset+(code-start)*2)
+					if glyph != 0 {
+						glyph += delta
+					}
+				}
+				if glyph != 0 {
+					cmap[rune(code)] = glyph
+				}
+			}
+		}
+	default:
+		return nil, errors.New("в шрифте нет юникодной таблицы символов")
+	}
+	return cmap, nil
+}
+
+// Glyph - номер глифа символа; 0 - символа в шрифте нет
+func (font *trueTypeFont) Glyph(r rune) uint16 {
+	return font.cmap[r]
+}
+
+// Advance - ширина глифа в единицах шрифта
+func (font *trueTypeFont) Advance(gid uint16) int {
+	if int(gid) >= len(font.advances) {
+		return 0
+	}
+	return int(font.advances[gid])
+}
+
+// glyphData - описание глифа из таблицы glyf
+func (font *trueTypeFont) glyphData(gid uint16) []byte {
+	glyf := font.tables["glyf"]
+	if int(gid)+1 >= len(font.loca) {
+		return nil
+	}
+	start, end := font.loca[gid], font.loca[gid+1]
+	if start >= end || int(end) > len(glyf) {
+		return nil
+	}
+	return glyf[start:end]
+}
+
+// compositeComponents - глифы, из которых собран составной глиф
+func compositeComponents(glyph []byte) []uint16 {
+	if len(glyph) < 10 || int16(trueTypeUint16(glyph, 0)) >= 0 {
+		return nil
+	}
+	var components []uint16
+	for offset := 10; offset+4 <= len(glyph); {
+		flags := trueTypeUint16(glyph, offset)
+		components = append(components, trueTypeUint16(glyph, offset+2))
+		offset += 4
+		if flags&0x0001 != 0 { // ARG_1_AND_2_ARE_WORDS
+			offset += 4
+		} else {
+			offset += 2
+		}
+		switch {
+		case flags&0x0008 != 0: // WE_HAVE_A_SCALE
+			offset += 2
+		case flags&0x0040 != 0: // WE_HAVE_AN_X_AND_Y_SCALE
+			offset += 4
+		case flags&0x0080 != 0: // WE_HAVE_A_TWO_BY_TWO
+			offset += 8
+		}
+		if flags&0x0020 == 0 { // MORE_COMPONENTS
+			break
+		}
+	}
+	return components
+}
+
+// trueTypeChecksum - контрольная сумма таблицы: сумма 32-битных слов
+func trueTypeChecksum(data []byte) uint32 {
+	var sum uint32
+	for i := 0; i < len(data); i += 4 {
+		var word [4]byte
+		copy(word[:], data[i:])
+		sum += binary.BigEndian.Uint32(word[:])
+	}
+	return sum
+}
+
+// Subset собирает шрифт только с указанными глифами. Номера глифов сохраняются,
+// описания неиспользуемых глифов удаляются - так шрифт в PDF занимает десятки килобайт.
+func (font *trueTypeFont) Subset(glyphs map[uint16]bool) []byte {
+	keep := map[uint16]bool{0: true}
+	pending := make([]uint16, 0, len(glyphs))
+	for gid := range glyphs {
+		pending = append(pending, gid)
+	}
+	for len(pending) > 0 {
+		gid := pending[len(pending)-1]
+		pending = pending[:len(pending)-1]
+		if keep[gid] && gid != 0 {
+			continue
+		}
+		keep[gid] = true
+		for _, component := range compositeComponents(font.glyphData(gid)) {
+			if !keep[component] {
+				pending = append(pending, component)
+			}
+		}
+	}
+
+	glyf := make([]byte, 0)
+	loca := make([]byte, 0, len(font.loca)*4)
+	for gid := 0; gid < len(font.loca)-1; gid++ {
+		loca = binary.BigEndian.AppendUint32(loca, uint32(len(glyf)))
+		if keep[uint16(gid)] {
+			glyf = append(glyf, font.glyphData(uint16(gid))...)
+			for len(glyf)%4 != 0 {
+				glyf = append(glyf, 0)
+			}
+		}
+	}
+	loca = binary.BigEndian.AppendUint32(loca, uint32(len(glyf)))
+
+	head := append([]byte{}, font.tables["head"]...)
+	binary.BigEndian.PutUint32(head[8:], 0)  // checkSumAdjustment пересчитывается ниже
+	binary.BigEndian.PutUint16(head[50:], 1) // длинный формат loca
+
+	tables := map[string][]byte{"glyf": glyf, "loca": loca, "head": head}
+	var tags []string
+	for _, tag := range trueTypeEmbeddedTables {
+		if tables[tag] == nil {
+			if font.tables[tag] == nil {
+				continue
+			}
+			tables[tag] = font.tables[tag]
+		}
+		tags = append(tags, tag)
+	}
+	sort.Strings(tags)
+
+	searchRange, entrySelector := 1, 0
+	for searchRange*2 <= len(tags) {
+		searchRange *= 2
+		entrySelector++
+	}
+	output := make([]byte, 12, 12+len(tags)*16)
+	binary.BigEndian.PutUint32(output[0:], 0x00010000)
+	binary.BigEndian.PutUint16(output[4:], uint16(len(tags)))
+	binary.BigEndian.PutUint16(output[6:], uint16(searchRange*16))
+	binary.BigEndian.PutUint16(output[8:], uint16(entrySelector))
+	binary.BigEndian.PutUint16(output[10:], uint16(len(tags)*16-searchRange*16))
+
+	offset := 12 + len(tags)*16
+	headOffset := 0
+	for _, tag := range tags {
+		data := tables[tag]
+		output = append(output, tag...)
+		output = binary.BigEndian.AppendUint32(output, trueTypeChecksum(data))
+		output = binary.BigEndian.AppendUint32(output, uint32(offset))
+		output = binary.BigEndian.AppendUint32(output, uint32(len(data)))
+		if tag == "head" {
+			headOffset = offset
+		}
+		offset += (len(data) + 3) &^ 3
+	}
+	for _, tag := range tags {
+		output = append(output, tables[tag]...)
+		for len(output)%4 != 0 {
+			output = append(output, 0)
+		}
+	}
+	binary.BigEndian.PutUint32(output[headOffset+8:], 0xB1B0AFBA-trueTypeChecksum(output))
+	return output
+}
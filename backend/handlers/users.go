@@ -2,8 +2,12 @@ package handlers
 
 import (
 	"database/sql"
+	"log"
 	"net/http"
 	"psycho-test-system/database"
+	"psycho-test-system/models"
+	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
 )
@@ -22,13 +26,17 @@ func GetUserProfile(c *gin.Context) {
 		FirstName  string `json:"first_name"`
 		Patronymic string `json:"patronymic"`
 		Role       string `json:"role"`
+		Gender     string `json:"gender"`
+		BirthDate  string `json:"birth_date"`
 	}
 
 	err := database.DB.QueryRow(`
-		SELECT id, email, last_name, first_name, patronymic, role 
+		SELECT id, email, last_name, first_name, patronymic, role,
+		       COALESCE(gender, ''), COALESCE(TO_CHAR(birth_date, 'YYYY-MM-DD'), '')
 		FROM users 
 		WHERE id = $1
-	`, userID).Scan(&user.ID, &user.Email, &user.LastName, &user.FirstName, &user.Patronymic, &user.Role)
+	`, userID).Scan(&user.ID, &user.Email, &user.LastName, &user.FirstName, &user.Patronymic, &user.Role,
+		&user.Gender, &user.BirthDate)
 
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка получения данных пользователя"})
@@ -48,6 +56,7 @@ func GetUserStats(c *gin.Context) {
     var stats struct {
         TestsCompleted  int    `json:"tests_completed"`
         LastTestDate    string `json:"last_test_date"`
+        Recommendation  string `json:"monthly_recommendation"`
     }
 
     // Количество пройденных тестов за последний месяц
@@ -75,43 +84,74 @@ func GetUserStats(c *gin.Context) {
         stats.LastTestDate = lastDate.String
     }
 
+    // Рекомендация строится по интерпретациям тестов и значимым изменениям при повторных прохождениях
+    tests, err := loadUserProgress(userID.(int), 0)
+    if err != nil {
+        log.Printf("Ошибка загрузки истории прохождений пользователя %d: %v", userID, err)
+        stats.Recommendation = "Рекомендации временно недоступны"
+    } else {
+        stats.Recommendation = getMonthlyRecommendation(tests)
+    }
+
     c.JSON(http.StatusOK, gin.H{
         "stats": stats,
     })
 }
 
-// getMonthlyRecommendation возвращает рекомендацию на основе среднего балла за последний месяц
-func getMonthlyRecommendation(userID int) string {
-	var avgScore sql.NullFloat64
-	
-	// Получаем средний балл за все тесты за последний месяц (нормализованный к шкале 1-5)
-	err := database.DB.QueryRow(`
-		SELECT AVG((score/max_score) * 5)
-		FROM test_results 
-		WHERE user_id = $1 AND completed_at >= NOW() - INTERVAL '30 days'
-	`, userID).Scan(&avgScore)
-
-	if err != nil || !avgScore.Valid {
-		return "Пройдите первый тест для получения рекомендаций"
+// getMonthlyRecommendation собирает рекомендации по последним достоверным попыткам за месяц
+// (tests - история из loadUserProgress). Для каждого теста берётся рекомендация по его
+// собственной интерпретации и отмечаются надёжные изменения относительно прошлого прохождения.
+// Проценты разных методик несопоставимы, поэтому результаты тестов не усредняются.
+func getMonthlyRecommendation(tests []models.TestProgress) string {
+	monthAgo := time.Now().AddDate(0, 0, -30)
+	var parts []string
+	attempted := false
+	for _, test := range tests {
+		if len(test.Attempts) > 0 {
+			attempted = true
+		}
+		var latest *models.ProgressAttempt
+		for i := len(test.Attempts) - 1; i >= 0; i-- {
+			if test.Attempts[i].IsValid {
+				latest = &test.Attempts[i]
+				break
+			}
+		}
+		if latest == nil || !latest.CompletedAt.After(monthAgo) {
+			continue
+		}
+
+		text := latest.Recommendation
+		if text == "" {
+			text = latest.Interpretation
+		}
+		part := "«" + test.TestTitle + "»: " + text
+		if change := latest.Change; change != nil && change.Significant {
+			if change.Direction == "down" {
+				part += " Результат заметно снизился по сравнению с прошлым прохождением - обсудите изменения со специалистом."
+			} else if change.Direction == "up" {
+				part += " Результат заметно вырос по сравнению с прошлым прохождением."
+			}
+		}
+		var changed []string
+		for _, scale := range latest.Scales {
+			if scale.Change != nil && scale.Change.Significant && scale.Change.Direction != "none" {
+				changed = append(changed, scale.ScaleName)
+			}
+		}
+		if len(changed) > 0 {
+			part += " Заметно изменились шкалы: " + strings.Join(changed, ", ") + "."
+		}
+		parts = append(parts, part)
 	}
 
-	// Определяем рекомендацию на основе среднего балла
-	switch {
-	case avgScore.Float64 >= 4.5:
-		return "Отличные результаты! Ваше психологическое состояние стабильно на высоком уровне. Продолжайте следить за балансом работы и отдыха."
-	
-	case avgScore.Float64 >= 3.5:
-		return "Хорошие показатели. Рекомендуется поддерживать текущие практики и обращать внимание на периоды повышенного стресса."
-	
-	case avgScore.Float64 >= 2.5:
-		return "Стабильное состояние с периодами напряжения. Рекомендуется внедрить регулярные практики релаксации и следить за режимом дня."
-	
-	case avgScore.Float64 >= 1.5:
-		return "Требуется внимание к психологическому состоянию. Рекомендуется консультация специалиста и регулярный мониторинг самочувствия."
-	
-	default:
-		return "Рекомендуется профессиональная консультация. Ваше состояние требует внимания специалиста."
+	if len(parts) == 0 {
+		if attempted {
+			return "За последний месяц нет достоверных прохождений. Пройдите тест повторно, чтобы получить актуальные рекомендации"
+		}
+		return "Пройдите первый тест для получения рекомендаций"
 	}
+	return strings.Join(parts, " ")
 }
 
 func UpdateUserProfile(c *gin.Context) {
@@ -126,6 +166,10 @@ func UpdateUserProfile(c *gin.Context) {
 		FirstName  string `json:"first_name"`
 		Patronymic string `json:"patronymic"`
 		Email      string `json:"email"`
+		// Пол и дата рождения нужны для выбора нормативной группы.
+		// Не переданные поля не меняются, пустая строка очищает значение.
+		Gender    *string `json:"gender"`
+		BirthDate *string `json:"birth_date"`
 	}
 
 	if err := c.ShouldBindJSON(&updateData); err != nil {
@@ -133,11 +177,27 @@ func UpdateUserProfile(c *gin.Context) {
 		return
 	}
 
+	if updateData.Gender != nil && *updateData.Gender != "" &&
+		*updateData.Gender != models.GenderMale && *updateData.Gender != models.GenderFemale {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Неверное значение пола"})
+		return
+	}
+	if updateData.BirthDate != nil && *updateData.BirthDate != "" {
+		birthDate, err := time.Parse("2006-01-02", *updateData.BirthDate)
+		if err != nil || birthDate.After(time.Now()) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Неверная дата рождения"})
+			return
+		}
+	}
+
 	_, err := database.DB.Exec(`
 		UPDATE users 
-		SET last_name = $1, first_name = $2, patronymic = $3, email = $4 
+		SET last_name = $1, first_name = $2, patronymic = $3, email = $4,
+		    gender = CASE WHEN $6::text IS NULL THEN gender ELSE NULLIF($6, '') END,
+		    birth_date = CASE WHEN $7::text IS NULL THEN birth_date ELSE NULLIF($7, '')::date END
 		WHERE id = $5
-	`, updateData.LastName, updateData.FirstName, updateData.Patronymic, updateData.Email, userID)
+	`, updateData.LastName, updateData.FirstName, updateData.Patronymic, updateData.Email, userID,
+		updateData.Gender, updateData.BirthDate)
 
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка обновления профиля"})
@@ -0,0 +1,331 @@
+package handlers
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"psycho-test-system/database"
+	"psycho-test-system/models"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Меньше ответов - однообразие не проверяется: на коротком тесте совпадения случайны
+const minStraightLineAnswers = 5
+
+// Заключение для результата, не прошедшего проверку достоверности
+const invalidResultInterpretation = "⚠️ РЕЗУЛЬТАТ НЕДОСТОВЕРЕН"
+
+// loadValiditySettings загружает настройки проверки достоверности версии теста
+func loadValiditySettings(versionID int) (*models.ValiditySettings, error) {
+	var settings models.ValiditySettings
+	err := database.DB.QueryRow(`
+		SELECT COALESCE(lie_scale, ''), COALESCE(lie_threshold, 0), COALESCE(max_inconsistencies, 0),
+		       COALESCE(straight_line_threshold, 0), COALESCE(min_duration_seconds, 0)
+		FROM test_versions WHERE id = $1
+	`, versionID).Scan(&settings.LieScale, &settings.LieThreshold, &settings.MaxInconsistencies,
+		&settings.StraightLineThreshold, &settings.MinDurationSeconds)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := database.DB.Query(`
+		SELECT question_a, question_b, is_opposite, max_difference
+		FROM inconsistency_pairs
+		WHERE version_id = $1
+		ORDER BY id
+	`, versionID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	settings.InconsistencyPairs = []models.InconsistencyPair{}
+	for rows.Next() {
+		var pair models.InconsistencyPair
+		if err := rows.Scan(&pair.QuestionA, &pair.QuestionB, &pair.IsOpposite, &pair.MaxDifference); err != nil {
+			return nil, err
+		}
+		settings.InconsistencyPairs = append(settings.InconsistencyPairs, pair)
+	}
+	return &settings, rows.Err()
+}
+
+// answerPosition - номер выбранного варианта или значение шкалы Лайкерта по порядку.
+// Для остальных типов вопросов позиция не определена.
+func answerPosition(question *scoringQuestion, answer models.AnswerSubmission) (int, bool) {
+	switch question.QuestionType {
+	case models.QuestionSingleChoice:
+		position, exists := question.Positions[answer.OptionID]
+		return position, exists
+	case models.QuestionLikert:
+		if answer.Value == nil {
+			return 0, false
+		}
+		return int(math.Round(*answer.Value)) - question.ScaleMin + 1, true
+	}
+	return 0, false
+}
+
+// answerLevel - балл ответа с учётом ключа вопроса, приведённый к диапазону от 0 до 1
+func answerLevel(question *scoringQuestion, answer models.AnswerSubmission) (float64, bool) {
+	keyed := question.withKey(question.Reversed)
+
+	var minScore, maxScore float64
+	switch question.QuestionType {
+	case models.QuestionSingleChoice:
+		if _, exists := question.Options[answer.OptionID]; !exists {
+			return 0, false
+		}
+		minScore, maxScore = float64(question.MaxValue), float64(question.MaxValue)
+		for _, value := range question.Options {
+			minScore = math.Min(minScore, float64(value))
+		}
+	case models.QuestionLikert:
+		if answer.Value == nil {
+			return 0, false
+		}
+		minScore, maxScore = float64(question.ScaleMin), float64(question.ScaleMax)
+	default:
+		return 0, false
+	}
+
+	if maxScore <= minScore {
+		return 0, false
+	}
+	return (questionScore(keyed, answer) - minScore) / (maxScore - minScore), true
+}
+
+// checkValidity проверяет ответы на признаки недостоверности и возвращает их описание.
+// Пустой список - результат достоверен.
+func checkValidity(settings *models.ValiditySettings, questions []*scoringQuestion,
+	selected map[int]models.AnswerSubmission, scales []models.ScaleResult, durationSeconds int) []string {
+	var flags []string
+
+	if settings.LieScale != "" && settings.LieThreshold > 0 {
+		for _, scale := range scales {
+			if scale.ScaleName == settings.LieScale && scale.Percentage >= settings.LieThreshold {
+				flags = append(flags, fmt.Sprintf("Высокий балл по шкале лжи «%s»: %.1f%% (порог %.1f%%)",
+					scale.ScaleName, scale.Percentage, settings.LieThreshold))
+			}
+		}
+	}
+
+	if len(settings.InconsistencyPairs) > 0 {
+		byNumber := make(map[int]*scoringQuestion, len(questions))
+		for _, question := range questions {
+			byNumber[question.OrderIndex] = question
+		}
+
+		inconsistent := 0
+		for _, pair := range settings.InconsistencyPairs {
+			questionA, questionB := byNumber[pair.QuestionA], byNumber[pair.QuestionB]
+			if questionA == nil || questionB == nil {
+				continue
+			}
+			answerA, answeredA := selected[questionA.ID]
+			answerB, answeredB := selected[questionB.ID]
+			if !answeredA || !answeredB {
+				continue
+			}
+			levelA, okA := answerLevel(questionA, answerA)
+			levelB, okB := answerLevel(questionB, answerB)
+			if !okA || !okB {
+				continue
+			}
+			if pair.IsOpposite {
+				levelB = 1 - levelB
+			}
+			if math.Abs(levelA-levelB)*100 > pair.MaxDifference {
+				inconsistent++
+			}
+		}
+		if inconsistent > settings.MaxInconsistencies {
+			flags = append(flags, fmt.Sprintf("Противоречивые ответы в %d парах вопросов (допустимо %d)",
+				inconsistent, settings.MaxInconsistencies))
+		}
+	}
+
+	if settings.StraightLineThreshold > 0 {
+		counts := make(map[int]int)
+		total, most := 0, 0
+		for _, question := range questions {
+			answer, answered := selected[question.ID]
+			if !answered {
+				continue
+			}
+			position, ok := answerPosition(question, answer)
+			if !ok {
+				continue
+			}
+			total++
+			counts[position]++
+			if counts[position] > most {
+				most = counts[position]
+			}
+		}
+		if total >= minStraightLineAnswers && percentOf(float64(most), float64(total)) >= settings.StraightLineThreshold {
+			flags = append(flags, fmt.Sprintf("Однотипные ответы: %d из %d вопросов с одинаковым вариантом", most, total))
+		}
+	}
+
+	if settings.MinDurationSeconds > 0 && durationSeconds < settings.MinDurationSeconds {
+		flags = append(flags, fmt.Sprintf("Слишком быстрое прохождение: %d с при минимуме %d с",
+			durationSeconds, settings.MinDurationSeconds))
+	}
+
+	return flags
+}
+
+// invalidResultRecommendation - рекомендация вместо вердикта для недостоверного результата
+func invalidResultRecommendation(flags []string) string {
+	return "Результат не может быть интерпретирован: " + strings.Join(flags, "; ") +
+		". Рекомендуется повторное тестирование."
+}
+
+func validateValiditySettings(settings *models.ValiditySettings, questionsCount int) string {
+	settings.LieScale = strings.TrimSpace(settings.LieScale)
+	if settings.LieScale != "" && (settings.LieThreshold <= 0 || settings.LieThreshold > 100) {
+		return "Порог шкалы лжи должен быть от 0 до 100%"
+	}
+	if settings.StraightLineThreshold < 0 || settings.StraightLineThreshold > 100 {
+		return "Порог однотипных ответов должен быть от 0 до 100%"
+	}
+	if settings.MinDurationSeconds < 0 {
+		return "Минимальное время прохождения не может быть отрицательным"
+	}
+	if settings.MaxInconsistencies < 0 {
+		return "Допустимое число противоречий не может быть отрицательным"
+	}
+	for i, pair := range settings.InconsistencyPairs {
+		if pair.QuestionA < 1 || pair.QuestionA > questionsCount || pair.QuestionB < 1 || pair.QuestionB > questionsCount {
+			return fmt.Sprintf("Пара №%d: пункта нет в тесте", i+1)
+		}
+		if pair.QuestionA == pair.QuestionB {
+			return fmt.Sprintf("Пара №%d: пункты должны различаться", i+1)
+		}
+		if pair.MaxDifference < 0 || pair.MaxDifference > 100 {
+			return fmt.Sprintf("Пара №%d: допустимое расхождение должно быть от 0 до 100%%", i+1)
+		}
+	}
+	return ""
+}
+
+// writeValiditySettings сохраняет настройки достоверности версии теста вместе с парами вопросов
+// в рамках транзакции. Если версии нет, возвращает sql.ErrNoRows.
+func writeValiditySettings(tx *sql.Tx, testID, versionID int, settings *models.ValiditySettings) error {
+	result, err := tx.Exec(`
+		UPDATE test_versions
+		SET lie_scale = $1, lie_threshold = $2, max_inconsistencies = $3,
+		    straight_line_threshold = $4, min_duration_seconds = $5
+		WHERE id = $6
+	`, settings.LieScale, settings.LieThreshold, settings.MaxInconsistencies,
+		settings.StraightLineThreshold, settings.MinDurationSeconds, versionID)
+	if err != nil {
+		return err
+	}
+	if affected, _ := result.RowsAffected(); affected == 0 {
+		return sql.ErrNoRows
+	}
+
+	if _, err := tx.Exec("DELETE FROM inconsistency_pairs WHERE version_id = $1", versionID); err != nil {
+		return fmt.Errorf("ошибка удаления пар вопросов: %v", err)
+	}
+
+	rows := make([][]interface{}, 0, len(settings.InconsistencyPairs))
+	for _, pair := range settings.InconsistencyPairs {
+		rows = append(rows, []interface{}{testID, versionID, pair.QuestionA, pair.QuestionB, pair.IsOpposite, pair.MaxDifference})
+	}
+	return insertBatch(tx, "inconsistency_pairs",
+		[]string{"test_id", "version_id", "question_a", "question_b", "is_opposite", "max_difference"}, rows)
+}
+
+// Получение настроек проверки достоверности текущей версии теста
+func GetValiditySettings(c *gin.Context) {
+	testID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Неверный ID теста"})
+		return
+	}
+
+	versionID, err := resolveVersionID(testID, 0)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Тест не найден"})
+		return
+	}
+	settings, err := loadValiditySettings(versionID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Тест не найден"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"validity": settings})
+}
+
+// Замена настроек проверки достоверности теста вместе со списком пар вопросов.
+// Если по текущей версии уже начинались попытки, настройки сохраняются в новой версии.
+func SetValiditySettings(c *gin.Context) {
+	testID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Неверный ID теста"})
+		return
+	}
+
+	var settings models.ValiditySettings
+	if err := c.ShouldBindJSON(&settings); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Неверный формат данных"})
+		return
+	}
+
+	var questionsCount int
+	err = database.DB.QueryRow(`
+		SELECT COUNT(*) FROM test_questions
+		WHERE version_id = (SELECT current_version_id FROM psychological_tests WHERE id = $1)
+	`, testID).Scan(&questionsCount)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка получения вопросов"})
+		return
+	}
+	if message := validateValiditySettings(&settings, questionsCount); message != "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": message})
+		return
+	}
+
+	tx, err := database.DB.Begin()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка начала транзакции"})
+		return
+	}
+	defer tx.Rollback()
+
+	userID, _ := c.Get("userID")
+	versionID, versionNumber, err := editableVersion(tx, testID, userID, "Изменение настроек достоверности")
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Тест не найден"})
+		return
+	} else if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка создания версии теста"})
+		return
+	}
+
+	err = writeValiditySettings(tx, testID, versionID, &settings)
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Тест не найден"})
+		return
+	} else if err != nil {
+		log.Printf("Ошибка сохранения настроек достоверности теста %d: %v", testID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка сохранения настроек"})
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка завершения операции"})
+		return
+	}
+
+	respondVersionedChange(c, "Настройки достоверности сохранены", versionNumber)
+}
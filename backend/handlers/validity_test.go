@@ -0,0 +1,93 @@
+package handlers
+
+import (
+	"psycho-test-system/models"
+	"strings"
+	"testing"
+)
+
+// validityQuestions - count вопросов с одним вариантом; варианты 1, 2, 3 стоят на позициях 1, 2, 3
+func validityQuestions(count int) []*scoringQuestion {
+	questions := make([]*scoringQuestion, 0, count)
+	for i := 1; i <= count; i++ {
+		question := scaleQuestion(i, "E", 1)
+		question.Positions = map[int]int{1: 1, 2: 2, 3: 3}
+		questions = append(questions, question)
+	}
+	return questions
+}
+
+// chosenOptions - ответы на вопросы по порядку: ID выбранного варианта
+func chosenOptions(optionIDs ...int) map[int]models.AnswerSubmission {
+	selected := make(map[int]models.AnswerSubmission, len(optionIDs))
+	for i, optionID := range optionIDs {
+		selected[i+1] = models.AnswerSubmission{QuestionID: i + 1, OptionID: optionID}
+	}
+	return selected
+}
+
+func TestCheckValidity(t *testing.T) {
+	questions := validityQuestions(6)
+	varied := chosenOptions(1, 2, 3, 1, 2, 3)
+
+	tests := []struct {
+		name     string
+		settings models.ValiditySettings
+		selected map[int]models.AnswerSubmission
+		scales   []models.ScaleResult
+		duration int
+		flag     string // начало ожидаемого признака; пусто - результат достоверен
+	}{
+		{"без настроек", models.ValiditySettings{}, chosenOptions(1, 1, 1, 1, 1, 1), nil, 1, ""},
+		{"шкала лжи выше порога", models.ValiditySettings{LieScale: "L", LieThreshold: 70}, varied,
+			[]models.ScaleResult{{ScaleName: "L", Percentage: 80}}, 600, "Высокий балл по шкале лжи"},
+		{"шкала лжи ниже порога", models.ValiditySettings{LieScale: "L", LieThreshold: 70}, varied,
+			[]models.ScaleResult{{ScaleName: "L", Percentage: 60}}, 600, ""},
+		// Баллы 0 и 3 - противоположные концы шкалы ответа
+		{"противоречие в прямой паре", models.ValiditySettings{
+			InconsistencyPairs: []models.InconsistencyPair{{QuestionA: 1, QuestionB: 3, MaxDifference: 50}},
+		}, varied, nil, 600, "Противоречивые ответы в 1 парах"},
+		{"согласованная обратная пара", models.ValiditySettings{
+			InconsistencyPairs: []models.InconsistencyPair{{QuestionA: 1, QuestionB: 3, IsOpposite: true, MaxDifference: 50}},
+		}, varied, nil, 600, ""},
+		{"противоречия в пределах допустимого", models.ValiditySettings{
+			InconsistencyPairs: []models.InconsistencyPair{{QuestionA: 1, QuestionB: 3, MaxDifference: 50}},
+			MaxInconsistencies: 1,
+		}, varied, nil, 600, ""},
+		{"однотипные ответы", models.ValiditySettings{StraightLineThreshold: 80}, chosenOptions(2, 2, 2, 2, 2, 1), nil, 600,
+			"Однотипные ответы: 5 из 6"},
+		{"разнообразные ответы", models.ValiditySettings{StraightLineThreshold: 80}, varied, nil, 600, ""},
+		{"однотипных ответов слишком мало для проверки", models.ValiditySettings{StraightLineThreshold: 80},
+			chosenOptions(2, 2, 2, 2), nil, 600, ""},
+		{"слишком быстрое прохождение", models.ValiditySettings{MinDurationSeconds: 60}, varied, nil, 30, "Слишком быстрое прохождение"},
+		{"время прохождения достаточное", models.ValiditySettings{MinDurationSeconds: 60}, varied, nil, 60, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			flags := checkValidity(&tt.settings, questions, tt.selected, tt.scales, tt.duration)
+			if tt.flag == "" {
+				if len(flags) > 0 {
+					t.Errorf("неожиданные признаки недостоверности: %v", flags)
+				}
+				return
+			}
+			if len(flags) != 1 || !strings.HasPrefix(flags[0], tt.flag) {
+				t.Errorf("признаки = %v, ожидался «%s…»", flags, tt.flag)
+			}
+		})
+	}
+}
+
+func TestScoreAnswersExcludesLieScale(t *testing.T) {
+	questions := validityQuestions(2)
+	questions[1].Keys = []scaleKey{{Scale: "L"}}
+
+	sheet := scoreAnswers(questions, chosenOptions(2, 3), "L")
+	if sheet.TotalScore != 1 || sheet.MaxScore != 3 {
+		t.Errorf("итог = %v из %v, вопрос шкалы лжи не должен входить в итог", sheet.TotalScore, sheet.MaxScore)
+	}
+	if len(sheet.Scales) != 2 || sheet.Scales[1].ScaleName != "L" || sheet.Scales[1].Score != 3 {
+		t.Errorf("шкала лжи должна считаться отдельно: %+v", sheet.Scales)
+	}
+}
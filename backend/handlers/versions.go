@@ -0,0 +1,465 @@
+package handlers
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"psycho-test-system/database"
+	"psycho-test-system/models"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Наибольший вес вопроса, который помещается в test_questions.weight DECIMAL(3,2)
+const maxQuestionWeight = 9.99
+
+// normalizeQuestionInputs приводит вопросы редактора к виду, в котором они хранятся,
+// и проверяет их. Возвращает текст ошибки или пустую строку.
+func normalizeQuestionInputs(questions []models.QuestionInput) string {
+	for i := range questions {
+		question := &questions[i]
+		question.QuestionType = normalizeQuestionType(question.QuestionType)
+		question.ScoringKey = normalizeScoringKey(question.ScoringKey)
+		// Вес не передан - вопрос считается с обычным весом, как по умолчанию в базе
+		if question.Weight == 0 {
+			question.Weight = 1
+		}
+		// Вес хранится с точностью до сотых, иначе каждое сохранение выглядело бы изменением
+		question.Weight = math.Round(question.Weight*100) / 100
+		if !(question.Weight > 0 && question.Weight <= maxQuestionWeight) {
+			return fmt.Sprintf("Вопрос №%d: вес должен быть больше 0 и не больше %.2f", i+1, maxQuestionWeight)
+		}
+		// Если флаг не передан, вопрос считается обязательным
+		isRequired := question.IsRequired == nil || *question.IsRequired
+		question.IsRequired = &isRequired
+		if message := validateQuestionDefinition(question.QuestionType, len(question.Options), &question.MinValue, &question.MaxValue); message != "" {
+			return fmt.Sprintf("Вопрос №%d: %s", i+1, message)
+		}
+	}
+	return ""
+}
+
+// insertVersionQuestions сохраняет вопросы и варианты ответов версии теста
+func insertVersionQuestions(tx *sql.Tx, testID, versionID int, questions []models.QuestionInput) error {
+	for i, question := range questions {
+		var questionID int
+		err := tx.QueryRow(`
+			INSERT INTO test_questions (test_id, version_id, question_text, question_type, scale_type, weight, order_index,
+			                            is_required, min_value, max_value, scoring_key)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11) RETURNING id
+		`, testID, versionID, question.QuestionText, question.QuestionType, question.ScaleType, question.Weight, i+1,
+			question.IsRequired == nil || *question.IsRequired, question.MinValue, question.MaxValue, question.ScoringKey).Scan(&questionID)
+		if err != nil {
+			return fmt.Errorf("ошибка сохранения вопроса №%d: %v", i+1, err)
+		}
+
+		rows := make([][]interface{}, 0, len(question.Options))
+		for j, option := range question.Options {
+			rows = append(rows, []interface{}{questionID, option.OptionText, option.ScoreValue, j + 1})
+		}
+		if err := insertBatch(tx, "question_options", []string{"question_id", "option_text", "score_value", "order_index"}, rows); err != nil {
+			return fmt.Errorf("ошибка сохранения вариантов вопроса №%d: %v", i+1, err)
+		}
+	}
+	return nil
+}
+
+// createTestVersion создаёт следующую версию теста с указанными вопросами и делает её текущей.
+// Название и порог прохождения берутся из теста на момент создания версии, настройки
+// достоверности, ключи шкал и правила интерпретации копируются из версии sourceID (0 - не копируются).
+// Возвращает ID и номер созданной версии.
+func createTestVersion(tx *sql.Tx, testID int, userID interface{}, comment string, questions []models.QuestionInput,
+	sourceID int) (int, int, error) {
+	var versionID, versionNumber int
+	err := tx.QueryRow(`
+		INSERT INTO test_versions (test_id, version_number, title, pass_threshold, comment, created_by)
+		SELECT id, COALESCE((SELECT MAX(version_number) FROM test_versions WHERE test_id = $1), 0) + 1,
+		       title, pass_threshold, $2, $3
+		FROM psychological_tests WHERE id = $1
+		RETURNING id, version_number
+	`, testID, comment, userID).Scan(&versionID, &versionNumber)
+	if err != nil {
+		return 0, 0, fmt.Errorf("ошибка создания версии теста: %v", err)
+	}
+
+	if err := insertVersionQuestions(tx, testID, versionID, questions); err != nil {
+		return 0, 0, err
+	}
+	if sourceID != 0 {
+		if err := copyVersionConfig(tx, sourceID, versionID); err != nil {
+			return 0, 0, err
+		}
+	}
+
+	if _, err := tx.Exec("UPDATE psychological_tests SET current_version_id = $1 WHERE id = $2", versionID, testID); err != nil {
+		return 0, 0, fmt.Errorf("ошибка переключения версии теста: %v", err)
+	}
+	return versionID, versionNumber, nil
+}
+
+// copyVersionConfig копирует настройки подсчёта версии fromID в версию toID: пороги
+// достоверности, пары вопросов, ключи шкал и правила интерпретации (в порядке их ID)
+func copyVersionConfig(tx *sql.Tx, fromID, toID int) error {
+	_, err := tx.Exec(`
+		UPDATE test_versions v
+		SET lie_scale = src.lie_scale, lie_threshold = src.lie_threshold, max_inconsistencies = src.max_inconsistencies,
+		    straight_line_threshold = src.straight_line_threshold, min_duration_seconds = src.min_duration_seconds
+		FROM test_versions src
+		WHERE v.id = $2 AND src.id = $1
+	`, fromID, toID)
+	if err != nil {
+		return fmt.Errorf("ошибка копирования настроек достоверности: %v", err)
+	}
+
+	copies := []struct{ table, columns string }{
+		{"inconsistency_pairs", "test_id, question_a, question_b, is_opposite, max_difference"},
+		{"scale_keys", "test_id, scale_type, question_number, is_reverse"},
+		{"interpretation_rules", "test_id, scale_type, min_percentage, max_percentage, interpretation, recommendation"},
+	}
+	for _, table := range copies {
+		_, err := tx.Exec(fmt.Sprintf(`
+			INSERT INTO %[1]s (version_id, %[2]s)
+			SELECT $2, %[2]s FROM %[1]s WHERE version_id = $1 ORDER BY id
+		`, table.table, table.columns), fromID, toID)
+		if err != nil {
+			return fmt.Errorf("ошибка копирования %s: %v", table.table, err)
+		}
+	}
+	return nil
+}
+
+// resolveVersionID возвращает versionID или, если он равен 0, текущую версию теста
+func resolveVersionID(testID, versionID int) (int, error) {
+	if versionID != 0 {
+		return versionID, nil
+	}
+	err := database.DB.QueryRow(`
+		SELECT COALESCE(current_version_id, 0) FROM psychological_tests WHERE id = $1
+	`, testID).Scan(&versionID)
+	return versionID, err
+}
+
+// editableVersion возвращает версию, в которой можно менять настройки подсчёта теста.
+// Текущая версия меняется на месте, пока по ней не начато ни одной попытки; иначе создаётся
+// новая версия с теми же вопросами и настройками, чтобы начатые и завершённые попытки
+// оценивались так, как при их начале. Возвращает ID версии и номер новой версии (0 - не создавалась).
+// Если теста нет, возвращает sql.ErrNoRows.
+func editableVersion(tx *sql.Tx, testID int, userID interface{}, comment string) (int, int, error) {
+	var currentID int
+	var inUse bool
+	err := tx.QueryRow(`
+		SELECT pt.current_version_id,
+		       EXISTS(SELECT 1 FROM test_sessions s WHERE s.version_id = pt.current_version_id)
+		       OR EXISTS(SELECT 1 FROM test_results tr WHERE tr.version_id = pt.current_version_id)
+		FROM psychological_tests pt
+		WHERE pt.id = $1 AND pt.current_version_id IS NOT NULL
+		FOR UPDATE OF pt
+	`, testID).Scan(&currentID, &inUse)
+	if err != nil {
+		return 0, 0, err
+	}
+	if !inUse {
+		return currentID, 0, nil
+	}
+
+	questions, err := loadVersionQuestions(currentID)
+	if err != nil {
+		return 0, 0, fmt.Errorf("ошибка загрузки вопросов: %v", err)
+	}
+	return createTestVersion(tx, testID, userID, comment, questions, currentID)
+}
+
+// respondVersionedChange отвечает об изменении настроек подсчёта; versionNumber > 0 - создана новая версия
+func respondVersionedChange(c *gin.Context, message string, versionNumber int) {
+	if versionNumber > 0 {
+		c.JSON(http.StatusOK, gin.H{"message": fmt.Sprintf("%s, создана версия %d", message, versionNumber), "version_number": versionNumber})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": message})
+}
+
+// loadVersionQuestions загружает вопросы версии в том виде, в каком их принимает редактор
+func loadVersionQuestions(versionID int) ([]models.QuestionInput, error) {
+	rows, err := database.DB.Query(`
+		SELECT q.id, q.question_text, COALESCE(q.question_type, ''), q.scale_type, COALESCE(q.weight, 1.0),
+		       COALESCE(q.is_required, true), COALESCE(q.min_value, 0), COALESCE(q.max_value, 0),
+		       COALESCE(q.scoring_key, ''), o.option_text, o.score_value
+		FROM test_questions q
+		LEFT JOIN question_options o ON o.question_id = q.id
+		WHERE q.version_id = $1
+		ORDER BY q.order_index, o.order_index
+	`, versionID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	questions := []models.QuestionInput{}
+	lastQuestionID := 0
+	for rows.Next() {
+		var questionID int
+		var question models.QuestionInput
+		var isRequired bool
+		var optionText sql.NullString
+		var scoreValue sql.NullInt64
+		if err := rows.Scan(&questionID, &question.QuestionText, &question.QuestionType, &question.ScaleType, &question.Weight,
+			&isRequired, &question.MinValue, &question.MaxValue, &question.ScoringKey, &optionText, &scoreValue); err != nil {
+			return nil, err
+		}
+
+		if questionID != lastQuestionID {
+			question.QuestionType = normalizeQuestionType(question.QuestionType)
+			question.ScoringKey = normalizeScoringKey(question.ScoringKey)
+			question.IsRequired = &isRequired
+			questions = append(questions, question)
+			lastQuestionID = questionID
+		}
+		if optionText.Valid {
+			last := &questions[len(questions)-1]
+			last.Options = append(last.Options, models.OptionInput{OptionText: optionText.String, ScoreValue: int(scoreValue.Int64)})
+		}
+	}
+	return questions, rows.Err()
+}
+
+// questionDiffFields - поля вопроса, которые различаются в двух версиях
+func questionDiffFields(before, after models.QuestionInput) []string {
+	var fields []string
+	if before.QuestionText != after.QuestionText {
+		fields = append(fields, "question_text")
+	}
+	if before.QuestionType != after.QuestionType {
+		fields = append(fields, "question_type")
+	}
+	if before.ScaleType != after.ScaleType {
+		fields = append(fields, "scale_type")
+	}
+	if before.Weight != after.Weight {
+		fields = append(fields, "weight")
+	}
+	if (before.IsRequired == nil || *before.IsRequired) != (after.IsRequired == nil || *after.IsRequired) {
+		fields = append(fields, "is_required")
+	}
+	if before.MinValue != after.MinValue || before.MaxValue != after.MaxValue {
+		fields = append(fields, "range")
+	}
+	if before.ScoringKey != after.ScoringKey {
+		fields = append(fields, "scoring_key")
+	}
+	if len(before.Options) != len(after.Options) {
+		fields = append(fields, "options")
+	} else {
+		for i := range before.Options {
+			if before.Options[i] != after.Options[i] {
+				fields = append(fields, "options")
+				break
+			}
+		}
+	}
+	return fields
+}
+
+// compareVersionQuestions сопоставляет вопросы двух версий по номеру
+func compareVersionQuestions(before, after []models.QuestionInput) []models.QuestionChange {
+	changes := []models.QuestionChange{}
+	for i := 0; i < len(before) || i < len(after); i++ {
+		switch {
+		case i >= len(before):
+			changes = append(changes, models.QuestionChange{QuestionNumber: i + 1, Change: models.QuestionAdded, After: &after[i]})
+		case i >= len(after):
+			changes = append(changes, models.QuestionChange{QuestionNumber: i + 1, Change: models.QuestionRemoved, Before: &before[i]})
+		default:
+			if fields := questionDiffFields(before[i], after[i]); len(fields) > 0 {
+				changes = append(changes, models.QuestionChange{
+					QuestionNumber: i + 1, Change: models.QuestionChanged, Fields: fields, Before: &before[i], After: &after[i],
+				})
+			}
+		}
+	}
+	return changes
+}
+
+// loadTestVersions загружает историю версий теста, начиная с последней
+func loadTestVersions(testID int) ([]models.TestVersion, error) {
+	rows, err := database.DB.Query(`
+		SELECT v.id, v.test_id, v.version_number, v.title, v.pass_threshold, COALESCE(v.comment, ''),
+		       COALESCE(u.last_name || ' ' || u.first_name, ''), v.created_at,
+		       (SELECT COUNT(*) FROM test_questions q WHERE q.version_id = v.id),
+		       (SELECT COUNT(*) FROM test_results tr WHERE tr.version_id = v.id),
+		       v.id = COALESCE(pt.current_version_id, 0)
+		FROM test_versions v
+		JOIN psychological_tests pt ON pt.id = v.test_id
+		LEFT JOIN users u ON u.id = v.created_by
+		WHERE v.test_id = $1
+		ORDER BY v.version_number DESC
+	`, testID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	versions := []models.TestVersion{}
+	for rows.Next() {
+		var version models.TestVersion
+		if err := rows.Scan(&version.ID, &version.TestID, &version.VersionNumber, &version.Title, &version.PassThreshold,
+			&version.Comment, &version.CreatedBy, &version.CreatedAt, &version.QuestionsCount, &version.ResultsCount,
+			&version.IsCurrent); err != nil {
+			return nil, err
+		}
+		version.CreatedBy = strings.TrimSpace(version.CreatedBy)
+		versions = append(versions, version)
+	}
+	return versions, rows.Err()
+}
+
+// findTestVersion возвращает ID версии теста по её номеру; номер 0 - текущая версия
+func findTestVersion(testID, versionNumber int) (int, int, error) {
+	var versionID int
+	err := database.DB.QueryRow(`
+		SELECT v.id, v.version_number
+		FROM test_versions v
+		JOIN psychological_tests pt ON pt.id = v.test_id
+		WHERE v.test_id = $1 AND (v.version_number = $2 OR ($2 = 0 AND v.id = pt.current_version_id))
+	`, testID, versionNumber).Scan(&versionID, &versionNumber)
+	return versionID, versionNumber, err
+}
+
+// Получение истории версий теста
+func GetTestVersions(c *gin.Context) {
+	testID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Неверный ID теста"})
+		return
+	}
+
+	versions, err := loadTestVersions(testID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка получения версий теста"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"versions": versions})
+}
+
+// Сравнение вопросов двух версий: параметры from и to - номера версий (to по умолчанию - текущая)
+func CompareTestVersions(c *gin.Context) {
+	testID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Неверный ID теста"})
+		return
+	}
+
+	fromNumber, err := strconv.Atoi(c.Query("from"))
+	if err != nil || fromNumber < 1 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Неверный номер версии"})
+		return
+	}
+	toNumber := 0
+	if toParam := c.Query("to"); toParam != "" {
+		if toNumber, err = strconv.Atoi(toParam); err != nil || toNumber < 1 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Неверный номер версии"})
+			return
+		}
+	}
+
+	fromID, fromNumber, err := findTestVersion(testID, fromNumber)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Версия теста не найдена"})
+		return
+	}
+	toID, toNumber, err := findTestVersion(testID, toNumber)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Версия теста не найдена"})
+		return
+	}
+
+	before, err := loadVersionQuestions(fromID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка получения вопросов"})
+		return
+	}
+	after, err := loadVersionQuestions(toID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка получения вопросов"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"from_version": fromNumber,
+		"to_version":   toNumber,
+		"changes":      compareVersionQuestions(before, after),
+	})
+}
+
+// Откат теста к одной из прошлых версий. История не переписывается: вопросы, название, порог
+// прохождения и настройки подсчёта выбранной версии копируются в новую версию, которая становится текущей.
+func RollbackTestVersion(c *gin.Context) {
+	testID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Неверный ID теста"})
+		return
+	}
+	versionNumber, err := strconv.Atoi(c.Param("version"))
+	if err != nil || versionNumber < 1 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Неверный номер версии"})
+		return
+	}
+
+	versionID, _, err := findTestVersion(testID, versionNumber)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Версия теста не найдена"})
+		return
+	}
+	currentID, _, err := findTestVersion(testID, 0)
+	if err == nil && currentID == versionID {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Эта версия уже текущая"})
+		return
+	}
+
+	questions, err := loadVersionQuestions(versionID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка получения вопросов"})
+		return
+	}
+
+	userID, _ := c.Get("userID")
+
+	tx, err := database.DB.Begin()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка начала транзакции"})
+		return
+	}
+	defer tx.Rollback()
+
+	// Название и порог прохождения возвращаются вместе с вопросами и настройками подсчёта
+	_, err = tx.Exec(`
+		UPDATE psychological_tests pt SET title = v.title, pass_threshold = v.pass_threshold
+		FROM test_versions v
+		WHERE pt.id = $1 AND v.id = $2
+	`, testID, versionID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка отката версии"})
+		return
+	}
+
+	_, newNumber, err := createTestVersion(tx, testID, userID, fmt.Sprintf("Откат к версии %d", versionNumber), questions, versionID)
+	if err != nil {
+		log.Printf("Ошибка отката теста %d к версии %d: %v", testID, versionNumber, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка отката версии"})
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка завершения операции"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":        fmt.Sprintf("Тест возвращён к версии %d", versionNumber),
+		"version_number": newNumber,
+	})
+}
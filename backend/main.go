@@ -59,15 +59,27 @@ func main() {
 		{
 			tests.GET("", handlers.GetTests)
 			tests.GET("/:id", handlers.GetTest)
+			tests.POST("/:id/start", handlers.StartTest)
+			tests.GET("/:id/draft", handlers.GetDraft)
+			tests.PUT("/:id/draft", handlers.SaveDraft)
 			tests.POST("/:id/submit", handlers.SubmitTest)
 		}
 
+		batteries := api.Group("/batteries")
+		batteries.Use(middleware.AuthRequired())
+		{
+			batteries.GET("", handlers.GetBatteries)
+			batteries.GET("/:id", handlers.GetBattery)
+		}
+
 		user := api.Group("/user")
 		user.Use(middleware.AuthRequired())
 		{
 			user.GET("/profile", handlers.GetUserProfile)
 			user.GET("/stats", handlers.GetUserStats)
+			user.GET("/progress", handlers.GetUserProgress)
 			user.PUT("/profile", handlers.UpdateUserProfile)
+			user.GET("/results/:id", handlers.GetUserResult)
 		}
 
 		admin := api.Group("/admin")
@@ -76,20 +88,89 @@ func main() {
 		{
 			// Статистика
 			admin.GET("/stats", handlers.GetAdminStats)
+			admin.GET("/analytics", handlers.GetAdminAnalytics)
 			
 			// Пользователи
 			admin.GET("/users", handlers.GetAllUsers)
 			admin.POST("/users/:id/block", handlers.BlockUser)
+			admin.GET("/users/:id/progress", handlers.GetUserProgressAdmin)
 			
 			// Тесты
 			admin.GET("/tests", handlers.GetAllTests)
 			admin.GET("/tests/:id/edit", handlers.GetTestForEdit)
 			admin.POST("/tests", handlers.CreateTest)
 			admin.PUT("/tests/:id", handlers.UpdateTest)
-			admin.DELETE("/tests/:id", handlers.DeleteTest)
+			admin.POST("/tests/:id/archive", handlers.ArchiveTest)
+			admin.POST("/tests/:id/restore", handlers.RestoreTest)
+			admin.DELETE("/tests/:id", handlers.ArchiveTest) // прежний DELETE только убирает тест в архив
+			admin.POST("/tests/:id/purge", handlers.PurgeTest)
+			
+			// Перенос тестов между экземплярами системы
+			admin.GET("/tests/:id/export", handlers.ExportTestPackage)
+			admin.POST("/tests/import", handlers.ImportTestPackage)
+			admin.GET("/tests/:id/export/qti", handlers.ExportQTIPackage)
+			admin.POST("/tests/import/qti", handlers.ImportQTIPackage)
+			
+			// Правила интерпретации
+			admin.GET("/tests/:id/interpretations", handlers.GetInterpretationRules)
+			admin.POST("/tests/:id/interpretations", handlers.CreateInterpretationRule)
+			admin.PUT("/tests/:id/interpretations/:ruleId", handlers.UpdateInterpretationRule)
+			admin.DELETE("/tests/:id/interpretations/:ruleId", handlers.DeleteInterpretationRule)
+			
+			// Ключи шкал
+			admin.GET("/tests/:id/scale-keys", handlers.GetScaleKeys)
+			admin.PUT("/tests/:id/scale-keys", handlers.SetScaleKeys)
+			
+			// Нормативные таблицы
+			admin.GET("/tests/:id/norms", handlers.GetNormTables)
+			admin.PUT("/tests/:id/norms", handlers.UploadNormTables)
+			admin.POST("/tests/:id/norms/recompute", handlers.RecomputeNormTables)
+			
+			// Проверка достоверности ответов
+			admin.GET("/tests/:id/validity", handlers.GetValiditySettings)
+			admin.PUT("/tests/:id/validity", handlers.SetValiditySettings)
+			
+			// Психометрический анализ пунктов
+			admin.GET("/tests/:id/psychometrics", handlers.GetTestPsychometrics)
+			admin.GET("/tests/:id/group-comparison", handlers.CompareGroups)
+			
+			// Версии теста
+			admin.GET("/tests/:id/versions", handlers.GetTestVersions)
+			admin.GET("/tests/:id/versions/compare", handlers.CompareTestVersions)
+			admin.POST("/tests/:id/versions/:version/rollback", handlers.RollbackTestVersion)
+			
+			// Назначения тестов
+			admin.GET("/tests/:id/assignments", handlers.GetTestAssignments)
+			admin.POST("/tests/:id/assignments", handlers.AssignTest)
+			admin.DELETE("/tests/:id/assignments/users/:userId", handlers.UnassignTestUser)
+			admin.DELETE("/tests/:id/assignments/groups/:groupId", handlers.UnassignTestGroup)
+			admin.GET("/assignments", handlers.GetAllAssignments)
+			
+			// Батареи тестов
+			admin.GET("/batteries", handlers.GetAllBatteries)
+			admin.POST("/batteries", handlers.CreateBattery)
+			admin.PUT("/batteries/:id", handlers.UpdateBattery)
+			admin.DELETE("/batteries/:id", handlers.DeleteBattery)
+			admin.POST("/batteries/:id/assignments", handlers.AssignBattery)
+			admin.GET("/batteries/:id/results", handlers.GetBatteryResults)
+			
+			// Группы пользователей
+			admin.GET("/groups", handlers.GetGroups)
+			admin.POST("/groups", handlers.CreateGroup)
+			admin.GET("/groups/:id", handlers.GetGroup)
+			admin.PUT("/groups/:id", handlers.UpdateGroup)
+			admin.DELETE("/groups/:id", handlers.DeleteGroup)
+			admin.PUT("/groups/:id/members", handlers.SetGroupMembers)
 			
 			// Результаты
 			admin.GET("/results", handlers.GetAllResults)
+			admin.GET("/results/export", handlers.ExportResults)
+			admin.GET("/results/:id/report.pdf", handlers.GetResultReport)
+			admin.GET("/free-text-answers", handlers.GetFreeTextAnswers)
+			admin.PUT("/free-text-answers/:id/review", handlers.ReviewFreeTextAnswer)
+			
+			// Журнал аудита
+			admin.GET("/audit-log", handlers.GetAuditLog)
 		}
 
 		// Health check
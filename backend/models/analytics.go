@@ -0,0 +1,20 @@
+package models
+
+// Показатели за один период ряда аналитики. Процент прохождения и средний процент
+// считаются только по достоверным результатам.
+type AnalyticsPoint struct {
+	Period            string   `json:"period"`
+	Submissions       int      `json:"submissions"`
+	Valid             int      `json:"valid"`
+	Passed            int      `json:"passed"`
+	PassRate          *float64 `json:"pass_rate"`
+	AveragePercentage *float64 `json:"average_percentage"`
+	NewUsers          int      `json:"new_users"`
+}
+
+// Ряд прохождений по одной методике
+type MethodologySeries struct {
+	Methodology string           `json:"methodology"`
+	Label       string           `json:"label"`
+	Points      []AnalyticsPoint `json:"points"`
+}
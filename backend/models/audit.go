@@ -0,0 +1,26 @@
+package models
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// Действия администраторов, которые записываются в журнал аудита
+const (
+	AuditTestArchived = "test_archived"
+	AuditTestRestored = "test_restored"
+	AuditTestPurged   = "test_purged"
+	AuditTestImported = "test_imported"
+)
+
+// Запись журнала аудита
+type AuditEntry struct {
+	ID         int             `json:"id"`
+	UserID     *int            `json:"user_id"`
+	UserName   string          `json:"user_name"`
+	Action     string          `json:"action"`
+	EntityType string          `json:"entity_type"`
+	EntityID   int             `json:"entity_id"`
+	Details    json.RawMessage `json:"details,omitempty"`
+	CreatedAt  time.Time       `json:"created_at"`
+}
@@ -0,0 +1,61 @@
+package models
+
+import "time"
+
+// Батарея - упорядоченный набор тестов с общим правилом прохождения
+type TestBattery struct {
+	ID            int           `json:"id"`
+	Title         string        `json:"title"`
+	Description   string        `json:"description"`
+	PassRule      string        `json:"pass_rule"`
+	PassThreshold float64       `json:"pass_threshold"` // для правила weighted - минимальный взвешенный процент
+	IsActive      bool          `json:"is_active"`
+	CreatedAt     time.Time     `json:"created_at"`
+	Tests         []BatteryTest `json:"tests"`
+}
+
+type BatteryTest struct {
+	TestID     int     `json:"test_id"`
+	TestTitle  string  `json:"test_title,omitempty"`
+	OrderIndex int     `json:"order_index"`
+	Weight     float64 `json:"weight"`
+}
+
+type BatteryRequest struct {
+	Title         string        `json:"title" binding:"required"`
+	Description   string        `json:"description"`
+	PassRule      string        `json:"pass_rule"`
+	PassThreshold float64       `json:"pass_threshold"`
+	IsActive      *bool         `json:"is_active"`
+	Tests         []BatteryTest `json:"tests"` // порядок прохождения задаётся порядком в массиве
+}
+
+// Официальный результат одного теста батареи
+type BatteryTestProgress struct {
+	BatteryTest
+	Completed  bool    `json:"completed"`
+	ResultID   int     `json:"result_id,omitempty"`
+	Percentage float64 `json:"percentage"`
+	IsPassed   bool    `json:"is_passed"`
+}
+
+// Итоговое заключение о пригодности по батарее
+type BatteryVerdict struct {
+	BatteryID      int                   `json:"battery_id"`
+	UserID         int                   `json:"user_id"`
+	UserName       string                `json:"user_name,omitempty"`
+	UserEmail      string                `json:"user_email,omitempty"`
+	Completed      bool                  `json:"completed"`
+	CompletedTests int                   `json:"completed_tests"`
+	TotalTests     int                   `json:"total_tests"`
+	Percentage     float64               `json:"percentage"`
+	IsPassed       bool                  `json:"is_passed"`
+	Verdict        string                `json:"verdict"`
+	NextTestID     int                   `json:"next_test_id,omitempty"`
+	Tests          []BatteryTestProgress `json:"tests"`
+}
+
+const (
+	BatteryRuleAllPassed = "all_passed" // пригоден, если пройдены все тесты
+	BatteryRuleWeighted  = "weighted"   // пригоден, если взвешенный процент не ниже порога
+)
@@ -0,0 +1,95 @@
+package models
+
+// Сравнение групп пользователей по результатам теста. Учитываются официальные достоверные
+// попытки участников; показатели считаются по проценту от максимального балла, поэтому
+// результаты разных версий теста сопоставимы. Значимость определяется по p-уровням
+// с поправкой Холма на все критерии отчёта.
+type GroupComparisonReport struct {
+	TestID      int                   `json:"test_id"`
+	TestTitle   string                `json:"test_title"`
+	Alpha       float64               `json:"alpha"`      // уровень значимости
+	Correction  string                `json:"correction"` // поправка на множественные сравнения
+	Groups      []GroupResultStats    `json:"groups"`
+	Comparisons []GroupPairComparison `json:"comparisons"`
+}
+
+// Показатели одной группы
+type GroupResultStats struct {
+	GroupID     int                 `json:"group_id"`
+	GroupName   string              `json:"group_name"`
+	Members     int                 `json:"members"`
+	Respondents int                 `json:"respondents"`
+	Passed      int                 `json:"passed"`
+	PassRate    *float64            `json:"pass_rate"`
+	Total       DistributionStats   `json:"total"`
+	Scales      []ScaleDistribution `json:"scales"`
+}
+
+// Описательная статистика выборки. Показатели не рассчитываются (null), если данных нет.
+// Histogram - число значений в интервалах по 10%: [0; 10), [10; 20), ..., [90; 100].
+type DistributionStats struct {
+	N         int      `json:"n"`
+	Mean      *float64 `json:"mean"`
+	Median    *float64 `json:"median"`
+	StdDev    *float64 `json:"std_dev"`
+	Min       *float64 `json:"min"`
+	Q1        *float64 `json:"q1"`
+	Q3        *float64 `json:"q3"`
+	Max       *float64 `json:"max"`
+	Histogram []int    `json:"histogram"`
+}
+
+type ScaleDistribution struct {
+	ScaleType string            `json:"scale_type"`
+	Stats     DistributionStats `json:"stats"`
+}
+
+// Проверка различий между двумя группами
+type GroupPairComparison struct {
+	GroupA   int                 `json:"group_a"`
+	GroupB   int                 `json:"group_b"`
+	Overlap  int                 `json:"overlap"` // пользователи из обеих групп; исключаются из обеих выборок, так как критерии предполагают независимые выборки
+	PassRate *ProportionTest     `json:"pass_rate"`
+	Total    SignificanceTests   `json:"total"`
+	Scales   []ScaleSignificance `json:"scales"`
+}
+
+type ScaleSignificance struct {
+	ScaleType string            `json:"scale_type"`
+	Tests     SignificanceTests `json:"tests"`
+}
+
+// Параметрический и непараметрический критерии; null, если выборки слишком малы
+type SignificanceTests struct {
+	TTest       *TTestResult       `json:"t_test"`
+	MannWhitney *MannWhitneyResult `json:"mann_whitney"`
+}
+
+// t-критерий Уэлча (без предположения о равенстве дисперсий)
+type TTestResult struct {
+	MeanDifference float64  `json:"mean_difference"` // среднее группы A минус среднее группы B
+	T              float64  `json:"t"`
+	DF             float64  `json:"df"`
+	P              float64  `json:"p"`
+	PAdjusted      float64  `json:"p_adjusted"` // с поправкой на множественные сравнения
+	CohenD         *float64 `json:"cohen_d"`
+	Significant    bool     `json:"significant"` // по скорректированному p
+}
+
+// U-критерий Манна-Уитни с нормальной аппроксимацией и поправкой на связанные ранги
+type MannWhitneyResult struct {
+	U           float64 `json:"u"` // U для группы A
+	Z           float64 `json:"z"`
+	P           float64 `json:"p"`
+	PAdjusted   float64 `json:"p_adjusted"`
+	Significant bool    `json:"significant"`
+}
+
+// z-критерий разности долей прошедших тест
+type ProportionTest struct {
+	Difference  float64 `json:"difference"` // разность процентов прохождения, A минус B
+	Z           float64 `json:"z"`
+	P           float64 `json:"p"`
+	PAdjusted   float64 `json:"p_adjusted"`
+	Significant bool    `json:"significant"`
+}
@@ -0,0 +1,34 @@
+package models
+
+import "time"
+
+// Нормативная таблица шкалы: среднее и стандартное отклонение сырого балла в нормативной группе.
+// Пустой пол и незаданные границы возраста означают, что таблица подходит всем.
+// Рассчитанные таблицы хранят версию теста, по результатам которой они построены.
+type NormTable struct {
+	ID            int       `json:"id"`
+	TestID        int       `json:"test_id"`
+	VersionID     *int      `json:"version_id,omitempty"`
+	VersionNumber *int      `json:"version_number,omitempty"`
+	ScaleType     string    `json:"scale_type"`
+	Gender        string    `json:"gender"`
+	AgeMin        *int      `json:"age_min"`
+	AgeMax        *int      `json:"age_max"`
+	Mean          float64   `json:"mean"`
+	StdDev        float64   `json:"std_dev"`
+	SampleSize    int       `json:"sample_size"`
+	Source        string    `json:"source"`
+	UpdatedAt     time.Time `json:"updated_at"`
+}
+
+// Параметры пересчёта норм по накопленным результатам
+type NormRecomputeRequest struct {
+	ByGender      bool     `json:"by_gender"`
+	AgeGroups     [][2]int `json:"age_groups"` // границы возраста включительно, например [[18, 30], [31, 45]]
+	MinSampleSize int      `json:"min_sample_size"`
+}
+
+const (
+	NormSourceUpload   = "upload"   // загружена администратором из опубликованных норм
+	NormSourceComputed = "computed" // рассчитана по результатам системы
+)
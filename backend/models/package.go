@@ -0,0 +1,74 @@
+package models
+
+import "time"
+
+// Формат переносимого пакета теста. Версия формата увеличивается при несовместимых
+// изменениях структуры, чтобы старые экземпляры системы не импортировали пакет неверно.
+const (
+	TestPackageFormat  = "psycho-test-package"
+	TestPackageVersion = 1
+)
+
+// Пакет теста для переноса между экземплярами системы (JSON или YAML)
+type TestPackage struct {
+	Format              string           `json:"format"`
+	Version             int              `json:"version"`
+	ExportedAt          time.Time        `json:"exported_at"`
+	Test                PackageTest      `json:"test"`
+	Questions           []QuestionInput  `json:"questions"`
+	ScaleKeys           []ScaleKey       `json:"scale_keys"`
+	InterpretationRules []PackageRule    `json:"interpretation_rules"`
+	Validity            ValiditySettings `json:"validity"`
+	Norms               []PackageNorm    `json:"norms"`
+}
+
+// Описание и настройки прохождения теста без привязки к ID конкретной базы
+type PackageTest struct {
+	Title                string  `json:"title"`
+	Description          string  `json:"description"`
+	Instructions         string  `json:"instructions"`
+	EstimatedTime        int     `json:"estimated_time"`
+	PassThreshold        float64 `json:"pass_threshold"`
+	MethodologyType      string  `json:"methodology_type"`
+	TimeLimit            int     `json:"time_limit"`
+	LateSubmissionPolicy string  `json:"late_submission_policy"`
+	MaxAttempts          int     `json:"max_attempts"`
+	RetakeIntervalHours  int     `json:"retake_interval_hours"`
+	OfficialAttempt      string  `json:"official_attempt"`
+}
+
+// Правило интерпретации в пакете
+type PackageRule struct {
+	ScaleType      string  `json:"scale_type"`
+	MinPercentage  float64 `json:"min_percentage"`
+	MaxPercentage  float64 `json:"max_percentage"`
+	Interpretation string  `json:"interpretation"`
+	Recommendation string  `json:"recommendation"`
+}
+
+// Нормативная таблица в пакете; при импорте загружается как опубликованная норма
+type PackageNorm struct {
+	ScaleType  string  `json:"scale_type"`
+	Gender     string  `json:"gender"`
+	AgeMin     *int    `json:"age_min"`
+	AgeMax     *int    `json:"age_max"`
+	Mean       float64 `json:"mean"`
+	StdDev     float64 `json:"std_dev"`
+	SampleSize int     `json:"sample_size"`
+}
+
+// Предварительный просмотр импорта: что изменится в базе
+type PackageImportPreview struct {
+	TestID                     int              `json:"test_id,omitempty"` // 0 - будет создан новый тест
+	CreatesTest                bool             `json:"creates_test"`
+	ChangedFields              []string         `json:"changed_fields"`
+	QuestionChanges            []QuestionChange `json:"question_changes"`
+	ScaleKeysChanged           bool             `json:"scale_keys_changed"`
+	InterpretationRulesChanged bool             `json:"interpretation_rules_changed"`
+	ValidityChanged            bool             `json:"validity_changed"`
+	NormsChanged               bool             `json:"norms_changed"`
+	QuestionsCount             int              `json:"questions_count"`
+	ScaleKeysCount             int              `json:"scale_keys_count"`
+	InterpretationRulesCount   int              `json:"interpretation_rules_count"`
+	NormsCount                 int              `json:"norms_count"`
+}
@@ -0,0 +1,59 @@
+package models
+
+import "time"
+
+// История повторных прохождений тестов пользователем
+type ProgressReport struct {
+	UserID         int            `json:"user_id"`
+	Tests          []TestProgress `json:"tests"`
+	Recommendation string         `json:"monthly_recommendation"`
+}
+
+// Прохождения одного теста по времени
+type TestProgress struct {
+	TestID           int               `json:"test_id"`
+	TestTitle        string            `json:"test_title"`
+	MethodologyLabel string            `json:"methodology_label"`
+	Attempts         []ProgressAttempt `json:"attempts"`
+}
+
+// Попытка с изменением относительно предыдущей достоверной попытки того же теста.
+// Недостоверные попытки показываются, но не сравниваются и не служат точкой отсчёта.
+type ProgressAttempt struct {
+	ResultID       int             `json:"result_id"`
+	AttemptNumber  int             `json:"attempt_number"`
+	VersionNumber  int             `json:"version_number"`
+	CompletedAt    time.Time       `json:"completed_at"`
+	IsValid        bool            `json:"is_valid"`
+	IsPassed       bool            `json:"is_passed"`
+	Percentage     float64         `json:"percentage"`
+	Interpretation string          `json:"interpretation"`
+	Recommendation string          `json:"recommendation"`
+	Change         *ScoreChange    `json:"change"`
+	Scales         []ScaleProgress `json:"scales"`
+}
+
+type ScaleProgress struct {
+	ScaleName  string       `json:"scale_name"`
+	Score      float64      `json:"score"`
+	MaxScore   float64      `json:"max_score"`
+	Percentage float64      `json:"percentage"`
+	Sten       *int         `json:"sten,omitempty"`
+	Change     *ScoreChange `json:"change"`
+}
+
+// Изменение процента от максимального балла. Значимость определяется индексом надёжного
+// изменения (method = "rci") по разбросу и альфе Кронбаха шкалы в версии теста, а если их
+// оценить не по чему - разницей не меньше двух стенов (method = "sten"); иначе method пуст
+// и изменение не оценивается.
+type ScoreChange struct {
+	PreviousResultID   int      `json:"previous_result_id"`
+	PreviousPercentage float64  `json:"previous_percentage"`
+	Delta              float64  `json:"delta"` // в процентных пунктах
+	StenDelta          *int     `json:"sten_delta,omitempty"`
+	RCI                *float64 `json:"rci,omitempty"`
+	Reliability        *float64 `json:"reliability,omitempty"` // альфа Кронбаха, по которой считался RCI
+	Method             string   `json:"method"`
+	Significant        bool     `json:"significant"`
+	Direction          string   `json:"direction"` // up, down или none
+}
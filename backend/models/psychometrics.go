@@ -0,0 +1,50 @@
+package models
+
+// Психометрический анализ текущей версии теста по сохранённым ответам (официальные достоверные попытки этой версии)
+type PsychometricsReport struct {
+	TestID      int                `json:"test_id"`
+	TestTitle   string             `json:"test_title"`
+	Respondents int                `json:"respondents"`
+	Alpha       *float64           `json:"alpha"` // альфа Кронбаха по итоговому баллу
+	Scales      []ScaleReliability `json:"scales"`
+	Items       []ItemAnalysis     `json:"items"`
+}
+
+// Надёжность шкалы. Показатели не рассчитываются (null), если данных недостаточно.
+type ScaleReliability struct {
+	ScaleType  string           `json:"scale_type"`
+	ItemsCount int              `json:"items_count"`
+	Alpha      *float64         `json:"alpha"`
+	Items      []ScaleItemStats `json:"items"`
+}
+
+type ScaleItemStats struct {
+	QuestionNumber      int      `json:"question_number"`
+	Reverse             bool     `json:"reverse"`
+	ItemRestCorrelation *float64 `json:"item_rest_correlation"` // корреляция с суммой остальных пунктов шкалы
+	AlphaIfDeleted      *float64 `json:"alpha_if_deleted"`
+}
+
+// Анализ отдельного пункта теста
+type ItemAnalysis struct {
+	QuestionID           int                  `json:"question_id"`
+	QuestionNumber       int                  `json:"question_number"`
+	QuestionText         string               `json:"question_text"`
+	QuestionType         string               `json:"question_type"`
+	ScaleType            string               `json:"scale_type"`
+	Answered             int                  `json:"answered"`
+	MeanScore            float64              `json:"mean_score"`
+	Difficulty           *float64             `json:"difficulty"`             // средний балл / максимальный балл пункта
+	ItemTotalCorrelation *float64             `json:"item_total_correlation"` // корреляция с итоговым баллом без этого пункта
+	Distribution         []AnswerDistribution `json:"distribution"`
+	Warnings             []string             `json:"warnings,omitempty"`
+}
+
+// Распределение ответов по вариантам (для шкалы Лайкерта - по значениям)
+type AnswerDistribution struct {
+	OptionID   int      `json:"option_id,omitempty"`
+	Label      string   `json:"label"`
+	Count      int      `json:"count"`
+	Percentage float64  `json:"percentage"`          // доля ответивших на пункт
+	MeanRank   *float64 `json:"mean_rank,omitempty"` // средняя позиция варианта при ранжировании
+}
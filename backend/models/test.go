@@ -26,6 +26,33 @@ type TestQuestion struct {
 	Options      []QuestionOption `json:"options"`
 	Weight       float64          `json:"weight"`
 	OrderIndex   int              `json:"order_index"`
+	IsRequired   bool             `json:"is_required"`
+	MinValue     int              `json:"min_value"` // границы шкалы для likert
+	MaxValue     int              `json:"max_value"`
+	ScoringKey   string           `json:"scoring_key"` // direct или reverse
+}
+
+// Типы вопросов
+const (
+	QuestionSingleChoice = "multiple_choice" // один вариант ответа
+	QuestionMultiSelect  = "multi_select"    // несколько вариантов, баллы суммируются
+	QuestionLikert       = "likert"          // числовое значение в диапазоне min_value..max_value
+	QuestionRanking      = "ranking"         // упорядочивание всех вариантов
+	QuestionFreeText     = "free_text"       // свободный ответ, не оценивается, проверяется администратором
+)
+
+// Ключ пункта: прямой или обратный. При обратном ключе балл варианта
+// зеркально отражается относительно диапазона баллов вопроса
+const (
+	ScoringDirect  = "direct"
+	ScoringReverse = "reverse"
+)
+
+// Ключ шкалы в том виде, как его публикуют методики: номера пунктов с прямым и обратным ключом
+type ScaleKey struct {
+	ScaleType string `json:"scale_type" binding:"required"`
+	Direct    []int  `json:"direct"`
+	Reverse   []int  `json:"reverse"`
 }
 
 type QuestionOption struct {
@@ -46,11 +73,79 @@ type TestResult struct {
 	IsPassed       bool      `json:"is_passed"`
 	Interpretation string    `json:"interpretation"`
 	Recommendation string    `json:"recommendation"`
-	ScaleResults   string    `json:"scale_results"` // JSON string with detailed scale results
+	ScaleResults   string    `json:"scale_results,omitempty"` // JSON string with detailed scale results
 	StartedAt      time.Time `json:"started_at"`
 	CompletedAt    time.Time `json:"completed_at"`
+	SessionID      int       `json:"session_id,omitempty"`
+	DurationSeconds int      `json:"duration_seconds"`
+	IsLate         bool      `json:"is_late"`
+	AttemptNumber  int       `json:"attempt_number"`
+	VersionID      int       `json:"version_id,omitempty"`
+	IsValid        bool      `json:"is_valid"`
+	ValidityFlags  []string  `json:"validity_flags,omitempty"` // причины недостоверности
 }
 
+// Сессия прохождения теста: фиксирует реальное время начала на сервере
+type TestSession struct {
+	ID               int        `json:"id"`
+	UserID           int        `json:"user_id"`
+	TestID           int        `json:"test_id"`
+	VersionID        int        `json:"version_id"` // версия теста, на которой начата попытка
+	Status           string     `json:"status"`
+	StartedAt        time.Time  `json:"started_at"`
+	ExpiresAt        *time.Time `json:"expires_at,omitempty"`
+	TimeLimit        int        `json:"time_limit"`
+	RemainingSeconds *int       `json:"remaining_seconds,omitempty"`
+}
+
+// Черновик ответов незавершённой сессии (автосохранение)
+type TestDraft struct {
+	SessionID int                `json:"session_id"`
+	TestID    int                `json:"test_id"`
+	Answers   []AnswerSubmission `json:"answers"`
+	SavedAt   *time.Time         `json:"saved_at,omitempty"`
+}
+
+// Назначение теста кандидату, в том числе через группу
+type TestAssignment struct {
+	ID         int        `json:"id"`
+	TestID     int        `json:"test_id"`
+	TestTitle  string     `json:"test_title,omitempty"`
+	UserID     int        `json:"user_id"`
+	UserName   string     `json:"user_name,omitempty"`
+	UserEmail  string     `json:"user_email,omitempty"`
+	GroupID    *int       `json:"group_id,omitempty"`
+	Deadline   *time.Time `json:"deadline,omitempty"`
+	AssignedAt time.Time  `json:"assigned_at"`
+	Status     string     `json:"status"`
+}
+
+type AssignTestRequest struct {
+	UserIDs  []int  `json:"user_ids"`
+	GroupIDs []int  `json:"group_ids"`
+	Deadline string `json:"deadline"` // YYYY-MM-DD или YYYY-MM-DDTHH:MM, пусто - без срока
+}
+
+const (
+	AssignmentAssigned  = "assigned"
+	AssignmentStarted   = "started"
+	AssignmentCompleted = "completed"
+	AssignmentOverdue   = "overdue"
+)
+
+const (
+	SessionActive    = "active"
+	SessionCompleted = "completed"
+	SessionExpired   = "expired"
+
+	LatePolicyFlag   = "flag"
+	LatePolicyReject = "reject"
+
+	// Какая попытка считается официальным результатом
+	OfficialAttemptFirst  = "first"
+	OfficialAttemptLatest = "latest"
+)
+
 type CreateTestRequest struct {
 	Title         string        `json:"title" binding:"required"`
 	Description   string        `json:"description"`
@@ -61,6 +156,44 @@ type CreateTestRequest struct {
 	Questions     []TestQuestion `json:"questions"`
 }
 
+// Ответ на один вопрос при отправке теста. Заполняется поле, соответствующее типу вопроса:
+// option_id - один вариант, option_ids - несколько вариантов или порядок ранжирования,
+// value - значение шкалы Лайкерта, text - свободный ответ
+type AnswerSubmission struct {
+	QuestionID int      `json:"question_id"`
+	OptionID   int      `json:"option_id,omitempty"`
+	OptionIDs  []int    `json:"option_ids,omitempty"`
+	Value      *float64 `json:"value,omitempty"`
+	Text       string   `json:"text,omitempty"`
+}
+
+// Свободный ответ для проверки администратором
+type FreeTextAnswer struct {
+	ID            int        `json:"id"`
+	ResultID      int        `json:"result_id"`
+	TestID        int        `json:"test_id"`
+	TestTitle     string     `json:"test_title"`
+	QuestionID    int        `json:"question_id"`
+	QuestionText  string     `json:"question_text"`
+	UserName      string     `json:"user_name"`
+	UserEmail     string     `json:"user_email"`
+	AnswerText    string     `json:"answer_text"`
+	AnsweredAt    time.Time  `json:"answered_at"`
+	ReviewComment string     `json:"review_comment"`
+	ReviewedAt    *time.Time `json:"reviewed_at,omitempty"`
+}
+
+type SubmitTestRequest struct {
+	Answers []AnswerSubmission `json:"answers" binding:"required"`
+}
+
+// Ошибка проверки конкретного поля запроса
+type FieldError struct {
+	Field      string `json:"field"`
+	QuestionID int    `json:"question_id,omitempty"`
+	Message    string `json:"message"`
+}
+
 // Структуры для детальных результатов по шкалам
 type ScaleResult struct {
 	ScaleName  string  `json:"scale_name"`
@@ -68,9 +201,28 @@ type ScaleResult struct {
 	MaxScore   float64 `json:"max_score"`
 	Percentage float64 `json:"percentage"`
 	Interpretation string `json:"interpretation"`
+	Recommendation string `json:"recommendation,omitempty"`
+	// Стандартные баллы заполняются, если для шкалы есть подходящая нормативная таблица
+	Sten       *int     `json:"sten,omitempty"`
+	TScore     *float64 `json:"t_score,omitempty"`
+	Percentile *float64 `json:"percentile,omitempty"`
+	NormGroup  string   `json:"norm_group,omitempty"`
+}
+
+// Правило интерпретации: диапазон процента по шкале (или по тесту целиком) и текст заключения
+type InterpretationRule struct {
+	ID             int     `json:"id"`
+	TestID         int     `json:"test_id"`
+	ScaleType      string  `json:"scale_type"` // пустая строка - правило для итогового результата
+	MinPercentage  float64 `json:"min_percentage"`
+	MaxPercentage  float64 `json:"max_percentage"`
+	Interpretation string  `json:"interpretation" binding:"required"`
+	Recommendation string  `json:"recommendation"`
 }
 
 type DetailedTestResult struct {
 	OverallResult TestResult    `json:"overall_result"`
+	TestTitle     string        `json:"test_title"`
+	Methodology   string        `json:"methodology_type"`
 	ScaleResults  []ScaleResult `json:"scale_results"`
 }
\ No newline at end of file
@@ -10,6 +10,8 @@ type User struct {
 	FirstName  string    `json:"first_name"`
 	Patronymic string    `json:"patronymic"`
 	Role       string    `json:"role"`
+	Gender     string    `json:"gender"`               // male, female или пустая строка
+	BirthDate  string    `json:"birth_date,omitempty"` // YYYY-MM-DD
 	CreatedAt  time.Time `json:"created_at"`
 }
 
@@ -26,7 +28,36 @@ type RegisterRequest struct {
 	Patronymic string `json:"patronymic"`
 }
 
+// Группа пользователей (отдел, поток набора)
+type UserGroup struct {
+	ID           int           `json:"id"`
+	Name         string        `json:"name"`
+	Description  string        `json:"description"`
+	MembersCount int           `json:"members_count"`
+	CreatedAt    time.Time     `json:"created_at"`
+	Members      []GroupMember `json:"members,omitempty"`
+}
+
+type GroupMember struct {
+	ID       int    `json:"id"`
+	Email    string `json:"email"`
+	FullName string `json:"full_name"`
+}
+
+// Запрос создания и изменения группы. При изменении состав группы заменяется,
+// только если передан user_ids (в том числе пустой список).
+type GroupRequest struct {
+	Name        string `json:"name" binding:"required"`
+	Description string `json:"description"`
+	UserIDs     []int  `json:"user_ids"`
+}
+
 const (
 	RoleAdmin = "admin"
 	RoleUser  = "user"
+)
+
+const (
+	GenderMale   = "male"
+	GenderFemale = "female"
 )
\ No newline at end of file
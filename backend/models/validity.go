@@ -0,0 +1,20 @@
+package models
+
+// Настройки проверки достоверности ответов теста. Нулевые значения отключают проверку.
+type ValiditySettings struct {
+	LieScale              string              `json:"lie_scale"`     // шкала лжи (социальной желательности)
+	LieThreshold          float64             `json:"lie_threshold"` // процент по шкале лжи, начиная с которого результат недостоверен
+	InconsistencyPairs    []InconsistencyPair `json:"inconsistency_pairs"`
+	MaxInconsistencies    int                 `json:"max_inconsistencies"`     // допустимое число несогласованных пар
+	StraightLineThreshold float64             `json:"straight_line_threshold"` // доля одинаковых ответов в процентах
+	MinDurationSeconds    int                 `json:"min_duration_seconds"`    // минимальное правдоподобное время прохождения
+}
+
+// Пара пунктов, ответы на которые должны совпадать (или быть противоположными).
+// Пункты задаются номерами, как в ключах шкал.
+type InconsistencyPair struct {
+	QuestionA     int     `json:"question_a"`
+	QuestionB     int     `json:"question_b"`
+	IsOpposite    bool    `json:"is_opposite"`    // утверждения противоположны по смыслу
+	MaxDifference float64 `json:"max_difference"` // допустимое расхождение в процентах шкалы ответа
+}
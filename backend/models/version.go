@@ -0,0 +1,52 @@
+package models
+
+import "time"
+
+// Версия теста: неизменяемый набор вопросов. Редактирование вопросов создаёт новую версию,
+// результаты ссылаются на версию, по которой тест был пройден.
+type TestVersion struct {
+	ID             int       `json:"id"`
+	TestID         int       `json:"test_id"`
+	VersionNumber  int       `json:"version_number"`
+	Title          string    `json:"title"`
+	PassThreshold  float64   `json:"pass_threshold"`
+	Comment        string    `json:"comment"`
+	CreatedBy      string    `json:"created_by"`
+	CreatedAt      time.Time `json:"created_at"`
+	QuestionsCount int       `json:"questions_count"`
+	ResultsCount   int       `json:"results_count"`
+	IsCurrent      bool      `json:"is_current"`
+}
+
+// Вопрос в запросе создания или редактирования теста
+type QuestionInput struct {
+	QuestionText string        `json:"question_text"`
+	QuestionType string        `json:"question_type"`
+	ScaleType    string        `json:"scale_type"`
+	Weight       float64       `json:"weight"`
+	IsRequired   *bool         `json:"is_required"`
+	MinValue     int           `json:"min_value"`
+	MaxValue     int           `json:"max_value"`
+	ScoringKey   string        `json:"scoring_key"`
+	Options      []OptionInput `json:"options"`
+}
+
+type OptionInput struct {
+	OptionText string `json:"option_text"`
+	ScoreValue int    `json:"score_value"`
+}
+
+// Отличие вопроса между двумя версиями; вопросы сопоставляются по номеру
+type QuestionChange struct {
+	QuestionNumber int            `json:"question_number"`
+	Change         string         `json:"change"` // added, removed, changed
+	Fields         []string       `json:"fields,omitempty"`
+	Before         *QuestionInput `json:"before,omitempty"`
+	After          *QuestionInput `json:"after,omitempty"`
+}
+
+const (
+	QuestionAdded   = "added"
+	QuestionRemoved = "removed"
+	QuestionChanged = "changed"
+)
@@ -1,7 +1,21 @@
+DROP TABLE IF EXISTS audit_log;
+DROP TABLE IF EXISTS inconsistency_pairs;
+DROP TABLE IF EXISTS norm_tables;
+DROP TABLE IF EXISTS battery_tests;
+DROP TABLE IF EXISTS test_batteries;
+DROP TABLE IF EXISTS test_group_assignments;
+DROP TABLE IF EXISTS test_assignments;
+DROP TABLE IF EXISTS user_group_members;
+DROP TABLE IF EXISTS user_groups;
+DROP TABLE IF EXISTS scale_keys;
+DROP TABLE IF EXISTS test_result_scales;
+DROP TABLE IF EXISTS interpretation_rules;
 DROP TABLE IF EXISTS user_answers;
 DROP TABLE IF EXISTS test_results;
+DROP TABLE IF EXISTS test_sessions;
 DROP TABLE IF EXISTS question_options;
 DROP TABLE IF EXISTS test_questions;
+DROP TABLE IF EXISTS test_versions;
 DROP TABLE IF EXISTS psychological_tests;
 DROP TABLE IF EXISTS users;
 
@@ -15,6 +29,8 @@ CREATE TABLE users (
     patronymic VARCHAR(30),
     role VARCHAR(10) DEFAULT 'user',
     is_blocked BOOLEAN DEFAULT false,
+    gender VARCHAR(10), -- male, female; нужен для выбора нормативной группы
+    birth_date DATE,
     created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
 );
 
@@ -28,19 +44,53 @@ CREATE TABLE psychological_tests (
     is_active BOOLEAN DEFAULT true,
     pass_threshold DECIMAL(5,2) NOT NULL DEFAULT 70.0,
     methodology_type VARCHAR(30) NOT NULL,
+    time_limit INTEGER DEFAULT 0, -- лимит времени в минутах, 0 = без ограничения
+    late_submission_policy VARCHAR(10) DEFAULT 'flag', -- flag = принять с отметкой, reject = отклонить
+    max_attempts INTEGER DEFAULT 0, -- 0 = без ограничения
+    retake_interval_hours INTEGER DEFAULT 0, -- минимальный интервал между попытками
+    official_attempt VARCHAR(10) DEFAULT 'latest', -- first или latest: какая попытка считается официальной
+    current_version_id INTEGER, -- текущая версия из test_versions (без внешнего ключа, чтобы не было цикла ссылок)
+    archived_at TIMESTAMP, -- тест в архиве: скрыт из списка для прохождения, результаты сохраняются
+    archived_by INTEGER REFERENCES users(id) ON DELETE SET NULL,
     created_by INTEGER REFERENCES users(id),
     created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
 );
 
+-- Таблица версий теста: версия хранит всё, что влияет на подсчёт и заключение, - вопросы,
+-- порог прохождения, настройки достоверности, ключи шкал и правила интерпретации.
+-- Попытка оценивается по версии, на которой начата; прежние версии не изменяются
+CREATE TABLE test_versions (
+    id SERIAL PRIMARY KEY,
+    test_id INTEGER REFERENCES psychological_tests(id) ON DELETE CASCADE,
+    version_number INTEGER NOT NULL,
+    title VARCHAR(255) NOT NULL,
+    pass_threshold DECIMAL(5,2) NOT NULL,
+    lie_scale VARCHAR(100) DEFAULT '', -- шкала лжи; пустая строка = не проверяется
+    lie_threshold DECIMAL(5,2) DEFAULT 0, -- процент по шкале лжи, начиная с которого результат недостоверен
+    max_inconsistencies INTEGER DEFAULT 0, -- допустимое число противоречивых пар вопросов
+    straight_line_threshold DECIMAL(5,2) DEFAULT 0, -- доля одинаковых ответов в %, 0 = не проверяется
+    min_duration_seconds INTEGER DEFAULT 0, -- минимальное правдоподобное время прохождения, 0 = не проверяется
+    comment TEXT DEFAULT '',
+    created_by INTEGER REFERENCES users(id) ON DELETE SET NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE (test_id, version_number)
+);
+
 -- Таблица вопросов теста
+-- question_type: multiple_choice (один вариант), multi_select, likert, ranking, free_text
 CREATE TABLE test_questions (
     id SERIAL PRIMARY KEY,
     test_id INTEGER REFERENCES psychological_tests(id),
+    version_id INTEGER REFERENCES test_versions(id),
     question_text TEXT NOT NULL,
     question_type VARCHAR(50) DEFAULT 'multiple_choice',
     scale_type VARCHAR(100) NOT NULL,
     weight DECIMAL(3,2) DEFAULT 1.0,
-    order_index INTEGER
+    order_index INTEGER,
+    is_required BOOLEAN DEFAULT true,
+    min_value INTEGER, -- границы шкалы для вопросов likert
+    max_value INTEGER,
+    scoring_key VARCHAR(10) DEFAULT 'direct' -- direct или reverse: обратный ключ отражает баллы вариантов
 );
 
 -- Таблица вариантов ответов
@@ -52,18 +102,39 @@ CREATE TABLE question_options (
     order_index INTEGER
 );
 
+-- Таблица сессий прохождения тестов (реальное время начала фиксируется на сервере)
+CREATE TABLE test_sessions (
+    id SERIAL PRIMARY KEY,
+    user_id INTEGER REFERENCES users(id),
+    test_id INTEGER REFERENCES psychological_tests(id) ON DELETE CASCADE,
+    version_id INTEGER REFERENCES test_versions(id) ON DELETE SET NULL, -- версия, на которой начата попытка
+    status VARCHAR(20) NOT NULL DEFAULT 'active', -- active, completed, expired
+    started_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    expires_at TIMESTAMP,
+    completed_at TIMESTAMP,
+    draft_answers JSONB, -- автосохранение ответов незавершённой попытки
+    draft_saved_at TIMESTAMP
+);
+
 -- Таблица результатов тестирования
 CREATE TABLE test_results (
     id SERIAL PRIMARY KEY,
     user_id INTEGER REFERENCES users(id),
     test_id INTEGER REFERENCES psychological_tests(id),
-    total_score DECIMAL(5,2) NOT NULL,
-    max_possible_score DECIMAL(5,2) NOT NULL,
+    total_score DECIMAL(8,2) NOT NULL,
+    max_possible_score DECIMAL(8,2) NOT NULL,
     percentage DECIMAL(5,2) NOT NULL,
     is_passed BOOLEAN NOT NULL,
     interpretation TEXT NOT NULL,
     recommendation TEXT,
     scale_results JSONB,
+    session_id INTEGER REFERENCES test_sessions(id) ON DELETE SET NULL,
+    duration_seconds INTEGER,
+    is_late BOOLEAN DEFAULT false,
+    attempt_number INTEGER DEFAULT 1,
+    is_valid BOOLEAN DEFAULT true, -- false = ответы не прошли проверку достоверности
+    validity_flags JSONB, -- причины недостоверности
+    version_id INTEGER REFERENCES test_versions(id) ON DELETE SET NULL, -- версия теста, на которой получен результат
     started_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
     completed_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
 );
@@ -74,18 +145,174 @@ CREATE TABLE user_answers (
     result_id INTEGER REFERENCES test_results(id),
     question_id INTEGER REFERENCES test_questions(id),
     option_id INTEGER REFERENCES question_options(id),
+    rank_position INTEGER, -- позиция варианта в вопросе ranking
+    answer_value DECIMAL(8,2), -- значение шкалы likert
+    answer_text TEXT, -- свободный ответ (не оценивается)
+    review_comment TEXT, -- комментарий администратора к свободному ответу
+    reviewed_by INTEGER REFERENCES users(id) ON DELETE SET NULL,
+    reviewed_at TIMESTAMP,
     answered_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
 );
 
+-- Разбивка результата по шкалам (дублирует test_results.scale_results для агрегатных запросов)
+CREATE TABLE test_result_scales (
+    id SERIAL PRIMARY KEY,
+    result_id INTEGER REFERENCES test_results(id) ON DELETE CASCADE,
+    scale_type VARCHAR(100) NOT NULL,
+    score DECIMAL(8,2) NOT NULL,
+    max_score DECIMAL(8,2) NOT NULL,
+    percentage DECIMAL(5,2) NOT NULL,
+    sten INTEGER, -- стандартные баллы заполняются, если для шкалы есть нормы
+    t_score DECIMAL(5,1),
+    percentile DECIMAL(4,1)
+);
+
+-- Таблица правил интерпретации (диапазоны процента по тесту и по шкалам)
+CREATE TABLE interpretation_rules (
+    id SERIAL PRIMARY KEY,
+    test_id INTEGER REFERENCES psychological_tests(id) ON DELETE CASCADE,
+    version_id INTEGER REFERENCES test_versions(id) ON DELETE CASCADE,
+    scale_type VARCHAR(100) NOT NULL DEFAULT '', -- пустая строка = итоговый результат теста
+    min_percentage DECIMAL(5,2) NOT NULL,
+    max_percentage DECIMAL(5,2) NOT NULL,
+    interpretation TEXT NOT NULL,
+    recommendation TEXT,
+    CHECK (min_percentage <= max_percentage)
+);
+
+-- Таблица ключей шкал: номера пунктов с прямым и обратным ключом, как в опубликованных методиках.
+-- Если ключи заданы, состав шкал теста определяется ими, а не scale_type вопросов
+CREATE TABLE scale_keys (
+    id SERIAL PRIMARY KEY,
+    test_id INTEGER REFERENCES psychological_tests(id) ON DELETE CASCADE,
+    version_id INTEGER REFERENCES test_versions(id) ON DELETE CASCADE,
+    scale_type VARCHAR(100) NOT NULL,
+    question_number INTEGER NOT NULL, -- order_index вопроса
+    is_reverse BOOLEAN NOT NULL DEFAULT false,
+    UNIQUE (version_id, scale_type, question_number)
+);
+
+-- Таблица групп пользователей (например, кандидаты на одну вакансию)
+CREATE TABLE user_groups (
+    id SERIAL PRIMARY KEY,
+    name VARCHAR(255) UNIQUE NOT NULL,
+    description TEXT,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE user_group_members (
+    group_id INTEGER REFERENCES user_groups(id) ON DELETE CASCADE,
+    user_id INTEGER REFERENCES users(id) ON DELETE CASCADE,
+    PRIMARY KEY (group_id, user_id)
+);
+
+-- Таблица назначений тестов пользователям (статус вычисляется по сессиям и результатам)
+CREATE TABLE test_assignments (
+    id SERIAL PRIMARY KEY,
+    test_id INTEGER REFERENCES psychological_tests(id) ON DELETE CASCADE,
+    user_id INTEGER REFERENCES users(id) ON DELETE CASCADE,
+    group_id INTEGER REFERENCES user_groups(id) ON DELETE SET NULL, -- через какую группу назначен
+    assigned_by INTEGER REFERENCES users(id) ON DELETE SET NULL,
+    assigned_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    deadline TIMESTAMP,
+    UNIQUE (test_id, user_id)
+);
+
+-- Таблица назначений тестов группам: по ним назначения участников
+-- создаются и отзываются при изменении состава группы
+CREATE TABLE test_group_assignments (
+    id SERIAL PRIMARY KEY,
+    test_id INTEGER REFERENCES psychological_tests(id) ON DELETE CASCADE,
+    group_id INTEGER REFERENCES user_groups(id) ON DELETE CASCADE,
+    assigned_by INTEGER REFERENCES users(id) ON DELETE SET NULL,
+    assigned_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    deadline TIMESTAMP,
+    UNIQUE (test_id, group_id)
+);
+
+-- Таблица батарей: набор тестов, проходимых одним потоком, с общим правилом пригодности
+CREATE TABLE test_batteries (
+    id SERIAL PRIMARY KEY,
+    title VARCHAR(255) NOT NULL,
+    description TEXT,
+    pass_rule VARCHAR(20) NOT NULL DEFAULT 'all_passed', -- all_passed, weighted
+    pass_threshold DECIMAL(5,2) NOT NULL DEFAULT 0, -- порог взвешенного процента для weighted
+    is_active BOOLEAN DEFAULT true,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE battery_tests (
+    battery_id INTEGER REFERENCES test_batteries(id) ON DELETE CASCADE,
+    test_id INTEGER REFERENCES psychological_tests(id) ON DELETE CASCADE,
+    order_index INTEGER NOT NULL,
+    weight DECIMAL(5,2) NOT NULL DEFAULT 1,
+    PRIMARY KEY (battery_id, test_id)
+);
+
+-- Таблица норм: среднее и стандартное отклонение сырого балла шкалы в нормативной группе.
+-- Пустой пол и незаданные границы возраста - норма для всех
+CREATE TABLE norm_tables (
+    id SERIAL PRIMARY KEY,
+    test_id INTEGER REFERENCES psychological_tests(id) ON DELETE CASCADE,
+    version_id INTEGER REFERENCES test_versions(id) ON DELETE SET NULL, -- версия, по результатам которой рассчитана норма
+    scale_type VARCHAR(100) NOT NULL,
+    gender VARCHAR(10) NOT NULL DEFAULT '',
+    age_min INTEGER,
+    age_max INTEGER,
+    mean DECIMAL(8,3) NOT NULL,
+    std_dev DECIMAL(8,3) NOT NULL CHECK (std_dev > 0),
+    sample_size INTEGER NOT NULL DEFAULT 0,
+    source VARCHAR(10) NOT NULL DEFAULT 'upload', -- upload, computed
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Таблица пар вопросов для проверки согласованности ответов (номера пунктов, как в scale_keys)
+CREATE TABLE inconsistency_pairs (
+    id SERIAL PRIMARY KEY,
+    test_id INTEGER REFERENCES psychological_tests(id) ON DELETE CASCADE,
+    version_id INTEGER REFERENCES test_versions(id) ON DELETE CASCADE,
+    question_a INTEGER NOT NULL,
+    question_b INTEGER NOT NULL,
+    is_opposite BOOLEAN NOT NULL DEFAULT false, -- утверждения противоположны по смыслу
+    max_difference DECIMAL(5,2) NOT NULL DEFAULT 50 -- допустимое расхождение ответов в %
+);
+
+-- Журнал аудита административных операций: архивирование, удаление и импорт тестов
+CREATE TABLE audit_log (
+    id SERIAL PRIMARY KEY,
+    user_id INTEGER REFERENCES users(id) ON DELETE SET NULL,
+    action VARCHAR(50) NOT NULL,
+    entity_type VARCHAR(50) NOT NULL,
+    entity_id INTEGER NOT NULL,
+    details JSONB,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
 -- Создаём индексы для производительности
 CREATE INDEX idx_users_email ON users(email);
 CREATE INDEX idx_test_results_user_id ON test_results(user_id);
 CREATE INDEX idx_test_results_test_id ON test_results(test_id);
 CREATE INDEX idx_test_questions_test_id ON test_questions(test_id);
+CREATE INDEX idx_test_questions_version_id ON test_questions(version_id);
 CREATE INDEX idx_question_options_question_id ON question_options(question_id);
 CREATE INDEX idx_user_answers_result_id ON user_answers(result_id);
+CREATE INDEX idx_user_answers_free_text ON user_answers(question_id) WHERE answer_text IS NOT NULL;
 CREATE INDEX idx_test_results_completed_at ON test_results(completed_at);
+CREATE INDEX idx_test_results_user_test ON test_results(user_id, test_id, completed_at);
+CREATE INDEX idx_users_created_at ON users(created_at);
 CREATE INDEX idx_users_is_blocked ON users(is_blocked);
+CREATE INDEX idx_test_sessions_user_test ON test_sessions(user_id, test_id, status);
+CREATE INDEX idx_test_result_scales_result_id ON test_result_scales(result_id);
+CREATE INDEX idx_interpretation_rules_version_id ON interpretation_rules(version_id);
+CREATE INDEX idx_scale_keys_version_id ON scale_keys(version_id);
+CREATE INDEX idx_inconsistency_pairs_version_id ON inconsistency_pairs(version_id);
+CREATE INDEX idx_test_assignments_user_id ON test_assignments(user_id);
+CREATE INDEX idx_test_assignments_group_id ON test_assignments(group_id);
+CREATE INDEX idx_test_group_assignments_group_id ON test_group_assignments(group_id);
+CREATE INDEX idx_battery_tests_test_id ON battery_tests(test_id);
+CREATE INDEX idx_norm_tables_test_id ON norm_tables(test_id);
+CREATE INDEX idx_inconsistency_pairs_test_id ON inconsistency_pairs(test_id);
+CREATE INDEX idx_audit_log_entity ON audit_log(entity_type, entity_id);
 
 -- Обновляем ограничения внешних ключей для поддержки SET NULL
 ALTER TABLE user_answers 
@@ -114,59 +341,42 @@ INSERT INTO psychological_tests (title, description, instructions, estimated_tim
 'Отвечайте "Да" или "Нет" на следующие утверждения. Выбирайте тот вариант, который наиболее точно отражает ваше обычное поведение.',
 15, 60.0, 'rigidity_scale');
 
--- Вопросы для методики ригидности (все с прямой шкалой)
-INSERT INTO test_questions (test_id, question_text, scale_type, order_index) VALUES 
-(1, 'Мне трудно менять свои привычки даже когда этого требуют обстоятельства', 'cognitive_rigidity', 1),
-(1, 'Я легко переключаюсь с одной задачи на другую', 'behavioral_flexibility', 2),
-(1, 'Новые методы работы вызывают у меня сопротивление и раздражение', 'innovation_resistance', 3),
-(1, 'Я предпочитаю работать по проверенным схемам, а не экспериментировать', 'procedural_preference', 4),
-(1, 'Мне сложно адаптироваться к изменениям в рабочих процессах', 'adaptability', 5),
-(1, 'Я быстро осваиваю новые технологии и инструменты', 'learning_ability', 6),
-(1, 'Мне нравится, когда все идет по плану без неожиданностей', 'planning_preference', 7),
-(1, 'Я легко нахожу альтернативные решения при возникновении проблем', 'problem_solving', 8),
-(1, 'Изменения в распорядке дня выводят меня из равновесия', 'emotional_stability', 9),
-(1, 'Я готов изменить свое мнение под влиянием новых фактов', 'openness_to_change', 10);
-
--- Варианты ответов для методики ригидности (прямая шкала)
--- Высокие баллы = низкая ригидность (хорошо для ИБ)
+-- Вопросы для методики ригидности
+-- Утверждения о ригидности (пункты 1, 3, 4, 5, 7, 9) имеют обратный ключ: баллы за них отражаются автоматически
+INSERT INTO test_questions (test_id, question_text, scale_type, order_index, scoring_key) VALUES 
+(1, 'Мне трудно менять свои привычки даже когда этого требуют обстоятельства', 'cognitive_rigidity', 1, 'reverse'),
+(1, 'Я легко переключаюсь с одной задачи на другую', 'behavioral_flexibility', 2, 'direct'),
+(1, 'Новые методы работы вызывают у меня сопротивление и раздражение', 'innovation_resistance', 3, 'reverse'),
+(1, 'Я предпочитаю работать по проверенным схемам, а не экспериментировать', 'procedural_preference', 4, 'reverse'),
+(1, 'Мне сложно адаптироваться к изменениям в рабочих процессах', 'adaptability', 5, 'reverse'),
+(1, 'Я быстро осваиваю новые технологии и инструменты', 'learning_ability', 6, 'direct'),
+(1, 'Мне нравится, когда все идет по плану без неожиданностей', 'planning_preference', 7, 'reverse'),
+(1, 'Я легко нахожу альтернативные решения при возникновении проблем', 'problem_solving', 8, 'direct'),
+(1, 'Изменения в распорядке дня выводят меня из равновесия', 'emotional_stability', 9, 'reverse'),
+(1, 'Я готов изменить свое мнение под влиянием новых фактов', 'openness_to_change', 10, 'direct');
+
+-- Варианты ответов для методики ригидности
+-- Баллы указаны по согласию с утверждением (ДА = 2, НЕТ = 0); для пунктов с обратным ключом
+-- движок сам отражает их, поэтому высокие баллы по-прежнему означают низкую ригидность
 INSERT INTO question_options (question_id, option_text, score_value, order_index) VALUES 
--- Вопрос 1: Мне трудно менять свои привычки (НЕТ = 2 балла, ДА = 0 баллов)
-(1, 'Да', 0, 1),
-(1, 'Нет', 2, 2),
-
--- Вопрос 2: Я легко переключаюсь с одной задачи на другую (ДА = 2 балла, НЕТ = 0 баллов)
+(1, 'Да', 2, 1),
+(1, 'Нет', 0, 2),
 (2, 'Да', 2, 1),
 (2, 'Нет', 0, 2),
-
--- Вопрос 3: Новые методы вызывают сопротивление (НЕТ = 2 балла, ДА = 0 баллов)
-(3, 'Да', 0, 1),
-(3, 'Нет', 2, 2),
-
--- Вопрос 4: Предпочитаю проверенные схемы (НЕТ = 2 балла, ДА = 0 баллов)
-(4, 'Да', 0, 1),
-(4, 'Нет', 2, 2),
-
--- Вопрос 5: Сложно адаптироваться к изменениям (НЕТ = 2 балла, ДА = 0 баллов)
-(5, 'Да', 0, 1),
-(5, 'Нет', 2, 2),
-
--- Вопрос 6: Быстро осваиваю новые технологии (ДА = 2 балла, НЕТ = 0 баллов)
+(3, 'Да', 2, 1),
+(3, 'Нет', 0, 2),
+(4, 'Да', 2, 1),
+(4, 'Нет', 0, 2),
+(5, 'Да', 2, 1),
+(5, 'Нет', 0, 2),
 (6, 'Да', 2, 1),
 (6, 'Нет', 0, 2),
-
--- Вопрос 7: Люблю когда все по плану (НЕТ = 2 балла, ДА = 0 баллов)
-(7, 'Да', 0, 1),
-(7, 'Нет', 2, 2),
-
--- Вопрос 8: Легко нахожу альтернативные решения (ДА = 2 балла, НЕТ = 0 баллов)
+(7, 'Да', 2, 1),
+(7, 'Нет', 0, 2),
 (8, 'Да', 2, 1),
 (8, 'Нет', 0, 2),
-
--- Вопрос 9: Изменения выводят из равновесия (НЕТ = 2 балла, ДА = 0 баллов)
-(9, 'Да', 0, 1),
-(9, 'Нет', 2, 2),
-
--- Вопрос 10: Готов изменить мнение (ДА = 2 балла, НЕТ = 0 баллов)
+(9, 'Да', 2, 1),
+(9, 'Нет', 0, 2),
 (10, 'Да', 2, 1),
 (10, 'Нет', 0, 2);
 
@@ -324,7 +534,58 @@ INSERT INTO question_options (question_id, option_text, score_value, order_index
 (30, 'Действую по ситуации, гибко подхожу к задачам', 1, 1),
 (30, 'Ставлю четкие цели и следую плану', 6, 2);
 
+-- Исходные версии встроенных методик
+INSERT INTO test_versions (test_id, version_number, title, pass_threshold, comment)
+SELECT id, 1, title, pass_threshold, 'Исходная версия' FROM psychological_tests ORDER BY id;
+
+UPDATE psychological_tests pt SET current_version_id = tv.id
+FROM test_versions tv WHERE tv.test_id = pt.id;
+
+UPDATE test_questions q SET version_id = pt.current_version_id
+FROM psychological_tests pt WHERE pt.id = q.test_id;
+
+-- Правила интерпретации встроенных методик
+-- В тексте доступны подстановки {score}, {max_score} и {percentage}
+-- Вердикт о пригодности добавляется по порогу прохождения, правила задают только описание
+INSERT INTO interpretation_rules (test_id, version_id, scale_type, min_percentage, max_percentage, interpretation, recommendation) VALUES 
+(1, 1, '', 0, 60, 'Высокий уровень ригидности',
+ 'Результат: {percentage}%. Выявлен повышенный уровень ригидности: возможны трудности с адаптацией к изменениям и переключением между задачами.'),
+(1, 1, '', 60, 100, 'Низкий уровень ригидности',
+ 'Результат: {percentage}%. Выражена психологическая гибкость: способность адаптироваться к изменениям и переключаться между задачами.'),
+(2, 2, '', 0, 70, 'Низкий уровень волевого самоконтроля',
+ 'Результат: {percentage}%. Выявлены недостатки волевой регуляции: возможны проблемы с самодисциплиной, концентрацией внимания и работой в стрессовых ситуациях.'),
+(2, 2, '', 70, 100, 'Высокий уровень волевого самоконтроля',
+ 'Результат: {percentage}%. Развиты волевые качества: настойчивость, самодисциплина, эмоциональная устойчивость.'),
+(3, 3, '', 0, 65, 'Менее выраженные профессионально значимые черты',
+ 'Результат: {percentage}%. Эмоциональная стабильность, ответственность и самоконтроль выражены слабее, чем в благоприятном для работы в ИБ профиле.'),
+(3, 3, '', 65, 100, 'Выраженные профессионально значимые черты',
+ 'Результат: {percentage}%. Выражены эмоциональная стабильность, ответственность и самоконтроль.');
+
+-- Проверка достоверности встроенных методик: слишком быстрое прохождение.
+-- В ригидности половина пунктов с обратным ключом, поэтому одинаковые ответы почти на все вопросы подозрительны
+UPDATE test_versions SET min_duration_seconds = 30 WHERE test_id IN (1, 2, 3);
+UPDATE test_versions SET straight_line_threshold = 90 WHERE test_id = 1;
+
+-- Утверждения 1 и 2 ригидности противоположны по смыслу: с учётом ключей ответы на них должны совпадать
+INSERT INTO inconsistency_pairs (test_id, version_id, question_a, question_b, is_opposite, max_difference) VALUES 
+(1, 1, 1, 2, false, 50);
+
+-- Батарея профессионального отбора из встроенных методик
+INSERT INTO test_batteries (title, description, pass_rule) VALUES 
+('Профессиональный отбор в ИБ', 'Комплексная оценка: ригидность, волевой самоконтроль и личностный профиль 16PF', 'all_passed');
+
+INSERT INTO battery_tests (battery_id, test_id, order_index, weight) VALUES 
+(1, 1, 1, 1),
+(1, 2, 2, 1),
+(1, 3, 3, 1);
+
 -- Создаем тестовых пользователей (пароли будут установлены через функцию CreateTestUsers)
 INSERT INTO users (email, password_hash, last_name, first_name, patronymic, role, is_blocked) VALUES 
 ('admin@psycho.test', 'temp_password', 'Администратор', 'Системы', '', 'admin', false),
-('user@test.ru', 'temp_password', 'Пользователь', 'Тестовый', 'Тестович', 'user', false);
\ No newline at end of file
+('user@test.ru', 'temp_password', 'Пользователь', 'Тестовый', 'Тестович', 'user', false);
+
+-- Назначаем встроенные методики тестовому пользователю
+INSERT INTO test_assignments (test_id, user_id, assigned_by) VALUES 
+(1, 2, 1),
+(2, 2, 1),
+(3, 2, 1);